@@ -57,13 +57,26 @@ func init() {
     rootCmd.AddCommand(initCmd)
     rootCmd.AddCommand(commitCmd)
     rootCmd.AddCommand(diffCmd)
+    rootCmd.AddCommand(validateCmd)
     rootCmd.AddCommand(verifyCmd)
     rootCmd.AddCommand(deployCmd)
     rootCmd.AddCommand(revertCmd)
     rootCmd.AddCommand(historyCmd)
     rootCmd.AddCommand(statusCmd)
     rootCmd.AddCommand(branchCmd)
+    rootCmd.AddCommand(checkoutCmd)
     rootCmd.AddCommand(mergeCmd)
+    rootCmd.AddCommand(tagCmd)
+    rootCmd.AddCommand(remoteCmd)
+    rootCmd.AddCommand(pushCmd)
+    rootCmd.AddCommand(fetchCmd)
+    rootCmd.AddCommand(pullCmd)
+    rootCmd.AddCommand(serveCmd)
+    rootCmd.AddCommand(importCmd)
+    rootCmd.AddCommand(exportCmd)
+    rootCmd.AddCommand(policyCmd)
+    rootCmd.AddCommand(queryCmd)
+    rootCmd.AddCommand(lintCmd)
 }
 
 func initConfig() {
@@ -83,16 +96,21 @@ package netgit
 
 import (
     "fmt"
-    "os"
+    "io"
     "strings"
+    "os"
+    "strconv"
     "time"
     
     "github.com/spf13/cobra"
+    "github.com/spf13/viper"
     "netgit/pkg/storage"
     "netgit/pkg/config"
     "netgit/pkg/policy"
     "netgit/pkg/deploy"
     "netgit/pkg/audit"
+    "netgit/pkg/hooks"
+    "netgit/pkg/reach"
 )
 
 var (
@@ -101,6 +119,23 @@ var (
     force   bool
     target  string
     canary  bool
+    output  string
+    env     string
+    
+    deleteBranch bool
+    renameBranch bool
+    newBranch    bool
+    
+    mergeContinue bool
+    mergeAbort    bool
+    
+    annotate  bool
+    deleteTag bool
+    
+    diffReachability bool
+    
+    failOn       string
+    verifyOutput string
 )
 
 var initCmd = &cobra.Command{
@@ -137,6 +172,32 @@ var commitCmd = &cobra.Command{
             return err
         }
         
+        runner, err := hooks.Load(repo.Path())
+        if err != nil {
+            return err
+        }
+        event := hooks.Event{
+            Commit: &hooks.Commit{Message: message, Author: "user@netgit.local"},
+            Files:  hooks.Files(configFiles),
+        }
+        event.Commit.Branch, _ = repo.CurrentBranch()
+        event.Commit.Parent, _ = repo.ResolveCommit("HEAD")
+        if err := runner.Run(hooks.PreCommit, event); err != nil {
+            return err
+        }
+        if err := runner.Run(hooks.CommitMsg, event); err != nil {
+            return err
+        }
+        
+        report, err := checkPolicies("commit", configFiles, "")
+        if err != nil {
+            return err
+        }
+        printReport(report)
+        if report.Blocked > 0 {
+            return fmt.Errorf("commit blocked by %d policy violations", report.Blocked)
+        }
+        
         commit, err := repo.Commit(configFiles, message, "user@netgit.local")
         if err != nil {
             return err
@@ -151,12 +212,18 @@ var commitCmd = &cobra.Command{
         
         fmt.Printf("[%s] %s\n", commit.Hash[:8], commit.Message)
         fmt.Printf("%d files changed\n", len(configFiles))
+        
+        // The commit is made; a failing post-commit hook cannot undo it
+        event.Commit.Hash, event.Commit.Parent, event.Commit.Timestamp = commit.Hash, commit.Parent, &commit.Timestamp
+        if err := runner.Run(hooks.PostCommit, event); err != nil {
+            fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+        }
         return nil
     },
 }
 
 var diffCmd = &cobra.Command{
-    Use:   "diff [rev1] [rev2]",
+    Use:   "diff [rev1] [rev2] | [rev1..rev2]",
     Short: "Show changes between commits",
     RunE: func(cmd *cobra.Command, args []string) error {
         repo, err := storage.OpenRepository(".")
@@ -172,8 +239,11 @@ var diffCmd = &cobra.Command{
             rev1 = "HEAD"
             rev2 = "WORKING"
         case 1:
-            rev1 = args[0]
-            rev2 = "WORKING"
+            var isRange bool
+            if rev1, rev2, isRange = storage.SplitRange(args[0]); !isRange {
+                rev1 = args[0]
+                rev2 = "WORKING"
+            }
         case 2:
             rev1 = args[0]
             rev2 = args[1]
@@ -181,60 +251,347 @@ var diffCmd = &cobra.Command{
             return fmt.Errorf("too many arguments")
         }
         
-        diff, err := repo.Diff(rev1, rev2)
-        if err != nil {
-            return err
+        if diffReachability {
+            before, err := repo.EnvironmentConfig(rev1, env)
+            if err != nil {
+                return err
+            }
+            after, err := repo.EnvironmentConfig(rev2, env)
+            if err != nil {
+                return err
+            }
+            delta := &reach.Delta{From: rev1, To: rev2, Changes: reach.Compare(before, after)}
+            content, err := delta.Format(output)
+            if err != nil {
+                return err
+            }
+            fmt.Print(content)
+            return nil
         }
         
-        fmt.Print(diff.String())
-        return nil
-    },
-}
-
-var verifyCmd = &cobra.Command{
-    Use:   "verify",
-    Short: "Verify configurations against policies",
-    RunE: func(cmd *cobra.Command, args []string) error {
-        configFiles, err := config.LoadWorkingDirectory(".")
+        diff, err := repo.DiffEnvironment(rev1, rev2, env)
         if err != nil {
             return err
         }
         
-        policyEngine, err := policy.NewEngine("policies")
+        content, err := diff.Format(output)
         if err != nil {
             return err
         }
         
-        violations := []policy.Violation{}
-        for _, cfg := range configFiles {
-            results, err := policyEngine.Verify(cfg)
+        fmt.Print(content)
+        return nil
+    },
+}
+
+var validateCmd = &cobra.Command{
+    Use:   "validate [file...]",
+    Short: "Check configuration files against the netgit schema",
+    RunE: func(cmd *cobra.Command, args []string) error {
+        var count int
+        var err error
+        if len(args) == 0 {
+            count, err = validateWorkingDirectory("")
             if err != nil {
                 return err
             }
-            violations = append(violations, results...)
         }
-        
-        if len(violations) > 0 {
-            fmt.Printf("Found %d policy violations:\n\n", len(violations))
-            for _, v := range violations {
-                fmt.Printf("❌ %s: %s\n", v.Rule, v.Message)
-                fmt.Printf("   File: %s\n", v.File)
-                if v.Path != "" {
-                    fmt.Printf("   Path: %s\n", v.Path)
-                }
-                fmt.Println()
+        for _, file := range args {
+            problems, err := config.ValidateFile(file)
+            if err != nil {
+                fmt.Printf("%s: %v\n", file, err)
+                count++
+                continue
             }
+            for _, p := range problems {
+                fmt.Println(p)
+            }
+            count += len(problems)
+        }
+        
+        if count > 0 {
+            fmt.Printf("\n❌ Found %d problems\n", count)
             os.Exit(1)
         }
         
-        fmt.Printf("✅ All configurations passed policy verification\n")
+        fmt.Printf("✅ All configurations are valid\n")
         return nil
     },
 }
 
+// validateWorkingDirectory prints every problem in the working directory's
+// configs, including files that fail to load, and returns how many it found
+func validateWorkingDirectory(env string) (int, error) {
+    problems, err := config.ValidateWorkingDirectory(".", env)
+    if err != nil {
+        return 0, err
+    }
+    for _, p := range problems {
+        fmt.Println(p)
+    }
+    return len(problems), nil
+}
+
+// Exit codes of verify
+const (
+    exitPassed     = 0 // no violation blocks, though some may warn
+    exitViolations = 1 // a policy violation blocks the configs
+    exitInvalid    = 2 // configs fail the schema, so policies were not run
+    exitError      = 3 // verify could not run, e.g. a policy file is broken
+)
+
+var verifyCmd = &cobra.Command{
+    Use:   "verify",
+    Short: "Verify configurations against policies",
+    Long: `Verify checks the working directory's configurations against the
+schema and then the policies, and exits with:
+  
+  0  no violation blocks, though some may warn
+  1  a policy violation blocks the configurations
+  2  a configuration fails the schema, so policies were not run
+  3  verify could not run, for example because a policy file is broken
+
+--output json, sarif or junit writes the result to stdout in that format,
+schema problems included as results of the schema rule, and messages for
+people to stderr.`,
+    Run: func(cmd *cobra.Command, args []string) {
+        code, err := runVerify()
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        }
+        os.Exit(code)
+    },
+}
+
+func runVerify() (int, error) {
+    machine := verifyOutput != policy.OutputText
+    if machine {
+        if _, err := (&policy.Report{}).Format(verifyOutput); err != nil {
+            return exitError, err
+        }
+    }
+    
+    // Policies assume well-formed configs, so schema problems come first
+    problems, err := config.ValidateWorkingDirectory(".", env)
+    if err != nil {
+        return exitError, err
+    }
+    if len(problems) > 0 {
+        out := os.Stdout
+        if machine {
+            content, err := policy.SchemaReport(problems).Format(verifyOutput)
+            if err != nil {
+                return exitError, err
+            }
+            fmt.Print(content)
+            out = os.Stderr
+        }
+        for _, p := range problems {
+            fmt.Fprintln(out, p)
+        }
+        fmt.Fprintf(out, "\n❌ Found %d schema problems\n", len(problems))
+        return exitInvalid, nil
+    }
+    
+    configFiles, err := config.LoadEnvironment(".", env)
+    if err != nil {
+        return exitError, err
+    }
+    
+    report, err := checkPolicies("verify", configFiles, env)
+    if err != nil {
+        return exitError, err
+    }
+    
+    code := exitPassed
+    if report.Blocked > 0 {
+        code = exitViolations
+    }
+    if machine {
+        content, err := report.Format(verifyOutput)
+        if err != nil {
+            return exitError, err
+        }
+        fmt.Print(content)
+        printWaivers(os.Stderr, report)
+        return code, nil
+    }
+    
+    printReport(report)
+    switch {
+    case report.Blocked > 0:
+        fmt.Printf("❌ %d policy violations block these configurations\n", report.Blocked)
+    case report.Warned > 0:
+        fmt.Printf("✅ All configurations passed policy verification with %d warnings\n", report.Warned)
+    default:
+        fmt.Printf("✅ All configurations passed policy verification\n")
+    }
+    return code, nil
+}
+
+// policyEnforcement reads the enforcement levels under the enforcement key
+// of .netgit.yaml, with --fail-on overriding the threshold there
+func policyEnforcement() (policy.Enforcement, error) {
+    var enforcement policy.Enforcement
+    if err := viper.UnmarshalKey("enforcement", &enforcement); err != nil {
+        return enforcement, fmt.Errorf("enforcement: %w", err)
+    }
+    if failOn != "" {
+        enforcement.FailOn = failOn
+    }
+    return enforcement, enforcement.Check()
+}
+
+// checkPolicies verifies configs against the policies directory and
+// enforces what it finds for env, or for the environment each config's
+// metadata names when env is empty. Violations a waiver exempts are left
+// out. Every violation, audit-level and waived ones included, goes to the
+// audit log.
+func checkPolicies(operation string, configs []config.NetworkConfig, env string) (*policy.Report, error) {
+    engine, err := policy.NewEngine("policies")
+    if err != nil {
+        return nil, err
+    }
+    enforcement, err := policyEnforcement()
+    if err != nil {
+        return nil, err
+    }
+    waivers, err := policy.LoadWaivers("policies", waiverKey(), allowUnsignedWaivers())
+    if err != nil {
+        return nil, err
+    }
+    
+    now := time.Now()
+    report := &policy.Report{Violations: []policy.Violation{}}
+    for _, cfg := range configs {
+        if cfg.Fragment {
+            continue
+        }
+        report.Files = append(report.Files, cfg.Source)
+        found, err := engine.Verify(cfg)
+        if err != nil {
+            return nil, err
+        }
+        found, waived := waivers.Apply(found, now)
+        report.Waived = append(report.Waived, waived...)
+        
+        environment := env
+        if environment == "" {
+            environment = cfg.Metadata.Environment
+        }
+        enforcement.Enforce(found, environment)
+        report.Violations = append(report.Violations, found...)
+    }
+    report.Expired = waivers.Expired(now)
+    report.Unused = waivers.Unused(now)
+    report.Unsigned = waivers.Unsigned()
+    
+    for _, v := range report.Violations {
+        switch v.Enforcement {
+        case policy.EnforceBlock:
+            report.Blocked++
+        case policy.EnforceWarn:
+            report.Warned++
+        }
+    }
+    
+    for _, w := range report.Waived {
+        audit.LogEvent("policy_waiver", map[string]interface{}{
+            "operation":     operation,
+            "rule":          w.Violation.Rule,
+            "severity":      w.Violation.Level,
+            "file":          w.Violation.File,
+            "path":          w.Violation.Path,
+            "message":       w.Violation.Message,
+            "justification": w.Waiver.Justification,
+            "approved_by":   w.Waiver.ApprovedBy,
+            "expires":       w.Waiver.Expires,
+            "signed":        w.Waiver.Signed(),
+        })
+    }
+    if len(report.Violations) > 0 {
+        audit.LogEvent("policy_check", map[string]interface{}{
+            "operation":   operation,
+            "environment": env,
+            "blocked":     report.Blocked,
+            "warned":      report.Warned,
+            "violations":  report.Violations,
+        })
+    }
+    return report, nil
+}
+
+// printWaivers tells people which violations were waived and which waivers
+// have expired or match nothing
+func printWaivers(out io.Writer, report *policy.Report) {
+    for _, w := range report.Waived {
+        fmt.Fprintf(out, "🔕 Waived %s (approved by %s until %s)\n", w.Waiver, w.Waiver.ApprovedBy, w.Waiver.Expires)
+    }
+    for _, w := range report.Expired {
+        fmt.Fprintf(out, "⚠️  Waiver for %s expired on %s\n", w, w.Expires)
+    }
+    for _, w := range report.Unused {
+        fmt.Fprintf(out, "⚠️  Waiver for %s matches no violation\n", w)
+    }
+    for _, w := range report.Unsigned {
+        fmt.Fprintf(out, "⚠️  Waiver for %s is not signed\n", w)
+    }
+    if len(report.Waived) > 0 || len(report.Expired) > 0 || len(report.Unused) > 0 || len(report.Unsigned) > 0 {
+        fmt.Fprintln(out)
+    }
+}
+
+// printReport prints the waivers and the violations that block or warn
+func printReport(report *policy.Report) {
+    printWaivers(os.Stdout, report)
+    
+    var reported []policy.Violation
+    for _, v := range report.Violations {
+        if v.Enforcement == policy.EnforceBlock || v.Enforcement == policy.EnforceWarn {
+            reported = append(reported, v)
+        }
+    }
+    if len(reported) == 0 {
+        return
+    }
+    
+    fmt.Printf("Found %d policy violations:\n\n", len(reported))
+    for _, v := range reported {
+        mark := "❌"
+        if v.Enforcement == policy.EnforceWarn {
+            mark = "⚠️ "
+        }
+        fmt.Printf("%s %s (%s, %s): %s\n", mark, v.Rule, v.Level, v.Enforcement, v.Message)
+        if v.Line > 0 {
+            fmt.Printf("   File: %s:%d:%d\n", v.File, v.Line, v.Column)
+        } else {
+            fmt.Printf("   File: %s\n", v.File)
+        }
+        if v.Path != "" {
+            fmt.Printf("   Path: %s\n", v.Path)
+        }
+        fmt.Println()
+    }
+}
+
+// waiverKey is the key waivers must be signed with, from
+// NETGIT_WAIVER_KEY
+func waiverKey() []byte {
+    return []byte(os.Getenv("NETGIT_WAIVER_KEY"))
+}
+
+// allowUnsignedWaivers reports whether the operator has set
+// NETGIT_ALLOW_UNSIGNED_WAIVERS to honour waivers without a checked
+// signature, which are then reported
+func allowUnsignedWaivers() bool {
+    allow, _ := strconv.ParseBool(os.Getenv("NETGIT_ALLOW_UNSIGNED_WAIVERS"))
+    return allow
+}
+
 var deployCmd = &cobra.Command{
-    Use:   "deploy",
+    Use:   "deploy [revision]",
     Short: "Deploy configurations to target environment",
+    Args:  cobra.MaximumNArgs(1),
     RunE: func(cmd *cobra.Command, args []string) error {
         repo, err := storage.OpenRepository(".")
         if err != nil {
@@ -242,16 +599,63 @@ var deployCmd = &cobra.Command{
         }
         defer repo.Close()
         
-        head, err := repo.GetHEAD()
+        revision := "HEAD"
+        if len(args) == 1 {
+            revision = args[0]
+        }
+        
+        hash, err := repo.ResolveCommit(revision)
+        if err != nil {
+            return err
+        }
+        head, err := repo.GetCommit(hash)
+        if err != nil {
+            return err
+        }
+        if env != "" {
+            if head.Config, err = repo.EnvironmentConfig(hash, env); err != nil {
+                return err
+            }
+        }
+        
+        files, err := repo.EnvironmentFiles(hash, env)
+        if err != nil {
+            return err
+        }
+        report, err := checkPolicies("deploy", files, env)
         if err != nil {
             return err
         }
+        printReport(report)
+        if report.Blocked > 0 {
+            return fmt.Errorf("deploy of %s blocked by %d policy violations", head.Hash[:8], report.Blocked)
+        }
         
         deployer, err := deploy.GetDeployer(target)
         if err != nil {
             return err
         }
         
+        deployment := &deploy.Deployment{
+            CommitHash:  head.Hash,
+            Target:      target,
+            Environment: env,
+            Canary:      canary,
+            Timestamp:   time.Now(),
+            Status:      "pending",
+        }
+        
+        // pre-deploy runs for dry runs too, so that they refuse what the
+        // deploy would
+        runner, err := hooks.Load(repo.Path())
+        if err != nil {
+            return err
+        }
+        event := hooks.Event{Deployment: deployment, Files: hooks.Files(files)}
+        if err := runner.Run(hooks.PreDeploy, event); err != nil {
+            return err
+        }
+        
         if dryRun {
             fmt.Println("Performing dry run...")
             if err := deployer.DryRun(head.Config); err != nil {
@@ -261,13 +665,6 @@ var deployCmd = &cobra.Command{
             return nil
         }
         
-        deployment := &deploy.Deployment{
-            CommitHash: head.Hash,
-            Target:     target,
-            Canary:     canary,
-            Timestamp:  time.Now(),
-        }
-        
         if canary {
             fmt.Println("Starting canary deployment (10% traffic)...")
             if err := deployer.CanaryDeploy(head.Config, 10); err != nil {
@@ -290,34 +687,49 @@ var deployCmd = &cobra.Command{
         
         audit.LogEvent("deploy", map[string]interface{}{
             "commit_hash": head.Hash,
+            "revision":    revision,
             "target":      target,
+            "environment": env,
             "canary":      canary,
             "timestamp":   deployment.Timestamp,
         })
         
         fmt.Printf("✅ Successfully deployed %s to %s\n", head.Hash[:8], target)
+        
+        deployment.Status = "succeeded"
+        if err := runner.Run(hooks.PostDeploy, event); err != nil {
+            fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+        }
         return nil
     },
 }
 
 var revertCmd = &cobra.Command{
-    Use:   "revert <commit>",
+    Use:   "revert <commit|tag>",
     Short: "Revert to a specific commit",
     Args:  cobra.ExactArgs(1),
     RunE: func(cmd *cobra.Command, args []string) error {
-        commitHash := args[0]
-        
         repo, err := storage.OpenRepository(".")
         if err != nil {
             return err
         }
         defer repo.Close()
         
+        commitHash, err := repo.ResolveCommit(args[0])
+        if err != nil {
+            return err
+        }
         commit, err := repo.GetCommit(commitHash)
         if err != nil {
             return err
         }
         
+        // Files whose deployed state the rollback changes
+        var files []string
+        if changes, err := repo.Diff("HEAD", commit.Hash); err == nil {
+            files = changes.Files()
+        }
+        
         deployer, err := deploy.GetDeployer(target)
         if err != nil {
             return err
@@ -329,18 +741,24 @@ var revertCmd = &cobra.Command{
         
         audit.LogEvent("revert", map[string]interface{}{
             "commit_hash": commitHash,
+            "revision":    args[0],
             "target":      target,
+            "files":       files,
             "timestamp":   time.Now(),
         })
         
+        for _, file := range files {
+            fmt.Printf("  reverted: %s\n", file)
+        }
         fmt.Printf("✅ Reverted to commit %s\n", commitHash[:8])
         return nil
     },
 }
 
 var historyCmd = &cobra.Command{
-    Use:   "history",
+    Use:   "history [revision|rev1..rev2]",
     Short: "Show commit history",
+    Args:  cobra.MaximumNArgs(1),
     RunE: func(cmd *cobra.Command, args []string) error {
         repo, err := storage.OpenRepository(".")
         if err != nil {
@@ -348,7 +766,12 @@ var historyCmd = &cobra.Command{
         }
         defer repo.Close()
         
-        commits, err := repo.GetHistory()
+        revision := "HEAD"
+        if len(args) == 1 {
+            revision = args[0]
+        }
+        
+        commits, err := repo.History(revision)
         if err != nil {
             return err
         }
@@ -379,7 +802,20 @@ var statusCmd = &cobra.Command{
             return err
         }
         
-        fmt.Printf("On branch %s\n", status.Branch)
+        if status.Detached != "" {
+            fmt.Printf("HEAD detached at %s\n", status.Detached[:8])
+        } else {
+            fmt.Printf("On branch %s\n", status.Branch)
+        }
+        if status.Merge != nil {
+            fmt.Printf("You are in the middle of a merge with %s\n", status.Merge.Head[:8])
+            for _, conflict := range status.Merge.Conflicts {
+                fmt.Printf("  conflict: %s\n", conflict)
+            }
+            fmt.Println("  (fix conflicts and run \"netgit merge --continue\")")
+            fmt.Println("  (use \"netgit merge --abort\" to abort the merge)")
+            fmt.Println()
+        }
         if status.Clean {
             fmt.Println("nothing to commit, working directory clean")
         } else {
@@ -393,6 +829,13 @@ var statusCmd = &cobra.Command{
             for _, file := range status.Deleted {
                 fmt.Printf("  deleted:  %s\n", file)
             }
+            if len(status.Invalid) > 0 {
+                fmt.Printf("\nFiles that could not be parsed:\n")
+                for _, invalid := range status.Invalid {
+                    fmt.Printf("  invalid:  %s\n", invalid.File)
+                    fmt.Printf("            %v\n", invalid.Err)
+                }
+            }
         }
         return nil
     },
@@ -400,7 +843,8 @@ var statusCmd = &cobra.Command{
 
 var branchCmd = &cobra.Command{
     Use:   "branch [name]",
-    Short: "List or create branches",
+    Short: "List, create, rename or delete branches",
+    Args:  cobra.MaximumNArgs(2),
     RunE: func(cmd *cobra.Command, args []string) error {
         repo, err := storage.OpenRepository(".")
         if err != nil {
@@ -408,6 +852,41 @@ var branchCmd = &cobra.Command{
         }
         defer repo.Close()
         
+        if deleteBranch {
+            if len(args) != 1 {
+                return fmt.Errorf("branch name required")
+            }
+            if err := repo.DeleteBranch(args[0], force); err != nil {
+                return err
+            }
+            fmt.Printf("Deleted branch '%s'\n", args[0])
+            return nil
+        }
+        
+        if renameBranch {
+            oldName, newName := "", ""
+            switch len(args) {
+            case 1:
+                oldName, err = repo.CurrentBranch()
+                if err != nil {
+                    return err
+                }
+                if oldName == "" {
+                    return fmt.Errorf("HEAD is detached; name the branch to rename")
+                }
+                newName = args[0]
+            case 2:
+                oldName, newName = args[0], args[1]
+            default:
+                return fmt.Errorf("new branch name required")
+            }
+            if err := repo.RenameBranch(oldName, newName); err != nil {
+                return err
+            }
+            fmt.Printf("Renamed branch '%s' to '%s'\n", oldName, newName)
+            return nil
+        }
+        
         if len(args) == 0 {
             branches, err := repo.ListBranches()
             if err != nil {
@@ -415,6 +894,11 @@ var branchCmd = &cobra.Command{
             }
             
             current, _ := repo.CurrentBranch()
+            if current == "" {
+                if head, err := repo.GetHEAD(); err == nil {
+                    fmt.Printf("* (HEAD detached at %s)\n", head.Hash[:8])
+                }
+            }
             for _, branch := range branches {
                 if branch == current {
                     fmt.Printf("* %s\n", branch)
@@ -425,6 +909,10 @@ var branchCmd = &cobra.Command{
             return nil
         }
         
+        if len(args) > 1 {
+            return fmt.Errorf("too many arguments")
+        }
+        
         branchName := args[0]
         if err := repo.CreateBranch(branchName); err != nil {
             return err
@@ -435,1286 +923,16774 @@ var branchCmd = &cobra.Command{
     },
 }
 
-var mergeCmd = &cobra.Command{
-    Use:   "merge <branch>",
-    Short: "Merge branch into current branch",
+var checkoutCmd = &cobra.Command{
+    Use:   "checkout <branch|tag|commit>",
+    Short: "Switch branches or detach HEAD at a commit",
     Args:  cobra.ExactArgs(1),
     RunE: func(cmd *cobra.Command, args []string) error {
-        branchName := args[0]
-        
         repo, err := storage.OpenRepository(".")
         if err != nil {
             return err
         }
         defer repo.Close()
         
-        merge, err := repo.Merge(branchName)
+        name := args[0]
+        if newBranch {
+            if err := repo.CreateBranch(name); err != nil {
+                return err
+            }
+        }
+        
+        commit, err := repo.Checkout(name, force)
         if err != nil {
             return err
         }
         
-        fmt.Printf("Merge commit %s\n", merge.Hash[:8])
+        if branch, _ := repo.CurrentBranch(); branch != "" {
+            fmt.Printf("Switched to branch '%s'\n", branch)
+        } else {
+            fmt.Printf("HEAD is now at %s %s\n", commit.Hash[:8], commit.Message)
+        }
         return nil
     },
 }
 
-func init() {
-    commitCmd.Flags().StringVarP(&message, "message", "m", "", "Commit message")
-    deployCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Perform a dry run")
-    deployCmd.Flags().StringVarP(&target, "target", "t", "mock", "Deployment target")
-    deployCmd.Flags().BoolVar(&canary, "canary", false, "Use canary deployment")
-    revertCmd.Flags().StringVarP(&target, "target", "t", "mock", "Deployment target")
-}
-
-// pkg/storage/repository.go
-package storage
-
-import (
-    "crypto/sha256"
-    "encoding/json"
-    "fmt"
-    "os"
-    "path/filepath"
-    "time"
-    
-    "go.etcd.io/bbolt"
-    "github.com/google/uuid"
-    "netgit/pkg/config"
-)
-
-type Repository struct {
-    db   *bbolt.DB
-    path string
-}
-
-type Commit struct {
-    Hash      string                 `json:"hash"`
-    Parent    string                 `json:"parent"`
-    Message   string                 `json:"message"`
-    Author    string                 `json:"author"`
-    Timestamp time.Time              `json:"timestamp"`
-    Config    config.NetworkConfig   `json:"config"`
-}
-
-type Diff struct {
-    Added    []string `json:"added"`
-    Modified []string `json:"modified"`
-    Deleted  []string `json:"deleted"`
-    Content  string   `json:"content"`
-}
-
-type Status struct {
-    Branch   string   `json:"branch"`
-    Clean    bool     `json:"clean"`
-    Added    []string `json:"added"`
-    Modified []string `json:"modified"`
-    Deleted  []string `json:"deleted"`
-}
-
-func (d *Diff) String() string {
-    return d.Content
+var mergeCmd = &cobra.Command{
+    Use:   "merge <branch> | --continue | --abort",
+    Short: "Merge branch into current branch",
+    Args:  cobra.MaximumNArgs(1),
+    RunE: func(cmd *cobra.Command, args []string) error {
+        repo, err := storage.OpenRepository(".")
+        if err != nil {
+            return err
+        }
+        defer repo.Close()
+        
+        if mergeAbort {
+            if err := repo.AbortMerge(); err != nil {
+                return err
+            }
+            fmt.Println("Merge aborted")
+            return nil
+        }
+        
+        if mergeContinue {
+            commit, err := repo.ContinueMerge("user@netgit.local")
+            if err != nil {
+                return err
+            }
+            logMerge(commit)
+            fmt.Printf("Merge commit %s\n", commit.Hash[:8])
+            return nil
+        }
+        
+        if len(args) != 1 {
+            return fmt.Errorf("branch name required")
+        }
+        
+        result, err := repo.Merge(args[0], "user@netgit.local")
+        if err != nil {
+            return err
+        }
+        
+        switch {
+        case result.UpToDate:
+            fmt.Println("Already up to date.")
+        case result.FastForward:
+            fmt.Printf("Fast-forward to %s\n", result.Commit.Hash[:8])
+        case len(result.Conflicts) > 0:
+            for _, conflict := range result.Conflicts {
+                fmt.Printf("CONFLICT %s\n", conflict)
+            }
+            return fmt.Errorf("automatic merge failed; fix conflicts and then run 'netgit merge --continue'")
+        default:
+            logMerge(result.Commit)
+            fmt.Printf("Merge commit %s\n", result.Commit.Hash[:8])
+        }
+        return nil
+    },
 }
 
-func NewRepository(path string) (*Repository, error) {
-    netgitDir := filepath.Join(path, ".netgit")
-    if err := os.MkdirAll(netgitDir, 0755); err != nil {
-        return nil, err
-    }
-    
-    dbPath := filepath.Join(netgitDir, "objects.db")
-    db, err := bbolt.Open(dbPath, 0600, nil)
-    if err != nil {
-        return nil, err
-    }
-    
-    repo := &Repository{db: db, path: path}
-    
-    // Initialize buckets
-    err = db.Update(func(tx *bbolt.Tx) error {
-        buckets := []string{"commits", "refs", "branches", "config"}
-        for _, bucket := range buckets {
-            if _, err := tx.CreateBucketIfNotExists([]byte(bucket)); err != nil {
+var tagCmd = &cobra.Command{
+    Use:   "tag [name] [commit]",
+    Short: "List, create, or delete tags",
+    Args:  cobra.MaximumNArgs(2),
+    RunE: func(cmd *cobra.Command, args []string) error {
+        repo, err := storage.OpenRepository(".")
+        if err != nil {
+            return err
+        }
+        defer repo.Close()
+        
+        if len(args) == 0 {
+            tags, err := repo.ListTags()
+            if err != nil {
+                return err
+            }
+            for _, tag := range tags {
+                if tag.Annotated {
+                    fmt.Printf("%-20s %s %s\n", tag.Name, tag.Commit[:8], strings.SplitN(tag.Message, "\n", 2)[0])
+                } else {
+                    fmt.Printf("%-20s %s\n", tag.Name, tag.Commit[:8])
+                }
+            }
+            return nil
+        }
+        
+        name := args[0]
+        if deleteTag {
+            if err := repo.DeleteTag(name); err != nil {
                 return err
             }
+            fmt.Printf("Deleted tag '%s'\n", name)
+            return nil
         }
         
-        // Set initial branch
-        refs := tx.Bucket([]byte("refs"))
-        if refs.Get([]byte("HEAD")) == nil {
-            refs.Put([]byte("HEAD"), []byte("refs/heads/main"))
+        if annotate && message == "" {
+            return fmt.Errorf("annotated tags require a message (-m)")
+        }
+        
+        revision := "HEAD"
+        if len(args) == 2 {
+            revision = args[1]
+        }
+        
+        tag, err := repo.CreateTag(name, revision, "user@netgit.local", message)
+        if err != nil {
+            return err
         }
         
+        audit.LogEvent("tag", map[string]interface{}{
+            "tag":         tag.Name,
+            "commit_hash": tag.Commit,
+            "annotated":   tag.Annotated,
+            "timestamp":   time.Now(),
+        })
+        
+        fmt.Printf("Tagged %s as '%s'\n", tag.Commit[:8], tag.Name)
         return nil
-    })
-    
-    return repo, err
+    },
 }
 
-func OpenRepository(path string) (*Repository, error) {
-    netgitDir := filepath.Join(path, ".netgit")
-    dbPath := filepath.Join(netgitDir, "objects.db")
-    
-    if _, err := os.Stat(dbPath); os.IsNotExist(err) {
-        return nil, fmt.Errorf("not a netgit repository")
-    }
-    
-    db, err := bbolt.Open(dbPath, 0600, nil)
-    if err != nil {
-        return nil, err
-    }
-    
-    return &Repository{db: db, path: path}, nil
+func logMerge(commit *storage.Commit) {
+    audit.LogEvent("merge", map[string]interface{}{
+        "commit_hash":  commit.Hash,
+        "parent":       commit.Parent,
+        "merge_parent": commit.MergeParent,
+        "timestamp":    commit.Timestamp,
+    })
 }
 
-func (r *Repository) Close() error {
-    return r.db.Close()
+func init() {
+    commitCmd.Flags().StringVarP(&message, "message", "m", "", "Commit message")
+    diffCmd.Flags().StringVarP(&output, "output", "o", "unified", "Output format (unified, color, json)")
+    deployCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Perform a dry run")
+    deployCmd.Flags().StringVarP(&target, "target", "t", "mock", "Deployment target")
+    deployCmd.Flags().BoolVar(&canary, "canary", false, "Use canary deployment")
+    deployCmd.Flags().StringVarP(&env, "env", "e", "", "Deploy the configuration with this environment's variables and overlays")
+    verifyCmd.Flags().StringVarP(&env, "env", "e", "", "Verify the configuration with this environment's variables and overlays")
+    verifyCmd.Flags().StringVarP(&verifyOutput, "output", "o", "text", "Output format (text, json, sarif, junit)")
+    verifyCmd.Flags().StringVar(&failOn, "fail-on", "", "Block exactly the violations at or above this severity (low, medium, high, critical)")
+    commitCmd.Flags().StringVar(&failOn, "fail-on", "", "Refuse to commit on violations at or above this severity")
+    deployCmd.Flags().StringVar(&failOn, "fail-on", "", "Refuse to deploy on violations at or above this severity")
+    diffCmd.Flags().StringVarP(&env, "env", "e", "", "Compare the configuration with this environment's variables and overlays")
+    diffCmd.Flags().BoolVar(&diffReachability, "reachability", false, "List the flows that become allowed or blocked instead of the rule changes")
+    revertCmd.Flags().StringVarP(&target, "target", "t", "mock", "Deployment target")
+    branchCmd.Flags().BoolVarP(&deleteBranch, "delete", "d", false, "Delete a branch")
+    branchCmd.Flags().BoolVarP(&renameBranch, "move", "m", false, "Rename a branch")
+    branchCmd.Flags().BoolVarP(&force, "force", "f", false, "Delete a branch even if it is not merged")
+    checkoutCmd.Flags().BoolVarP(&newBranch, "branch", "b", false, "Create the branch before switching to it")
+    checkoutCmd.Flags().BoolVarP(&force, "force", "f", false, "Discard local changes")
+    mergeCmd.Flags().BoolVar(&mergeContinue, "continue", false, "Commit a merge after resolving conflicts")
+    mergeCmd.Flags().BoolVar(&mergeAbort, "abort", false, "Abandon a conflicted merge")
+    tagCmd.Flags().BoolVarP(&annotate, "annotate", "a", false, "Create an annotated tag")
+    tagCmd.Flags().StringVarP(&message, "message", "m", "", "Tag message (implies --annotate)")
+    tagCmd.Flags().BoolVarP(&deleteTag, "delete", "d", false, "Delete a tag")
 }
 
-func (r *Repository) Path() string {
-    return r.path
-}
+// cmd/netgit/remote.go
+package netgit
 
-func (r *Repository) Commit(configs []config.NetworkConfig, message, author string) (*Commit, error) {
-    if len(configs) == 0 {
-        return nil, fmt.Errorf("no configurations to commit")
-    }
-    
-    // Merge all configs into one
-    mergedConfig := config.NetworkConfig{
-        Metadata: config.Metadata{
-            Name:        "merged",
-            Version:     "1.0",
-            Environment: "production",
-        },
-    }
-    
-    for _, cfg := range configs {
-        mergedConfig.SecurityGroups = append(mergedConfig.SecurityGroups, cfg.SecurityGroups...)
-        mergedConfig.NetworkPolicies = append(mergedConfig.NetworkPolicies, cfg.NetworkPolicies...)
-        mergedConfig.FirewallRules = append(mergedConfig.FirewallRules, cfg.FirewallRules...)
-    }
-    
-    commit := &Commit{
-        Hash:      r.generateHash(mergedConfig, message, author),
-        Message:   message,
-        Author:    author,
-        Timestamp: time.Now(),
-        Config:    mergedConfig,
-    }
-    
-    // Get parent commit
-    if head, err := r.GetHEAD(); err == nil {
-        commit.Parent = head.Hash
-    }
+import (
+    "fmt"
+    "net/http"
+    "os"
+    "time"
     
-    return commit, r.storeCommit(commit)
-}
+    "github.com/gin-gonic/gin"
+    "github.com/spf13/cobra"
+    "netgit/pkg/audit"
+    "netgit/pkg/remote"
+    "netgit/pkg/storage"
+)
 
-func (r *Repository) GetCommit(hash string) (*Commit, error) {
-    var commit Commit
-    err := r.db.View(func(tx *bbolt.Tx) error {
-        bucket := tx.Bucket([]byte("commits"))
-        data := bucket.Get([]byte(hash))
-        if data == nil {
-            return fmt.Errorf("commit not found: %s", hash)
-        }
-        return json.Unmarshal(data, &commit)
-    })
-    return &commit, err
-}
+var (
+    pushTags         bool
+    serveAddr        string
+    serveToken       string
+    serveAllowForce  bool
+    serveAllowDelete bool
+)
 
-func (r *Repository) GetHEAD() (*Commit, error) {
-    var headRef string
-    err := r.db.View(func(tx *bbolt.Tx) error {
-        refs := tx.Bucket([]byte("refs"))
-        head := refs.Get([]byte("HEAD"))
-        if head == nil {
-            return fmt.Errorf("no HEAD found")
+var remoteCmd = &cobra.Command{
+    Use:   "remote",
+    Short: "List, add or remove remote repositories",
+    Args:  cobra.NoArgs,
+    RunE: func(cmd *cobra.Command, args []string) error {
+        repo, err := storage.OpenRepository(".")
+        if err != nil {
+            return err
         }
-        headRef = string(head)
-        return nil
-    })
-    if err != nil {
-        return nil, err
-    }
-    
-    // Get the actual commit hash from the branch reference
-    var commitHash string
-    err = r.db.View(func(tx *bbolt.Tx) error {
-        refs := tx.Bucket([]byte("refs"))
-        hash := refs.Get([]byte(headRef))
-        if hash == nil {
-            return fmt.Errorf("branch reference not found: %s", headRef)
+        defer repo.Close()
+        
+        remotes, err := repo.ListRemotes()
+        if err != nil {
+            return err
+        }
+        for _, r := range remotes {
+            fmt.Printf("%-12s %s\n", r.Name, r.URL)
         }
-        commitHash = string(hash)
         return nil
-    })
-    if err != nil {
-        return nil, err
-    }
-    
-    return r.GetCommit(commitHash)
+    },
 }
 
-func (r *Repository) Diff(rev1, rev2 string) (*Diff, error) {
-    var commit1, commit2 *Commit
-    var err error
-    
-    if rev1 == "HEAD" {
-        commit1, err = r.GetHEAD()
+var remoteAddCmd = &cobra.Command{
+    Use:   "add <name> <url>",
+    Short: "Add a remote repository",
+    Args:  cobra.ExactArgs(2),
+    RunE: func(cmd *cobra.Command, args []string) error {
+        repo, err := storage.OpenRepository(".")
         if err != nil {
-            return nil, err
+            return err
         }
-    } else {
-        commit1, err = r.GetCommit(rev1)
-        if err != nil {
-            return nil, err
+        defer repo.Close()
+        
+        if err := repo.AddRemote(args[0], args[1]); err != nil {
+            return err
         }
-    }
-    
-    if rev2 == "WORKING" {
-        // Compare with working directory
-        configs, err := config.LoadWorkingDirectory(r.path)
+        fmt.Printf("Added remote '%s' (%s)\n", args[0], args[1])
+        return nil
+    },
+}
+
+var remoteRemoveCmd = &cobra.Command{
+    Use:   "remove <name>",
+    Short: "Remove a remote and its remote-tracking branches",
+    Args:  cobra.ExactArgs(1),
+    RunE: func(cmd *cobra.Command, args []string) error {
+        repo, err := storage.OpenRepository(".")
         if err != nil {
-            return nil, err
+            return err
         }
+        defer repo.Close()
         
-        diff := &Diff{
-            Content: r.generateDiffContent(commit1.Config, configs[0]),
+        if err := repo.RemoveRemote(args[0]); err != nil {
+            return err
         }
-        return diff, nil
-    }
-    
-    commit2, err = r.GetCommit(rev2)
-    if err != nil {
-        return nil, err
-    }
-    
-    diff := &Diff{
-        Content: r.generateDiffContent(commit1.Config, commit2.Config),
-    }
-    
-    return diff, nil
+        fmt.Printf("Removed remote '%s'\n", args[0])
+        return nil
+    },
 }
 
-func (r *Repository) GetHistory() ([]*Commit, error) {
-    var commits []*Commit
-    
-    head, err := r.GetHEAD()
-    if err != nil {
-        return commits, err
-    }
-    
-    current := head
-    for current != nil {
-        commits = append(commits, current)
-        if current.Parent == "" {
-            break
-        }
-        current, err = r.GetCommit(current.Parent)
+var pushCmd = &cobra.Command{
+    Use:   "push [remote] [branch|tag...]",
+    Short: "Send branches and tags to a remote repository",
+    RunE: func(cmd *cobra.Command, args []string) error {
+        repo, err := storage.OpenRepository(".")
         if err != nil {
-            break
+            return err
         }
-    }
-    
-    return commits, nil
+        defer repo.Close()
+        
+        remoteName := "origin"
+        if len(args) > 0 {
+            remoteName, args = args[0], args[1:]
+        }
+        
+        names := args
+        if len(names) == 0 && !pushTags {
+            branch, err := repo.CurrentBranch()
+            if err != nil {
+                return err
+            }
+            if branch == "" {
+                return fmt.Errorf("HEAD is detached; name the branch to push")
+            }
+            names = []string{branch}
+        }
+        if pushTags {
+            tags, err := repo.ListTags()
+            if err != nil {
+                return err
+            }
+            for _, tag := range tags {
+                names = append(names, tag.Name)
+            }
+        }
+        
+        results, err := remote.Push(repo, remoteName, names, force)
+        if err != nil {
+            return err
+        }
+        
+        rejected := 0
+        for _, result := range results {
+            switch {
+            case result.Error != "":
+                rejected++
+                fmt.Printf(" ! [rejected] %s (%s)\n", result.Ref, result.Error)
+            case result.UpToDate:
+                fmt.Printf(" = [up to date] %s\n", result.Ref)
+            default:
+                fmt.Printf("   %s..%s %s\n", shortRev(result.Old), shortRev(result.New), result.Ref)
+                audit.LogEvent("push", map[string]interface{}{
+                    "remote":    remoteName,
+                    "ref":       result.Ref,
+                    "old":       result.Old,
+                    "new":       result.New,
+                    "forced":    force,
+                    "timestamp": time.Now(),
+                })
+            }
+        }
+        if rejected > 0 {
+            return fmt.Errorf("failed to push %d ref(s) to '%s'", rejected, remoteName)
+        }
+        return nil
+    },
 }
 
-func (r *Repository) Status() (*Status, error) {
-    status := &Status{
-        Branch: "main",
-        Clean:  true,
-    }
-    
-    // Simplified status - in real implementation would compare working dir with HEAD
-    
-    return status, nil
+var fetchCmd = &cobra.Command{
+    Use:   "fetch [remote]",
+    Short: "Download branches and tags from a remote repository",
+    Args:  cobra.MaximumNArgs(1),
+    RunE: func(cmd *cobra.Command, args []string) error {
+        repo, err := storage.OpenRepository(".")
+        if err != nil {
+            return err
+        }
+        defer repo.Close()
+        
+        remoteName := "origin"
+        if len(args) == 1 {
+            remoteName = args[0]
+        }
+        
+        _, err = fetchRemote(repo, remoteName)
+        return err
+    },
 }
 
-func (r *Repository) ListBranches() ([]string, error) {
-    var branches []string
-    
-    err := r.db.View(func(tx *bbolt.Tx) error {
-        branches = append(branches, "main")
+var pullCmd = &cobra.Command{
+    Use:   "pull [remote] [branch]",
+    Short: "Fetch from a remote and merge its branch into the current branch",
+    Args:  cobra.MaximumNArgs(2),
+    RunE: func(cmd *cobra.Command, args []string) error {
+        repo, err := storage.OpenRepository(".")
+        if err != nil {
+            return err
+        }
+        defer repo.Close()
+        
+        remoteName := "origin"
+        if len(args) > 0 {
+            remoteName = args[0]
+        }
+        branch := ""
+        if len(args) > 1 {
+            branch = args[1]
+        } else if branch, err = repo.CurrentBranch(); err != nil {
+            return err
+        } else if branch == "" {
+            return fmt.Errorf("HEAD is detached; name the branch to pull")
+        }
+        
+        if _, err := fetchRemote(repo, remoteName); err != nil {
+            return err
+        }
+        
+        result, err := repo.Merge(remoteName+"/"+branch, "user@netgit.local")
+        if err != nil {
+            return err
+        }
+        switch {
+        case result.UpToDate:
+            fmt.Println("Already up to date.")
+        case result.FastForward:
+            fmt.Printf("Fast-forward to %s\n", result.Commit.Hash[:8])
+        case len(result.Conflicts) > 0:
+            for _, conflict := range result.Conflicts {
+                fmt.Printf("CONFLICT %s\n", conflict)
+            }
+            return fmt.Errorf("automatic merge failed; fix conflicts and then run 'netgit merge --continue'")
+        default:
+            logMerge(result.Commit)
+            fmt.Printf("Merge commit %s\n", result.Commit.Hash[:8])
+        }
         return nil
-    })
-    
-    return branches, err
+    },
 }
 
-func (r *Repository) CurrentBranch() (string, error) {
-    return "main", nil
+var serveCmd = &cobra.Command{
+    Use:   "serve",
+    Short: "Serve this repository to netgit clients over HTTP",
+    Args:  cobra.NoArgs,
+    RunE: func(cmd *cobra.Command, args []string) error {
+        repo, err := storage.OpenRepository(".")
+        if err != nil {
+            return err
+        }
+        defer repo.Close()
+        
+        token := serveToken
+        if token == "" {
+            token = os.Getenv(remote.TokenEnv)
+        }
+        if token == "" {
+            return fmt.Errorf("serve needs a token: set --token or %s", remote.TokenEnv)
+        }
+        
+        gin.SetMode(gin.ReleaseMode)
+        fmt.Printf("Serving %s on %s\n", repo.Path(), serveAddr)
+        return http.ListenAndServe(serveAddr, remote.NewServer(repo, remote.ServerOptions{
+            Token:       token,
+            AllowForce:  serveAllowForce,
+            AllowDelete: serveAllowDelete,
+        }))
+    },
 }
 
-func (r *Repository) CreateBranch(name string) error {
-    head, err := r.GetHEAD()
+func fetchRemote(repo *storage.Repository, remoteName string) (*remote.FetchResult, error) {
+    result, err := remote.Fetch(repo, remoteName)
     if err != nil {
-        return err
+        return nil, err
     }
     
-    return r.db.Update(func(tx *bbolt.Tx) error {
-        refs := tx.Bucket([]byte("refs"))
-        branchRef := fmt.Sprintf("refs/heads/%s", name)
-        return refs.Put([]byte(branchRef), []byte(head.Hash))
+    for _, branch := range result.Branches {
+        if !branch.UpToDate {
+            fmt.Printf("   %s..%s %s\n", shortRev(branch.Old), shortRev(branch.New), branch.Ref)
+        }
+    }
+    for _, tag := range result.Tags {
+        if tag.Error != "" {
+            fmt.Printf(" ! [rejected] %s (%s)\n", tag.Ref, tag.Error)
+        } else {
+            fmt.Printf(" * [new tag] %s\n", tag.Ref)
+        }
+    }
+    
+    audit.LogEvent("fetch", map[string]interface{}{
+        "remote":    remoteName,
+        "objects":   result.Objects,
+        "timestamp": time.Now(),
     })
+    return result, nil
 }
 
-func (r *Repository) Merge(branchName string) (*Commit, error) {
-    // Simplified merge implementation
-    return &Commit{
-        Hash:    uuid.New().String()[:8],
-        Message: fmt.Sprintf("Merge branch '%s'", branchName),
-    }, nil
+func shortRev(hash string) string {
+    if hash == "" {
+        return "(new)"
+    }
+    return hash[:8]
 }
 
-func (r *Repository) storeCommit(commit *Commit) error {
-    return r.db.Update(func(tx *bbolt.Tx) error {
-        bucket := tx.Bucket([]byte("commits"))
-        data, err := json.Marshal(commit)
+func init() {
+    remoteCmd.AddCommand(remoteAddCmd)
+    remoteCmd.AddCommand(remoteRemoveCmd)
+    pushCmd.Flags().BoolVarP(&force, "force", "f", false, "Overwrite remote refs even if the update is not a fast-forward")
+    pushCmd.Flags().BoolVar(&pushTags, "tags", false, "Push all tags")
+    serveCmd.Flags().StringVar(&serveAddr, "addr", "127.0.0.1:8080", "Address to listen on")
+    serveCmd.Flags().StringVar(&serveToken, "token", "", "Token clients must present (default $"+remote.TokenEnv+")")
+    serveCmd.Flags().BoolVar(&serveAllowForce, "allow-force", false, "Accept forced pushes that rewind refs")
+    serveCmd.Flags().BoolVar(&serveAllowDelete, "allow-delete", false, "Accept pushes that delete refs")
+}
+
+// cmd/netgit/import.go
+package netgit
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    
+    "github.com/spf13/cobra"
+    "netgit/pkg/importer"
+)
+
+var (
+    importOutput      string
+    importName        string
+    importEnvironment string
+)
+
+var importCmd = &cobra.Command{
+    Use:   "import <aws|gcp|azure|k8s> <file>",
+    Short: "Convert a cloud provider export into a netgit configuration",
+    Long: `Import reads a provider's native export and writes the equivalent netgit
+configuration as YAML:
+  
+  aws    output of 'aws ec2 describe-security-groups'
+  gcp    output of 'gcloud compute firewall-rules list --format=json'
+  azure  output of 'az network nsg show' or 'az network nsg list'
+  k8s    NetworkPolicy manifests, as YAML or JSON`,
+    Args: cobra.ExactArgs(2),
+    RunE: func(cmd *cobra.Command, args []string) error {
+        source, filename := args[0], args[1]
+        cfg, warnings, err := importer.ImportFile(source, filename)
         if err != nil {
             return err
         }
+        for _, warning := range warnings {
+            fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+        }
+        
+        cfg.Metadata.Name = importName
+        if cfg.Metadata.Name == "" {
+            base := filepath.Base(filename)
+            cfg.Metadata.Name = strings.TrimSuffix(base, filepath.Ext(base))
+        }
+        cfg.Metadata.Environment = importEnvironment
+        cfg.Metadata.Labels = map[string]string{"imported-from": source}
         
-        if err := bucket.Put([]byte(commit.Hash), data); err != nil {
+        data, err := cfg.ToYAML()
+        if err != nil {
             return err
         }
         
-        // Update HEAD reference
-        refs := tx.Bucket([]byte("refs"))
-        headRef := refs.Get([]byte("HEAD"))
-        return refs.Put(headRef, []byte(commit.Hash))
-    })
-}
-
-func (r *Repository) generateHash(config config.NetworkConfig, message, author string) string {
-    data := fmt.Sprintf("%v%s%s%d", config, message, author, time.Now().Unix())
-    hash := sha256.Sum256([]byte(data))
-    return fmt.Sprintf("%x", hash)[:8]
+        if importOutput == "" {
+            _, err = os.Stdout.Write(data)
+            return err
+        }
+        if err := ioutil.WriteFile(importOutput, data, 0644); err != nil {
+            return err
+        }
+        fmt.Printf("Imported %s into %s\n", filename, importOutput)
+        return nil
+    },
 }
 
-func (r *Repository) generateDiffContent(old, new config.NetworkConfig) string {
-    // Simplified diff generation
-    return fmt.Sprintf("--- old\n+++ new\n@@ -1,1 +1,1 @@\n-SecurityGroups: %d\n+SecurityGroups: %d\n", 
-        len(old.SecurityGroups), len(new.SecurityGroups))
+func init() {
+    importCmd.Flags().StringVarP(&importOutput, "output", "o", "", "Write the configuration to a file instead of stdout")
+    importCmd.Flags().StringVar(&importName, "name", "", "Configuration name (defaults to the file name)")
+    importCmd.Flags().StringVar(&importEnvironment, "environment", "", "Environment recorded in the configuration metadata")
 }
 
-// pkg/config/parser.go
-package config
+// cmd/netgit/export.go
+package netgit
 
 import (
-    "encoding/json"
     "fmt"
     "io/ioutil"
-    "path/filepath"
+    "os"
     "strings"
     
-    "gopkg.in/yaml.v3"
+    "github.com/spf13/cobra"
+    "netgit/pkg/exporter"
+    "netgit/pkg/storage"
 )
 
-type NetworkConfig struct {
-    Metadata         Metadata          `yaml:"metadata" json:"metadata"`
-    SecurityGroups   []SecurityGroup   `yaml:"securityGroups" json:"securityGroups"`
-    NetworkPolicies  []NetworkPolicy   `yaml:"networkPolicies" json:"networkPolicies"`
-    FirewallRules    []FirewallRule    `yaml:"firewallRules" json:"firewallRules"`
-}
+var (
+    exportFormat string
+    exportOutput string
+)
 
-type Metadata struct {
-    Name        string            `yaml:"name" json:"name"`
-    Version     string            `yaml:"version" json:"version"`
-    Environment string            `yaml:"environment" json:"environment"`
-    Labels      map[string]string `yaml:"labels" json:"labels"`
+var exportCmd = &cobra.Command{
+    Use:   "export [revision]",
+    Short: "Render a committed configuration as Terraform or Kubernetes manifests",
+    Long: `Export renders the configuration recorded at a revision (HEAD by default)
+in a provider's native format:
+  
+  terraform-aws    aws_security_group resources
+  terraform-gcp    google_compute_firewall resources
+  terraform-azure  azurerm_network_security_group resources
+  k8s              networking.k8s.io/v1 NetworkPolicy manifests`,
+    Args: cobra.MaximumNArgs(1),
+    RunE: func(cmd *cobra.Command, args []string) error {
+        exp, err := exporter.GetExporter(exportFormat)
+        if err != nil {
+            return err
+        }
+        
+        repo, err := storage.OpenRepository(".")
+        if err != nil {
+            return err
+        }
+        defer repo.Close()
+        
+        revision := "HEAD"
+        if len(args) == 1 {
+            revision = args[0]
+        }
+        hash, err := repo.ResolveCommit(revision)
+        if err != nil {
+            return err
+        }
+        commit, err := repo.GetCommit(hash)
+        if err != nil {
+            return err
+        }
+        
+        data, err := exp.Export(commit.Config)
+        if err != nil {
+            return err
+        }
+        
+        if exportOutput == "" {
+            _, err = os.Stdout.Write(data)
+            return err
+        }
+        if err := ioutil.WriteFile(exportOutput, data, 0644); err != nil {
+            return err
+        }
+        fmt.Printf("Exported %s to %s\n", hash[:8], exportOutput)
+        return nil
+    },
 }
 
-type SecurityGroup struct {
-    Name        string `yaml:"name" json:"name"`
-    Description string `yaml:"description" json:"description"`
-    VpcId       string `yaml:"vpcId" json:"vpcId"`
-    Rules       []Rule `yaml:"rules" json:"rules"`
+func init() {
+    exportCmd.Flags().StringVarP(&exportFormat, "format", "f", "", "Output format ("+strings.Join(exporter.Formats, ", ")+")")
+    exportCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "Write to a file instead of stdout")
+    exportCmd.MarkFlagRequired("format")
 }
 
-type NetworkPolicy struct {
-    Name      string              `yaml:"name" json:"name"`
-    Namespace string              `yaml:"namespace" json:"namespace"`
-    Selector  map[string]string   `yaml:"selector" json:"selector"`
-    Ingress   []NetworkPolicyRule `yaml:"ingress" json:"ingress"`
-    Egress    []NetworkPolicyRule `yaml:"egress" json:"egress"`
-}
+// cmd/netgit/policy.go
+package netgit
 
-type FirewallRule struct {
-    Name         string   `yaml:"name" json:"name"`
-    Direction    string   `yaml:"direction" json:"direction"`
-    Priority     int      `yaml:"priority" json:"priority"`
-    Protocol     string   `yaml:"protocol" json:"protocol"`
-    Ports        []string `yaml:"ports" json:"ports"`
-    SourceRanges []string `yaml:"sourceRanges" json:"sourceRanges"`
-    TargetTags   []string `yaml:"targetTags" json:"targetTags"`
-}
+import (
+    "fmt"
+    "time"
+    
+    "github.com/spf13/cobra"
+    "netgit/pkg/policy"
+)
 
-type Rule struct {
-    Protocol string   `yaml:"protocol" json:"protocol"`
-    Ports    []string `yaml:"ports" json:"ports"`
-    Sources  []string `yaml:"sources" json:"sources"`
-    Action   string   `yaml:"action" json:"action"`
+var policyCmd = &cobra.Command{
+    Use:   "policy",
+    Short: "Work with the policies verify checks configurations against",
 }
 
-type NetworkPolicyRule struct {
-    Ports []NetworkPolicyPort `yaml:"ports" json:"ports"`
-    From  []NetworkPolicyPeer `yaml:"from" json:"from"`
+var policyTestCmd = &cobra.Command{
+    Use:   "test [dir]",
+    Short: "Run the Rego tests of the built-in rules and a policy directory",
+    Long: `Test runs every test_ rule in the Rego files of a policy directory
+("policies" by default), *_test.rego files included, together with the tests
+of the built-in rules those policies may build on.`,
+    Args: cobra.MaximumNArgs(1),
+    RunE: func(cmd *cobra.Command, args []string) error {
+        dir := "policies"
+        if len(args) == 1 {
+            dir = args[0]
+        }
+        
+        results, err := policy.RunTests(dir)
+        if err != nil {
+            return err
+        }
+        
+        failed := 0
+        for _, r := range results {
+            switch {
+            case r.Error != "":
+                failed++
+                fmt.Printf("ERROR %s.%s (%s): %s\n", r.Package, r.Name, r.Location, r.Error)
+            case !r.Passed:
+                failed++
+                fmt.Printf("FAIL  %s.%s (%s)\n", r.Package, r.Name, r.Location)
+            default:
+                fmt.Printf("PASS  %s.%s\n", r.Package, r.Name)
+            }
+        }
+        
+        fmt.Printf("\n%d passed, %d failed\n", len(results)-failed, failed)
+        if failed > 0 {
+            return fmt.Errorf("%d policy tests failed", failed)
+        }
+        return nil
+    },
 }
 
-type NetworkPolicyPort struct {
-    Protocol string `yaml:"protocol" json:"protocol"`
-    Port     string `yaml:"port" json:"port"`
+var policyListCmd = &cobra.Command{
+    Use:   "list [dir]",
+    Short: "List the policies verify applies and where each comes from",
+    Args:  cobra.MaximumNArgs(1),
+    RunE: func(cmd *cobra.Command, args []string) error {
+        dir := "policies"
+        if len(args) == 1 {
+            dir = args[0]
+        }
+        
+        engine, err := policy.NewEngine(dir)
+        if err != nil {
+            return err
+        }
+        
+        fmt.Printf("%-32s %-8s %-36s %s\n", "NAME", "SEVERITY", "EVALUATES", "SOURCE")
+        for _, p := range engine.Policies() {
+            evaluates := p.Rule
+            switch p.Kind() {
+            case "match":
+                evaluates = "match/assert"
+            case "rego":
+                evaluates = p.Module
+            }
+            source := p.Source
+            if p.Bundle != "" {
+                source = fmt.Sprintf("%s (%s)", p.Source, p.Bundle)
+            }
+            fmt.Printf("%-32s %-8s %-36s %s\n", p.Name, p.Severity, evaluates, source)
+        }
+        return nil
+    },
 }
 
-type NetworkPolicyPeer struct {
-    PodSelector       map[string]string `yaml:"podSelector" json:"podSelector"`
-    NamespaceSelector map[string]string `yaml:"namespaceSelector" json:"namespaceSelector"`
-}
+var signWaivers bool
 
-func LoadWorkingDirectory(path string) ([]NetworkConfig, error) {
-    var configs []NetworkConfig
-    
-    files, err := filepath.Glob(filepath.Join(path, "*.{yaml,yml,json}"))
-    if err != nil {
-        return nil, err
-    }
-    
-    for _, file := range files {
-        if strings.Contains(file, ".netgit") {
-            continue
+var policyWaiversCmd = &cobra.Command{
+    Use:   "waivers [dir]",
+    Short: "List the waivers in a policy directory's exceptions file",
+    Long: `Waivers lists the waivers in exceptions.yaml of a policy directory
+("policies" by default), whether each is still in force and whether its
+signature under NETGIT_WAIVER_KEY was checked. With --sign it also prints
+the signature each waiver needs, for the approver to add to the file.`,
+    Args: cobra.MaximumNArgs(1),
+    RunE: func(cmd *cobra.Command, args []string) error {
+        dir := "policies"
+        if len(args) == 1 {
+            dir = args[0]
         }
         
-        config, err := LoadFile(file)
+        key := waiverKey()
+        if signWaivers && len(key) == 0 {
+            return fmt.Errorf("--sign needs the waiver key in NETGIT_WAIVER_KEY")
+        }
+        // Signatures are being made, not checked
+        loadKey := key
+        if signWaivers {
+            loadKey = nil
+        }
+        waivers, err := policy.LoadWaivers(dir, loadKey, true)
         if err != nil {
-            continue // Skip invalid files
+            return err
         }
         
-        configs = append(configs, *config)
-    }
-    
-    if len(configs) == 0 {
-        // Return a default empty config
-        configs = append(configs, NetworkConfig{
-            Metadata: Metadata{
-                Name:        "default",
-                Version:     "1.0",
-                Environment: "development",
-            },
-        })
-    }
-    
-    return configs, nil
+        now := time.Now()
+        for _, w := range waivers.List() {
+            status := "active"
+            if !now.Before(w.ExpiresAt()) {
+                status = "expired"
+            }
+            fmt.Printf("%-8s %s\n", status, w)
+            fmt.Printf("         approved by %s until %s: %s\n", w.ApprovedBy, w.Expires, w.Justification)
+            if !w.Signed() && !signWaivers {
+                fmt.Printf("         unsigned: applies only with NETGIT_ALLOW_UNSIGNED_WAIVERS set\n")
+            }
+            if signWaivers {
+                fmt.Printf("         signature: %s\n", w.Sign(key))
+            }
+        }
+        return nil
+    },
 }
 
-func LoadFile(filename string) (*NetworkConfig, error) {
-    data, err := ioutil.ReadFile(filename)
-    if err != nil {
-        return nil, err
-    }
-    
-    var config NetworkConfig
-    
-    if strings.HasSuffix(filename, ".json") {
-        err = json.Unmarshal(data, &config)
-    } else {
-        err = yaml.Unmarshal(data, &config)
-    }
+func init() {
+    policyCmd.AddCommand(policyTestCmd)
+    policyCmd.AddCommand(policyListCmd)
+    policyCmd.AddCommand(policyWaiversCmd)
     
-    if err != nil {
-        return nil, fmt.Errorf("failed to parse %s: %w", filename, err)
-    }
+    policyWaiversCmd.Flags().BoolVar(&signWaivers, "sign", false, "Print the signature of each waiver")
+}
+
+// cmd/netgit/lint.go
+package netgit
+
+import (
+    "fmt"
+    "os"
     
-    return &config, nil
+    "github.com/spf13/cobra"
+    "netgit/pkg/config"
+    "netgit/pkg/policy"
+)
+
+var lintCmd = &cobra.Command{
+    Use:   "lint",
+    Short: "Find shadowed, redundant, overly broad and contradictory rules",
+    Long: `Lint checks the rules of each configuration against each other. It reports
+firewall rules a higher-priority rule hides, rules another rule already
+covers, allow rules open to the internet on every port, and allow and deny
+rules that overlap, each with the smallest rewrite that resolves it.`,
+    RunE: func(cmd *cobra.Command, args []string) error {
+        configFiles, err := config.LoadEnvironment(".", env)
+        if err != nil {
+            return err
+        }
+        
+        findings := []policy.Violation{}
+        for _, cfg := range configFiles {
+            findings = append(findings, policy.Lint(cfg)...)
+        }
+        
+        if len(findings) > 0 {
+            fmt.Printf("Found %d lint findings:\n\n", len(findings))
+            for _, v := range findings {
+                fmt.Printf("❌ %s (%s): %s\n", v.Rule, v.Level, v.Message)
+                fmt.Printf("   File: %s\n", v.File)
+                fmt.Printf("   Path: %s\n", v.Path)
+                fmt.Printf("   Suggestion: %s\n", v.Suggestion)
+                fmt.Println()
+            }
+            os.Exit(1)
+        }
+        
+        fmt.Printf("✅ No shadowed, redundant or conflicting rules found\n")
+        return nil
+    },
 }
 
-func (c *NetworkConfig) ToYAML() ([]byte, error) {
-    return yaml.Marshal(c)
+func init() {
+    lintCmd.Flags().StringVarP(&env, "env", "e", "", "Lint the configuration with this environment's variables and overlays")
 }
 
-func (c *NetworkConfig) ToJSON() ([]byte, error) {
-    return json.MarshalIndent(c, "", "  ")
+// cmd/netgit/query.go
+package netgit
+
+import (
+    "fmt"
+    "strings"
+    
+    "github.com/spf13/cobra"
+    "netgit/pkg/reach"
+    "netgit/pkg/storage"
+)
+
+var (
+    queryFrom     string
+    queryTo       string
+    queryProtocol string
+    queryPort     int
+    queryRevision string
+)
+
+var queryCmd = &cobra.Command{
+    Use:   "query",
+    Short: "Ask what a configuration allows",
 }
 
-func (c *NetworkConfig) Normalize() {
-    // Normalize configuration for consistent comparisons
-    if c.Labels == nil {
-        c.Labels = make(map[string]string)
-    }
+var queryReachableCmd = &cobra.Command{
+    Use:   "reachable",
+    Short: "Show whether one endpoint can reach another and the rules that decide it",
+    Long: `Reachable answers whether --from can open a connection to --to, and lists
+the firewall rules, security group rules and network policies that let the
+traffic through or block it. Endpoints are written as:
+  
+  203.0.113.7, 0.0.0.0/0   an address or CIDR
+  db-tier-sg, sg-0123      a security group, by name or id
+  tag:web                  the instances a firewall target tag selects
+  pod:prod/app=db          Kubernetes pods, by namespace and labels`,
+    Args: cobra.NoArgs,
+    RunE: func(cmd *cobra.Command, args []string) error {
+        flow := queryProtocol
+        if queryPort != 0 {
+            flow = fmt.Sprintf("%s/%d", queryProtocol, queryPort)
+        }
+        protocol, port, err := reach.ParseFlow(flow)
+        if err != nil {
+            return err
+        }
+        
+        repo, err := storage.OpenRepository(".")
+        if err != nil {
+            return err
+        }
+        defer repo.Close()
+        
+        cfg, err := repo.EnvironmentConfig(queryRevision, env)
+        if err != nil {
+            return err
+        }
+        
+        model := reach.New(cfg)
+        from, err := model.Endpoint(queryFrom)
+        if err != nil {
+            return err
+        }
+        to, err := model.Endpoint(queryTo)
+        if err != nil {
+            return err
+        }
+        
+        result := model.Reachable(from, to, protocol, port)
+        switch {
+        case result.Allowed:
+            fmt.Printf("✅ %s can reach %s on %s/%d\n", from, to, protocol, port)
+        case len(result.Partial) > 0:
+            fmt.Printf("⚠️  %s can reach %s on %s/%d only for %s\n", from, to, protocol, port, strings.Join(result.Partial, ", "))
+        default:
+            fmt.Printf("❌ %s cannot reach %s on %s/%d: %s\n", from, to, protocol, port, result.Reason)
+        }
+        for i, step := range result.Chain {
+            fmt.Printf("   %d. %s\n", i+1, step)
+        }
+        if len(result.Partial) > 0 {
+            fmt.Printf("   The rest is blocked: %s\n", result.Reason)
+        }
+        return nil
+    },
 }
 
-// pkg/policy/engine.go
-package policy
+func init() {
+    queryReachableCmd.Flags().StringVar(&queryFrom, "from", "", "Source endpoint")
+    queryReachableCmd.Flags().StringVar(&queryTo, "to", "", "Destination endpoint")
+    queryReachableCmd.Flags().StringVar(&queryProtocol, "protocol", "tcp", "Protocol, or an application protocol such as mysql for its port")
+    queryReachableCmd.Flags().IntVar(&queryPort, "port", 0, "Destination port")
+    queryReachableCmd.Flags().StringVar(&queryRevision, "rev", "WORKING", "Revision to query, or WORKING for the working directory")
+    queryReachableCmd.Flags().StringVarP(&env, "env", "e", "", "Query the configuration with this environment's variables and overlays")
+    queryReachableCmd.MarkFlagRequired("from")
+    queryReachableCmd.MarkFlagRequired("to")
+    queryCmd.AddCommand(queryReachableCmd)
+}
+
+// pkg/storage/repository.go
+package storage
 
 import (
+    "bytes"
     "encoding/json"
+    "errors"
     "fmt"
-    "io/ioutil"
+    "os"
     "path/filepath"
+    "reflect"
     "strings"
+    "time"
     
+    "go.etcd.io/bbolt"
     "netgit/pkg/config"
+    "netgit/pkg/diff"
 )
 
-type Engine struct {
-    policies []Policy
+type Repository struct {
+    db   *bbolt.DB
+    path string
 }
 
-type Policy struct {
-    Name        string `yaml:"name" json:"name"`
-    Description string `yaml:"description" json:"description"`
-    Rule        string `yaml:"rule" json:"rule"`
-    Severity    string `yaml:"severity" json:"severity"`
+type Commit struct {
+    Hash        string                 `json:"hash"`
+    Tree        string                 `json:"tree,omitempty"`
+    Parent      string                 `json:"parent"`
+    MergeParent string                 `json:"mergeParent,omitempty"`
+    Message     string                 `json:"message"`
+    Author      string                 `json:"author"`
+    Timestamp   time.Time              `json:"timestamp"`
+    Config      config.NetworkConfig   `json:"config"`
+    Files       []config.NetworkConfig `json:"files,omitempty"`
 }
 
-type Violation struct {
-    Rule    string `json:"rule"`
-    Message string `json:"message"`
-    File    string `json:"file"`
-    Path    string `json:"path"`
-    Level   string `json:"level"`
+type Diff struct {
+    Added    []string              `json:"added"`
+    Modified []string              `json:"modified"`
+    Deleted  []string              `json:"deleted"`
+    Changes  []diff.ResourceChange `json:"changes"`
+    Content  string                `json:"-"`
 }
 
-func NewEngine(policyDir string) (*Engine, error) {
-    engine := &Engine{}
-    
-    // Load default policies if no policy directory exists
-    engine.policies = []Policy{
-        {
-            Name:        "no-public-db",
-            Description: "Database must never be publicly accessible",
-            Rule:        "deny_public_database",
-            Severity:    "high",
-        },
-        {
-            Name:        "require-redundant-routes",
-            Description: "Always require at least 2 redundant routes",
-            Rule:        "require_redundancy",
-            Severity:    "medium",
-        },
-        {
-            Name:        "secure-protocols",
-            Description: "Only allow secure protocols (HTTPS, SSH)",
-            Rule:        "secure_protocols_only",
-            Severity:    "high",
-        },
-    }
-    
-    // Try to load additional policies from directory
-    if policyFiles, err := filepath.Glob(filepath.Join(policyDir, "*.json")); err == nil {
-        for _, file := range policyFiles {
-            if policy, err := loadPolicyFile(file); err == nil {
-                engine.policies = append(engine.policies, *policy)
-            }
+type Status struct {
+    Branch   string             `json:"branch"`
+    Detached string             `json:"detached,omitempty"`
+    Merge    *MergeState        `json:"merge,omitempty"`
+    Clean    bool               `json:"clean"`
+    Added    []string           `json:"added"`
+    Modified []string           `json:"modified"`
+    Deleted  []string           `json:"deleted"`
+    Invalid  []config.FileError `json:"invalid"`
+}
+
+func (d *Diff) String() string {
+    return d.Content
+}
+
+// Format renders the diff as unified text, colored text or JSON
+func (d *Diff) Format(format string) (string, error) {
+    if format == diff.FormatJSON {
+        data, err := json.MarshalIndent(d, "", "  ")
+        if err != nil {
+            return "", err
         }
+        return string(data) + "\n", nil
     }
-    
-    return engine, nil
+    result := &diff.Result{Changes: d.Changes}
+    return result.Format(format)
 }
 
-func loadPolicyFile(filename string) (*Policy, error) {
-    data, err := ioutil.ReadFile(filename)
-    if err != nil {
-        return nil, err
+// Files lists the source files touched by the diff
+func (d *Diff) Files() []string {
+    var files []string
+    seen := map[string]bool{}
+    for _, change := range d.Changes {
+        if !seen[change.File] {
+            seen[change.File] = true
+            files = append(files, change.File)
+        }
     }
-    
-    var policy Policy
-    err = json.Unmarshal(data, &policy)
-    return &policy, err
+    return files
 }
 
-func (e *Engine) Verify(config config.NetworkConfig) ([]Violation, error) {
-    var violations []Violation
-    
-    for _, policy := range e.policies {
-        policyViolations := e.checkPolicy(config, policy)
-        violations = append(violations, policyViolations...)
+func newDiff(before, after []config.NetworkConfig) *Diff {
+    result := diff.CompareFiles(before, after)
+    return &Diff{
+        Added:    result.IDs(diff.Added),
+        Modified: result.IDs(diff.Modified),
+        Deleted:  result.IDs(diff.Removed),
+        Changes:  result.Changes,
+        Content:  result.String(),
     }
-    
-    return violations, nil
 }
 
-func (e *Engine) checkPolicy(config config.NetworkConfig, policy Policy) []Violation {
-    var violations []Violation
+func NewRepository(path string) (*Repository, error) {
+    netgitDir := filepath.Join(path, ".netgit")
+    if err := os.MkdirAll(netgitDir, 0755); err != nil {
+        return nil, err
+    }
     
-    switch policy.Rule {
-    case "deny_public_database":
-        violations = append(violations, e.checkPublicDatabase(config, policy)...)
-    case "require_redundancy":
-        violations = append(violations, e.checkRedundancy(config, policy)...)
-    case "secure_protocols_only":
-        violations = append(violations, e.checkSecureProtocols(config, policy)...)
+    dbPath := filepath.Join(netgitDir, "objects.db")
+    db, err := bbolt.Open(dbPath, 0600, nil)
+    if err != nil {
+        return nil, err
     }
     
-    return violations
-}
-
-func (e *Engine) checkPublicDatabase(config config.NetworkConfig, policy Policy) []Violation {
-    var violations []Violation
+    repo := &Repository{db: db, path: path}
     
-    for _, sg := range config.SecurityGroups {
-        for _, rule := range sg.Rules {
-            if strings.Contains(rule.Protocol, "mysql") || strings.Contains(rule.Protocol, "postgres") {
-                for _, source := range rule.Sources {
-                    if source == "0.0.0.0/0" {
-                        violations = append(violations, Violation{
-                            Rule:    policy.Name,
-                            Message: "Database security group allows public access",
-                            File:    sg.Name,
-                            Path:    "securityGroups.rules.sources",
-                            Level:   policy.Severity,
-                        })
-                    }
-                }
-            }
+    // Initialize buckets
+    err = db.Update(func(tx *bbolt.Tx) error {
+        if err := initBuckets(tx); err != nil {
+            return err
         }
-    }
+        
+        // Set initial branch
+        refs := tx.Bucket([]byte("refs"))
+        if refs.Get([]byte("HEAD")) == nil {
+            refs.Put([]byte("HEAD"), []byte("refs/heads/main"))
+        }
+        
+        return migrateLegacyCommits(tx)
+    })
     
-    return violations
+    return repo, err
 }
 
-func (e *Engine) checkRedundancy(config config.NetworkConfig, policy Policy) []Violation {
-    var violations []Violation
+func OpenRepository(path string) (*Repository, error) {
+    netgitDir := filepath.Join(path, ".netgit")
+    dbPath := filepath.Join(netgitDir, "objects.db")
     
-    if len(config.FirewallRules) < 2 {
-        violations = append(violations, Violation{
-            Rule:    policy.Name,
-            Message: "Insufficient redundant routes configured",
-            File:    "config",
-            Path:    "firewallRules",
-            Level:   policy.Severity,
-        })
+    if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+        return nil, fmt.Errorf("not a netgit repository")
     }
     
-    return violations
-}
-
-func (e *Engine) checkSecureProtocols(config config.NetworkConfig, policy Policy) []Violation {
-    var violations []Violation
-    insecureProtocols := []string{"http", "ftp", "telnet"}
+    db, err := bbolt.Open(dbPath, 0600, nil)
+    if err != nil {
+        return nil, err
+    }
     
-    for _, sg := range config.SecurityGroups {
-        for _, rule := range sg.Rules {
-            for _, insecure := range insecureProtocols {
-                if strings.ToLower(rule.Protocol) == insecure {
-                    violations = append(violations, Violation{
-                        Rule:    policy.Name,
-                        Message: fmt.Sprintf("Insecure protocol '%s' is not allowed", rule.Protocol),
-                        File:    sg.Name,
-                        Path:    "securityGroups.rules.protocol",
-                        Level:   policy.Severity,
-                    })
-                }
-            }
+    // Repositories created before the object store keep whole commits in
+    // the "commits" bucket; rewrite them on first open.
+    err = db.Update(func(tx *bbolt.Tx) error {
+        if err := initBuckets(tx); err != nil {
+            return err
         }
+        return migrateLegacyCommits(tx)
+    })
+    if err != nil {
+        db.Close()
+        return nil, fmt.Errorf("failed to migrate repository: %w", err)
     }
     
-    return violations
+    return &Repository{db: db, path: path}, nil
 }
 
-// pkg/deploy/deployer.go
-package deploy
+func (r *Repository) Close() error {
+    return r.db.Close()
+}
 
-import (
-    "fmt"
-    "time"
-    
-    "netgit/pkg/config"
-)
+func (r *Repository) Path() string {
+    return r.path
+}
 
-type Deployer interface {
-    DryRun(config config.NetworkConfig) error
-    Apply(config config.NetworkConfig) error
-    Rollback(config config.NetworkConfig) error
-    CanaryDeploy(config config.NetworkConfig, percentage int) error
-    ExpandCanary(config config.NetworkConfig) error
-}
-
-type Deployment struct {
-    CommitHash string    `json:"commit_hash"`
-    Target     string    `json:"target"`
-    Canary     bool      `json:"canary"`
-    Timestamp  time.Time `json:"timestamp"`
-    Status     string    `json:"status"`
+func (r *Repository) Commit(configs []config.NetworkConfig, message, author string) (*Commit, error) {
+    if state, err := r.mergeState(); err != nil {
+        return nil, err
+    } else if state != nil {
+        return nil, fmt.Errorf("merge in progress; run 'netgit merge --continue' or 'netgit merge --abort'")
+    }
+    
+    return r.commit(configs, message, author, "")
 }
 
-func GetDeployer(target string) (Deployer, error) {
-    switch target {
-    case "mock":
-        return NewMockDeployer(), nil
-    case "aws":
-        return NewAWSDeployer(), nil
-    case "gcp":
-        return NewGCPDeployer(), nil
-    case "azure":
-        return NewAzureDeployer(), nil
-    case "k8s", "kubernetes":
-        return NewKubernetesDeployer(), nil
-    default:
-        return nil, fmt.Errorf("unknown deployment target: %s", target)
+func (r *Repository) commit(configs []config.NetworkConfig, message, author, mergeParent string) (*Commit, error) {
+    if len(configs) == 0 {
+        return nil, fmt.Errorf("no configurations to commit")
+    }
+    
+    mergedConfig := mergeConfigs(configs)
+    
+    commit := &Commit{
+        Message:     message,
+        Author:      author,
+        Timestamp:   time.Now(),
+        Config:      mergedConfig,
+        Files:       configs,
+        MergeParent: mergeParent,
+    }
+    
+    // The parent is HEAD, unless the branch has no commits yet; a HEAD
+    // that cannot be read must not turn this into a new root commit
+    if !r.unborn() {
+        head, err := r.GetHEAD()
+        if err != nil {
+            return nil, err
+        }
+        commit.Parent = head.Hash
     }
+    
+    return commit, r.storeCommit(commit)
 }
 
-// Mock Deployer for testing
-type MockDeployer struct{}
-
-func NewMockDeployer() *MockDeployer {
-    return &MockDeployer{}
+func (r *Repository) GetCommit(hash string) (*Commit, error) {
+    var commit *Commit
+    err := r.db.View(func(tx *bbolt.Tx) error {
+        var err error
+        commit, err = readCommit(tx, hash)
+        return err
+    })
+    return commit, err
 }
 
-func (m *MockDeployer) DryRun(config config.NetworkConfig) error {
-    fmt.Printf("Mock: Dry run for %d security groups, %d policies, %d firewall rules\n", 
-        len(config.SecurityGroups), len(config.NetworkPolicies), len(config.FirewallRules))
-    return nil
+func (r *Repository) GetHEAD() (*Commit, error) {
+    var commitHash string
+    err := r.db.View(func(tx *bbolt.Tx) error {
+        var err error
+        commitHash, err = headCommit(tx)
+        return err
+    })
+    if err != nil {
+        return nil, err
+    }
+    
+    return r.GetCommit(commitHash)
 }
 
-func (m *MockDeployer) Apply(config config.NetworkConfig) error {
-    fmt.Printf("Mock: Applying configuration...\n")
-    time.Sleep(2 * time.Second) // Simulate deployment time
-    fmt.Printf("Mock: Applied %d security groups, %d policies, %d firewall rules\n", 
-        len(config.SecurityGroups), len(config.NetworkPolicies), len(config.FirewallRules))
-    return nil
+func (r *Repository) Diff(rev1, rev2 string) (*Diff, error) {
+    return r.DiffEnvironment(rev1, rev2, "")
 }
 
-func (m *MockDeployer) Rollback(config config.NetworkConfig) error {
-    fmt.Printf("Mock: Rolling back configuration...\n")
-    time.Sleep(1 * time.Second)
-    fmt.Printf("Mock: Rollback completed\n")
-    return nil
+// DiffEnvironment compares two revisions as the environment env sees them,
+// after variables are resolved and its overlays applied. With env empty it
+// compares the files as committed.
+func (r *Repository) DiffEnvironment(rev1, rev2, env string) (*Diff, error) {
+    files := r.revisionFiles
+    if env != "" {
+        files = func(rev string) ([]config.NetworkConfig, error) {
+            return r.EnvironmentFiles(rev, env)
+        }
+    }
+    
+    before, err := files(rev1)
+    if err != nil {
+        return nil, err
+    }
+    
+    after, err := files(rev2)
+    if err != nil {
+        return nil, err
+    }
+    
+    return newDiff(before, after), nil
 }
 
-func (m *MockDeployer) CanaryDeploy(config config.NetworkConfig, percentage int) error {
-    fmt.Printf("Mock: Deploying to %d%% of targets...\n", percentage)
-    time.Sleep(1 * time.Second)
-    return nil
+// EnvironmentFiles loads the files recorded at rev, or on disk for
+// "WORKING", as the environment env sees them. A commit's files are
+// written out to a temporary directory and loaded from there, so that
+// variables and overlays resolve from the committed sources.
+func (r *Repository) EnvironmentFiles(rev, env string) ([]config.NetworkConfig, error) {
+    if rev == "WORKING" {
+        return config.LoadEnvironment(r.path, env)
+    }
+    
+    hash, err := r.ResolveCommit(rev)
+    if err != nil {
+        return nil, err
+    }
+    commit, err := r.GetCommit(hash)
+    if err != nil {
+        return nil, err
+    }
+    
+    dir, err := os.MkdirTemp("", "netgit-env")
+    if err != nil {
+        return nil, err
+    }
+    defer os.RemoveAll(dir)
+    
+    if err := writeFiles(dir, commit.SourceFiles()); err != nil {
+        return nil, err
+    }
+    return config.LoadEnvironment(dir, env)
 }
 
-func (m *MockDeployer) ExpandCanary(config config.NetworkConfig) error {
-    fmt.Printf("Mock: Expanding canary to 100%%...\n")
-    time.Sleep(1 * time.Second)
-    return nil
+// EnvironmentConfig is the config deployed to env from rev, the
+// environment's counterpart of Commit.Config
+func (r *Repository) EnvironmentConfig(rev, env string) (config.NetworkConfig, error) {
+    files, err := r.EnvironmentFiles(rev, env)
+    if err != nil {
+        return config.NetworkConfig{}, err
+    }
+    return mergeConfigs(files), nil
 }
 
-// AWS Deployer (stub implementation)
-type AWSDeployer struct{}
-
-func NewAWSDeployer() *AWSDeployer {
-    return &AWSDeployer{}
+// revisionFiles loads the files recorded at rev, where rev is anything
+// ResolveCommit accepts, or "WORKING" for the files on disk.
+func (r *Repository) revisionFiles(rev string) ([]config.NetworkConfig, error) {
+    if rev == "WORKING" {
+        return config.LoadWorkingDirectory(r.path)
+    }
+    
+    hash, err := r.ResolveCommit(rev)
+    if err != nil {
+        return nil, err
+    }
+    commit, err := r.GetCommit(hash)
+    if err != nil {
+        return nil, err
+    }
+    return commit.SourceFiles(), nil
 }
 
-func (a *AWSDeployer) DryRun(config config.NetworkConfig) error {
-    fmt.Printf("AWS: Validating security groups and VPC configuration\n")
-    return nil
+func (r *Repository) GetHistory() ([]*Commit, error) {
+    return r.History("HEAD")
 }
 
-func (a *AWSDeployer) Apply(config config.NetworkConfig) error {
-    fmt.Printf("AWS: Applying security groups to VPC\n")
-    for _, sg := range config.SecurityGroups {
-        fmt.Printf("AWS: Creating/updating security group %s\n", sg.Name)
+// History lists the first-parent history of a revision, newest first. For
+// an "A..B" range it lists the commits of B that are not ancestors of A.
+func (r *Repository) History(rev string) ([]*Commit, error) {
+    var commits []*Commit
+    
+    exclude := map[string]bool{}
+    if from, to, ok := SplitRange(rev); ok {
+        fromHash, err := r.ResolveCommit(from)
+        if err != nil {
+            return nil, err
+        }
+        err = r.walkAncestors(fromHash, func(commit *Commit) bool {
+            exclude[commit.Hash] = true
+            return true
+        })
+        if err != nil {
+            return nil, err
+        }
+        rev = to
     }
-    return nil
+    
+    hash, err := r.ResolveCommit(rev)
+    if err != nil {
+        return commits, err
+    }
+    
+    current, err := r.GetCommit(hash)
+    if err != nil {
+        return commits, err
+    }
+    for current != nil && !exclude[current.Hash] {
+        commits = append(commits, current)
+        if current.Parent == "" {
+            break
+        }
+        current, err = r.GetCommit(current.Parent)
+        if err != nil {
+            return nil, err
+        }
+    }
+    
+    return commits, nil
 }
 
-func (a *AWSDeployer) Rollback(config config.NetworkConfig) error {
-    fmt.Printf("AWS: Rolling back security group changes\n")
-    return nil
+func (r *Repository) Status() (*Status, error) {
+    branch, err := r.CurrentBranch()
+    if err != nil {
+        return nil, err
+    }
+    status := &Status{Branch: branch}
+    
+    status.Merge, err = r.mergeState()
+    if err != nil {
+        return nil, err
+    }
+    
+    var committed []config.NetworkConfig
+    legacy := false
+    if head, err := r.GetHEAD(); err == nil {
+        committed = head.SourceFiles()
+        legacy = len(head.Files) == 0
+        if branch == "" {
+            status.Detached = head.Hash
+        }
+    }
+    
+    working, err := config.LoadWorkingDirectory(r.path)
+    var loadErr *config.LoadError
+    if errors.As(err, &loadErr) {
+        status.Invalid = loadErr.Files
+    } else if err != nil {
+        return nil, err
+    }
+    
+    // A migrated legacy commit holds the config merged from every file
+    // rather than the files, so the working files are compared as a whole
+    if legacy {
+        changed := fileChanged(committed[0], mergeConfigs(working))
+        if changed {
+            for _, file := range working {
+                status.Modified = append(status.Modified, file.Source)
+            }
+        }
+        status.Clean = !changed && len(status.Invalid) == 0
+        return status, nil
+    }
+    
+    committedByFile := map[string]config.NetworkConfig{}
+    for _, file := range committed {
+        committedByFile[file.Source] = file
+    }
+    
+    onDisk := map[string]bool{}
+    for _, file := range working {
+        onDisk[file.Source] = true
+        previous, ok := committedByFile[file.Source]
+        if !ok {
+            status.Added = append(status.Added, file.Source)
+        } else if fileChanged(previous, file) {
+            status.Modified = append(status.Modified, file.Source)
+        }
+    }
+    
+    // A file that exists but fails to parse is reported as invalid, not deleted
+    for _, invalid := range status.Invalid {
+        onDisk[invalid.File] = true
+    }
+    for _, file := range committed {
+        if !onDisk[file.Source] {
+            status.Deleted = append(status.Deleted, file.Source)
+        }
+    }
+    
+    status.Clean = len(status.Added) == 0 && len(status.Modified) == 0 &&
+        len(status.Deleted) == 0 && len(status.Invalid) == 0
+    return status, nil
 }
 
-func (a *AWSDeployer) CanaryDeploy(config config.NetworkConfig, percentage int) error {
-    fmt.Printf("AWS: Canary deploy to %d%% of instances\n", percentage)
-    return nil
+func fileChanged(committed, working config.NetworkConfig) bool {
+    if committed.Fragment || working.Fragment {
+        return committed.Fragment != working.Fragment || !bytes.Equal(committed.Raw, working.Raw)
+    }
+    if !reflect.DeepEqual(committed.Canonical().Metadata, working.Canonical().Metadata) {
+        return true
+    }
+    if !sameJSON(committed.Variables, working.Variables) || !sameJSON(committed.Delete, working.Delete) {
+        return true
+    }
+    return !diff.Compare(committed, working).Empty()
 }
 
-func (a *AWSDeployer) ExpandCanary(config config.NetworkConfig) error {
-    fmt.Printf("AWS: Expanding to all instances\n")
-    return nil
+// sameJSON compares values as they are stored. Variable values read back
+// from a commit hold float64 where the working file's decode as int, and
+// an empty list or map is stored as none.
+func sameJSON(a, b interface{}) bool {
+    normalize := func(v interface{}) string {
+        data, _ := json.Marshal(v)
+        switch string(data) {
+        case "[]", "{}":
+            return "null"
+        }
+        return string(data)
+    }
+    return normalize(a) == normalize(b)
 }
 
-// GCP Deployer (stub implementation)
-type GCPDeployer struct{}
-
-func NewGCPDeployer() *GCPDeployer {
-    return &GCPDeployer{}
+func (r *Repository) ListBranches() ([]string, error) {
+    var branches []string
+    
+    err := r.db.View(func(tx *bbolt.Tx) error {
+        c := tx.Bucket([]byte("refs")).Cursor()
+        prefix := []byte(branchPrefix)
+        for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+            branches = append(branches, strings.TrimPrefix(string(k), branchPrefix))
+        }
+        return nil
+    })
+    
+    return branches, err
 }
 
-func (g *GCPDeployer) DryRun(config config.NetworkConfig) error {
-    fmt.Printf("GCP: Validating firewall rules\n")
-    return nil
+// CurrentBranch returns the branch HEAD follows, or "" when HEAD is detached
+func (r *Repository) CurrentBranch() (string, error) {
+    var branch string
+    err := r.db.View(func(tx *bbolt.Tx) error {
+        head := tx.Bucket([]byte("refs")).Get([]byte("HEAD"))
+        if head == nil {
+            return fmt.Errorf("no HEAD found")
+        }
+        if isSymbolicRef(head) {
+            branch = strings.TrimPrefix(string(head), branchPrefix)
+        }
+        return nil
+    })
+    return branch, err
 }
 
-func (g *GCPDeployer) Apply(config config.NetworkConfig) error {
-    fmt.Printf("GCP: Applying firewall rules\n")
-    for _, rule := range config.FirewallRules {
-        fmt.Printf("GCP: Creating/updating firewall rule %s\n", rule.Name)
+func (r *Repository) CreateBranch(name string) error {
+    if err := validateRefName("branch", name); err != nil {
+        return err
     }
-    return nil
+    
+    head, err := r.GetHEAD()
+    if err != nil {
+        return err
+    }
+    
+    return r.db.Update(func(tx *bbolt.Tx) error {
+        refs := tx.Bucket([]byte("refs"))
+        branchRef := branchPrefix + name
+        if refs.Get([]byte(branchRef)) != nil {
+            return fmt.Errorf("branch '%s' already exists", name)
+        }
+        return refs.Put([]byte(branchRef), []byte(head.Hash))
+    })
 }
 
-func (g *GCPDeployer) Rollback(config config.NetworkConfig) error {
-    fmt.Printf("GCP: Rolling back firewall rules\n")
-    return nil
+func (r *Repository) storeCommit(commit *Commit) error {
+    return r.db.Update(func(tx *bbolt.Tx) error {
+        if err := writeCommit(tx, commit); err != nil {
+            return err
+        }
+        
+        return advanceHEAD(tx, commit.Hash)
+    })
 }
 
-func (g *GCPDeployer) CanaryDeploy(config config.NetworkConfig, percentage int) error {
-    fmt.Printf("GCP: Canary deploy to %d%% of targets\n", percentage)
-    return nil
+// mergeConfigs combines per-file configs into the single config handed to
+// deployers. A lone file keeps its metadata; otherwise only an environment
+// shared by every file is kept. Overlays are left out: they only apply to
+// an environment, through EnvironmentConfig.
+func mergeConfigs(configs []config.NetworkConfig) config.NetworkConfig {
+    var files []config.NetworkConfig
+    for _, cfg := range configs {
+        if !cfg.Fragment && !cfg.Metadata.Overlay {
+            files = append(files, cfg)
+        }
+    }
+    
+    var merged config.NetworkConfig
+    if len(files) == 1 {
+        merged.Metadata = files[0].Metadata
+    }
+    
+    for i, cfg := range files {
+        if i == 0 {
+            merged.Metadata.Environment = cfg.Metadata.Environment
+        } else if merged.Metadata.Environment != cfg.Metadata.Environment {
+            merged.Metadata.Environment = ""
+        }
+        merged.SecurityGroups = append(merged.SecurityGroups, cfg.SecurityGroups...)
+        merged.NetworkPolicies = append(merged.NetworkPolicies, cfg.NetworkPolicies...)
+        merged.FirewallRules = append(merged.FirewallRules, cfg.FirewallRules...)
+    }
+    
+    return merged
 }
 
-func (g *GCPDeployer) ExpandCanary(config config.NetworkConfig) error {
-    fmt.Printf("GCP: Expanding to all targets\n")
-    return nil
+// SourceFiles returns the files recorded by the commit. Commits migrated
+// from before files were tracked report their config as a single file.
+func (c *Commit) SourceFiles() []config.NetworkConfig {
+    if len(c.Files) > 0 {
+        return c.Files
+    }
+    return []config.NetworkConfig{c.Config}
 }
 
-// Azure Deployer (stub implementation)
-type AzureDeployer struct{}
-
-func NewAzureDeployer() *AzureDeployer {
-    return &AzureDeployer{}
+// WriteFiles writes committed files back into the working directory. Files
+// are written byte-for-byte as committed; files committed without their
+// original bytes are rendered in the format their extension names.
+func (r *Repository) WriteFiles(files []config.NetworkConfig) error {
+    return writeFiles(r.path, files)
 }
 
-func (az *AzureDeployer) DryRun(config config.NetworkConfig) error {
-    fmt.Printf("Azure: Validating Network Security Groups\n")
+func writeFiles(root string, files []config.NetworkConfig) error {
+    for _, file := range files {
+        if file.Source == "" || !filepath.IsLocal(filepath.FromSlash(file.Source)) {
+            return fmt.Errorf("refusing to write file outside the working directory: %q", file.Source)
+        }
+        
+        data := file.Raw
+        if data == nil {
+            var err error
+            if strings.HasSuffix(file.Source, ".json") {
+                data, err = file.ToJSON()
+            } else {
+                data, err = file.ToYAML()
+            }
+            if err != nil {
+                return err
+            }
+        }
+        
+        target := filepath.Join(root, filepath.FromSlash(file.Source))
+        if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+            return err
+        }
+        if err := os.WriteFile(target, data, 0644); err != nil {
+            return err
+        }
+    }
     return nil
 }
 
-func (az *AzureDeployer) Apply(config config.NetworkConfig) error {
-    fmt.Printf("Azure: Applying Network Security Groups\n")
-    return nil
-}
+// pkg/storage/objects.go
+package storage
 
-func (az *AzureDeployer) Rollback(config config.NetworkConfig) error {
-    fmt.Printf("Azure: Rolling back NSG changes\n")
-    return nil
-}
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+    
+    "go.etcd.io/bbolt"
+    "netgit/pkg/config"
+)
 
-func (az *AzureDeployer) CanaryDeploy(config config.NetworkConfig, percentage int) error {
-    fmt.Printf("Azure: Canary deploy to %d%% of resource groups\n", percentage)
-    return nil
-}
+// Objects are stored git-style: a "<type> <size>\x00" header followed by the
+// canonical JSON body, keyed by the full SHA-256 of header and body.
+type ObjectType string
 
-func (az *AzureDeployer) ExpandCanary(config config.NetworkConfig) error {
-    fmt.Printf("Azure: Expanding to all resource groups\n")
-    return nil
-}
+const (
+    BlobObject   ObjectType = "blob"
+    TreeObject   ObjectType = "tree"
+    CommitObject ObjectType = "commit"
+    TagObject    ObjectType = "tag"
+)
 
-// Kubernetes Deployer (stub implementation)
-type KubernetesDeployer struct{}
+// KindFile marks a root tree entry pointing at the subtree of one source file
+const KindFile = "file"
 
-func NewKubernetesDeployer() *KubernetesDeployer {
-    return &KubernetesDeployer{}
+type TreeEntry struct {
+    Kind string `json:"kind"`
+    Name string `json:"name"`
+    Hash string `json:"hash"`
 }
 
-func (k *KubernetesDeployer) DryRun(config config.NetworkConfig) error {
-    fmt.Printf("Kubernetes: Validating network policies\n")
-    return nil
+// Tree is either a root tree of file entries or the tree of one file. A file
+// tree also points at a blob holding the file's original bytes.
+type Tree struct {
+    Metadata  config.Metadata            `json:"metadata"`
+    Raw       string                     `json:"raw,omitempty"`
+    Fragment  bool                       `json:"fragment,omitempty"`
+    Variables map[string]config.Variable `json:"variables,omitempty"`
+    Delete    []string                   `json:"delete,omitempty"`
+    Entries   []TreeEntry                `json:"entries"`
 }
 
-func (k *KubernetesDeployer) Apply(config config.NetworkConfig) error {
-    fmt.Printf("Kubernetes: Applying network policies\n")
-    for _, policy := range config.NetworkPolicies {
-        fmt.Printf("Kubernetes: Creating/updating NetworkPolicy %s in namespace %s\n", 
-            policy.Name, policy.Namespace)
-    }
-    return nil
+type commitObject struct {
+    Tree        string    `json:"tree"`
+    Parent      string    `json:"parent,omitempty"`
+    MergeParent string    `json:"mergeParent,omitempty"`
+    Message     string    `json:"message"`
+    Author      string    `json:"author"`
+    Timestamp   time.Time `json:"timestamp"`
 }
 
-func (k *KubernetesDeployer) Rollback(config config.NetworkConfig) error {
-    fmt.Printf("Kubernetes: Rolling back network policies\n")
+func initBuckets(tx *bbolt.Tx) error {
+    buckets := []string{"objects", "refs", "branches", "config", "remotes"}
+    for _, bucket := range buckets {
+        if _, err := tx.CreateBucketIfNotExists([]byte(bucket)); err != nil {
+            return err
+        }
+    }
     return nil
 }
 
-func (k *KubernetesDeployer) CanaryDeploy(config config.NetworkConfig, percentage int) error {
-    fmt.Printf("Kubernetes: Canary deploy to %d%% of namespaces\n", percentage)
-    return nil
+func HashObject(typ ObjectType, data []byte) string {
+    h := sha256.New()
+    h.Write(objectHeader(typ, data))
+    h.Write(data)
+    return hex.EncodeToString(h.Sum(nil))
 }
 
-func (k *KubernetesDeployer) ExpandCanary(config config.NetworkConfig) error {
-    fmt.Printf("Kubernetes: Expanding to all namespaces\n")
-    return nil
+func objectHeader(typ ObjectType, data []byte) []byte {
+    return []byte(fmt.Sprintf("%s %d\x00", typ, len(data)))
 }
 
-// pkg/audit/logger.go
-package audit
-
-import (
-    "encoding/json"
-    "fmt"
-    "os"
-    "time"
+func decodeObject(raw []byte) (ObjectType, []byte, error) {
+    idx := bytes.IndexByte(raw, 0)
+    if idx < 0 {
+        return "", nil, fmt.Errorf("missing object header")
+    }
     
-    "go.uber.org/zap"
-    "go.uber.org/zap/zapcore"
-)
+    header := strings.SplitN(string(raw[:idx]), " ", 2)
+    if len(header) != 2 {
+        return "", nil, fmt.Errorf("malformed object header %q", raw[:idx])
+    }
+    
+    data := raw[idx+1:]
+    size, err := strconv.Atoi(header[1])
+    if err != nil || size != len(data) {
+        return "", nil, fmt.Errorf("object size mismatch")
+    }
+    
+    return ObjectType(header[0]), data, nil
+}
 
-var logger *zap.Logger
+func putObject(tx *bbolt.Tx, typ ObjectType, data []byte) (string, error) {
+    hash := HashObject(typ, data)
+    bucket := tx.Bucket([]byte("objects"))
+    if bucket.Get([]byte(hash)) != nil {
+        return hash, nil
+    }
+    
+    raw := append(objectHeader(typ, data), data...)
+    return hash, bucket.Put([]byte(hash), raw)
+}
 
-func init() {
-    config := zap.NewProductionConfig()
-    config.OutputPaths = []string{"stdout", "audit.log"}
-    config.ErrorOutputPaths = []string{"stderr"}
-    config.EncoderConfig.TimeKey = "timestamp"
-    config.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+func getObject(tx *bbolt.Tx, hash string, want ObjectType) ([]byte, error) {
+    raw := tx.Bucket([]byte("objects")).Get([]byte(hash))
+    if raw == nil {
+        return nil, fmt.Errorf("object not found: %s", hash)
+    }
     
-    var err error
-    logger, err = config.Build()
+    typ, data, err := decodeObject(raw)
     if err != nil {
-        panic(fmt.Sprintf("Failed to initialize logger: %v", err))
+        return nil, fmt.Errorf("corrupt object %s: %w", hash, err)
+    }
+    if typ != want {
+        return nil, fmt.Errorf("object %s is a %s, not a %s", hash, typ, want)
     }
+    
+    // bbolt memory is only valid for the life of the transaction
+    return append([]byte(nil), data...), nil
 }
 
-type Event struct {
-    Action    string                 `json:"action"`
-    Timestamp time.Time              `json:"timestamp"`
-    User      string                 `json:"user"`
-    Data      map[string]interface{} `json:"data"`
+func putJSONObject(tx *bbolt.Tx, typ ObjectType, v interface{}) (string, error) {
+    data, err := json.Marshal(v)
+    if err != nil {
+        return "", err
+    }
+    return putObject(tx, typ, data)
 }
 
-func LogEvent(action string, data map[string]interface{}) {
-    event := Event{
-        Action:    action,
-        Timestamp: time.Now(),
-        User:      getUser(),
-        Data:      data,
+func getJSONObject(tx *bbolt.Tx, hash string, typ ObjectType, v interface{}) error {
+    data, err := getObject(tx, hash, typ)
+    if err != nil {
+        return err
     }
+    return json.Unmarshal(data, v)
+}
+
+// writeTree stores the canonical form of a file's resources, so the same
+// resource hashes the same however its file orders or spells it
+func writeTree(tx *bbolt.Tx, cfg config.NetworkConfig) (string, error) {
+    cfg.Normalize()
+    tree := Tree{Metadata: cfg.Metadata, Fragment: cfg.Fragment, Variables: cfg.Variables, Delete: cfg.Delete}
     
-    logger.Info("audit_event",
-        zap.String("action", event.Action),
-        zap.Time("timestamp", event.Timestamp),
-        zap.String("user", event.User),
-        zap.Any("data", event.Data),
-    )
+    if cfg.Raw != nil {
+        hash, err := putObject(tx, BlobObject, cfg.Raw)
+        if err != nil {
+            return "", err
+        }
+        tree.Raw = hash
+    }
     
-    // Also write to structured JSON file
-    writeJSONLog(event)
+    add := func(kind, name string, resource interface{}) error {
+        hash, err := putJSONObject(tx, BlobObject, resource)
+        if err != nil {
+            return err
+        }
+        tree.Entries = append(tree.Entries, TreeEntry{Kind: kind, Name: name, Hash: hash})
+        return nil
+    }
+    
+    for _, sg := range cfg.SecurityGroups {
+        if err := add(config.KindSecurityGroup, sg.Name, sg); err != nil {
+            return "", err
+        }
+    }
+    for _, np := range cfg.NetworkPolicies {
+        if err := add(config.KindNetworkPolicy, np.Name, np); err != nil {
+            return "", err
+        }
+    }
+    for _, fw := range cfg.FirewallRules {
+        if err := add(config.KindFirewallRule, fw.Name, fw); err != nil {
+            return "", err
+        }
+    }
+    
+    return putJSONObject(tx, TreeObject, tree)
 }
 
-func writeJSONLog(event Event) {
-    file, err := os.OpenFile("audit.json", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-    if err != nil {
-        return
+// writeRootTree stores one subtree per source file under a root tree
+func writeRootTree(tx *bbolt.Tx, files []config.NetworkConfig) (string, error) {
+    root := Tree{}
+    for _, file := range files {
+        hash, err := writeTree(tx, file)
+        if err != nil {
+            return "", err
+        }
+        root.Entries = append(root.Entries, TreeEntry{Kind: KindFile, Name: fileEntryName(file), Hash: hash})
     }
-    defer file.Close()
-    
-    jsonData, _ := json.Marshal(event)
-    file.Write(jsonData)
-    file.WriteString("\n")
+    return putJSONObject(tx, TreeObject, root)
 }
 
-func getUser() string {
-    if user := os.Getenv("USER"); user != "" {
-        return user
+func fileEntryName(cfg config.NetworkConfig) string {
+    if cfg.Source != "" {
+        return cfg.Source
     }
-    if user := os.Getenv("USERNAME"); user != "" {
-        return user
+    if cfg.Metadata.Name != "" {
+        return cfg.Metadata.Name
     }
-    return "unknown"
+    return "config"
 }
 
-// pkg/metrics/prometheus.go
-package metrics
+func readTree(tx *bbolt.Tx, hash string) (config.NetworkConfig, error) {
+    var tree Tree
+    if err := getJSONObject(tx, hash, TreeObject, &tree); err != nil {
+        return config.NetworkConfig{}, err
+    }
+    return decodeTree(tx, hash, tree)
+}
 
-import (
-    "github.com/prometheus/client_golang/prometheus"
-    "github.com/prometheus/client_golang/prometheus/promauto"
-)
+// readFiles returns the per-file configs of a root tree. Trees written before
+// files were tracked hold resources directly and report ok == false.
+func readFiles(tx *bbolt.Tx, hash string) (files []config.NetworkConfig, ok bool, err error) {
+    var tree Tree
+    if err := getJSONObject(tx, hash, TreeObject, &tree); err != nil {
+        return nil, false, err
+    }
+    if len(tree.Entries) == 0 || tree.Entries[0].Kind != KindFile {
+        return nil, false, nil
+    }
+    
+    for _, entry := range tree.Entries {
+        if entry.Kind != KindFile {
+            return nil, false, fmt.Errorf("tree %s: mixed file and resource entries", hash)
+        }
+        file, err := readTree(tx, entry.Hash)
+        if err != nil {
+            return nil, false, err
+        }
+        file.Source = entry.Name
+        files = append(files, file)
+    }
+    return files, true, nil
+}
 
-var (
-    CommitsTotal = promauto.NewCounter(prometheus.CounterOpts{
-        Name: "netgit_commits_total",
-        Help: "The total number of commits",
+func decodeTree(tx *bbolt.Tx, hash string, tree Tree) (config.NetworkConfig, error) {
+    cfg := config.NetworkConfig{
+        Metadata:  tree.Metadata,
+        Fragment:  tree.Fragment,
+        Variables: tree.Variables,
+        Delete:    tree.Delete,
+    }
+    if tree.Raw != "" {
+        raw, err := getObject(tx, tree.Raw, BlobObject)
+        if err != nil {
+            return config.NetworkConfig{}, fmt.Errorf("tree %s: %w", hash, err)
+        }
+        cfg.Raw = raw
+    }
+    for _, entry := range tree.Entries {
+        var err error
+        switch entry.Kind {
+        case config.KindSecurityGroup:
+            var sg config.SecurityGroup
+            err = getJSONObject(tx, entry.Hash, BlobObject, &sg)
+            cfg.SecurityGroups = append(cfg.SecurityGroups, sg)
+        case config.KindNetworkPolicy:
+            var np config.NetworkPolicy
+            err = getJSONObject(tx, entry.Hash, BlobObject, &np)
+            cfg.NetworkPolicies = append(cfg.NetworkPolicies, np)
+        case config.KindFirewallRule:
+            var fw config.FirewallRule
+            err = getJSONObject(tx, entry.Hash, BlobObject, &fw)
+            cfg.FirewallRules = append(cfg.FirewallRules, fw)
+        default:
+            err = fmt.Errorf("unknown tree entry kind %q", entry.Kind)
+        }
+        if err != nil {
+            return config.NetworkConfig{}, fmt.Errorf("tree %s: %w", hash, err)
+        }
+    }
+    
+    return cfg, nil
+}
+
+// writeCommit stores the commit's files as blobs and trees, then the commit
+// object itself, filling in commit.Tree and commit.Hash. Commits without
+// files (migrated legacy commits) store their config as a single tree.
+func writeCommit(tx *bbolt.Tx, commit *Commit) error {
+    var treeHash string
+    var err error
+    if len(commit.Files) > 0 {
+        treeHash, err = writeRootTree(tx, commit.Files)
+    } else {
+        treeHash, err = writeTree(tx, commit.Config)
+    }
+    if err != nil {
+        return err
+    }
+    
+    hash, err := putJSONObject(tx, CommitObject, commitObject{
+        Tree:        treeHash,
+        Parent:      commit.Parent,
+        MergeParent: commit.MergeParent,
+        Message:     commit.Message,
+        Author:      commit.Author,
+        Timestamp:   commit.Timestamp,
     })
+    if err != nil {
+        return err
+    }
     
-    DeploymentsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
-        Name: "netgit_deployments_total",
-        Help: "The total number of deployments",
-    }, []string{"target", "status"})
+    commit.Tree = treeHash
+    commit.Hash = hash
+    return nil
+}
+
+func readCommit(tx *bbolt.Tx, hash string) (*Commit, error) {
+    var obj commitObject
+    if err := getJSONObject(tx, hash, CommitObject, &obj); err != nil {
+        return nil, fmt.Errorf("commit not found: %s: %w", hash, err)
+    }
     
-    PolicyViolationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
-        Name: "netgit_policy_violations_total",
-        Help: "The total number of policy violations",
-    }, []string{"rule", "severity"})
+    commit := &Commit{
+        Hash:        hash,
+        Tree:        obj.Tree,
+        Parent:      obj.Parent,
+        MergeParent: obj.MergeParent,
+        Message:     obj.Message,
+        Author:      obj.Author,
+        Timestamp:   obj.Timestamp,
+    }
     
-    DeploymentDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
-        Name: "netgit_deployment_duration_seconds",
-        Help: "The duration of deployments",
-        Buckets: prometheus.DefBuckets,
-    }, []string{"target"})
-)
+    files, ok, err := readFiles(tx, obj.Tree)
+    if err != nil {
+        return nil, err
+    }
+    if ok {
+        commit.Files = files
+        commit.Config = mergeConfigs(files)
+        return commit, nil
+    }
+    
+    commit.Config, err = readTree(tx, obj.Tree)
+    if err != nil {
+        return nil, err
+    }
+    return commit, nil
+}
 
-// examples/sample-configs/aws-security-groups.yaml
-metadata:
-  name: "production-security-groups"
+// migrateLegacyCommits converts the old "commits" bucket, which held each
+// commit as one JSON document keyed by a short hash, into objects. Commit
+// hashes change, so parents and refs are rewritten to the new hashes.
+func migrateLegacyCommits(tx *bbolt.Tx) error {
+    bucket := tx.Bucket([]byte("commits"))
+    if bucket == nil {
+        return nil
+    }
+    
+    legacy := map[string]*Commit{}
+    err := bucket.ForEach(func(k, v []byte) error {
+        var commit Commit
+        if err := json.Unmarshal(v, &commit); err != nil {
+            return fmt.Errorf("legacy commit %s: %w", k, err)
+        }
+        legacy[string(k)] = &commit
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+    
+    rewritten := map[string]string{}
+    var convert func(hash string) (string, error)
+    convert = func(hash string) (string, error) {
+        if newHash, ok := rewritten[hash]; ok {
+            return newHash, nil
+        }
+        
+        commit, ok := legacy[hash]
+        if !ok {
+            return "", fmt.Errorf("legacy commit not found: %s", hash)
+        }
+        
+        migrated := *commit
+        if commit.Parent != "" {
+            parent, err := convert(commit.Parent)
+            if err != nil {
+                return "", err
+            }
+            migrated.Parent = parent
+        }
+        
+        if err := writeCommit(tx, &migrated); err != nil {
+            return "", err
+        }
+        rewritten[hash] = migrated.Hash
+        return migrated.Hash, nil
+    }
+    
+    for hash := range legacy {
+        if _, err := convert(hash); err != nil {
+            return err
+        }
+    }
+    
+    refs := tx.Bucket([]byte("refs"))
+    updates := map[string]string{}
+    err = refs.ForEach(func(k, v []byte) error {
+        if newHash, ok := rewritten[string(v)]; ok {
+            updates[string(k)] = newHash
+        }
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+    for ref, hash := range updates {
+        if err := refs.Put([]byte(ref), []byte(hash)); err != nil {
+            return err
+        }
+    }
+    
+    return tx.DeleteBucket([]byte("commits"))
+}
+
+// pkg/storage/refs.go
+package storage
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "regexp"
+    "strings"
+    
+    "go.etcd.io/bbolt"
+    "netgit/pkg/config"
+)
+
+// HEAD is either symbolic, holding the ref of the checked-out branch
+// ("refs/heads/main"), or detached, holding a commit hash.
+const (
+    branchPrefix = "refs/heads/"
+    tagPrefix    = "refs/tags/"
+)
+
+var refNamePattern = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+
+func isSymbolicRef(value []byte) bool {
+    return strings.HasPrefix(string(value), "refs/")
+}
+
+func validateRefName(kind, name string) error {
+    if !refNamePattern.MatchString(name) || name == "HEAD" ||
+        strings.HasPrefix(name, "-") || strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/") ||
+        strings.Contains(name, "..") || strings.Contains(name, "//") {
+        return fmt.Errorf("invalid %s name: %q", kind, name)
+    }
+    return nil
+}
+
+func headCommit(tx *bbolt.Tx) (string, error) {
+    refs := tx.Bucket([]byte("refs"))
+    head := refs.Get([]byte("HEAD"))
+    if head == nil {
+        return "", fmt.Errorf("no HEAD found")
+    }
+    if !isSymbolicRef(head) {
+        return string(head), nil
+    }
+    
+    hash := refs.Get(head)
+    if hash == nil {
+        return "", fmt.Errorf("branch reference not found: %s", head)
+    }
+    return string(hash), nil
+}
+
+// unborn reports whether HEAD is a branch that has no commits yet
+func (r *Repository) unborn() bool {
+    unborn := false
+    r.db.View(func(tx *bbolt.Tx) error {
+        refs := tx.Bucket([]byte("refs"))
+        head := refs.Get([]byte("HEAD"))
+        unborn = head != nil && isSymbolicRef(head) && refs.Get(head) == nil
+        return nil
+    })
+    return unborn
+}
+
+// advanceHEAD moves the checked-out branch, or HEAD itself when detached,
+// to a new commit
+func advanceHEAD(tx *bbolt.Tx, hash string) error {
+    refs := tx.Bucket([]byte("refs"))
+    head := refs.Get([]byte("HEAD"))
+    if head == nil || !isSymbolicRef(head) {
+        return refs.Put([]byte("HEAD"), []byte(hash))
+    }
+    return refs.Put(append([]byte(nil), head...), []byte(hash))
+}
+
+// ResolveCommit turns a revision expression into the hash of a commit. See
+// resolveRevision for the accepted syntax.
+func (r *Repository) ResolveCommit(name string) (string, error) {
+    var hash string
+    err := r.db.View(func(tx *bbolt.Tx) error {
+        var err error
+        hash, err = resolveRevision(tx, name)
+        return err
+    })
+    return hash, err
+}
+
+// resolveName looks up HEAD, a branch, a tag, a remote-tracking branch
+// such as origin/main, or a full or abbreviated object hash
+func resolveName(tx *bbolt.Tx, name string) (string, error) {
+    if name == "HEAD" {
+        return headCommit(tx)
+    }
+    
+    refs := tx.Bucket([]byte("refs"))
+    if hash := refs.Get([]byte(branchPrefix + name)); hash != nil {
+        return string(hash), nil
+    }
+    if hash := refs.Get([]byte(tagPrefix + name)); hash != nil {
+        return peelTag(tx, string(hash))
+    }
+    if hash := refs.Get([]byte(remotePrefix + name)); hash != nil {
+        return string(hash), nil
+    }
+    if hash, err := resolveHash(tx, name); err != nil || hash != "" {
+        return hash, err
+    }
+    
+    return "", fmt.Errorf("unknown revision: %s", name)
+}
+
+func (r *Repository) branchHash(name string) (string, bool) {
+    var hash string
+    r.db.View(func(tx *bbolt.Tx) error {
+        hash = string(tx.Bucket([]byte("refs")).Get([]byte(branchPrefix + name)))
+        return nil
+    })
+    return hash, hash != ""
+}
+
+// isAncestor reports whether ancestor is reachable from hash by following
+// parents, including merge parents
+func (r *Repository) isAncestor(ancestor, hash string) (bool, error) {
+    found := false
+    err := r.walkAncestors(hash, func(commit *Commit) bool {
+        found = commit.Hash == ancestor
+        return !found
+    })
+    return found, err
+}
+
+// walkAncestors visits hash and its ancestors breadth-first, nearest first,
+// until visit returns false
+func (r *Repository) walkAncestors(hash string, visit func(*Commit) bool) error {
+    queue := []string{hash}
+    seen := map[string]bool{hash: true}
+    for len(queue) > 0 {
+        commit, err := r.GetCommit(queue[0])
+        if err != nil {
+            return err
+        }
+        queue = queue[1:]
+        
+        if !visit(commit) {
+            return nil
+        }
+        for _, parent := range []string{commit.Parent, commit.MergeParent} {
+            if parent != "" && !seen[parent] {
+                seen[parent] = true
+                queue = append(queue, parent)
+            }
+        }
+    }
+    return nil
+}
+
+// DeleteBranch removes a branch ref. Unless force is set, the branch must be
+// fully merged into HEAD so that no commits become unreachable.
+func (r *Repository) DeleteBranch(name string, force bool) error {
+    hash, ok := r.branchHash(name)
+    if !ok {
+        return fmt.Errorf("branch '%s' not found", name)
+    }
+    
+    current, err := r.CurrentBranch()
+    if err != nil {
+        return err
+    }
+    if current == name {
+        return fmt.Errorf("cannot delete the checked-out branch '%s'", name)
+    }
+    
+    if !force {
+        head, err := r.GetHEAD()
+        if err != nil {
+            return err
+        }
+        merged, err := r.isAncestor(hash, head.Hash)
+        if err != nil {
+            return err
+        }
+        if !merged {
+            return fmt.Errorf("branch '%s' is not fully merged; use --force to delete it anyway", name)
+        }
+    }
+    
+    return r.db.Update(func(tx *bbolt.Tx) error {
+        return tx.Bucket([]byte("refs")).Delete([]byte(branchPrefix + name))
+    })
+}
+
+// RenameBranch moves a branch ref, keeping HEAD on it if it was checked out
+func (r *Repository) RenameBranch(oldName, newName string) error {
+    if err := validateRefName("branch", newName); err != nil {
+        return err
+    }
+    
+    return r.db.Update(func(tx *bbolt.Tx) error {
+        refs := tx.Bucket([]byte("refs"))
+        oldRef := []byte(branchPrefix + oldName)
+        newRef := []byte(branchPrefix + newName)
+        
+        hash := refs.Get(oldRef)
+        if hash == nil {
+            return fmt.Errorf("branch '%s' not found", oldName)
+        }
+        if refs.Get(newRef) != nil {
+            return fmt.Errorf("branch '%s' already exists", newName)
+        }
+        
+        if err := refs.Put(newRef, append([]byte(nil), hash...)); err != nil {
+            return err
+        }
+        if err := refs.Delete(oldRef); err != nil {
+            return err
+        }
+        
+        if string(refs.Get([]byte("HEAD"))) == string(oldRef) {
+            return refs.Put([]byte("HEAD"), newRef)
+        }
+        return nil
+    })
+}
+
+// Checkout switches HEAD to a branch, or detaches it at a commit, and
+// rewrites the working directory from the target's stored tree. Files
+// tracked by the old HEAD but absent from the target are removed. Local
+// changes are refused unless force is set.
+func (r *Repository) Checkout(target string, force bool) (*Commit, error) {
+    hash, isBranch := r.branchHash(target)
+    if !isBranch {
+        var err error
+        if hash, err = r.ResolveCommit(target); err != nil {
+            return nil, err
+        }
+    }
+    
+    commit, err := r.GetCommit(hash)
+    if err != nil {
+        return nil, fmt.Errorf("'%s' is not a branch or commit", target)
+    }
+    
+    headValue := commit.Hash
+    if isBranch {
+        headValue = branchPrefix + target
+    }
+    if state, err := r.mergeState(); err != nil {
+        return nil, err
+    } else if state != nil {
+        return nil, fmt.Errorf("merge in progress; run 'netgit merge --continue' or 'netgit merge --abort'")
+    }
+    if len(commit.Files) == 0 {
+        return nil, fmt.Errorf("commit %s predates file tracking and cannot be checked out", commit.Hash[:8])
+    }
+    
+    if !force {
+        status, err := r.Status()
+        if err != nil {
+            return nil, err
+        }
+        if !status.Clean {
+            return nil, fmt.Errorf("working directory has uncommitted changes; commit them or use --force")
+        }
+    }
+    
+    if head, err := r.GetHEAD(); err == nil {
+        if err := r.removeUntracked(head.Files, commit.Files); err != nil {
+            return nil, err
+        }
+    }
+    if err := r.WriteFiles(commit.Files); err != nil {
+        return nil, err
+    }
+    
+    err = r.db.Update(func(tx *bbolt.Tx) error {
+        return tx.Bucket([]byte("refs")).Put([]byte("HEAD"), []byte(headValue))
+    })
+    return commit, err
+}
+
+// removeUntracked deletes files of the current checkout that the target
+// commit does not contain
+func (r *Repository) removeUntracked(current, target []config.NetworkConfig) error {
+    keep := map[string]bool{}
+    for _, file := range target {
+        keep[file.Source] = true
+    }
+    
+    for _, file := range current {
+        if keep[file.Source] || !filepath.IsLocal(filepath.FromSlash(file.Source)) {
+            continue
+        }
+        err := os.Remove(filepath.Join(r.path, filepath.FromSlash(file.Source)))
+        if err != nil && !os.IsNotExist(err) {
+            return err
+        }
+    }
+    return nil
+}
+
+// pkg/storage/tags.go
+package storage
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+    "time"
+    
+    "go.etcd.io/bbolt"
+)
+
+// Tag names a commit. Lightweight tags are plain refs to the commit;
+// annotated tags point at a tag object recording who tagged it and why.
+type Tag struct {
+    Name      string    `json:"name"`
+    Commit    string    `json:"commit"`
+    Annotated bool      `json:"annotated"`
+    Tagger    string    `json:"tagger,omitempty"`
+    Message   string    `json:"message,omitempty"`
+    Timestamp time.Time `json:"timestamp,omitempty"`
+}
+
+type tagObject struct {
+    Object    string    `json:"object"`
+    Name      string    `json:"name"`
+    Tagger    string    `json:"tagger"`
+    Message   string    `json:"message"`
+    Timestamp time.Time `json:"timestamp"`
+}
+
+// CreateTag tags the commit that target resolves to. A non-empty message
+// makes the tag annotated. Tags are never moved once created.
+func (r *Repository) CreateTag(name, target, tagger, message string) (*Tag, error) {
+    if err := validateRefName("tag", name); err != nil {
+        return nil, err
+    }
+    
+    var tag *Tag
+    err := r.db.Update(func(tx *bbolt.Tx) error {
+        refs := tx.Bucket([]byte("refs"))
+        ref := []byte(tagPrefix + name)
+        if refs.Get(ref) != nil {
+            return fmt.Errorf("tag '%s' already exists", name)
+        }
+        
+        hash, err := resolveRevision(tx, target)
+        if err != nil {
+            return err
+        }
+        if _, err := readCommit(tx, hash); err != nil {
+            return err
+        }
+        
+        tag = &Tag{Name: name, Commit: hash}
+        if message == "" {
+            return refs.Put(ref, []byte(hash))
+        }
+        
+        obj := tagObject{
+            Object:    hash,
+            Name:      name,
+            Tagger:    tagger,
+            Message:   message,
+            Timestamp: time.Now(),
+        }
+        objHash, err := putJSONObject(tx, TagObject, obj)
+        if err != nil {
+            return err
+        }
+        
+        tag.Annotated = true
+        tag.Tagger = obj.Tagger
+        tag.Message = obj.Message
+        tag.Timestamp = obj.Timestamp
+        return refs.Put(ref, []byte(objHash))
+    })
+    if err != nil {
+        return nil, err
+    }
+    return tag, nil
+}
+
+// GetTag returns the tag with the given name
+func (r *Repository) GetTag(name string) (*Tag, error) {
+    var tag *Tag
+    err := r.db.View(func(tx *bbolt.Tx) error {
+        hash := tx.Bucket([]byte("refs")).Get([]byte(tagPrefix + name))
+        if hash == nil {
+            return fmt.Errorf("tag '%s' not found", name)
+        }
+        
+        var err error
+        tag, err = readTag(tx, name, string(hash))
+        return err
+    })
+    return tag, err
+}
+
+// ListTags returns all tags sorted by name
+func (r *Repository) ListTags() ([]*Tag, error) {
+    var tags []*Tag
+    err := r.db.View(func(tx *bbolt.Tx) error {
+        c := tx.Bucket([]byte("refs")).Cursor()
+        prefix := []byte(tagPrefix)
+        for k, v := c.Seek(prefix); k != nil && strings.HasPrefix(string(k), tagPrefix); k, v = c.Next() {
+            tag, err := readTag(tx, strings.TrimPrefix(string(k), tagPrefix), string(v))
+            if err != nil {
+                return err
+            }
+            tags = append(tags, tag)
+        }
+        return nil
+    })
+    
+    sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+    return tags, err
+}
+
+// DeleteTag removes a tag ref. The tag object of an annotated tag is kept.
+func (r *Repository) DeleteTag(name string) error {
+    return r.db.Update(func(tx *bbolt.Tx) error {
+        refs := tx.Bucket([]byte("refs"))
+        ref := []byte(tagPrefix + name)
+        if refs.Get(ref) == nil {
+            return fmt.Errorf("tag '%s' not found", name)
+        }
+        return refs.Delete(ref)
+    })
+}
+
+func readTag(tx *bbolt.Tx, name, hash string) (*Tag, error) {
+    typ, _, err := decodeObject(tx.Bucket([]byte("objects")).Get([]byte(hash)))
+    if err != nil {
+        return nil, fmt.Errorf("tag '%s' points at a missing object: %s", name, hash)
+    }
+    if typ != TagObject {
+        return &Tag{Name: name, Commit: hash}, nil
+    }
+    
+    var obj tagObject
+    if err := getJSONObject(tx, hash, TagObject, &obj); err != nil {
+        return nil, err
+    }
+    return &Tag{
+        Name:      name,
+        Commit:    obj.Object,
+        Annotated: true,
+        Tagger:    obj.Tagger,
+        Message:   obj.Message,
+        Timestamp: obj.Timestamp,
+    }, nil
+}
+
+// peelTag follows tag objects until it reaches a non-tag object
+func peelTag(tx *bbolt.Tx, hash string) (string, error) {
+    objects := tx.Bucket([]byte("objects"))
+    for {
+        typ, _, err := decodeObject(objects.Get([]byte(hash)))
+        if err != nil {
+            return "", fmt.Errorf("object not found: %s", hash)
+        }
+        if typ != TagObject {
+            return hash, nil
+        }
+        
+        var obj tagObject
+        if err := getJSONObject(tx, hash, TagObject, &obj); err != nil {
+            return "", err
+        }
+        hash = obj.Object
+    }
+}
+
+// pkg/storage/revision.go
+package storage
+
+import (
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+    
+    "go.etcd.io/bbolt"
+)
+
+// Abbreviated hashes must be at least this long, as in git
+const minAbbrevLength = 4
+
+// resolveRevision resolves a revision expression to a commit hash. An
+// expression is a name followed by any number of suffixes:
+//
+//   name        HEAD, a branch, a tag, or a full or abbreviated commit hash
+//   name@{when} the commit the branch pointed at at that time, e.g.
+//               main@{yesterday}, main@{2.weeks.ago}, main@{2024-01-31}
+//   ~n          the n-th first-parent ancestor; "~" alone is "~1"
+//   ^n          the n-th parent, where ^2 is the merge parent; "^" is "^1"
+//
+// An empty name, or "@", is HEAD.
+func resolveRevision(tx *bbolt.Tx, expr string) (string, error) {
+    if _, _, ok := SplitRange(expr); ok {
+        return "", fmt.Errorf("%s is a range, not a single revision", expr)
+    }
+    
+    name, rest := expr, ""
+    if i := strings.IndexAny(expr, "~^@"); i >= 0 {
+        name, rest = expr[:i], expr[i:]
+    }
+    if name == "" {
+        name = "HEAD"
+    }
+    if rest == "@" {
+        rest = ""
+    }
+    
+    hash, err := resolveName(tx, name)
+    if err != nil {
+        return "", err
+    }
+    
+    if strings.HasPrefix(rest, "@{") {
+        end := strings.IndexByte(rest, '}')
+        if end < 0 {
+            return "", fmt.Errorf("invalid revision %s: unterminated @{", expr)
+        }
+        when, err := parseApproxDate(rest[2:end], time.Now())
+        if err != nil {
+            return "", fmt.Errorf("invalid revision %s: %w", expr, err)
+        }
+        if hash, err = commitAsOf(tx, hash, when); err != nil {
+            return "", fmt.Errorf("%s: %w", expr, err)
+        }
+        rest = rest[end+1:]
+    }
+    
+    for rest != "" {
+        op := rest[0]
+        if op != '~' && op != '^' {
+            return "", fmt.Errorf("invalid revision %s", expr)
+        }
+        rest = rest[1:]
+        
+        digits := len(rest) - len(strings.TrimLeft(rest, "0123456789"))
+        n := 1
+        if digits > 0 {
+            n, err = strconv.Atoi(rest[:digits])
+            if err != nil {
+                return "", fmt.Errorf("invalid revision %s: %w", expr, err)
+            }
+            rest = rest[digits:]
+        }
+        
+        if op == '~' {
+            hash, err = nthAncestor(tx, hash, n)
+        } else {
+            hash, err = nthParent(tx, hash, n)
+        }
+        if err != nil {
+            return "", fmt.Errorf("%s: %w", expr, err)
+        }
+    }
+    
+    return hash, nil
+}
+
+// SplitRange splits an "A..B" range into its ends. A missing end is HEAD.
+func SplitRange(expr string) (from, to string, ok bool) {
+    i := strings.Index(expr, "..")
+    if i < 0 {
+        return "", "", false
+    }
+    from, to = expr[:i], expr[i+2:]
+    if from == "" {
+        from = "HEAD"
+    }
+    if to == "" {
+        to = "HEAD"
+    }
+    return from, to, true
+}
+
+// resolveHash finds the commit a full or abbreviated hash names. Only
+// commits and tags are candidates, so a prefix shared with a blob or tree
+// is not ambiguous. It returns "" when nothing matches.
+func resolveHash(tx *bbolt.Tx, prefix string) (string, error) {
+    if len(prefix) < minAbbrevLength || strings.Trim(prefix, "0123456789abcdef") != "" {
+        return "", nil
+    }
+    
+    var matches []string
+    c := tx.Bucket([]byte("objects")).Cursor()
+    for k, v := c.Seek([]byte(prefix)); k != nil && strings.HasPrefix(string(k), prefix); k, v = c.Next() {
+        typ, _, err := decodeObject(v)
+        if err != nil || (typ != CommitObject && typ != TagObject) {
+            continue
+        }
+        matches = append(matches, string(k))
+    }
+    
+    switch len(matches) {
+    case 0:
+        return "", nil
+    case 1:
+        return peelTag(tx, matches[0])
+    }
+    
+    sort.Strings(matches)
+    candidates := make([]string, len(matches))
+    for i, match := range matches {
+        candidates[i] = match[:12]
+    }
+    return "", fmt.Errorf("short hash %s is ambiguous; candidates are %s", prefix, strings.Join(candidates, ", "))
+}
+
+func nthAncestor(tx *bbolt.Tx, hash string, n int) (string, error) {
+    for i := 0; i < n; i++ {
+        commit, err := readCommit(tx, hash)
+        if err != nil {
+            return "", err
+        }
+        if commit.Parent == "" {
+            return "", fmt.Errorf("commit %s has no parent", hash[:8])
+        }
+        hash = commit.Parent
+    }
+    return hash, nil
+}
+
+func nthParent(tx *bbolt.Tx, hash string, n int) (string, error) {
+    commit, err := readCommit(tx, hash)
+    if err != nil {
+        return "", err
+    }
+    
+    var parent string
+    switch n {
+    case 0:
+        return hash, nil
+    case 1:
+        parent = commit.Parent
+    case 2:
+        parent = commit.MergeParent
+    }
+    if parent == "" {
+        return "", fmt.Errorf("commit %s has no parent %d", hash[:8], n)
+    }
+    return parent, nil
+}
+
+// commitAsOf approximates git's reflog lookup: netgit keeps no reflog, so
+// the answer is the newest commit on the first-parent chain from hash that
+// was made at or before when.
+func commitAsOf(tx *bbolt.Tx, hash string, when time.Time) (string, error) {
+    for hash != "" {
+        commit, err := readCommit(tx, hash)
+        if err != nil {
+            return "", err
+        }
+        if !commit.Timestamp.After(when) {
+            return hash, nil
+        }
+        hash = commit.Parent
+    }
+    return "", fmt.Errorf("no commit as of %s", when.Format(time.RFC3339))
+}
+
+var dateUnits = map[string]time.Duration{
+    "second": time.Second,
+    "minute": time.Minute,
+    "hour":   time.Hour,
+    "day":    24 * time.Hour,
+    "week":   7 * 24 * time.Hour,
+}
+
+// parseApproxDate understands "now", "yesterday", "<n> <unit> ago" (with
+// spaces or dots between words) and absolute dates
+func parseApproxDate(s string, now time.Time) (time.Time, error) {
+    s = strings.TrimSpace(s)
+    switch strings.ToLower(s) {
+    case "now":
+        return now, nil
+    case "yesterday":
+        return now.AddDate(0, 0, -1), nil
+    }
+    
+    words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool { return r == ' ' || r == '.' })
+    if len(words) == 3 && words[2] == "ago" {
+        n, err := strconv.Atoi(words[0])
+        if err != nil {
+            return time.Time{}, fmt.Errorf("invalid date %q", s)
+        }
+        unit := strings.TrimSuffix(words[1], "s")
+        switch unit {
+        case "month":
+            return now.AddDate(0, -n, 0), nil
+        case "year":
+            return now.AddDate(-n, 0, 0), nil
+        }
+        if d, ok := dateUnits[unit]; ok {
+            return now.Add(-time.Duration(n) * d), nil
+        }
+        return time.Time{}, fmt.Errorf("unknown time unit %q", words[1])
+    }
+    
+    for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"} {
+        if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+            return t, nil
+        }
+    }
+    return time.Time{}, fmt.Errorf("invalid date %q", s)
+}
+
+// pkg/storage/transfer.go
+package storage
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+    
+    "go.etcd.io/bbolt"
+)
+
+// Object is a stored object as exchanged with other repositories. Data is
+// the object body without its header; receivers recompute the hash.
+type Object struct {
+    Hash string     `json:"hash"`
+    Type ObjectType `json:"type"`
+    Data []byte     `json:"data"`
+}
+
+// RefUpdate moves Ref from Old to New. An empty Old expects the ref not to
+// exist yet. Without Force the ref must still be at Old, a branch may only
+// move forward and a tag may not move at all.
+type RefUpdate struct {
+    Ref   string `json:"ref"`
+    Old   string `json:"old,omitempty"`
+    New   string `json:"new"`
+    Force bool   `json:"force,omitempty"`
+}
+
+// Refs returns every branch and tag ref with the hash it points at
+func (r *Repository) Refs() (map[string]string, error) {
+    refs := map[string]string{}
+    err := r.db.View(func(tx *bbolt.Tx) error {
+        return tx.Bucket([]byte("refs")).ForEach(func(k, v []byte) error {
+            ref := string(k)
+            if strings.HasPrefix(ref, branchPrefix) || strings.HasPrefix(ref, tagPrefix) {
+                refs[ref] = string(v)
+            }
+            return nil
+        })
+    })
+    return refs, err
+}
+
+// HasObject reports whether the object is stored locally
+func (r *Repository) HasObject(hash string) bool {
+    found := false
+    r.db.View(func(tx *bbolt.Tx) error {
+        found = tx.Bucket([]byte("objects")).Get([]byte(hash)) != nil
+        return nil
+    })
+    return found
+}
+
+// CollectObjects returns the objects needed to have every commit or tag in
+// wants, leaving out history reachable from haves. Haves this repository
+// does not know are ignored.
+func (r *Repository) CollectObjects(wants, haves []string) ([]Object, error) {
+    var objects []Object
+    err := r.db.View(func(tx *bbolt.Tx) error {
+        c := &collector{tx: tx, seen: map[string]bool{}, exclude: map[string]bool{}}
+        for _, have := range haves {
+            if err := c.excludeHistory(have); err != nil {
+                return err
+            }
+        }
+        for _, want := range wants {
+            if err := c.want(want); err != nil {
+                return err
+            }
+        }
+        objects = c.objects
+        return nil
+    })
+    return objects, err
+}
+
+type collector struct {
+    tx      *bbolt.Tx
+    seen    map[string]bool
+    exclude map[string]bool
+    objects []Object
+}
+
+func (c *collector) excludeHistory(hash string) error {
+    objects := c.tx.Bucket([]byte("objects"))
+    if objects.Get([]byte(hash)) == nil {
+        return nil
+    }
+    hash, err := peelTag(c.tx, hash)
+    if err != nil {
+        return err
+    }
+    
+    queue := []string{hash}
+    for len(queue) > 0 {
+        hash, queue = queue[0], queue[1:]
+        if c.exclude[hash] || objects.Get([]byte(hash)) == nil {
+            continue
+        }
+        c.exclude[hash] = true
+        
+        var obj commitObject
+        if err := getJSONObject(c.tx, hash, CommitObject, &obj); err != nil {
+            return err
+        }
+        for _, parent := range []string{obj.Parent, obj.MergeParent} {
+            if parent != "" {
+                queue = append(queue, parent)
+            }
+        }
+    }
+    return nil
+}
+
+func (c *collector) add(hash string, typ ObjectType) ([]byte, bool, error) {
+    if c.seen[hash] {
+        return nil, false, nil
+    }
+    c.seen[hash] = true
+    
+    data, err := getObject(c.tx, hash, typ)
+    if err != nil {
+        return nil, false, err
+    }
+    c.objects = append(c.objects, Object{Hash: hash, Type: typ, Data: data})
+    return data, true, nil
+}
+
+func (c *collector) want(hash string) error {
+    typ, _, err := decodeObject(c.tx.Bucket([]byte("objects")).Get([]byte(hash)))
+    if err != nil {
+        return fmt.Errorf("object not found: %s", hash)
+    }
+    
+    switch typ {
+    case TagObject:
+        if _, added, err := c.add(hash, TagObject); err != nil || !added {
+            return err
+        }
+        var obj tagObject
+        if err := getJSONObject(c.tx, hash, TagObject, &obj); err != nil {
+            return err
+        }
+        return c.want(obj.Object)
+    case CommitObject:
+        return c.commits(hash)
+    default:
+        return fmt.Errorf("object %s is a %s, not a commit or tag", hash, typ)
+    }
+}
+
+// commits adds hash and its ancestors down to the excluded history
+func (c *collector) commits(hash string) error {
+    queue := []string{hash}
+    for len(queue) > 0 {
+        hash, queue = queue[0], queue[1:]
+        if c.exclude[hash] || c.seen[hash] {
+            continue
+        }
+        if _, _, err := c.add(hash, CommitObject); err != nil {
+            return err
+        }
+        
+        var obj commitObject
+        if err := getJSONObject(c.tx, hash, CommitObject, &obj); err != nil {
+            return err
+        }
+        if err := c.tree(obj.Tree); err != nil {
+            return err
+        }
+        for _, parent := range []string{obj.Parent, obj.MergeParent} {
+            if parent != "" {
+                queue = append(queue, parent)
+            }
+        }
+    }
+    return nil
+}
+
+func (c *collector) tree(hash string) error {
+    if c.seen[hash] {
+        return nil
+    }
+    if _, _, err := c.add(hash, TreeObject); err != nil {
+        return err
+    }
+    
+    var tree Tree
+    if err := getJSONObject(c.tx, hash, TreeObject, &tree); err != nil {
+        return err
+    }
+    if tree.Raw != "" {
+        if _, _, err := c.add(tree.Raw, BlobObject); err != nil {
+            return err
+        }
+    }
+    for _, entry := range tree.Entries {
+        var err error
+        if entry.Kind == KindFile {
+            err = c.tree(entry.Hash)
+        } else {
+            _, _, err = c.add(entry.Hash, BlobObject)
+        }
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// StoreObjects writes objects received from another repository, refusing
+// any whose content does not match its hash
+func (r *Repository) StoreObjects(objects []Object) error {
+    return r.db.Update(func(tx *bbolt.Tx) error {
+        return storeObjects(tx, objects)
+    })
+}
+
+func storeObjects(tx *bbolt.Tx, objects []Object) error {
+    for _, obj := range objects {
+        if HashObject(obj.Type, obj.Data) != obj.Hash {
+            return fmt.Errorf("object %s does not match its content", obj.Hash)
+        }
+        if _, err := putObject(tx, obj.Type, obj.Data); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// errNothingApplied rolls back a receive in which every update failed
+var errNothingApplied = errors.New("no ref update applied")
+
+// Receive stores objects pushed by another repository and applies the ref
+// updates they were sent for, returning each update's error. Objects are
+// only kept if some update is applied.
+func (r *Repository) Receive(objects []Object, updates []RefUpdate) ([]error, error) {
+    results := make([]error, len(updates))
+    err := r.db.Update(func(tx *bbolt.Tx) error {
+        if err := storeObjects(tx, objects); err != nil {
+            return err
+        }
+        applied := false
+        for i, update := range updates {
+            if results[i] = updateRef(tx, update); results[i] == nil {
+                applied = true
+            }
+        }
+        if !applied {
+            return errNothingApplied
+        }
+        return nil
+    })
+    if err == errNothingApplied {
+        err = nil
+    }
+    return results, err
+}
+
+// UpdateRef applies a ref update. The new target and its history must be
+// stored in full.
+func (r *Repository) UpdateRef(update RefUpdate) error {
+    return r.db.Update(func(tx *bbolt.Tx) error {
+        return updateRef(tx, update)
+    })
+}
+
+func updateRef(tx *bbolt.Tx, update RefUpdate) error {
+    var kind, name string
+    switch {
+    case strings.HasPrefix(update.Ref, branchPrefix):
+        kind, name = "branch", strings.TrimPrefix(update.Ref, branchPrefix)
+    case strings.HasPrefix(update.Ref, tagPrefix):
+        kind, name = "tag", strings.TrimPrefix(update.Ref, tagPrefix)
+    case strings.HasPrefix(update.Ref, remotePrefix):
+        kind, name = "remote-tracking ref", strings.TrimPrefix(update.Ref, remotePrefix)
+    default:
+        return fmt.Errorf("invalid ref %q", update.Ref)
+    }
+    if err := validateRefName(kind, name); err != nil {
+        return err
+    }
+    
+    refs := tx.Bucket([]byte("refs"))
+    current := string(refs.Get([]byte(update.Ref)))
+    
+    if update.New == "" {
+        if !update.Force && current != update.Old {
+            return fmt.Errorf("%s: stale ref, expected %s but found %s", update.Ref, shortHash(update.Old), shortHash(current))
+        }
+        if string(refs.Get([]byte("HEAD"))) == update.Ref {
+            return fmt.Errorf("%s: cannot delete the checked-out branch", update.Ref)
+        }
+        return refs.Delete([]byte(update.Ref))
+    }
+    
+    commit, err := peelTag(tx, update.New)
+    if err != nil {
+        return fmt.Errorf("%s: %w", update.Ref, err)
+    }
+    if err := checkHistory(tx, commit); err != nil {
+        return fmt.Errorf("%s: incomplete history: %w", update.Ref, err)
+    }
+    
+    if !update.Force && current != update.New {
+        if current != update.Old {
+            return fmt.Errorf("%s: stale ref, expected %s but found %s; fetch first", update.Ref, shortHash(update.Old), shortHash(current))
+        }
+        if current != "" && kind == "tag" {
+            return fmt.Errorf("%s: tag already exists", update.Ref)
+        }
+        if current != "" {
+            ff, err := isAncestorTx(tx, current, commit)
+            if err != nil {
+                return err
+            }
+            if !ff {
+                return fmt.Errorf("%s: non-fast-forward update rejected; use --force to overwrite", update.Ref)
+            }
+        }
+    }
+    
+    return refs.Put([]byte(update.Ref), []byte(update.New))
+}
+
+// checkHistory finds objects missing from a commit's history: the commits,
+// their trees and blobs, down to the commits refs already point at, which
+// were checked when the refs were set
+func checkHistory(tx *bbolt.Tx, commit string) error {
+    complete := map[string]bool{}
+    tx.Bucket([]byte("refs")).ForEach(func(k, v []byte) error {
+        if hash, err := peelTag(tx, string(v)); err == nil {
+            complete[hash] = true
+        }
+        return nil
+    })
+    
+    seen := map[string]bool{}
+    queue := []string{commit}
+    for len(queue) > 0 {
+        var hash string
+        hash, queue = queue[0], queue[1:]
+        if complete[hash] || seen[hash] {
+            continue
+        }
+        seen[hash] = true
+        
+        var obj commitObject
+        if err := getJSONObject(tx, hash, CommitObject, &obj); err != nil {
+            return err
+        }
+        if err := checkTree(tx, obj.Tree, seen); err != nil {
+            return err
+        }
+        for _, parent := range []string{obj.Parent, obj.MergeParent} {
+            if parent != "" {
+                queue = append(queue, parent)
+            }
+        }
+    }
+    return nil
+}
+
+func checkTree(tx *bbolt.Tx, hash string, seen map[string]bool) error {
+    if seen[hash] {
+        return nil
+    }
+    seen[hash] = true
+    
+    var tree Tree
+    if err := getJSONObject(tx, hash, TreeObject, &tree); err != nil {
+        return err
+    }
+    if tree.Raw != "" {
+        if _, err := getObject(tx, tree.Raw, BlobObject); err != nil {
+            return err
+        }
+    }
+    for _, entry := range tree.Entries {
+        var err error
+        if entry.Kind == KindFile {
+            err = checkTree(tx, entry.Hash, seen)
+        } else {
+            _, err = getObject(tx, entry.Hash, BlobObject)
+        }
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// isAncestorTx is isAncestor within a transaction
+func isAncestorTx(tx *bbolt.Tx, ancestor, hash string) (bool, error) {
+    queue := []string{hash}
+    seen := map[string]bool{hash: true}
+    for len(queue) > 0 {
+        hash, queue = queue[0], queue[1:]
+        if hash == ancestor {
+            return true, nil
+        }
+        
+        var obj commitObject
+        if err := getJSONObject(tx, hash, CommitObject, &obj); err != nil {
+            return false, err
+        }
+        for _, parent := range []string{obj.Parent, obj.MergeParent} {
+            if parent != "" && !seen[parent] {
+                seen[parent] = true
+                queue = append(queue, parent)
+            }
+        }
+    }
+    return false, nil
+}
+
+func shortHash(hash string) string {
+    if hash == "" {
+        return "nothing"
+    }
+    if len(hash) > 8 {
+        return hash[:8]
+    }
+    return hash
+}
+
+// pkg/storage/remotes.go
+package storage
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/url"
+    "sort"
+    "strings"
+    
+    "go.etcd.io/bbolt"
+)
+
+// Remote-tracking refs record where a remote's branches were at the last
+// fetch or push, as refs/remotes/<remote>/<branch>
+const remotePrefix = "refs/remotes/"
+
+type Remote struct {
+    Name string `json:"name"`
+    URL  string `json:"url"`
+}
+
+// AddRemote records a remote repository by name
+func (r *Repository) AddRemote(name, rawURL string) error {
+    if err := validateRefName("remote", name); err != nil || strings.Contains(name, "/") {
+        return fmt.Errorf("invalid remote name: %q", name)
+    }
+    u, err := url.Parse(rawURL)
+    if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+        return fmt.Errorf("invalid remote URL %q: expected http:// or https://", rawURL)
+    }
+    
+    return r.db.Update(func(tx *bbolt.Tx) error {
+        remotes := tx.Bucket([]byte("remotes"))
+        if remotes.Get([]byte(name)) != nil {
+            return fmt.Errorf("remote '%s' already exists", name)
+        }
+        data, err := json.Marshal(Remote{Name: name, URL: rawURL})
+        if err != nil {
+            return err
+        }
+        return remotes.Put([]byte(name), data)
+    })
+}
+
+// RemoveRemote forgets a remote and its remote-tracking refs
+func (r *Repository) RemoveRemote(name string) error {
+    return r.db.Update(func(tx *bbolt.Tx) error {
+        remotes := tx.Bucket([]byte("remotes"))
+        if remotes.Get([]byte(name)) == nil {
+            return fmt.Errorf("remote '%s' not found", name)
+        }
+        if err := remotes.Delete([]byte(name)); err != nil {
+            return err
+        }
+        
+        refs := tx.Bucket([]byte("refs"))
+        prefix := remotePrefix + name + "/"
+        var tracking [][]byte
+        c := refs.Cursor()
+        for k, _ := c.Seek([]byte(prefix)); k != nil && strings.HasPrefix(string(k), prefix); k, _ = c.Next() {
+            tracking = append(tracking, append([]byte(nil), k...))
+        }
+        for _, ref := range tracking {
+            if err := refs.Delete(ref); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+}
+
+// GetRemote returns the remote with the given name
+func (r *Repository) GetRemote(name string) (*Remote, error) {
+    var remote *Remote
+    err := r.db.View(func(tx *bbolt.Tx) error {
+        data := tx.Bucket([]byte("remotes")).Get([]byte(name))
+        if data == nil {
+            return fmt.Errorf("remote '%s' not found", name)
+        }
+        remote = &Remote{}
+        return json.Unmarshal(data, remote)
+    })
+    return remote, err
+}
+
+// ListRemotes returns all remotes sorted by name
+func (r *Repository) ListRemotes() ([]*Remote, error) {
+    var remotes []*Remote
+    err := r.db.View(func(tx *bbolt.Tx) error {
+        return tx.Bucket([]byte("remotes")).ForEach(func(k, v []byte) error {
+            remote := &Remote{}
+            if err := json.Unmarshal(v, remote); err != nil {
+                return fmt.Errorf("remote %s: %w", k, err)
+            }
+            remotes = append(remotes, remote)
+            return nil
+        })
+    })
+    
+    sort.Slice(remotes, func(i, j int) bool { return remotes[i].Name < remotes[j].Name })
+    return remotes, err
+}
+
+// RemoteBranches returns the remote-tracking refs of a remote, keyed by
+// branch name
+func (r *Repository) RemoteBranches(remote string) (map[string]string, error) {
+    branches := map[string]string{}
+    err := r.db.View(func(tx *bbolt.Tx) error {
+        prefix := remotePrefix + remote + "/"
+        c := tx.Bucket([]byte("refs")).Cursor()
+        for k, v := c.Seek([]byte(prefix)); k != nil && strings.HasPrefix(string(k), prefix); k, v = c.Next() {
+            branches[strings.TrimPrefix(string(k), prefix)] = string(v)
+        }
+        return nil
+    })
+    return branches, err
+}
+
+// RemoteRef names the remote-tracking ref of a remote's branch
+func RemoteRef(remote, branch string) string {
+    return remotePrefix + remote + "/" + branch
+}
+
+// pkg/storage/merge.go
+package storage
+
+import (
+    "encoding/json"
+    "fmt"
+    
+    "go.etcd.io/bbolt"
+    "netgit/pkg/config"
+    "netgit/pkg/merge"
+)
+
+type MergeResult struct {
+    Commit      *Commit
+    FastForward bool
+    UpToDate    bool
+    Conflicts   []merge.Conflict
+}
+
+// MergeState is kept in the config bucket while a conflicted merge waits
+// for --continue or --abort
+type MergeState struct {
+    Head      string           `json:"head"`
+    Message   string           `json:"message"`
+    Files     []string         `json:"files"`
+    Conflicts []merge.Conflict `json:"conflicts"`
+}
+
+const mergeStateKey = "MERGE_STATE"
+
+func (r *Repository) mergeState() (*MergeState, error) {
+    var state *MergeState
+    err := r.db.View(func(tx *bbolt.Tx) error {
+        data := tx.Bucket([]byte("config")).Get([]byte(mergeStateKey))
+        if data == nil {
+            return nil
+        }
+        state = &MergeState{}
+        return json.Unmarshal(data, state)
+    })
+    return state, err
+}
+
+func (r *Repository) setMergeState(state *MergeState) error {
+    return r.db.Update(func(tx *bbolt.Tx) error {
+        bucket := tx.Bucket([]byte("config"))
+        if state == nil {
+            return bucket.Delete([]byte(mergeStateKey))
+        }
+        data, err := json.Marshal(state)
+        if err != nil {
+            return err
+        }
+        return bucket.Put([]byte(mergeStateKey), data)
+    })
+}
+
+// PendingMerge returns the state of a conflicted merge, or nil
+func (r *Repository) PendingMerge() (*MergeState, error) {
+    return r.mergeState()
+}
+
+// mergeBase finds the nearest common ancestor of two commits, or "" when
+// their histories are unrelated
+func (r *Repository) mergeBase(a, b string) (string, error) {
+    ancestors := map[string]bool{}
+    err := r.walkAncestors(a, func(commit *Commit) bool {
+        ancestors[commit.Hash] = true
+        return true
+    })
+    if err != nil {
+        return "", err
+    }
+    
+    base := ""
+    err = r.walkAncestors(b, func(commit *Commit) bool {
+        if ancestors[commit.Hash] {
+            base = commit.Hash
+            return false
+        }
+        return true
+    })
+    return base, err
+}
+
+// Merge merges a branch or commit into HEAD. A HEAD that is an ancestor of
+// the other side is fast-forwarded. Otherwise the files are merged three
+// ways against the common ancestor and committed with two parents; on
+// conflicts the merged files are written, conflicts keeping our side, and
+// the merge waits for ContinueMerge or AbortMerge.
+func (r *Repository) Merge(name, author string) (*MergeResult, error) {
+    if state, err := r.mergeState(); err != nil {
+        return nil, err
+    } else if state != nil {
+        return nil, fmt.Errorf("merge already in progress; run 'netgit merge --continue' or 'netgit merge --abort'")
+    }
+    
+    _, isBranch := r.branchHash(name)
+    theirHash, err := r.ResolveCommit(name)
+    if err != nil {
+        return nil, err
+    }
+    theirs, err := r.GetCommit(theirHash)
+    if err != nil {
+        return nil, fmt.Errorf("'%s' is not a branch or commit", name)
+    }
+    
+    status, err := r.Status()
+    if err != nil {
+        return nil, err
+    }
+    if !status.Clean {
+        return nil, fmt.Errorf("working directory has uncommitted changes; commit them before merging")
+    }
+    
+    // A branch with no commits yet, as after init, simply adopts theirs
+    if r.unborn() {
+        if err := r.WriteFiles(theirs.Files); err != nil {
+            return nil, err
+        }
+        err := r.db.Update(func(tx *bbolt.Tx) error {
+            return advanceHEAD(tx, theirs.Hash)
+        })
+        return &MergeResult{Commit: theirs, FastForward: true}, err
+    }
+    
+    head, err := r.GetHEAD()
+    if err != nil {
+        return nil, err
+    }
+    
+    base, err := r.mergeBase(head.Hash, theirs.Hash)
+    if err != nil {
+        return nil, err
+    }
+    
+    if base == theirs.Hash {
+        return &MergeResult{Commit: head, UpToDate: true}, nil
+    }
+    
+    if base == head.Hash {
+        if err := r.removeUntracked(head.Files, theirs.Files); err != nil {
+            return nil, err
+        }
+        if err := r.WriteFiles(theirs.Files); err != nil {
+            return nil, err
+        }
+        err := r.db.Update(func(tx *bbolt.Tx) error {
+            return advanceHEAD(tx, theirs.Hash)
+        })
+        return &MergeResult{Commit: theirs, FastForward: true}, err
+    }
+    
+    var baseFiles []config.NetworkConfig
+    if base != "" {
+        baseCommit, err := r.GetCommit(base)
+        if err != nil {
+            return nil, err
+        }
+        baseFiles = baseCommit.SourceFiles()
+    }
+    
+    merged, conflicts := merge.ThreeWay(baseFiles, head.SourceFiles(), theirs.SourceFiles())
+    if err := r.removeUntracked(head.Files, merged); err != nil {
+        return nil, err
+    }
+    if err := r.WriteFiles(merged); err != nil {
+        return nil, err
+    }
+    
+    message := fmt.Sprintf("Merge branch '%s'", name)
+    if !isBranch {
+        message = fmt.Sprintf("Merge commit '%s'", theirs.Hash[:8])
+    }
+    
+    if len(conflicts) > 0 {
+        state := &MergeState{Head: theirs.Hash, Message: message, Conflicts: conflicts}
+        for _, file := range merged {
+            state.Files = append(state.Files, file.Source)
+        }
+        return &MergeResult{Conflicts: conflicts}, r.setMergeState(state)
+    }
+    
+    commit, err := r.commit(merged, message, author, theirs.Hash)
+    if err != nil {
+        return nil, err
+    }
+    return &MergeResult{Commit: commit}, nil
+}
+
+// ContinueMerge records the resolved working directory as the merge commit
+func (r *Repository) ContinueMerge(author string) (*Commit, error) {
+    state, err := r.mergeState()
+    if err != nil {
+        return nil, err
+    }
+    if state == nil {
+        return nil, fmt.Errorf("no merge in progress")
+    }
+    
+    configs, err := config.LoadWorkingDirectory(r.path)
+    if err != nil {
+        return nil, err
+    }
+    
+    commit, err := r.commit(configs, state.Message, author, state.Head)
+    if err != nil {
+        return nil, err
+    }
+    return commit, r.setMergeState(nil)
+}
+
+// AbortMerge restores the working directory to HEAD and forgets the merge
+func (r *Repository) AbortMerge() error {
+    state, err := r.mergeState()
+    if err != nil {
+        return err
+    }
+    if state == nil {
+        return fmt.Errorf("no merge in progress")
+    }
+    
+    head, err := r.GetHEAD()
+    if err != nil {
+        return err
+    }
+    
+    var written []config.NetworkConfig
+    for _, file := range state.Files {
+        written = append(written, config.NetworkConfig{Source: file})
+    }
+    if err := r.removeUntracked(written, head.Files); err != nil {
+        return err
+    }
+    if err := r.WriteFiles(head.Files); err != nil {
+        return err
+    }
+    
+    return r.setMergeState(nil)
+}
+
+// pkg/config/parser.go
+package config
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "path/filepath"
+    "reflect"
+    "strings"
+    
+    "gopkg.in/yaml.v3"
+)
+
+// Resource kinds, used wherever resources are identified as kind/name
+const (
+    KindSecurityGroup = "securityGroup"
+    KindNetworkPolicy = "networkPolicy"
+    KindFirewallRule  = "firewallRule"
+)
+
+type NetworkConfig struct {
+    Metadata         Metadata          `yaml:"metadata" json:"metadata"`
+    SecurityGroups   []SecurityGroup   `yaml:"securityGroups" json:"securityGroups"`
+    NetworkPolicies  []NetworkPolicy   `yaml:"networkPolicies" json:"networkPolicies"`
+    FirewallRules    []FirewallRule    `yaml:"firewallRules" json:"firewallRules"`
+    
+    // Variables declares the values that ${name} references resolve to.
+    // In an overlay it sets new values for variables declared elsewhere.
+    Variables map[string]Variable `yaml:"variables,omitempty" json:"variables,omitempty"`
+    
+    // Delete lists kind/name references, such as firewallRule/office-ssh,
+    // to resources an overlay removes from the base configs
+    Delete []string `yaml:"delete,omitempty" json:"delete,omitempty"`
+    
+    // Source is the file the config was loaded from, relative to the
+    // working directory root, and Raw holds the file's bytes as read
+    Source string `yaml:"-" json:"-"`
+    Raw    []byte `yaml:"-" json:"-"`
+    
+    // Fragment marks a file that other configs include. It is tracked so
+    // history can restore it, but holds no resources of its own.
+    Fragment bool `yaml:"-" json:"-"`
+}
+
+type Metadata struct {
+    Name        string            `yaml:"name" json:"name"`
+    Version     string            `yaml:"version" json:"version"`
+    Environment string            `yaml:"environment" json:"environment"`
+    Labels      map[string]string `yaml:"labels" json:"labels"`
+    
+    // Overlay marks a config that patches the base configs for its
+    // Environment instead of standing on its own
+    Overlay bool `yaml:"overlay,omitempty" json:"overlay,omitempty"`
+}
+
+type SecurityGroup struct {
+    // ID is the provider's identifier for the group, when it has one
+    // apart from the name (an AWS sg-... id)
+    ID          string `yaml:"id,omitempty" json:"id,omitempty"`
+    Name        string `yaml:"name" json:"name"`
+    Description string `yaml:"description" json:"description"`
+    VpcId       string `yaml:"vpcId" json:"vpcId"`
+    Rules       []Rule `yaml:"rules" json:"rules"`
+}
+
+// NetworkPolicy is a Kubernetes NetworkPolicy. PolicyTypes lists the
+// directions it isolates, Ingress and Egress; a policy without them
+// isolates ingress, and egress too when it has egress rules.
+type NetworkPolicy struct {
+    Name        string              `yaml:"name" json:"name"`
+    Namespace   string              `yaml:"namespace" json:"namespace"`
+    Selector    map[string]string   `yaml:"selector" json:"selector"`
+    PolicyTypes []string            `yaml:"policyTypes,omitempty" json:"policyTypes,omitempty"`
+    Ingress     []NetworkPolicyRule `yaml:"ingress" json:"ingress"`
+    Egress      []NetworkPolicyRule `yaml:"egress" json:"egress"`
+}
+
+// Types returns the directions the policy isolates, inferring them as
+// Kubernetes does when PolicyTypes is unset
+func (np NetworkPolicy) Types() []string {
+    if len(np.PolicyTypes) > 0 {
+        return np.PolicyTypes
+    }
+    types := []string{"Ingress"}
+    if len(np.Egress) > 0 {
+        types = append(types, "Egress")
+    }
+    return types
+}
+
+// FirewallRule is a priority-ordered rule. An empty Action means allow;
+// DestinationRanges only apply to EGRESS rules.
+type FirewallRule struct {
+    Name              string   `yaml:"name" json:"name"`
+    Direction         string   `yaml:"direction" json:"direction"`
+    Priority          int      `yaml:"priority" json:"priority"`
+    Protocol          string   `yaml:"protocol" json:"protocol"`
+    Ports             []string `yaml:"ports" json:"ports"`
+    SourceRanges      []string `yaml:"sourceRanges" json:"sourceRanges"`
+    DestinationRanges []string `yaml:"destinationRanges,omitempty" json:"destinationRanges,omitempty"`
+    TargetTags        []string `yaml:"targetTags" json:"targetTags"`
+    Action            string   `yaml:"action,omitempty" json:"action,omitempty"`
+}
+
+type Rule struct {
+    Protocol string   `yaml:"protocol" json:"protocol"`
+    Ports    []string `yaml:"ports" json:"ports"`
+    Sources  []string `yaml:"sources" json:"sources"`
+    Action   string   `yaml:"action" json:"action"`
+}
+
+// Key names a rule by protocol and ports. Rules have no name of their own
+// and several rules may share a key, so use MatchRules to match them across
+// versions.
+func (r Rule) Key() string {
+    return fmt.Sprintf("%s:%s", r.Protocol, strings.Join(r.Ports, ","))
+}
+
+// RulePair is a rule matched across two versions of a security group, by
+// index. Old is -1 for an added rule and New -1 for a removed one.
+type RulePair struct {
+    Old, New int
+}
+
+// MatchRules matches the rules of two versions of a security group as
+// multisets: equal rules pair first, then the remaining rules that share a
+// Key pair in order, as one rule modified, then those with the same
+// protocol and overlapping ports, so that widening 80 to 80-81 modifies
+// the rule rather than replacing it. Pairs come in the order of old,
+// followed by the added rules in the order of new.
+func MatchRules(old, new []Rule) []RulePair {
+    matched := make([]int, len(old))
+    taken := make([]bool, len(new))
+    for i := range old {
+        matched[i] = -1
+        for j := range new {
+            if !taken[j] && reflect.DeepEqual(old[i], new[j]) {
+                matched[i], taken[j] = j, true
+                break
+            }
+        }
+    }
+    for _, same := range []func(a, b Rule) bool{
+        func(a, b Rule) bool { return a.Key() == b.Key() },
+        func(a, b Rule) bool { return a.Protocol == b.Protocol && PortsOverlap(a.Ports, b.Ports) },
+    } {
+        for i := range old {
+            if matched[i] >= 0 {
+                continue
+            }
+            for j := range new {
+                if !taken[j] && same(old[i], new[j]) {
+                    matched[i], taken[j] = j, true
+                    break
+                }
+            }
+        }
+    }
+    
+    pairs := make([]RulePair, 0, len(old)+len(new))
+    for i, j := range matched {
+        pairs = append(pairs, RulePair{Old: i, New: j})
+    }
+    for j := range new {
+        if !taken[j] {
+            pairs = append(pairs, RulePair{Old: -1, New: j})
+        }
+    }
+    return pairs
+}
+
+// NetworkPolicyRule matches peers in From for ingress rules and in To for
+// egress rules
+type NetworkPolicyRule struct {
+    Ports []NetworkPolicyPort `yaml:"ports" json:"ports"`
+    From  []NetworkPolicyPeer `yaml:"from" json:"from"`
+    To    []NetworkPolicyPeer `yaml:"to,omitempty" json:"to,omitempty"`
+}
+
+type NetworkPolicyPort struct {
+    Protocol string `yaml:"protocol" json:"protocol"`
+    Port     string `yaml:"port" json:"port"`
+}
+
+type NetworkPolicyPeer struct {
+    PodSelector       map[string]string `yaml:"podSelector" json:"podSelector"`
+    NamespaceSelector map[string]string `yaml:"namespaceSelector" json:"namespaceSelector"`
+    IPBlock           *IPBlock          `yaml:"ipBlock,omitempty" json:"ipBlock,omitempty"`
+}
+
+type IPBlock struct {
+    CIDR   string   `yaml:"cidr" json:"cidr"`
+    Except []string `yaml:"except,omitempty" json:"except,omitempty"`
+}
+
+// FileError reports a working-directory file that could not be loaded
+type FileError struct {
+    File string `json:"file"`
+    Err  error  `json:"-"`
+}
+
+func (e FileError) Error() string {
+    return e.Err.Error()
+}
+
+// LoadError is returned by LoadWorkingDirectory, together with every config
+// that did load, when one or more files could not be parsed.
+type LoadError struct {
+    Files []FileError
+}
+
+func (e *LoadError) Error() string {
+    messages := make([]string, len(e.Files))
+    for i, f := range e.Files {
+        messages[i] = f.Error()
+    }
+    return strings.Join(messages, "; ")
+}
+
+// LoadWorkingDirectory loads every config under path as committed:
+// variables take their declared values and overlays are loaded as files
+// without being applied
+func LoadWorkingDirectory(path string) ([]NetworkConfig, error) {
+    set, err := scanWorkingDirectory(path, "")
+    if err != nil {
+        return nil, err
+    }
+    return set.decode()
+}
+
+// LoadEnvironment loads the configs under path as env sees them: variables
+// take env's values and env's overlays are applied with ApplyEnvironment.
+// Files that fail to load are reported in a LoadError, as by
+// LoadWorkingDirectory, but the environment is not assembled without them.
+func LoadEnvironment(path, env string) ([]NetworkConfig, error) {
+    set, err := scanWorkingDirectory(path, env)
+    if err != nil {
+        return nil, err
+    }
+    configs, err := set.decode()
+    if err != nil {
+        return nil, err
+    }
+    return ApplyEnvironment(configs, env)
+}
+
+// ValidateWorkingDirectory validates every config under path with the
+// variables env sees, reporting files that fail to load as problems too.
+// Fragments are checked through the configs that include them.
+func ValidateWorkingDirectory(path, env string) ([]Problem, error) {
+    set, err := scanWorkingDirectory(path, env)
+    if err != nil {
+        return nil, err
+    }
+    
+    var problems []Problem
+    for _, f := range set.failed {
+        var invalid *ValidationError
+        if errors.As(f.Err, &invalid) {
+            problems = append(problems, invalid.Problems...)
+        } else {
+            problems = append(problems, Problem{File: f.File, Message: f.Err.Error()})
+        }
+    }
+    for _, source := range set.sources {
+        if p, ok := set.parsed[source]; ok && !set.included[source] {
+            problems = append(problems, p.check(true)...)
+        }
+    }
+    return problems, nil
+}
+
+// workingSet is a working directory parsed file by file, with includes and
+// variables resolved
+type workingSet struct {
+    sources  []string
+    parsed   map[string]*parsedFile
+    included map[string]bool
+    failed   []FileError
+}
+
+func scanWorkingDirectory(path, env string) (*workingSet, error) {
+    files, err := WorkingDirectoryFiles(path)
+    if err != nil {
+        return nil, err
+    }
+    
+    // Every file is parsed before any is decoded: a file that another one
+    // includes is a fragment, tracked for its bytes but not a config itself
+    set := &workingSet{
+        sources:  make([]string, len(files)),
+        parsed:   map[string]*parsedFile{},
+        included: map[string]bool{},
+    }
+    for i, file := range files {
+        source, err := filepath.Rel(path, file)
+        if err != nil {
+            return nil, err
+        }
+        set.sources[i] = filepath.ToSlash(source)
+        
+        p, err := parseFile(file, path)
+        if err != nil {
+            set.failed = append(set.failed, FileError{File: set.sources[i], Err: err})
+            continue
+        }
+        set.parsed[set.sources[i]] = p
+        for _, include := range p.includes {
+            set.included[include] = true
+        }
+    }
+    
+    // Variables are shared by the whole directory, so references are only
+    // resolved once every file's declarations are known
+    var configs []*parsedFile
+    for _, source := range set.sources {
+        if p, ok := set.parsed[source]; ok && !set.included[source] {
+            configs = append(configs, p)
+        }
+    }
+    values, problems := collectVariables(configs, env, true)
+    for _, source := range set.sources {
+        p, ok := set.parsed[source]
+        if !ok || set.included[source] {
+            continue
+        }
+        
+        // An overlay resolves with its own environment's values. Problems
+        // with those values were reported above, against their files.
+        values := values
+        if meta := p.metadata(); meta.Overlay && meta.Environment != env {
+            values, _ = collectVariables(configs, meta.Environment, true)
+        }
+        if found := append(problems[p], p.substitute(values, true)...); len(found) > 0 {
+            set.failed = append(set.failed, FileError{File: source, Err: &ValidationError{Problems: found}})
+            delete(set.parsed, source)
+        }
+    }
+    return set, nil
+}
+
+func (set *workingSet) decode() ([]NetworkConfig, error) {
+    var configs []NetworkConfig
+    failed := set.failed
+    for _, source := range set.sources {
+        p, ok := set.parsed[source]
+        if !ok {
+            continue
+        }
+        if set.included[source] {
+            configs = append(configs, NetworkConfig{Source: source, Raw: p.raw, Fragment: true})
+            continue
+        }
+        
+        config, err := p.decode()
+        if err != nil {
+            failed = append(failed, FileError{File: source, Err: err})
+            continue
+        }
+        
+        config.Source = source
+        configs = append(configs, *config)
+    }
+    
+    if len(failed) > 0 {
+        return configs, &LoadError{Files: failed}
+    }
+    return configs, nil
+}
+
+// LoadFile loads a single config. Only the variables the file declares
+// itself are resolved.
+func LoadFile(filename string) (*NetworkConfig, error) {
+    p, err := parseFile(filename, "")
+    if err != nil {
+        return nil, err
+    }
+    values, problems := collectVariables([]*parsedFile{p}, "", false)
+    if found := append(problems[p], p.substitute(values, true)...); len(found) > 0 {
+        return nil, &ValidationError{Problems: found}
+    }
+    return p.decode()
+}
+
+func (c *NetworkConfig) ToYAML() ([]byte, error) {
+    return yaml.Marshal(c)
+}
+
+func (c *NetworkConfig) ToJSON() ([]byte, error) {
+    return json.MarshalIndent(c, "", "  ")
+}
+
+// pkg/config/loader.go
+package config
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "io"
+    "io/fs"
+    "io/ioutil"
+    "os"
+    "path"
+    "path/filepath"
+    "reflect"
+    "sort"
+    "strings"
+    
+    "gopkg.in/yaml.v3"
+)
+
+// includeTag replaces the node it is on with the contents of another file,
+// e.g. `sources: !include shared/office-cidrs.yaml`. Inside a list, an
+// included list is spliced in rather than nested.
+const includeTag = "!include"
+
+// Paths the loader skips unless .netgitignore re-includes them: hidden
+// files and directories, which hold netgit's own state, the policy
+// directory read by verify, and the audit log netgit writes beside the
+// configs
+var defaultIgnores = []string{".*", "/policies/", "/audit.json"}
+
+type ignoreRule struct {
+    pattern  string
+    negate   bool
+    dirOnly  bool
+    anchored bool
+}
+
+// loadIgnoreRules reads root/.netgitignore. Patterns follow a subset of
+// gitignore: # comments, ! negation, a trailing / matching directories only
+// and a / anywhere else anchoring the pattern at the root. Unanchored
+// patterns match a base name at any depth; ** is not supported.
+func loadIgnoreRules(root string) ([]ignoreRule, error) {
+    lines := defaultIgnores
+    data, err := ioutil.ReadFile(filepath.Join(root, ".netgitignore"))
+    if err == nil {
+        lines = append(lines, strings.Split(string(data), "\n")...)
+    } else if !os.IsNotExist(err) {
+        return nil, err
+    }
+    
+    var rules []ignoreRule
+    for _, line := range lines {
+        line = strings.TrimSpace(line)
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        
+        var rule ignoreRule
+        if strings.HasPrefix(line, "!") {
+            rule.negate = true
+            line = line[1:]
+        }
+        if strings.HasSuffix(line, "/") {
+            rule.dirOnly = true
+            line = strings.TrimSuffix(line, "/")
+        }
+        if strings.Contains(line, "/") {
+            rule.anchored = true
+            line = strings.TrimPrefix(line, "/")
+        }
+        if _, err := path.Match(line, ""); err != nil {
+            return nil, fmt.Errorf(".netgitignore: bad pattern %q", line)
+        }
+        rule.pattern = line
+        rules = append(rules, rule)
+    }
+    return rules, nil
+}
+
+// ignored reports whether the slash-separated path rel is ignored. As in
+// gitignore, the last matching rule wins.
+func ignored(rules []ignoreRule, rel string, dir bool) bool {
+    result := false
+    for _, rule := range rules {
+        if rule.dirOnly && !dir {
+            continue
+        }
+        name := rel
+        if !rule.anchored {
+            name = path.Base(rel)
+        }
+        if ok, _ := path.Match(rule.pattern, name); ok {
+            result = !rule.negate
+        }
+    }
+    return result
+}
+
+// WorkingDirectoryFiles lists the config files in the tree under root that
+// .netgitignore does not exclude, sorted
+func WorkingDirectoryFiles(root string) ([]string, error) {
+    rules, err := loadIgnoreRules(root)
+    if err != nil {
+        return nil, err
+    }
+    
+    var files []string
+    err = filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+        if err != nil {
+            return err
+        }
+        if file == root {
+            return nil
+        }
+        
+        rel, err := filepath.Rel(root, file)
+        if err != nil {
+            return err
+        }
+        if ignored(rules, filepath.ToSlash(rel), d.IsDir()) {
+            if d.IsDir() {
+                return filepath.SkipDir
+            }
+            return nil
+        }
+        
+        switch filepath.Ext(file) {
+        case ".yaml", ".yml", ".json":
+            if !d.IsDir() {
+                files = append(files, file)
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    
+    sort.Strings(files)
+    return files, nil
+}
+
+// parsedFile is a config file read into YAML nodes, one per document, with
+// its includes resolved
+type parsedFile struct {
+    name string
+    raw  []byte
+    docs []*yaml.Node
+    
+    // origins maps included subtrees to the file they were read from, so
+    // problems inside them are reported against that file
+    origins map[*yaml.Node]string
+    
+    // includes lists every file included, directly or not, relative to
+    // the working directory root
+    includes []string
+}
+
+func parseFile(filename, root string) (*parsedFile, error) {
+    data, err := ioutil.ReadFile(filename)
+    if err != nil {
+        return nil, err
+    }
+    return parseData(filename, data, root)
+}
+
+// parseData parses a config file's contents. Includes are read relative to
+// the including file and, when root is set, must stay inside it.
+func parseData(filename string, data []byte, root string) (*parsedFile, error) {
+    docs, err := parseDocuments(data)
+    if err != nil {
+        return nil, fmt.Errorf("failed to parse %s: %w", filename, err)
+    }
+    
+    p := &parsedFile{name: filename, raw: data, docs: docs, origins: map[*yaml.Node]string{}}
+    r := &includeResolver{parsed: p}
+    if root != "" {
+        if r.root, err = filepath.Abs(root); err != nil {
+            return nil, err
+        }
+    }
+    if err := r.push(filename); err != nil {
+        return nil, err
+    }
+    for _, doc := range docs {
+        if err := r.resolve(doc, filename); err != nil {
+            return nil, err
+        }
+    }
+    return p, nil
+}
+
+// parseDocuments returns the root node of each document in a YAML stream.
+// JSON parses as a single document.
+func parseDocuments(data []byte) ([]*yaml.Node, error) {
+    var docs []*yaml.Node
+    decoder := yaml.NewDecoder(bytes.NewReader(data))
+    for {
+        var doc yaml.Node
+        err := decoder.Decode(&doc)
+        if errors.Is(err, io.EOF) {
+            return docs, nil
+        }
+        if err != nil {
+            return nil, err
+        }
+        if len(doc.Content) == 0 || doc.Content[0].Tag == "!!null" {
+            continue
+        }
+        docs = append(docs, doc.Content[0])
+    }
+}
+
+// decode builds the file's config. The documents of a multi-document file
+// are combined into one config. JSON is decoded from its YAML nodes too, so
+// that variables resolve the same way in both formats.
+func (p *parsedFile) decode() (*NetworkConfig, error) {
+    var config NetworkConfig
+    for _, doc := range p.docs {
+        var part NetworkConfig
+        if err := doc.Decode(&part); err != nil {
+            return nil, fmt.Errorf("failed to parse %s: %w", p.name, err)
+        }
+        config.merge(part)
+    }
+    
+    if problems := p.check(false); len(problems) > 0 {
+        return nil, &ValidationError{Problems: problems}
+    }
+    
+    config.Raw = p.raw
+    return &config, nil
+}
+
+// merge adds another document's resources to c. Metadata comes from the
+// first document that has any.
+func (c *NetworkConfig) merge(doc NetworkConfig) {
+    if reflect.DeepEqual(c.Metadata, Metadata{}) {
+        c.Metadata = doc.Metadata
+    }
+    c.SecurityGroups = append(c.SecurityGroups, doc.SecurityGroups...)
+    c.NetworkPolicies = append(c.NetworkPolicies, doc.NetworkPolicies...)
+    c.FirewallRules = append(c.FirewallRules, doc.FirewallRules...)
+    for name, v := range doc.Variables {
+        if c.Variables == nil {
+            c.Variables = map[string]Variable{}
+        }
+        c.Variables[name] = v
+    }
+    c.Delete = append(c.Delete, doc.Delete...)
+}
+
+type includeFrame struct {
+    abs  string
+    name string
+}
+
+type includeResolver struct {
+    parsed *parsedFile
+    root   string
+    stack  []includeFrame
+}
+
+func (r *includeResolver) push(name string) error {
+    abs, err := filepath.Abs(name)
+    if err != nil {
+        return err
+    }
+    r.stack = append(r.stack, includeFrame{abs, name})
+    return nil
+}
+
+// resolve replaces the !include nodes under n, which was read from file
+func (r *includeResolver) resolve(n *yaml.Node, file string) error {
+    if n.Tag == includeTag {
+        fragment, err := r.load(n, file)
+        if err != nil {
+            return err
+        }
+        r.replace(n, fragment)
+        return nil
+    }
+    
+    if n.Kind != yaml.SequenceNode {
+        for _, child := range n.Content {
+            if err := r.resolve(child, file); err != nil {
+                return err
+            }
+        }
+        return nil
+    }
+    
+    var items []*yaml.Node
+    for _, item := range n.Content {
+        if item.Tag != includeTag {
+            if err := r.resolve(item, file); err != nil {
+                return err
+            }
+            items = append(items, item)
+            continue
+        }
+        
+        fragment, err := r.load(item, file)
+        if err != nil {
+            return err
+        }
+        if fragment.Kind == yaml.SequenceNode {
+            items = append(items, fragment.Content...)
+            continue
+        }
+        r.replace(item, fragment)
+        items = append(items, item)
+    }
+    n.Content = items
+    return nil
+}
+
+// replace overwrites n with an included fragment, keeping the fragment's
+// origin for error positions
+func (r *includeResolver) replace(n, fragment *yaml.Node) {
+    origin := r.parsed.origins[fragment]
+    *n = *fragment
+    r.parsed.origins[n] = origin
+}
+
+// load reads the file an !include node names and resolves its own includes
+func (r *includeResolver) load(n *yaml.Node, file string) (*yaml.Node, error) {
+    fail := func(format string, args ...interface{}) error {
+        return &ValidationError{Problems: []Problem{{
+            File:    file,
+            Line:    n.Line,
+            Column:  n.Column,
+            Message: fmt.Sprintf(format, args...),
+        }}}
+    }
+    
+    if n.Kind != yaml.ScalarNode || n.Value == "" {
+        return nil, fail("%s takes a file path", includeTag)
+    }
+    if filepath.IsAbs(n.Value) {
+        return nil, fail("include %q: path must be relative", n.Value)
+    }
+    target := filepath.Join(filepath.Dir(file), filepath.FromSlash(n.Value))
+    abs, err := filepath.Abs(target)
+    if err != nil {
+        return nil, err
+    }
+    
+    if r.root != "" {
+        rel, err := filepath.Rel(r.root, abs)
+        if err != nil || !filepath.IsLocal(rel) {
+            return nil, fail("include %q is outside the working directory", n.Value)
+        }
+        r.parsed.includes = append(r.parsed.includes, filepath.ToSlash(rel))
+    }
+    for i, frame := range r.stack {
+        if frame.abs == abs {
+            var chain []string
+            for _, f := range r.stack[i:] {
+                chain = append(chain, f.name)
+            }
+            return nil, fail("include cycle: %s -> %s", strings.Join(chain, " -> "), target)
+        }
+    }
+    
+    data, err := ioutil.ReadFile(target)
+    if os.IsNotExist(err) {
+        return nil, fail("included file %q does not exist", n.Value)
+    } else if err != nil {
+        return nil, fail("cannot include %q: %v", n.Value, err)
+    }
+    docs, err := parseDocuments(data)
+    if err != nil {
+        return nil, fail("cannot include %q: %v", n.Value, err)
+    }
+    if len(docs) != 1 {
+        return nil, fail("included file %q must hold exactly one document", n.Value)
+    }
+    
+    fragment := docs[0]
+    r.stack = append(r.stack, includeFrame{abs, target})
+    err = r.resolve(fragment, target)
+    r.stack = r.stack[:len(r.stack)-1]
+    if err != nil {
+        return nil, err
+    }
+    
+    markOrigin(r.parsed.origins, fragment, target)
+    return fragment, nil
+}
+
+// metadata decodes the metadata of the file's first document that has any
+func (p *parsedFile) metadata() Metadata {
+    var meta Metadata
+    for _, doc := range p.docs {
+        if n := mappingValue(doc, "metadata"); n != nil && n.Decode(&meta) == nil {
+            break
+        }
+    }
+    return meta
+}
+
+// markOrigin records file as the origin of n and its descendants, leaving
+// nodes that came from deeper includes alone
+func markOrigin(origins map[*yaml.Node]string, n *yaml.Node, file string) {
+    if _, ok := origins[n]; ok {
+        return
+    }
+    origins[n] = file
+    for _, child := range n.Content {
+        markOrigin(origins, child, file)
+    }
+}
+
+// pkg/config/validate.go
+package config
+
+import (
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "net"
+    "reflect"
+    "sort"
+    "strconv"
+    "strings"
+    
+    "gopkg.in/yaml.v3"
+)
+
+// Problem is a validation finding, positioned in the file it was found in
+type Problem struct {
+    File    string `json:"file"`
+    Line    int    `json:"line"`
+    Column  int    `json:"column"`
+    Path    string `json:"path,omitempty"`
+    Message string `json:"message"`
+}
+
+func (p Problem) String() string {
+    if p.Line == 0 {
+        return fmt.Sprintf("%s: %s", p.File, p.Message)
+    }
+    if p.Path == "" {
+        return fmt.Sprintf("%s:%d:%d: %s", p.File, p.Line, p.Column, p.Message)
+    }
+    return fmt.Sprintf("%s:%d:%d: %s: %s", p.File, p.Line, p.Column, p.Path, p.Message)
+}
+
+// ValidationError is returned when a file does not match the config schema
+type ValidationError struct {
+    Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+    messages := make([]string, len(e.Problems))
+    for i, p := range e.Problems {
+        messages[i] = p.String()
+    }
+    return strings.Join(messages, "; ")
+}
+
+// ValidateFile reads and validates one config file
+func ValidateFile(filename string) ([]Problem, error) {
+    data, err := ioutil.ReadFile(filename)
+    if err != nil {
+        return nil, err
+    }
+    return Validate(filename, data)
+}
+
+// Validate checks a config file's contents against the schema and the
+// syntax of every typed field: CIDRs, port ranges, protocols, directions,
+// actions and priorities. JSON is read through the YAML parser, which
+// accepts it and keeps positions. Included fragments are checked in place
+// and their problems reported against the fragment's file. The error is
+// only for unparseable input.
+//
+// Variables the file declares are resolved; references to variables
+// declared elsewhere are left unchecked, as is an overlay's file. Use
+// ValidateWorkingDirectory to resolve them.
+func Validate(file string, data []byte) ([]Problem, error) {
+    p, err := parseData(file, data, "")
+    var invalid *ValidationError
+    if errors.As(err, &invalid) {
+        return invalid.Problems, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    
+    values, problems := collectVariables([]*parsedFile{p}, "", false)
+    found := append(problems[p], p.substitute(values, false)...)
+    return append(found, p.check(true)...), nil
+}
+
+// check validates every document of the file. With values unset only
+// unknown and mistyped fields are reported; loading runs that much so that
+// a misspelt key is not silently ignored.
+func (p *parsedFile) check(values bool) []Problem {
+    v := &validator{file: p.name, values: values, overlay: p.metadata().Overlay, origins: p.origins}
+    for _, doc := range p.docs {
+        v.walk(doc, reflect.TypeOf(NetworkConfig{}), "", nil)
+    }
+    sort.SliceStable(v.problems, func(i, j int) bool {
+        a, b := v.problems[i], v.problems[j]
+        if a.File != b.File {
+            return a.File < b.File
+        }
+        if a.Line != b.Line {
+            return a.Line < b.Line
+        }
+        return a.Column < b.Column
+    })
+    return v.problems
+}
+
+// fieldChecks validate scalar fields, keyed by struct type and YAML key.
+// A check on a list field applies to each item.
+var fieldChecks = map[string]func(string) error{
+    "Rule.protocol":                  checkProtocol,
+    "Rule.ports":                     checkPorts,
+    "Rule.sources":                   checkSource,
+    "Rule.action":                    checkAction,
+    "FirewallRule.direction":         checkDirection,
+    "FirewallRule.priority":          checkPriority,
+    "FirewallRule.protocol":          checkProtocol,
+    "FirewallRule.ports":             checkPorts,
+    "FirewallRule.sourceRanges":      checkRange,
+    "FirewallRule.destinationRanges": checkRange,
+    "FirewallRule.action":            checkAction,
+    "NetworkPolicy.policyTypes":      checkPolicyType,
+    "NetworkPolicyPort.protocol":     checkPolicyProtocol,
+    "NetworkPolicyPort.port":         checkPolicyPort,
+    "IPBlock.cidr":                   checkCIDR,
+    "IPBlock.except":                 checkCIDR,
+    "Variable.type":                  checkVariableType,
+    "NetworkConfig.delete":           checkResourceRef,
+}
+
+// requiredFields lists the fields a resource must set. An overlay only
+// needs the name that matches its resources to the base's.
+var requiredFields = map[string][]string{
+    "SecurityGroup": {"name"},
+    "NetworkPolicy": {"name"},
+    "FirewallRule":  {"name", "protocol"},
+    "Rule":          {"protocol"},
+    "IPBlock":       {"cidr"},
+}
+
+type validator struct {
+    file     string
+    values   bool
+    overlay  bool
+    origins  map[*yaml.Node]string
+    problems []Problem
+}
+
+func (v *validator) report(n *yaml.Node, path, format string, args ...interface{}) {
+    v.problems = append(v.problems, Problem{
+        File:    v.file,
+        Line:    n.Line,
+        Column:  n.Column,
+        Path:    path,
+        Message: fmt.Sprintf(format, args...),
+    })
+}
+
+// walk checks node n against type t
+func (v *validator) walk(n *yaml.Node, t reflect.Type, path string, check func(string) error) {
+    if n.Kind == yaml.AliasNode {
+        n = n.Alias
+    }
+    if origin, ok := v.origins[n]; ok {
+        file := v.file
+        v.file = origin
+        defer func() { v.file = file }()
+    }
+    if n.Kind == yaml.ScalarNode && n.Tag == "!!null" {
+        return
+    }
+    for t.Kind() == reflect.Ptr {
+        t = t.Elem()
+    }
+    // A reference left unresolved is checked once its variable is known
+    if n.Kind == yaml.ScalarNode && strings.Contains(n.Value, "${") {
+        return
+    }
+    
+    switch t.Kind() {
+    case reflect.Struct:
+        if n.Kind != yaml.MappingNode {
+            v.report(n, path, "expected a mapping")
+            return
+        }
+        fields := yamlFields(t)
+        seen := map[string]bool{}
+        for i := 0; i+1 < len(n.Content); i += 2 {
+            key, value := n.Content[i], n.Content[i+1]
+            field, ok := fields[key.Value]
+            if !ok {
+                v.report(key, path, "unknown field %q", key.Value)
+                continue
+            }
+            if seen[key.Value] {
+                v.report(key, path, "field %q is set more than once", key.Value)
+                continue
+            }
+            seen[key.Value] = true
+            v.walk(value, field.Type, joinPath(path, key.Value), fieldChecks[t.Name()+"."+key.Value])
+        }
+        if v.values {
+            for _, name := range requiredFields[t.Name()] {
+                if !seen[name] && (!v.overlay || name == "name") {
+                    v.report(n, path, "missing required field %q", name)
+                }
+            }
+        }
+    
+    case reflect.Slice:
+        if n.Kind != yaml.SequenceNode {
+            v.report(n, path, "expected a list")
+            return
+        }
+        for i, item := range n.Content {
+            v.walk(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), check)
+        }
+    
+    case reflect.Map:
+        if n.Kind != yaml.MappingNode {
+            v.report(n, path, "expected a mapping")
+            return
+        }
+        for i := 0; i+1 < len(n.Content); i += 2 {
+            v.walk(n.Content[i+1], t.Elem(), joinPath(path, n.Content[i].Value), nil)
+        }
+    
+    case reflect.String:
+        if n.Kind != yaml.ScalarNode {
+            v.report(n, path, "expected a string")
+            return
+        }
+        if v.values && check != nil {
+            if err := check(n.Value); err != nil {
+                v.report(n, path, "%v", err)
+            }
+        }
+    
+    case reflect.Int:
+        if n.Kind != yaml.ScalarNode || n.Tag != "!!int" {
+            v.report(n, path, "expected an integer")
+            return
+        }
+        if v.values && check != nil {
+            if err := check(n.Value); err != nil {
+                v.report(n, path, "%v", err)
+            }
+        }
+    }
+}
+
+// yamlFields maps the YAML keys of a struct to its fields
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+    fields := map[string]reflect.StructField{}
+    for i := 0; i < t.NumField(); i++ {
+        f := t.Field(i)
+        name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+        if name == "-" || f.PkgPath != "" {
+            continue
+        }
+        if name == "" {
+            name = strings.ToLower(f.Name)
+        }
+        fields[name] = f
+    }
+    return fields
+}
+
+func joinPath(path, key string) string {
+    if path == "" {
+        return key
+    }
+    return path + "." + key
+}
+
+// Application protocols that rules may name in place of the transport
+// protocol carrying them
+var applicationProtocols = map[string]string{
+    "http":     "tcp",
+    "https":    "tcp",
+    "ssh":      "tcp",
+    "rdp":      "tcp",
+    "smtp":     "tcp",
+    "mysql":    "tcp",
+    "postgres": "tcp",
+    "dns":      "udp",
+}
+
+// Ports the application protocols are served on, which a rule naming one
+// without ports allows
+var applicationPorts = map[string]int{
+    "http":     80,
+    "https":    443,
+    "ssh":      22,
+    "rdp":      3389,
+    "smtp":     25,
+    "mysql":    3306,
+    "postgres": 5432,
+    "dns":      53,
+}
+
+// ApplicationPort is the well-known port of an application protocol
+func ApplicationPort(protocol string) (int, bool) {
+    port, ok := applicationPorts[strings.ToLower(protocol)]
+    return port, ok
+}
+
+// TransportProtocol maps a rule's protocol to the transport protocol it
+// stands for: tcp, udp, icmp, icmpv6 or all
+func TransportProtocol(protocol string) (string, bool) {
+    p := strings.ToLower(protocol)
+    switch p {
+    case "tcp", "udp", "icmp", "icmpv6", "all":
+        return p, true
+    }
+    t, ok := applicationProtocols[p]
+    return t, ok
+}
+
+// ParsePortRange parses a port or an inclusive range such as "8000-8100"
+func ParsePortRange(s string) (from, to int, err error) {
+    lo, hi, isRange := strings.Cut(s, "-")
+    if !isRange {
+        hi = lo
+    }
+    if from, err = parsePort(lo); err != nil {
+        return 0, 0, fmt.Errorf("invalid port range %q: %v", s, err)
+    }
+    if to, err = parsePort(hi); err != nil {
+        return 0, 0, fmt.Errorf("invalid port range %q: %v", s, err)
+    }
+    if from > to {
+        return 0, 0, fmt.Errorf("invalid port range %q: start is above end", s)
+    }
+    return from, to, nil
+}
+
+func parsePort(s string) (int, error) {
+    port, err := strconv.Atoi(s)
+    if err != nil {
+        return 0, fmt.Errorf("%q is not a number", s)
+    }
+    if port < 0 || port > 65535 {
+        return 0, fmt.Errorf("port %d is out of range 0-65535", port)
+    }
+    return port, nil
+}
+
+func checkPorts(s string) error {
+    _, _, err := ParsePortRange(s)
+    return err
+}
+
+func checkProtocol(s string) error {
+    if _, ok := TransportProtocol(s); !ok {
+        return fmt.Errorf("unknown protocol %q", s)
+    }
+    return nil
+}
+
+func checkCIDR(s string) error {
+    ip, network, err := net.ParseCIDR(s)
+    if err != nil {
+        return fmt.Errorf("invalid CIDR %q", s)
+    }
+    if !ip.Equal(network.IP) {
+        return fmt.Errorf("CIDR %s has host bits set; the network is %s", s, network)
+    }
+    return nil
+}
+
+// checkSource accepts a CIDR or a reference to an AWS security group or
+// prefix list
+func checkSource(s string) error {
+    if strings.HasPrefix(s, "sg-") || strings.HasPrefix(s, "pl-") {
+        return nil
+    }
+    return checkCIDR(s)
+}
+
+// checkRange accepts a CIDR or a provider service tag such as
+// AzureLoadBalancer
+func checkRange(s string) error {
+    if s != "" && !strings.ContainsAny(s, "/.:") && (s[0] >= 'A' && s[0] <= 'Z' || s[0] >= 'a' && s[0] <= 'z') {
+        return nil
+    }
+    return checkCIDR(s)
+}
+
+func checkAction(s string) error {
+    if s != "" && s != "allow" && s != "deny" {
+        return fmt.Errorf("action must be allow or deny, got %q", s)
+    }
+    return nil
+}
+
+func checkDirection(s string) error {
+    if s != "INGRESS" && s != "EGRESS" {
+        return fmt.Errorf("direction must be INGRESS or EGRESS, got %q", s)
+    }
+    return nil
+}
+
+func checkPriority(s string) error {
+    priority, err := strconv.Atoi(s)
+    if err != nil || priority < 0 || priority > 65535 {
+        return fmt.Errorf("priority %s is out of range 0-65535", s)
+    }
+    return nil
+}
+
+func checkPolicyType(s string) error {
+    if s != "Ingress" && s != "Egress" {
+        return fmt.Errorf("policy type must be Ingress or Egress, got %q", s)
+    }
+    return nil
+}
+
+func checkPolicyProtocol(s string) error {
+    switch strings.ToUpper(s) {
+    case "TCP", "UDP", "SCTP":
+        return nil
+    }
+    return fmt.Errorf("network policy protocol must be TCP, UDP or SCTP, got %q", s)
+}
+
+// checkPolicyPort accepts a port, a port range or a named container port
+func checkPolicyPort(s string) error {
+    if s == "" {
+        return nil
+    }
+    if s[0] >= '0' && s[0] <= '9' {
+        return checkPorts(s)
+    }
+    if len(s) > 15 || strings.Trim(s, "abcdefghijklmnopqrstuvwxyz0123456789-") != "" {
+        return fmt.Errorf("invalid named port %q", s)
+    }
+    return nil
+}
+
+// pkg/config/variables.go
+package config
+
+import (
+    "fmt"
+    "regexp"
+    "strings"
+    
+    "gopkg.in/yaml.v3"
+)
+
+// Variable is a typed value that configs reference as ${name}. Base configs
+// declare each variable's type and default value; an overlay may set a
+// different value for its environment. References are resolved when files
+// are loaded, so committed configs hold the default values.
+type Variable struct {
+    Type  string      `yaml:"type,omitempty" json:"type,omitempty"`
+    Value interface{} `yaml:"value" json:"value"`
+}
+
+// Variable types
+const (
+    VarString = "string"
+    VarNumber = "number"
+    VarBool   = "bool"
+    VarList   = "list"
+)
+
+var (
+    // A reference, or the $${ escape for a literal ${
+    variableRef = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+    wholeRef    = regexp.MustCompile(`^\$\{([A-Za-z_][A-Za-z0-9_]*)\}$`)
+)
+
+func checkVariable(v Variable) error {
+    var ok bool
+    switch v.Type {
+    case VarString:
+        _, ok = v.Value.(string)
+    case VarNumber:
+        switch v.Value.(type) {
+        case int, int64, uint64, float64:
+            ok = true
+        }
+    case VarBool:
+        _, ok = v.Value.(bool)
+    case VarList:
+        _, ok = v.Value.([]interface{})
+    case "":
+        return fmt.Errorf("type is required")
+    default:
+        return fmt.Errorf("unknown type %q", v.Type)
+    }
+    if !ok {
+        return fmt.Errorf("value %v is not a %s", v.Value, v.Type)
+    }
+    return nil
+}
+
+func checkVariableType(s string) error {
+    switch s {
+    case "", VarString, VarNumber, VarBool, VarList:
+        return nil
+    }
+    return fmt.Errorf("variable type must be string, number, bool or list, got %q", s)
+}
+
+// mappingValue returns the value of key in a mapping node, or nil
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+    if n.Kind != yaml.MappingNode {
+        return nil
+    }
+    for i := 0; i+1 < len(n.Content); i += 2 {
+        if n.Content[i].Value == key {
+            return n.Content[i+1]
+        }
+    }
+    return nil
+}
+
+type declaration struct {
+    Variable
+    name   string
+    file   *parsedFile
+    node   *yaml.Node
+    active bool
+}
+
+// collectVariables gathers the variables declared by base configs and the
+// values that overlays for env give them, and renders each value as a YAML
+// node. Every overlay's values are checked against the declarations, unless
+// strict is unset because some declarations may be missing. Problems are
+// returned by the file they were found in.
+func collectVariables(files []*parsedFile, env string, strict bool) (map[string]*yaml.Node, map[*parsedFile][]Problem) {
+    problems := map[*parsedFile][]Problem{}
+    report := func(d declaration, format string, args ...interface{}) {
+        problems[d.file] = append(problems[d.file], Problem{
+            File:    d.file.name,
+            Line:    d.node.Line,
+            Column:  d.node.Column,
+            Path:    "variables." + d.name,
+            Message: fmt.Sprintf(format, args...),
+        })
+    }
+    
+    declared := map[string]declaration{}
+    var overrides []declaration
+    for _, p := range files {
+        meta := p.metadata()
+        active := !meta.Overlay || env != "" && meta.Environment == env
+        if !active && !strict {
+            continue
+        }
+        
+        for _, doc := range p.docs {
+            vars := mappingValue(doc, "variables")
+            if vars == nil || vars.Kind != yaml.MappingNode {
+                continue
+            }
+            for i := 0; i+1 < len(vars.Content); i += 2 {
+                d := declaration{name: vars.Content[i].Value, file: p, node: vars.Content[i+1]}
+                if err := d.node.Decode(&d.Variable); err != nil {
+                    report(d, "%v", err)
+                    continue
+                }
+                
+                if meta.Overlay {
+                    d.active = active
+                    overrides = append(overrides, d)
+                } else if previous, ok := declared[d.name]; ok {
+                    report(d, "variable %q is already declared in %s", d.name, previous.file.name)
+                } else {
+                    declared[d.name] = d
+                }
+            }
+        }
+    }
+    
+    for _, d := range overrides {
+        base, ok := declared[d.name]
+        if !ok {
+            report(d, "overlay sets undeclared variable %q", d.name)
+            continue
+        }
+        if d.Type != "" && d.Type != base.Type {
+            report(d, "variable %q is declared as %s, not %s", d.name, base.Type, d.Type)
+            continue
+        }
+        d.Type = base.Type
+        if err := checkVariable(d.Variable); err != nil {
+            report(d, "variable %q: %v", d.name, err)
+            continue
+        }
+        if d.active {
+            declared[d.name] = d
+        }
+    }
+    
+    values := map[string]*yaml.Node{}
+    for name, d := range declared {
+        if err := checkVariable(d.Variable); err != nil {
+            report(d, "variable %q: %v", name, err)
+            continue
+        }
+        var value yaml.Node
+        if err := value.Encode(d.Value); err != nil {
+            report(d, "variable %q: %v", name, err)
+            continue
+        }
+        values[name] = &value
+    }
+    return values, problems
+}
+
+// substitute resolves ${name} references in the file. A scalar that is
+// nothing but a reference takes the variable's typed value, and a list
+// variable referenced as a list item is spliced into the list; references
+// inside longer strings are interpolated. The variables section itself is
+// left alone. Unless strict, unknown references are left in place, for
+// files validated on their own without the rest of the working directory.
+func (p *parsedFile) substitute(values map[string]*yaml.Node, strict bool) []Problem {
+    s := &substituter{values: values, strict: strict, file: p.name, origins: p.origins}
+    for _, doc := range p.docs {
+        if doc.Kind != yaml.MappingNode {
+            s.walk(doc)
+            continue
+        }
+        for i := 0; i+1 < len(doc.Content); i += 2 {
+            if doc.Content[i].Value != "variables" {
+                s.walk(doc.Content[i+1])
+            }
+        }
+    }
+    return s.problems
+}
+
+type substituter struct {
+    values   map[string]*yaml.Node
+    strict   bool
+    file     string
+    origins  map[*yaml.Node]string
+    problems []Problem
+}
+
+func (s *substituter) report(n *yaml.Node, format string, args ...interface{}) {
+    file := s.file
+    if origin, ok := s.origins[n]; ok {
+        file = origin
+    }
+    s.problems = append(s.problems, Problem{File: file, Line: n.Line, Column: n.Column, Message: fmt.Sprintf(format, args...)})
+}
+
+func (s *substituter) walk(n *yaml.Node) {
+    switch n.Kind {
+    case yaml.ScalarNode:
+        s.scalar(n)
+    case yaml.MappingNode:
+        for i := 1; i < len(n.Content); i += 2 {
+            s.walk(n.Content[i])
+        }
+    case yaml.SequenceNode:
+        var items []*yaml.Node
+        for _, item := range n.Content {
+            if m := wholeRef.FindStringSubmatch(item.Value); item.Kind == yaml.ScalarNode && m != nil {
+                if value, ok := s.values[m[1]]; ok && value.Kind == yaml.SequenceNode {
+                    for _, v := range value.Content {
+                        items = append(items, s.copyAt(v, item))
+                    }
+                    continue
+                }
+            }
+            s.walk(item)
+            items = append(items, item)
+        }
+        n.Content = items
+    }
+}
+
+func (s *substituter) scalar(n *yaml.Node) {
+    if !strings.Contains(n.Value, "${") {
+        return
+    }
+    if m := wholeRef.FindStringSubmatch(n.Value); m != nil {
+        value, ok := s.values[m[1]]
+        if !ok {
+            s.undefined(n, m[1])
+            return
+        }
+        *n = *s.copyAt(value, n)
+        return
+    }
+    
+    n.Value = variableRef.ReplaceAllStringFunc(n.Value, func(ref string) string {
+        if ref == "$${" {
+            return "${"
+        }
+        name := ref[2 : len(ref)-1]
+        value, ok := s.values[name]
+        if !ok {
+            s.undefined(n, name)
+            return ref
+        }
+        if value.Kind != yaml.ScalarNode {
+            s.report(n, "list variable %q cannot be used inside a string", name)
+            return ref
+        }
+        return value.Value
+    })
+    n.Tag = "!!str"
+}
+
+func (s *substituter) undefined(n *yaml.Node, name string) {
+    if s.strict {
+        s.report(n, "undefined variable %q", name)
+    }
+}
+
+// copyAt copies a variable's value to stand in for the reference at n,
+// taking n's position so problems in the value point at the reference
+func (s *substituter) copyAt(value, n *yaml.Node) *yaml.Node {
+    c := *value
+    c.Line, c.Column = n.Line, n.Column
+    c.Content = make([]*yaml.Node, len(value.Content))
+    for i, child := range value.Content {
+        c.Content[i] = s.copyAt(child, n)
+    }
+    if origin, ok := s.origins[n]; ok {
+        s.origins[&c] = origin
+    }
+    return &c
+}
+
+// pkg/config/overlay.go
+package config
+
+import (
+    "fmt"
+    "strings"
+)
+
+// ApplyEnvironment returns the configs as env sees them. Configs that name
+// another environment are dropped, and each overlay for env is merged into
+// the base configs with strategic-merge semantics: resources are matched
+// by kind and name, fields the overlay sets replace the base's, labels and
+// selectors are merged key by key and security group rules are matched by
+// protocol and ports. Overlay resources with no match are added, and the
+// resources an overlay lists under delete are removed. With env empty,
+// overlays are dropped and the rest is returned as is.
+func ApplyEnvironment(configs []NetworkConfig, env string) ([]NetworkConfig, error) {
+    var result, overlays []NetworkConfig
+    for _, cfg := range configs {
+        switch {
+        case cfg.Metadata.Overlay:
+            if cfg.Metadata.Environment == "" {
+                return nil, fmt.Errorf("%s: an overlay must name its environment", cfg.Source)
+            }
+            if env != "" && cfg.Metadata.Environment == env {
+                overlays = append(overlays, cfg)
+            }
+        case env != "" && cfg.Metadata.Environment != "" && cfg.Metadata.Environment != env:
+            continue
+        default:
+            result = append(result, cfg)
+        }
+    }
+    
+    for _, overlay := range overlays {
+        var err error
+        if result, err = applyOverlay(result, overlay); err != nil {
+            return nil, err
+        }
+    }
+    if env != "" {
+        for i := range result {
+            result[i].Metadata.Environment = env
+        }
+    }
+    return result, nil
+}
+
+func applyOverlay(configs []NetworkConfig, overlay NetworkConfig) ([]NetworkConfig, error) {
+    added := NetworkConfig{Metadata: overlay.Metadata, Source: overlay.Source}
+    added.Metadata.Overlay = false
+    
+    for _, sg := range overlay.SecurityGroups {
+        if target := findSecurityGroup(configs, sg.Name); target != nil {
+            mergeSecurityGroup(target, sg)
+        } else {
+            added.SecurityGroups = append(added.SecurityGroups, sg)
+        }
+    }
+    for _, np := range overlay.NetworkPolicies {
+        if target := findNetworkPolicy(configs, np.Name); target != nil {
+            mergeNetworkPolicy(target, np)
+        } else {
+            added.NetworkPolicies = append(added.NetworkPolicies, np)
+        }
+    }
+    for _, fw := range overlay.FirewallRules {
+        if target := findFirewallRule(configs, fw.Name); target != nil {
+            mergeFirewallRule(target, fw)
+        } else {
+            added.FirewallRules = append(added.FirewallRules, fw)
+        }
+    }
+    
+    for _, ref := range overlay.Delete {
+        kind, name, _ := strings.Cut(ref, "/")
+        if !deleteResource(configs, kind, name) {
+            return nil, fmt.Errorf("%s: cannot delete %s: no such resource", overlay.Source, ref)
+        }
+    }
+    
+    if len(added.SecurityGroups) > 0 || len(added.NetworkPolicies) > 0 || len(added.FirewallRules) > 0 {
+        configs = append(configs, added)
+    }
+    return configs, nil
+}
+
+func findSecurityGroup(configs []NetworkConfig, name string) *SecurityGroup {
+    for i := range configs {
+        for j := range configs[i].SecurityGroups {
+            if configs[i].SecurityGroups[j].Name == name {
+                return &configs[i].SecurityGroups[j]
+            }
+        }
+    }
+    return nil
+}
+
+func findNetworkPolicy(configs []NetworkConfig, name string) *NetworkPolicy {
+    for i := range configs {
+        for j := range configs[i].NetworkPolicies {
+            if configs[i].NetworkPolicies[j].Name == name {
+                return &configs[i].NetworkPolicies[j]
+            }
+        }
+    }
+    return nil
+}
+
+func findFirewallRule(configs []NetworkConfig, name string) *FirewallRule {
+    for i := range configs {
+        for j := range configs[i].FirewallRules {
+            if configs[i].FirewallRules[j].Name == name {
+                return &configs[i].FirewallRules[j]
+            }
+        }
+    }
+    return nil
+}
+
+func deleteResource(configs []NetworkConfig, kind, name string) bool {
+    for i := range configs {
+        cfg := &configs[i]
+        switch kind {
+        case KindSecurityGroup:
+            for j, sg := range cfg.SecurityGroups {
+                if sg.Name == name {
+                    cfg.SecurityGroups = append(cfg.SecurityGroups[:j:j], cfg.SecurityGroups[j+1:]...)
+                    return true
+                }
+            }
+        case KindNetworkPolicy:
+            for j, np := range cfg.NetworkPolicies {
+                if np.Name == name {
+                    cfg.NetworkPolicies = append(cfg.NetworkPolicies[:j:j], cfg.NetworkPolicies[j+1:]...)
+                    return true
+                }
+            }
+        case KindFirewallRule:
+            for j, fw := range cfg.FirewallRules {
+                if fw.Name == name {
+                    cfg.FirewallRules = append(cfg.FirewallRules[:j:j], cfg.FirewallRules[j+1:]...)
+                    return true
+                }
+            }
+        }
+    }
+    return false
+}
+
+// The merge functions apply the fields an overlay sets. Strings and
+// numbers are set when non-zero, so an overlay cannot reset a priority to 0;
+// lists are set when present, so an empty list clears one.
+
+func mergeSecurityGroup(base *SecurityGroup, overlay SecurityGroup) {
+    mergeString(&base.ID, overlay.ID)
+    mergeString(&base.Description, overlay.Description)
+    mergeString(&base.VpcId, overlay.VpcId)
+    
+    // Each rule has its own copy of the list so the base config's backing
+    // array, which other environments may share, is not written to
+    rules := append([]Rule(nil), base.Rules...)
+    for _, rule := range overlay.Rules {
+        replaced := false
+        for i := range rules {
+            if rules[i].Key() == rule.Key() {
+                rules[i] = rule
+                replaced = true
+                break
+            }
+        }
+        if !replaced {
+            rules = append(rules, rule)
+        }
+    }
+    base.Rules = rules
+}
+
+func mergeNetworkPolicy(base *NetworkPolicy, overlay NetworkPolicy) {
+    mergeString(&base.Namespace, overlay.Namespace)
+    base.Selector = mergeLabels(base.Selector, overlay.Selector)
+    mergeList(&base.PolicyTypes, overlay.PolicyTypes)
+    if overlay.Ingress != nil {
+        base.Ingress = overlay.Ingress
+    }
+    if overlay.Egress != nil {
+        base.Egress = overlay.Egress
+    }
+}
+
+func mergeFirewallRule(base *FirewallRule, overlay FirewallRule) {
+    mergeString(&base.Direction, overlay.Direction)
+    mergeString(&base.Protocol, overlay.Protocol)
+    mergeString(&base.Action, overlay.Action)
+    if overlay.Priority != 0 {
+        base.Priority = overlay.Priority
+    }
+    mergeList(&base.Ports, overlay.Ports)
+    mergeList(&base.SourceRanges, overlay.SourceRanges)
+    mergeList(&base.DestinationRanges, overlay.DestinationRanges)
+    mergeList(&base.TargetTags, overlay.TargetTags)
+}
+
+func mergeString(base *string, overlay string) {
+    if overlay != "" {
+        *base = overlay
+    }
+}
+
+func mergeList(base *[]string, overlay []string) {
+    if overlay != nil {
+        *base = overlay
+    }
+}
+
+func mergeLabels(base, overlay map[string]string) map[string]string {
+    if len(overlay) == 0 {
+        return base
+    }
+    merged := make(map[string]string, len(base)+len(overlay))
+    for k, v := range base {
+        merged[k] = v
+    }
+    for k, v := range overlay {
+        merged[k] = v
+    }
+    return merged
+}
+
+// checkResourceRef checks a kind/name reference in an overlay's delete list
+func checkResourceRef(s string) error {
+    kind, name, ok := strings.Cut(s, "/")
+    if !ok || name == "" {
+        return fmt.Errorf("expected kind/name, got %q", s)
+    }
+    switch kind {
+    case KindSecurityGroup, KindNetworkPolicy, KindFirewallRule:
+        return nil
+    }
+    return fmt.Errorf("unknown resource kind %q", kind)
+}
+
+// pkg/config/normalize.go
+package config
+
+import (
+    "fmt"
+    "net/netip"
+    "reflect"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// Normalize rewrites c into its canonical form, so that configs that mean
+// the same thing compare, hash and diff as equal however their files are
+// written:
+//
+//   - protocols are lowercased, network policy protocols included; the
+//     Kubernetes exporter uppercases them again
+//   - port ranges are expanded to the ports they cover and those ports
+//     written back as the fewest sorted ranges: 81, 80 and 80-90 become
+//     80-90. Listing every port would turn 0-65535 into 65536 entries.
+//     Rule keys are taken from the merged ports, so diffs and merges
+//     compare rules in this form.
+//   - CIDRs are masked to their network and collapsed: 10.1.0.0/16 is
+//     dropped beside 10.0.0.0/8, and 10.0.0.0/9 plus 10.128.0.0/9 become
+//     10.0.0.0/8. Other sources, such as sg- references, are kept, sorted.
+//   - tags are sorted and deduplicated
+//   - resources are ordered by name and security group rules by key, with
+//     exact duplicates dropped
+//   - empty maps and lists are nil
+//
+// Values that do not parse are left as written. Normalize allocates new
+// lists and maps rather than writing into c's, so it is safe on a copy that
+// shares them with another config.
+func (c *NetworkConfig) Normalize() {
+    c.Metadata.Labels = normalizeLabels(c.Metadata.Labels)
+    
+    sgs := make([]SecurityGroup, len(c.SecurityGroups))
+    for i, sg := range c.SecurityGroups {
+        sgs[i] = sg.normalize()
+    }
+    sort.SliceStable(sgs, func(i, j int) bool { return sgs[i].Name < sgs[j].Name })
+    c.SecurityGroups = sgs
+    if len(sgs) == 0 {
+        c.SecurityGroups = nil
+    }
+    
+    nps := make([]NetworkPolicy, len(c.NetworkPolicies))
+    for i, np := range c.NetworkPolicies {
+        nps[i] = np.normalize()
+    }
+    sort.SliceStable(nps, func(i, j int) bool { return nps[i].Name < nps[j].Name })
+    c.NetworkPolicies = nps
+    if len(nps) == 0 {
+        c.NetworkPolicies = nil
+    }
+    
+    fws := make([]FirewallRule, len(c.FirewallRules))
+    for i, fw := range c.FirewallRules {
+        fws[i] = fw.normalize()
+    }
+    sort.SliceStable(fws, func(i, j int) bool { return fws[i].Name < fws[j].Name })
+    c.FirewallRules = fws
+    if len(fws) == 0 {
+        c.FirewallRules = nil
+    }
+}
+
+// Canonical returns the canonical form of c, leaving c as it is
+func (c NetworkConfig) Canonical() NetworkConfig {
+    c.Normalize()
+    return c
+}
+
+func (sg SecurityGroup) normalize() SecurityGroup {
+    var rules []Rule
+    for _, rule := range sg.Rules {
+        rule = rule.normalize()
+        duplicate := false
+        for _, seen := range rules {
+            if reflect.DeepEqual(seen, rule) {
+                duplicate = true
+                break
+            }
+        }
+        if !duplicate {
+            rules = append(rules, rule)
+        }
+    }
+    sort.SliceStable(rules, func(i, j int) bool { return rules[i].Key() < rules[j].Key() })
+    sg.Rules = rules
+    return sg
+}
+
+func (r Rule) normalize() Rule {
+    r.Protocol = strings.ToLower(r.Protocol)
+    r.Ports = NormalizePorts(r.Ports)
+    r.Sources = NormalizeCIDRs(r.Sources)
+    r.Action = strings.ToLower(r.Action)
+    return r
+}
+
+func (np NetworkPolicy) normalize() NetworkPolicy {
+    np.Selector = normalizeLabels(np.Selector)
+    np.PolicyTypes = sortedSet(np.PolicyTypes)
+    np.Ingress = normalizePolicyRules(np.Ingress)
+    np.Egress = normalizePolicyRules(np.Egress)
+    return np
+}
+
+// normalizePolicyRules keeps the order of rules and peers, which are
+// matched in order of appearance in diffs and violation paths
+func normalizePolicyRules(rules []NetworkPolicyRule) []NetworkPolicyRule {
+    if len(rules) == 0 {
+        return nil
+    }
+    normalized := make([]NetworkPolicyRule, len(rules))
+    for i, rule := range rules {
+        var ports []NetworkPolicyPort
+        for _, port := range rule.Ports {
+            port.Protocol = strings.ToLower(port.Protocol)
+            if !containsPort(ports, port) {
+                ports = append(ports, port)
+            }
+        }
+        sort.SliceStable(ports, func(i, j int) bool {
+            if ports[i].Protocol != ports[j].Protocol {
+                return ports[i].Protocol < ports[j].Protocol
+            }
+            return comparePorts(ports[i].Port, ports[j].Port) < 0
+        })
+        normalized[i] = NetworkPolicyRule{
+            Ports: ports,
+            From:  normalizePeers(rule.From),
+            To:    normalizePeers(rule.To),
+        }
+    }
+    return normalized
+}
+
+func containsPort(ports []NetworkPolicyPort, port NetworkPolicyPort) bool {
+    for _, p := range ports {
+        if p == port {
+            return true
+        }
+    }
+    return false
+}
+
+func normalizePeers(peers []NetworkPolicyPeer) []NetworkPolicyPeer {
+    if len(peers) == 0 {
+        return nil
+    }
+    normalized := make([]NetworkPolicyPeer, len(peers))
+    for i, peer := range peers {
+        peer.PodSelector = normalizeLabels(peer.PodSelector)
+        peer.NamespaceSelector = normalizeLabels(peer.NamespaceSelector)
+        if peer.IPBlock != nil {
+            block := *peer.IPBlock
+            if cidrs := NormalizeCIDRs([]string{block.CIDR}); len(cidrs) == 1 {
+                block.CIDR = cidrs[0]
+            }
+            block.Except = NormalizeCIDRs(block.Except)
+            peer.IPBlock = &block
+        }
+        normalized[i] = peer
+    }
+    return normalized
+}
+
+func (fw FirewallRule) normalize() FirewallRule {
+    fw.Direction = strings.ToUpper(fw.Direction)
+    fw.Protocol = strings.ToLower(fw.Protocol)
+    fw.Action = strings.ToLower(fw.Action)
+    fw.Ports = NormalizePorts(fw.Ports)
+    fw.SourceRanges = NormalizeCIDRs(fw.SourceRanges)
+    fw.DestinationRanges = NormalizeCIDRs(fw.DestinationRanges)
+    fw.TargetTags = sortedSet(fw.TargetTags)
+    return fw
+}
+
+// NormalizePorts merges ports and port ranges into the fewest ranges that
+// cover them, sorted. Entries that are not ports are kept after them.
+func NormalizePorts(ports []string) []string {
+    type portRange struct{ from, to int }
+    var ranges []portRange
+    var other []string
+    for _, p := range ports {
+        from, to, err := ParsePortRange(strings.TrimSpace(p))
+        if err != nil {
+            other = append(other, p)
+            continue
+        }
+        ranges = append(ranges, portRange{from, to})
+    }
+    sort.Slice(ranges, func(i, j int) bool { return ranges[i].from < ranges[j].from })
+    
+    var merged []portRange
+    for _, r := range ranges {
+        if n := len(merged); n > 0 && r.from <= merged[n-1].to+1 {
+            if r.to > merged[n-1].to {
+                merged[n-1].to = r.to
+            }
+            continue
+        }
+        merged = append(merged, r)
+    }
+    
+    var normalized []string
+    for _, r := range merged {
+        if r.from == r.to {
+            normalized = append(normalized, strconv.Itoa(r.from))
+        } else {
+            normalized = append(normalized, fmt.Sprintf("%d-%d", r.from, r.to))
+        }
+    }
+    return append(normalized, sortedSet(other)...)
+}
+
+// comparePorts orders port specs numerically where they parse
+func comparePorts(a, b string) int {
+    af, _, aerr := ParsePortRange(a)
+    bf, _, berr := ParsePortRange(b)
+    switch {
+    case aerr == nil && berr == nil && af != bf:
+        return af - bf
+    case aerr == nil && berr != nil:
+        return -1
+    case aerr != nil && berr == nil:
+        return 1
+    }
+    return strings.Compare(a, b)
+}
+
+// NormalizeCIDRs masks CIDRs to their network and collapses them into the
+// fewest prefixes that cover the same addresses, IPv4 before IPv6.
+// Entries that are not CIDRs are kept after them, sorted.
+func NormalizeCIDRs(cidrs []string) []string {
+    var prefixes []netip.Prefix
+    var other []string
+    for _, c := range cidrs {
+        prefix, err := netip.ParsePrefix(strings.TrimSpace(c))
+        if err != nil {
+            other = append(other, c)
+            continue
+        }
+        prefixes = append(prefixes, prefix.Masked())
+    }
+    
+    prefixes = collapsePrefixes(prefixes)
+    var normalized []string
+    for _, p := range prefixes {
+        normalized = append(normalized, p.String())
+    }
+    return append(normalized, sortedSet(other)...)
+}
+
+// collapsePrefixes drops prefixes covered by another and joins sibling
+// halves into their parent until neither applies
+func collapsePrefixes(prefixes []netip.Prefix) []netip.Prefix {
+    for {
+        sort.Slice(prefixes, func(i, j int) bool {
+            a, b := prefixes[i], prefixes[j]
+            if c := a.Addr().Compare(b.Addr()); c != 0 {
+                return c < 0
+            }
+            return a.Bits() < b.Bits()
+        })
+        
+        var kept []netip.Prefix
+        for _, p := range prefixes {
+            if n := len(kept); n > 0 && kept[n-1].Bits() <= p.Bits() && kept[n-1].Contains(p.Addr()) {
+                continue
+            }
+            kept = append(kept, p)
+        }
+        
+        joined := false
+        var result []netip.Prefix
+        for i := 0; i < len(kept); i++ {
+            if i+1 < len(kept) {
+                if parent, ok := siblings(kept[i], kept[i+1]); ok {
+                    result = append(result, parent)
+                    joined = true
+                    i++
+                    continue
+                }
+            }
+            result = append(result, kept[i])
+        }
+        
+        prefixes = result
+        if !joined {
+            return prefixes
+        }
+    }
+}
+
+// siblings reports whether a and b are the two halves of one prefix, and
+// returns it
+func siblings(a, b netip.Prefix) (netip.Prefix, bool) {
+    if a.Bits() != b.Bits() || a.Bits() == 0 || a.Addr().Is4() != b.Addr().Is4() {
+        return netip.Prefix{}, false
+    }
+    parent, err := a.Addr().Prefix(a.Bits() - 1)
+    if err != nil || parent.Addr() != a.Addr() || !parent.Contains(b.Addr()) {
+        return netip.Prefix{}, false
+    }
+    return parent, true
+}
+
+func sortedSet(items []string) []string {
+    if len(items) == 0 {
+        return nil
+    }
+    sorted := append([]string(nil), items...)
+    sort.Strings(sorted)
+    set := sorted[:1]
+    for _, item := range sorted[1:] {
+        if item != set[len(set)-1] {
+            set = append(set, item)
+        }
+    }
+    return set
+}
+
+func normalizeLabels(labels map[string]string) map[string]string {
+    if len(labels) == 0 {
+        return nil
+    }
+    return labels
+}
+
+// normalizeItem normalizes a single item of the list field
+func normalizeItem(field, item string) []string {
+    switch field {
+    case "ports":
+        return NormalizePorts([]string{item})
+    case "sources", "sourceRanges", "destinationRanges", "except":
+        return NormalizeCIDRs([]string{item})
+    }
+    return []string{item}
+}
+
+// OriginalPath maps a path into c's canonical form, such as the path of a
+// policy violation found in it, to the same element of c. Resources are
+// matched by name, rules and ports by their canonical value, and list
+// items that normalization merged map to the list that held them.
+func (c NetworkConfig) OriginalPath(path string) string {
+    if path == "" {
+        return path
+    }
+    canonical := c.Canonical()
+    original, normalized := reflect.ValueOf(c), reflect.ValueOf(canonical)
+    field := ""
+    
+    var mapped []string
+    for _, segment := range strings.Split(path, ".") {
+        name, index, hasIndex := splitIndex(segment)
+        if original.Kind() != reflect.Struct {
+            return strings.Join(append(mapped, segment), ".")
+        }
+        
+        f, ok := yamlFields(original.Type())[name]
+        if !ok {
+            return strings.Join(append(mapped, segment), ".")
+        }
+        field = name
+        original = original.FieldByIndex(f.Index)
+        normalized = normalized.FieldByIndex(f.Index)
+        if !hasIndex {
+            mapped = append(mapped, segment)
+            continue
+        }
+        
+        if original.Kind() != reflect.Slice || index >= normalized.Len() {
+            return strings.Join(append(mapped, segment), ".")
+        }
+        i, ok := originalIndex(field, original, normalized, index)
+        if !ok {
+            // Merged away: the list is as close as the path can get
+            return strings.Join(append(mapped, name), ".")
+        }
+        mapped = append(mapped, fmt.Sprintf("%s[%d]", name, i))
+        original, normalized = original.Index(i), normalized.Index(index)
+        for original.Kind() == reflect.Ptr && !original.IsNil() && !normalized.IsNil() {
+            original, normalized = original.Elem(), normalized.Elem()
+        }
+    }
+    return strings.Join(mapped, ".")
+}
+
+func splitIndex(segment string) (string, int, bool) {
+    open := strings.Index(segment, "[")
+    if open < 0 || !strings.HasSuffix(segment, "]") {
+        return segment, 0, false
+    }
+    index, err := strconv.Atoi(segment[open+1 : len(segment)-1])
+    if err != nil {
+        return segment, 0, false
+    }
+    return segment[:open], index, true
+}
+
+// originalIndex finds the item of the original list that normalizes to
+// item index of the canonical list
+func originalIndex(field string, original, canonical reflect.Value, index int) (int, bool) {
+    target := canonical.Index(index)
+    for i := 0; i < original.Len(); i++ {
+        item := original.Index(i)
+        var match bool
+        switch v := item.Interface().(type) {
+        case SecurityGroup:
+            match = v.Name == target.Interface().(SecurityGroup).Name
+        case NetworkPolicy:
+            match = v.Name == target.Interface().(NetworkPolicy).Name
+        case FirewallRule:
+            match = v.Name == target.Interface().(FirewallRule).Name
+        case Rule:
+            match = reflect.DeepEqual(v.normalize(), target.Interface())
+        case NetworkPolicyPort:
+            v.Protocol = strings.ToLower(v.Protocol)
+            match = v == target.Interface()
+        case string:
+            match = reflect.DeepEqual(normalizeItem(field, v), []string{target.String()})
+        default:
+            // Lists that normalization keeps in order
+            return index, index < original.Len()
+        }
+        if match {
+            return i, true
+        }
+    }
+    return 0, false
+}
+
+// pkg/config/sets.go
+package config
+
+import (
+    "net/netip"
+    "strings"
+)
+
+// PortsOverlap reports whether two port lists share a port. No ports means
+// every port.
+func PortsOverlap(a, b []string) bool {
+    if len(a) == 0 || len(b) == 0 {
+        return true
+    }
+    for _, pa := range a {
+        for _, pb := range b {
+            af, at, aerr := ParsePortRange(pa)
+            bf, bt, berr := ParsePortRange(pb)
+            if aerr != nil || berr != nil {
+                if pa == pb {
+                    return true
+                }
+                continue
+            }
+            if af <= bt && bf <= at {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// PortsWithin reports whether every port in ports is also in of. As in
+// rules, no ports means every port. Entries that are not ports, such as
+// named ports, must appear in of as they are.
+func PortsWithin(ports, of []string) bool {
+    if len(of) == 0 {
+        return true
+    }
+    if len(ports) == 0 {
+        ports = []string{"0-65535"}
+    }
+    // NormalizePorts joins adjacent ranges, so a covered range falls inside
+    // one of them
+    covering := NormalizePorts(of)
+    for _, p := range ports {
+        from, to, err := ParsePortRange(strings.TrimSpace(p))
+        covered := false
+        for _, c := range covering {
+            cf, ct, cerr := ParsePortRange(c)
+            if err != nil || cerr != nil {
+                covered = covered || p == c
+                continue
+            }
+            if cf <= from && to <= ct {
+                covered = true
+            }
+        }
+        if !covered {
+            return false
+        }
+    }
+    return true
+}
+
+// CIDRsOverlap reports whether two lists of CIDRs share an address.
+// Entries that are not CIDRs, such as security group references, only
+// overlap an equal entry.
+func CIDRsOverlap(a, b []string) bool {
+    for _, ca := range a {
+        for _, cb := range b {
+            pa, aerr := netip.ParsePrefix(strings.TrimSpace(ca))
+            pb, berr := netip.ParsePrefix(strings.TrimSpace(cb))
+            if aerr != nil || berr != nil {
+                if ca == cb {
+                    return true
+                }
+                continue
+            }
+            if pa.Masked().Overlaps(pb.Masked()) {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// CIDRWithin reports whether every address of cidr is in of. An entry that
+// is not a CIDR is only within an equal entry.
+func CIDRWithin(cidr string, of []string) bool {
+    prefix, err := netip.ParsePrefix(strings.TrimSpace(cidr))
+    if err != nil {
+        for _, o := range of {
+            if o == cidr {
+                return true
+            }
+        }
+        return false
+    }
+    prefix = prefix.Masked()
+    
+    // NormalizeCIDRs collapses the prefixes in of until none are halves of
+    // another, so a covered prefix falls inside one of them
+    for _, o := range NormalizeCIDRs(of) {
+        covering, err := netip.ParsePrefix(o)
+        if err == nil && covering.Bits() <= prefix.Bits() && covering.Contains(prefix.Addr()) {
+            return true
+        }
+    }
+    return false
+}
+
+// pkg/config/position.go
+package config
+
+import (
+    "bytes"
+    "errors"
+    "io"
+    "regexp"
+    "strconv"
+    
+    "gopkg.in/yaml.v3"
+)
+
+var pathSegment = regexp.MustCompile(`([^.\[\]]+)|\[(\d+)\]`)
+
+// Position finds the line and column in raw, the bytes of a config file,
+// of the value at path, such as "securityGroups[0].rules[1].protocol". As
+// loading merges the documents of a file, an index into a resource list
+// counts the resources of each document in turn. A path leading past what
+// the file holds resolves to the deepest part of it found; a path not
+// found at all, or bytes that do not parse, give 0, 0.
+func Position(raw []byte, path string) (line, column int) {
+    var docs []*yaml.Node
+    decoder := yaml.NewDecoder(bytes.NewReader(raw))
+    for {
+        var doc yaml.Node
+        if err := decoder.Decode(&doc); errors.Is(err, io.EOF) {
+            break
+        } else if err != nil {
+            return 0, 0
+        }
+        if len(doc.Content) > 0 && doc.Content[0].Kind == yaml.MappingNode {
+            docs = append(docs, doc.Content[0])
+        }
+    }
+    if len(docs) == 0 {
+        return 0, 0
+    }
+    
+    segments := pathSegment.FindAllStringSubmatch(path, -1)
+    if len(segments) == 0 || segments[0][1] == "" {
+        return 0, 0
+    }
+    
+    // The first key, and its index when it has one, pick the document
+    first, rest := segments[0][1], segments[1:]
+    index := -1
+    if len(rest) > 0 && rest[0][2] != "" {
+        index, _ = strconv.Atoi(rest[0][2])
+        rest = rest[1:]
+    }
+    var at, node *yaml.Node
+    for _, doc := range docs {
+        key, value := lookupKey(doc, first)
+        if key == nil {
+            continue
+        }
+        if at == nil {
+            at = key
+        }
+        if index < 0 {
+            at, node = positionOf(key, value), value
+            break
+        }
+        if value.Kind != yaml.SequenceNode {
+            continue
+        }
+        if index < len(value.Content) {
+            at, node = value.Content[index], value.Content[index]
+            break
+        }
+        index -= len(value.Content)
+    }
+    if at == nil {
+        return docs[0].Line, docs[0].Column
+    }
+    if node == nil {
+        return at.Line, at.Column
+    }
+    
+    for _, segment := range rest {
+        for node.Kind == yaml.AliasNode {
+            node = node.Alias
+        }
+        if segment[2] != "" {
+            i, _ := strconv.Atoi(segment[2])
+            if node.Kind != yaml.SequenceNode || i >= len(node.Content) {
+                break
+            }
+            node = node.Content[i]
+            at = node
+            continue
+        }
+        key, value := lookupKey(node, segment[1])
+        if key == nil {
+            break
+        }
+        at, node = positionOf(key, value), value
+    }
+    return at.Line, at.Column
+}
+
+func lookupKey(mapping *yaml.Node, name string) (key, value *yaml.Node) {
+    if mapping.Kind != yaml.MappingNode {
+        return nil, nil
+    }
+    for i := 0; i+1 < len(mapping.Content); i += 2 {
+        if mapping.Content[i].Value == name {
+            return mapping.Content[i], mapping.Content[i+1]
+        }
+    }
+    return nil, nil
+}
+
+// positionOf is where a field is reported: at its value when that is a
+// scalar, and otherwise at its key, since lists and mappings start on the
+// lines below
+func positionOf(key, value *yaml.Node) *yaml.Node {
+    if value.Kind == yaml.ScalarNode {
+        return value
+    }
+    return key
+}
+
+// pkg/diff/diff.go
+package diff
+
+import (
+    "fmt"
+    "reflect"
+    "sort"
+    "strings"
+    
+    "netgit/pkg/config"
+)
+
+type ChangeType string
+
+const (
+    Added    ChangeType = "added"
+    Removed  ChangeType = "removed"
+    Modified ChangeType = "modified"
+)
+
+// FieldChange describes one changed field of a modified resource. Scalar
+// fields carry Old/New; list fields carry the Added/Removed elements.
+type FieldChange struct {
+    Path    string      `json:"path"`
+    Old     interface{} `json:"old,omitempty"`
+    New     interface{} `json:"new,omitempty"`
+    Added   []string    `json:"added,omitempty"`
+    Removed []string    `json:"removed,omitempty"`
+}
+
+// KindMetadata marks a change to a file's metadata rather than a resource
+const KindMetadata = "metadata"
+
+type ResourceChange struct {
+    File   string        `json:"file,omitempty"`
+    Kind   string        `json:"kind"`
+    Name   string        `json:"name"`
+    Type   ChangeType    `json:"type"`
+    Fields []FieldChange `json:"fields,omitempty"`
+    Old    interface{}   `json:"old,omitempty"`
+    New    interface{}   `json:"new,omitempty"`
+}
+
+type Result struct {
+    Changes []ResourceChange `json:"changes"`
+}
+
+func (c ResourceChange) ID() string {
+    id := c.Kind + "/" + c.Name
+    if c.Kind == KindMetadata {
+        id = c.Kind
+    }
+    if c.File != "" {
+        id = c.File + ":" + id
+    }
+    return id
+}
+
+func (r *Result) Empty() bool {
+    return len(r.Changes) == 0
+}
+
+// IDs returns the kind/name of every change of the given type.
+func (r *Result) IDs(changeType ChangeType) []string {
+    var ids []string
+    for _, change := range r.Changes {
+        if change.Type == changeType {
+            ids = append(ids, change.ID())
+        }
+    }
+    return ids
+}
+
+type resource struct {
+    name  string
+    value interface{}
+}
+
+// Compare produces a resource-level diff of two configurations, matching
+// security groups, network policies and firewall rules by name. The
+// canonical forms are compared, so reordering a file or respelling a value
+// is not a change.
+func Compare(old, new config.NetworkConfig) *Result {
+    old.Normalize()
+    new.Normalize()
+    result := &Result{}
+    
+    result.compareKind(config.KindSecurityGroup, securityGroups(old), securityGroups(new), func(a, b interface{}) []FieldChange {
+        return compareSecurityGroups(a.(config.SecurityGroup), b.(config.SecurityGroup))
+    })
+    result.compareKind(config.KindNetworkPolicy, networkPolicies(old), networkPolicies(new), func(a, b interface{}) []FieldChange {
+        return compareNetworkPolicies(a.(config.NetworkPolicy), b.(config.NetworkPolicy))
+    })
+    result.compareKind(config.KindFirewallRule, firewallRules(old), firewallRules(new), func(a, b interface{}) []FieldChange {
+        return compareFirewallRules(a.(config.FirewallRule), b.(config.FirewallRule))
+    })
+    
+    return result
+}
+
+// CompareFiles diffs two sets of per-file configs, matching files by source
+// path so that every change names the file it belongs to. A resource moved
+// between files shows up as removed from one and added to the other.
+func CompareFiles(old, new []config.NetworkConfig) *Result {
+    oldByFile := map[string]config.NetworkConfig{}
+    for _, cfg := range old {
+        oldByFile[cfg.Source] = cfg
+    }
+    newByFile := map[string]config.NetworkConfig{}
+    for _, cfg := range new {
+        newByFile[cfg.Source] = cfg
+    }
+    
+    var files []string
+    for file := range oldByFile {
+        files = append(files, file)
+    }
+    for file := range newByFile {
+        if _, ok := oldByFile[file]; !ok {
+            files = append(files, file)
+        }
+    }
+    sort.Strings(files)
+    
+    result := &Result{}
+    for _, file := range files {
+        before, inOld := oldByFile[file]
+        after, inNew := newByFile[file]
+        
+        var changes []ResourceChange
+        switch {
+        case !inOld:
+            changes = append(changes, ResourceChange{Kind: KindMetadata, Type: Added, New: after.Metadata})
+        case !inNew:
+            changes = append(changes, ResourceChange{Kind: KindMetadata, Type: Removed, Old: before.Metadata})
+        default:
+            if fields := compareMetadata(before.Metadata, after.Metadata); len(fields) > 0 {
+                changes = append(changes, ResourceChange{Kind: KindMetadata, Type: Modified, Fields: fields})
+            }
+        }
+        changes = append(changes, Compare(before, after).Changes...)
+        
+        for i := range changes {
+            changes[i].File = file
+        }
+        result.Changes = append(result.Changes, changes...)
+    }
+    
+    return result
+}
+
+func compareMetadata(old, new config.Metadata) []FieldChange {
+    d := &fieldDiffer{}
+    d.scalar("name", old.Name, new.Name)
+    d.scalar("version", old.Version, new.Version)
+    d.scalar("environment", old.Environment, new.Environment)
+    d.scalar("overlay", old.Overlay, new.Overlay)
+    d.labels("labels", old.Labels, new.Labels)
+    return d.changes
+}
+
+func (r *Result) compareKind(kind string, old, new []resource, fields func(a, b interface{}) []FieldChange) {
+    oldByName := indexResources(old)
+    newByName := indexResources(new)
+    
+    var names []string
+    for name := range oldByName {
+        names = append(names, name)
+    }
+    for name := range newByName {
+        if _, ok := oldByName[name]; !ok {
+            names = append(names, name)
+        }
+    }
+    sort.Strings(names)
+    
+    for _, name := range names {
+        before, inOld := oldByName[name]
+        after, inNew := newByName[name]
+        
+        switch {
+        case !inOld:
+            r.Changes = append(r.Changes, ResourceChange{Kind: kind, Name: name, Type: Added, New: after})
+        case !inNew:
+            r.Changes = append(r.Changes, ResourceChange{Kind: kind, Name: name, Type: Removed, Old: before})
+        default:
+            if changes := fields(before, after); len(changes) > 0 {
+                r.Changes = append(r.Changes, ResourceChange{Kind: kind, Name: name, Type: Modified, Fields: changes})
+            }
+        }
+    }
+}
+
+// indexResources keys resources by name; repeated names get a "#n" suffix
+// so that no resource is silently dropped from the comparison.
+func indexResources(resources []resource) map[string]interface{} {
+    index := map[string]interface{}{}
+    for _, res := range resources {
+        key := res.name
+        for n := 2; ; n++ {
+            if _, exists := index[key]; !exists {
+                break
+            }
+            key = fmt.Sprintf("%s#%d", res.name, n)
+        }
+        index[key] = res.value
+    }
+    return index
+}
+
+func securityGroups(cfg config.NetworkConfig) []resource {
+    var resources []resource
+    for _, sg := range cfg.SecurityGroups {
+        resources = append(resources, resource{sg.Name, sg})
+    }
+    return resources
+}
+
+func networkPolicies(cfg config.NetworkConfig) []resource {
+    var resources []resource
+    for _, np := range cfg.NetworkPolicies {
+        resources = append(resources, resource{np.Name, np})
+    }
+    return resources
+}
+
+func firewallRules(cfg config.NetworkConfig) []resource {
+    var resources []resource
+    for _, fw := range cfg.FirewallRules {
+        resources = append(resources, resource{fw.Name, fw})
+    }
+    return resources
+}
+
+type fieldDiffer struct {
+    changes []FieldChange
+}
+
+func (d *fieldDiffer) scalar(path string, old, new interface{}) {
+    if !reflect.DeepEqual(old, new) {
+        d.changes = append(d.changes, FieldChange{Path: path, Old: old, New: new})
+    }
+}
+
+// list compares string lists as sets, which is how ports, sources and tags
+// are interpreted everywhere else.
+func (d *fieldDiffer) list(path string, old, new []string) {
+    added := setDifference(new, old)
+    removed := setDifference(old, new)
+    if len(added) > 0 || len(removed) > 0 {
+        d.changes = append(d.changes, FieldChange{Path: path, Added: added, Removed: removed})
+    }
+}
+
+func (d *fieldDiffer) labels(path string, old, new map[string]string) {
+    d.list(path, labelPairs(old), labelPairs(new))
+}
+
+func setDifference(a, b []string) []string {
+    seen := map[string]bool{}
+    for _, item := range b {
+        seen[item] = true
+    }
+    
+    var diff []string
+    for _, item := range a {
+        if !seen[item] {
+            diff = append(diff, item)
+            seen[item] = true
+        }
+    }
+    return diff
+}
+
+func labelPairs(labels map[string]string) []string {
+    var pairs []string
+    for k, v := range labels {
+        pairs = append(pairs, k+"="+v)
+    }
+    sort.Strings(pairs)
+    return pairs
+}
+
+func compareSecurityGroups(old, new config.SecurityGroup) []FieldChange {
+    d := &fieldDiffer{}
+    d.scalar("id", old.ID, new.ID)
+    d.scalar("description", old.Description, new.Description)
+    d.scalar("vpcId", old.VpcId, new.VpcId)
+    
+    // Rules are paths by key; a key shared by several rules shows up once
+    // per rule added, removed or modified
+    for _, pair := range config.MatchRules(old.Rules, new.Rules) {
+        switch {
+        case pair.New < 0:
+            before := old.Rules[pair.Old]
+            d.changes = append(d.changes, FieldChange{Path: fmt.Sprintf("rules[%s]", before.Key()), Old: before})
+        case pair.Old < 0:
+            after := new.Rules[pair.New]
+            d.changes = append(d.changes, FieldChange{Path: fmt.Sprintf("rules[%s]", after.Key()), New: after})
+        default:
+            before, after := old.Rules[pair.Old], new.Rules[pair.New]
+            path := fmt.Sprintf("rules[%s]", before.Key())
+            d.list(path+".ports", before.Ports, after.Ports)
+            d.list(path+".sources", before.Sources, after.Sources)
+            d.scalar(path+".action", before.Action, after.Action)
+        }
+    }
+    
+    return d.changes
+}
+
+func compareNetworkPolicies(old, new config.NetworkPolicy) []FieldChange {
+    d := &fieldDiffer{}
+    d.scalar("namespace", old.Namespace, new.Namespace)
+    d.labels("selector", old.Selector, new.Selector)
+    d.list("policyTypes", old.PolicyTypes, new.PolicyTypes)
+    comparePolicyRules(d, "ingress", old.Ingress, new.Ingress)
+    comparePolicyRules(d, "egress", old.Egress, new.Egress)
+    return d.changes
+}
+
+func comparePolicyRules(d *fieldDiffer, path string, old, new []config.NetworkPolicyRule) {
+    for i := 0; i < len(old) || i < len(new); i++ {
+        rulePath := fmt.Sprintf("%s[%d]", path, i)
+        switch {
+        case i >= len(new):
+            d.changes = append(d.changes, FieldChange{Path: rulePath, Old: old[i]})
+        case i >= len(old):
+            d.changes = append(d.changes, FieldChange{Path: rulePath, New: new[i]})
+        default:
+            d.list(rulePath+".ports", policyPorts(old[i].Ports), policyPorts(new[i].Ports))
+            d.list(rulePath+".from", policyPeers(old[i].From), policyPeers(new[i].From))
+            d.list(rulePath+".to", policyPeers(old[i].To), policyPeers(new[i].To))
+        }
+    }
+}
+
+func policyPorts(ports []config.NetworkPolicyPort) []string {
+    var out []string
+    for _, p := range ports {
+        out = append(out, p.Protocol+"/"+p.Port)
+    }
+    return out
+}
+
+func policyPeers(peers []config.NetworkPolicyPeer) []string {
+    var out []string
+    for _, p := range peers {
+        var parts []string
+        if len(p.PodSelector) > 0 {
+            parts = append(parts, "pods{"+strings.Join(labelPairs(p.PodSelector), ",")+"}")
+        }
+        if len(p.NamespaceSelector) > 0 {
+            parts = append(parts, "namespaces{"+strings.Join(labelPairs(p.NamespaceSelector), ",")+"}")
+        }
+        if p.IPBlock != nil {
+            block := p.IPBlock.CIDR
+            if len(p.IPBlock.Except) > 0 {
+                block += " except " + strings.Join(p.IPBlock.Except, ",")
+            }
+            parts = append(parts, "ipBlock{"+block+"}")
+        }
+        out = append(out, strings.Join(parts, " "))
+    }
+    return out
+}
+
+func compareFirewallRules(old, new config.FirewallRule) []FieldChange {
+    d := &fieldDiffer{}
+    d.scalar("direction", old.Direction, new.Direction)
+    d.scalar("priority", old.Priority, new.Priority)
+    d.scalar("protocol", old.Protocol, new.Protocol)
+    d.list("ports", old.Ports, new.Ports)
+    d.list("sourceRanges", old.SourceRanges, new.SourceRanges)
+    d.list("destinationRanges", old.DestinationRanges, new.DestinationRanges)
+    d.list("targetTags", old.TargetTags, new.TargetTags)
+    d.scalar("action", old.Action, new.Action)
+    return d.changes
+}
+
+// pkg/diff/format.go
+package diff
+
+import (
+    "encoding/json"
+    "fmt"
+    "strings"
+    
+    "gopkg.in/yaml.v3"
+)
+
+const (
+    FormatUnified = "unified"
+    FormatColor   = "color"
+    FormatJSON    = "json"
+)
+
+const (
+    colorReset = "\033[0m"
+    colorRed   = "\033[31m"
+    colorGreen = "\033[32m"
+    colorCyan  = "\033[36m"
+    colorBold  = "\033[1m"
+)
+
+func (r *Result) Format(format string) (string, error) {
+    switch format {
+    case "", FormatUnified:
+        return r.render(false), nil
+    case FormatColor:
+        return r.render(true), nil
+    case FormatJSON:
+        data, err := json.MarshalIndent(r, "", "  ")
+        if err != nil {
+            return "", err
+        }
+        return string(data) + "\n", nil
+    default:
+        return "", fmt.Errorf("unknown diff format: %s", format)
+    }
+}
+
+func (r *Result) String() string {
+    return r.render(false)
+}
+
+type printer struct {
+    sb    strings.Builder
+    color bool
+}
+
+func (p *printer) line(color, format string, args ...interface{}) {
+    text := fmt.Sprintf(format, args...)
+    if p.color && color != "" {
+        text = color + text + colorReset
+    }
+    p.sb.WriteString(text)
+    p.sb.WriteString("\n")
+}
+
+func (r *Result) render(color bool) string {
+    p := &printer{color: color}
+    
+    for _, change := range r.Changes {
+        p.line(colorBold, "diff %s", change.ID())
+        switch change.Type {
+        case Added:
+            p.line(colorBold, "--- /dev/null")
+            p.line(colorBold, "+++ b/%s", change.ID())
+            p.block(colorGreen, "+", change.New)
+        case Removed:
+            p.line(colorBold, "--- a/%s", change.ID())
+            p.line(colorBold, "+++ /dev/null")
+            p.block(colorRed, "-", change.Old)
+        case Modified:
+            p.line(colorBold, "--- a/%s", change.ID())
+            p.line(colorBold, "+++ b/%s", change.ID())
+            for _, field := range change.Fields {
+                p.field(field)
+            }
+        }
+    }
+    
+    return p.sb.String()
+}
+
+func (p *printer) field(field FieldChange) {
+    p.line(colorCyan, "@@ %s @@", field.Path)
+    
+    switch {
+    case field.Added != nil || field.Removed != nil:
+        for _, item := range field.Removed {
+            p.line(colorRed, "-%s", item)
+        }
+        for _, item := range field.Added {
+            p.line(colorGreen, "+%s", item)
+        }
+    default:
+        if field.Old != nil {
+            p.block(colorRed, "-", field.Old)
+        }
+        if field.New != nil {
+            p.block(colorGreen, "+", field.New)
+        }
+    }
+}
+
+// block prints a value as YAML, prefixing every line
+func (p *printer) block(color, prefix string, value interface{}) {
+    data, err := yaml.Marshal(value)
+    if err != nil {
+        p.line(color, "%s%v", prefix, value)
+        return
+    }
+    for _, l := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+        p.line(color, "%s%s", prefix, l)
+    }
+}
+
+// pkg/merge/merge.go
+package merge
+
+import (
+    "bytes"
+    "fmt"
+    "reflect"
+    "sort"
+    
+    "netgit/pkg/config"
+    "netgit/pkg/diff"
+)
+
+// Conflict is a change made differently on both sides of a merge. The
+// merged config keeps our side of every conflict.
+type Conflict struct {
+    File   string      `json:"file"`
+    Kind   string      `json:"kind"`
+    Name   string      `json:"name"`
+    Path   string      `json:"path,omitempty"`
+    Reason string      `json:"reason"`
+    Base   interface{} `json:"base,omitempty"`
+    Ours   interface{} `json:"ours,omitempty"`
+    Theirs interface{} `json:"theirs,omitempty"`
+}
+
+func (c Conflict) String() string {
+    location := c.File
+    if c.Kind != "" {
+        location += ":" + c.Kind + "/" + c.Name
+    }
+    if c.Path != "" {
+        location += " " + c.Path
+    }
+    return fmt.Sprintf("%s (%s)", location, c.Reason)
+}
+
+type merger struct {
+    file      string
+    kind      string
+    name      string
+    conflicts []Conflict
+}
+
+func (m *merger) conflict(path, reason string, base, ours, theirs interface{}) {
+    m.conflicts = append(m.conflicts, Conflict{
+        File:   m.file,
+        Kind:   m.kind,
+        Name:   m.name,
+        Path:   path,
+        Reason: reason,
+        Base:   base,
+        Ours:   ours,
+        Theirs: theirs,
+    })
+}
+
+// scalar merges a value changed on at most one side; a value changed
+// differently on both sides is a conflict
+func (m *merger) scalar(path string, base, ours, theirs interface{}) interface{} {
+    switch {
+    case reflect.DeepEqual(ours, theirs):
+        return ours
+    case reflect.DeepEqual(base, ours):
+        return theirs
+    case reflect.DeepEqual(base, theirs):
+        return ours
+    default:
+        m.conflict(path, "changed on both sides", base, ours, theirs)
+        return ours
+    }
+}
+
+// set merges string lists as sets: additions and removals from both sides
+// are applied, so set fields never conflict
+func set(base, ours, theirs []string) []string {
+    inBase := toSet(base)
+    inTheirs := toSet(theirs)
+    
+    var merged []string
+    seen := map[string]bool{}
+    for _, item := range ours {
+        // Dropped by theirs
+        if inBase[item] && !inTheirs[item] {
+            continue
+        }
+        if !seen[item] {
+            seen[item] = true
+            merged = append(merged, item)
+        }
+    }
+    for _, item := range theirs {
+        // Added by theirs
+        if !inBase[item] && !seen[item] {
+            seen[item] = true
+            merged = append(merged, item)
+        }
+    }
+    return merged
+}
+
+func toSet(items []string) map[string]bool {
+    s := map[string]bool{}
+    for _, item := range items {
+        s[item] = true
+    }
+    return s
+}
+
+type resource struct {
+    name  string
+    value interface{}
+}
+
+// resources merges one kind of named resource. Added and deleted resources
+// are taken from whichever side changed them; a resource deleted on one side
+// and modified on the other is a conflict. fields merges a resource present
+// on both sides, with a nil base when both sides added it.
+func (m *merger) resources(base, ours, theirs []resource, fields func(base, ours, theirs interface{}) interface{}) []interface{} {
+    baseByName := indexResources(base)
+    oursByName := indexResources(ours)
+    theirsByName := indexResources(theirs)
+    
+    var names []string
+    for _, res := range ours {
+        names = append(names, res.name)
+    }
+    for _, res := range theirs {
+        if _, ok := oursByName[res.name]; !ok {
+            names = append(names, res.name)
+        }
+    }
+    
+    var merged []interface{}
+    for _, name := range names {
+        m.name = name
+        b, inBase := baseByName[name]
+        o, inOurs := oursByName[name]
+        t, inTheirs := theirsByName[name]
+        
+        switch {
+        case inOurs && inTheirs:
+            if !inBase {
+                b = nil
+            }
+            merged = append(merged, fields(b, o, t))
+        case inOurs:
+            if !inBase {
+                merged = append(merged, o)
+            } else if !reflect.DeepEqual(b, o) {
+                m.conflict("", "modified in ours, deleted in theirs", b, o, nil)
+                merged = append(merged, o)
+            }
+        case inTheirs:
+            if !inBase {
+                merged = append(merged, t)
+            } else if !reflect.DeepEqual(b, t) {
+                m.conflict("", "deleted in ours, modified in theirs", b, nil, t)
+                merged = append(merged, t)
+            }
+        }
+    }
+    m.name = ""
+    return merged
+}
+
+func indexResources(resources []resource) map[string]interface{} {
+    index := map[string]interface{}{}
+    for _, res := range resources {
+        if _, exists := index[res.name]; !exists {
+            index[res.name] = res.value
+        }
+    }
+    return index
+}
+
+// ThreeWay merges ours and theirs, both descended from base. Files are
+// matched by source path and resources by name; the result keeps our file
+// order, followed by files only theirs added.
+func ThreeWay(base, ours, theirs []config.NetworkConfig) ([]config.NetworkConfig, []Conflict) {
+    baseByFile := indexFiles(base)
+    oursByFile := indexFiles(ours)
+    theirsByFile := indexFiles(theirs)
+    
+    var files []string
+    for _, cfg := range ours {
+        files = append(files, cfg.Source)
+    }
+    var added []string
+    for _, cfg := range theirs {
+        if _, ok := oursByFile[cfg.Source]; !ok {
+            added = append(added, cfg.Source)
+        }
+    }
+    sort.Strings(added)
+    files = append(files, added...)
+    
+    var merged []config.NetworkConfig
+    var conflicts []Conflict
+    for _, file := range files {
+        b, inBase := baseByFile[file]
+        o, inOurs := oursByFile[file]
+        t, inTheirs := theirsByFile[file]
+        m := &merger{file: file}
+        
+        switch {
+        case inOurs && inTheirs:
+            merged = append(merged, m.file3(b, o, t))
+        case inOurs:
+            if !inBase {
+                merged = append(merged, o)
+            } else if !sameConfig(b, o) {
+                m.conflict("", "modified in ours, deleted in theirs", nil, nil, nil)
+                merged = append(merged, o)
+            }
+        case inTheirs:
+            if !inBase {
+                merged = append(merged, t)
+            } else if !sameConfig(b, t) {
+                m.conflict("", "deleted in ours, modified in theirs", nil, nil, nil)
+                merged = append(merged, t)
+            }
+        }
+        conflicts = append(conflicts, m.conflicts...)
+    }
+    
+    return merged, conflicts
+}
+
+func indexFiles(configs []config.NetworkConfig) map[string]config.NetworkConfig {
+    index := map[string]config.NetworkConfig{}
+    for _, cfg := range configs {
+        index[cfg.Source] = cfg
+    }
+    return index
+}
+
+func sameConfig(a, b config.NetworkConfig) bool {
+    if a.Fragment || b.Fragment {
+        return a.Fragment == b.Fragment && bytes.Equal(a.Raw, b.Raw)
+    }
+    return reflect.DeepEqual(a.Metadata, b.Metadata) && reflect.DeepEqual(a.Variables, b.Variables) &&
+        reflect.DeepEqual(a.Delete, b.Delete) && diff.Compare(a, b).Empty()
+}
+
+// file3 merges one file present on both sides. When the result matches one
+// side exactly, that side's original bytes are kept.
+func (m *merger) file3(base, ours, theirs config.NetworkConfig) config.NetworkConfig {
+    // Fragments have no resources to merge, only bytes
+    if ours.Fragment || theirs.Fragment {
+        switch {
+        case sameConfig(ours, theirs) || sameConfig(base, theirs):
+            return ours
+        case sameConfig(base, ours):
+            return theirs
+        }
+        m.conflict("", "included fragment modified on both sides", nil, nil, nil)
+        return ours
+    }
+    
+    // Resources are merged in canonical form, in which diffs match them,
+    // so that files committed before normalization still line up
+    base, ours, theirs = base.Canonical(), ours.Canonical(), theirs.Canonical()
+    merged := config.NetworkConfig{Source: ours.Source}
+    
+    m.kind = diff.KindMetadata
+    merged.Metadata = m.scalar("", base.Metadata, ours.Metadata, theirs.Metadata).(config.Metadata)
+    merged.Variables = m.scalar("variables", base.Variables, ours.Variables, theirs.Variables).(map[string]config.Variable)
+    merged.Delete = set(base.Delete, ours.Delete, theirs.Delete)
+    
+    m.kind = config.KindSecurityGroup
+    for _, v := range m.resources(securityGroups(base), securityGroups(ours), securityGroups(theirs), m.securityGroup) {
+        merged.SecurityGroups = append(merged.SecurityGroups, v.(config.SecurityGroup))
+    }
+    m.kind = config.KindNetworkPolicy
+    for _, v := range m.resources(networkPolicies(base), networkPolicies(ours), networkPolicies(theirs), m.networkPolicy) {
+        merged.NetworkPolicies = append(merged.NetworkPolicies, v.(config.NetworkPolicy))
+    }
+    m.kind = config.KindFirewallRule
+    for _, v := range m.resources(firewallRules(base), firewallRules(ours), firewallRules(theirs), m.firewallRule) {
+        merged.FirewallRules = append(merged.FirewallRules, v.(config.FirewallRule))
+    }
+    m.kind = ""
+    
+    switch {
+    case sameConfig(merged, ours):
+        merged.Raw = ours.Raw
+    case sameConfig(merged, theirs):
+        merged.Raw = theirs.Raw
+    }
+    return merged
+}
+
+func securityGroups(cfg config.NetworkConfig) []resource {
+    var resources []resource
+    for _, sg := range cfg.SecurityGroups {
+        resources = append(resources, resource{sg.Name, sg})
+    }
+    return resources
+}
+
+func networkPolicies(cfg config.NetworkConfig) []resource {
+    var resources []resource
+    for _, np := range cfg.NetworkPolicies {
+        resources = append(resources, resource{np.Name, np})
+    }
+    return resources
+}
+
+func firewallRules(cfg config.NetworkConfig) []resource {
+    var resources []resource
+    for _, fw := range cfg.FirewallRules {
+        resources = append(resources, resource{fw.Name, fw})
+    }
+    return resources
+}
+
+func (m *merger) securityGroup(base, ours, theirs interface{}) interface{} {
+    var b config.SecurityGroup
+    if base != nil {
+        b = base.(config.SecurityGroup)
+    }
+    o := ours.(config.SecurityGroup)
+    t := theirs.(config.SecurityGroup)
+    
+    merged := o
+    merged.ID = m.scalar("id", b.ID, o.ID, t.ID).(string)
+    merged.Description = m.scalar("description", b.Description, o.Description, t.Description).(string)
+    merged.VpcId = m.scalar("vpcId", b.VpcId, o.VpcId, t.VpcId).(string)
+    
+    // Rules are matched against the base like in diffs. Each base rule is
+    // merged with what each side made of it; rules either side added are
+    // kept, once if both sides added the same rule.
+    ourMatch := make([]int, len(b.Rules))
+    theirMatch := make([]int, len(b.Rules))
+    var ourAdded, theirAdded []config.Rule
+    for _, pair := range config.MatchRules(b.Rules, o.Rules) {
+        if pair.Old < 0 {
+            ourAdded = append(ourAdded, o.Rules[pair.New])
+        } else {
+            ourMatch[pair.Old] = pair.New
+        }
+    }
+    for _, pair := range config.MatchRules(b.Rules, t.Rules) {
+        if pair.Old < 0 {
+            theirAdded = append(theirAdded, t.Rules[pair.New])
+        } else {
+            theirMatch[pair.Old] = pair.New
+        }
+    }
+    
+    merged.Rules = nil
+    for i, baseRule := range b.Rules {
+        path := fmt.Sprintf("rules[%s]", baseRule.Key())
+        oi, ti := ourMatch[i], theirMatch[i]
+        switch {
+        case oi >= 0 && ti >= 0:
+            rule := o.Rules[oi]
+            theirRule := t.Rules[ti]
+            rule.Ports = m.scalar(path+".ports", baseRule.Ports, rule.Ports, theirRule.Ports).([]string)
+            rule.Sources = set(baseRule.Sources, rule.Sources, theirRule.Sources)
+            rule.Action = m.scalar(path+".action", baseRule.Action, rule.Action, theirRule.Action).(string)
+            merged.Rules = append(merged.Rules, rule)
+        case oi >= 0 && !reflect.DeepEqual(baseRule, o.Rules[oi]):
+            m.conflict(path, "modified in ours, deleted in theirs", baseRule, o.Rules[oi], nil)
+            merged.Rules = append(merged.Rules, o.Rules[oi])
+        case ti >= 0 && !reflect.DeepEqual(baseRule, t.Rules[ti]):
+            m.conflict(path, "deleted in ours, modified in theirs", baseRule, nil, t.Rules[ti])
+        }
+        // Otherwise deleted by one side and unchanged by the other, or
+        // deleted by both
+    }
+    
+    merged.Rules = append(merged.Rules, ourAdded...)
+    for _, pair := range config.MatchRules(ourAdded, theirAdded) {
+        if pair.New < 0 {
+            continue
+        }
+        if pair.Old >= 0 && reflect.DeepEqual(ourAdded[pair.Old], theirAdded[pair.New]) {
+            continue
+        }
+        merged.Rules = append(merged.Rules, theirAdded[pair.New])
+    }
+    
+    return merged
+}
+
+func (m *merger) networkPolicy(base, ours, theirs interface{}) interface{} {
+    var b config.NetworkPolicy
+    if base != nil {
+        b = base.(config.NetworkPolicy)
+    }
+    o := ours.(config.NetworkPolicy)
+    t := theirs.(config.NetworkPolicy)
+    
+    merged := o
+    merged.Namespace = m.scalar("namespace", b.Namespace, o.Namespace, t.Namespace).(string)
+    merged.Selector = m.scalar("selector", b.Selector, o.Selector, t.Selector).(map[string]string)
+    merged.PolicyTypes = set(b.PolicyTypes, o.PolicyTypes, t.PolicyTypes)
+    merged.Ingress = m.scalar("ingress", b.Ingress, o.Ingress, t.Ingress).([]config.NetworkPolicyRule)
+    merged.Egress = m.scalar("egress", b.Egress, o.Egress, t.Egress).([]config.NetworkPolicyRule)
+    return merged
+}
+
+func (m *merger) firewallRule(base, ours, theirs interface{}) interface{} {
+    var b config.FirewallRule
+    if base != nil {
+        b = base.(config.FirewallRule)
+    }
+    o := ours.(config.FirewallRule)
+    t := theirs.(config.FirewallRule)
+    
+    merged := o
+    merged.Direction = m.scalar("direction", b.Direction, o.Direction, t.Direction).(string)
+    merged.Priority = m.scalar("priority", b.Priority, o.Priority, t.Priority).(int)
+    merged.Protocol = m.scalar("protocol", b.Protocol, o.Protocol, t.Protocol).(string)
+    merged.Ports = set(b.Ports, o.Ports, t.Ports)
+    merged.SourceRanges = set(b.SourceRanges, o.SourceRanges, t.SourceRanges)
+    merged.DestinationRanges = set(b.DestinationRanges, o.DestinationRanges, t.DestinationRanges)
+    merged.TargetTags = set(b.TargetTags, o.TargetTags, t.TargetTags)
+    merged.Action = m.scalar("action", b.Action, o.Action, t.Action).(string)
+    return merged
+}
+
+// pkg/remote/server.go
+package remote
+
+import (
+    "crypto/subtle"
+    "net/http"
+    "strings"
+    "time"
+    
+    "github.com/gin-gonic/gin"
+    "netgit/pkg/audit"
+    "netgit/pkg/storage"
+)
+
+// The protocol is JSON over HTTP. A client lists the server's refs, then
+// either fetches the objects it lacks or pushes objects with ref updates.
+type RefsResponse struct {
+    Refs map[string]string `json:"refs"`
+}
+
+type FetchRequest struct {
+    Wants []string `json:"wants"`
+    Haves []string `json:"haves"`
+}
+
+type FetchResponse struct {
+    Objects []storage.Object `json:"objects"`
+}
+
+type PushRequest struct {
+    Objects []storage.Object    `json:"objects"`
+    Updates []storage.RefUpdate `json:"updates"`
+}
+
+type PushResponse struct {
+    Results []RefResult `json:"results"`
+}
+
+// RefResult reports what happened to one ref during a push or fetch
+type RefResult struct {
+    Ref      string `json:"ref"`
+    Old      string `json:"old,omitempty"`
+    New      string `json:"new,omitempty"`
+    UpToDate bool   `json:"upToDate,omitempty"`
+    Error    string `json:"error,omitempty"`
+}
+
+type errorResponse struct {
+    Error string `json:"error"`
+}
+
+// TokenEnv names the environment variable holding the token a client
+// presents to servers and that netgit serve expects by default
+const TokenEnv = "NETGIT_TOKEN"
+
+// ServerOptions controls who may use a server and what a push may do.
+// Every request must carry Token as a bearer token; forced updates and
+// ref deletions are refused unless allowed.
+type ServerOptions struct {
+    Token       string
+    AllowForce  bool
+    AllowDelete bool
+}
+
+type server struct {
+    repo *storage.Repository
+    opts ServerOptions
+}
+
+// NewServer returns the HTTP handler that serves repo to netgit clients
+func NewServer(repo *storage.Repository, opts ServerOptions) *gin.Engine {
+    s := &server{repo: repo, opts: opts}
+    
+    router := gin.New()
+    router.Use(gin.Recovery(), s.authorize)
+    router.GET("/refs", s.refs)
+    router.POST("/fetch", s.fetch)
+    router.POST("/push", s.push)
+    return router
+}
+
+// authorize rejects requests without the server's token. A server without
+// a token serves no one.
+func (s *server) authorize(c *gin.Context) {
+    token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+    if !ok || s.opts.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.opts.Token)) != 1 {
+        c.AbortWithStatusJSON(http.StatusUnauthorized, errorResponse{Error: "unauthorized"})
+        return
+    }
+    c.Next()
+}
+
+func (s *server) refs(c *gin.Context) {
+    refs, err := s.repo.Refs()
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, errorResponse{Error: err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, RefsResponse{Refs: refs})
+}
+
+func (s *server) fetch(c *gin.Context) {
+    var req FetchRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, errorResponse{Error: err.Error()})
+        return
+    }
+    
+    objects, err := s.repo.CollectObjects(req.Wants, req.Haves)
+    if err != nil {
+        c.JSON(http.StatusNotFound, errorResponse{Error: err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, FetchResponse{Objects: objects})
+}
+
+// push checks each update against the server's options, then stores the
+// objects and applies the updates together, so each update can check that
+// the history it points at is complete and nothing is kept if none apply
+func (s *server) push(c *gin.Context) {
+    var req PushRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, errorResponse{Error: err.Error()})
+        return
+    }
+    
+    resp := PushResponse{}
+    var updates []storage.RefUpdate
+    var pending []int
+    for _, update := range req.Updates {
+        result := RefResult{Ref: update.Ref, Old: update.Old, New: update.New}
+        if !strings.HasPrefix(update.Ref, "refs/heads/") && !strings.HasPrefix(update.Ref, "refs/tags/") {
+            result.Error = "only branches and tags can be pushed"
+        } else if update.New == "" && !s.opts.AllowDelete {
+            result.Error = "deleting refs is disabled on this server"
+        } else if update.Force && !s.opts.AllowForce {
+            result.Error = "forced updates are disabled on this server"
+        } else {
+            updates = append(updates, update)
+            pending = append(pending, len(resp.Results))
+        }
+        resp.Results = append(resp.Results, result)
+    }
+    
+    errs, err := s.repo.Receive(req.Objects, updates)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, errorResponse{Error: err.Error()})
+        return
+    }
+    for i, update := range updates {
+        if errs[i] != nil {
+            resp.Results[pending[i]].Error = errs[i].Error()
+            continue
+        }
+        audit.LogEvent("receive", map[string]interface{}{
+            "ref":       update.Ref,
+            "old":       update.Old,
+            "new":       update.New,
+            "forced":    update.Force,
+            "remote":    c.ClientIP(),
+            "timestamp": time.Now(),
+        })
+    }
+    c.JSON(http.StatusOK, resp)
+}
+
+// pkg/remote/client.go
+package remote
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+    
+    "netgit/pkg/storage"
+)
+
+// Client talks to a netgit server, presenting token on every request
+type Client struct {
+    url   string
+    token string
+    http  *http.Client
+}
+
+func NewClient(url, token string) *Client {
+    return &Client{
+        url:   strings.TrimSuffix(url, "/"),
+        token: token,
+        http:  &http.Client{Timeout: 5 * time.Minute},
+    }
+}
+
+func (c *Client) Refs() (map[string]string, error) {
+    var resp RefsResponse
+    if err := c.do(http.MethodGet, "/refs", nil, &resp); err != nil {
+        return nil, err
+    }
+    return resp.Refs, nil
+}
+
+func (c *Client) Fetch(wants, haves []string) ([]storage.Object, error) {
+    var resp FetchResponse
+    if err := c.do(http.MethodPost, "/fetch", FetchRequest{Wants: wants, Haves: haves}, &resp); err != nil {
+        return nil, err
+    }
+    return resp.Objects, nil
+}
+
+func (c *Client) Push(objects []storage.Object, updates []storage.RefUpdate) ([]RefResult, error) {
+    var resp PushResponse
+    if err := c.do(http.MethodPost, "/push", PushRequest{Objects: objects, Updates: updates}, &resp); err != nil {
+        return nil, err
+    }
+    return resp.Results, nil
+}
+
+func (c *Client) do(method, path string, body, out interface{}) error {
+    var reader *bytes.Reader
+    if body != nil {
+        data, err := json.Marshal(body)
+        if err != nil {
+            return err
+        }
+        reader = bytes.NewReader(data)
+    } else {
+        reader = bytes.NewReader(nil)
+    }
+    
+    req, err := http.NewRequest(method, c.url+path, reader)
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    if c.token != "" {
+        req.Header.Set("Authorization", "Bearer "+c.token)
+    }
+    
+    resp, err := c.http.Do(req)
+    if err != nil {
+        return fmt.Errorf("remote %s: %w", c.url, err)
+    }
+    defer resp.Body.Close()
+    
+    if resp.StatusCode != http.StatusOK {
+        var e errorResponse
+        if json.NewDecoder(resp.Body).Decode(&e) == nil && e.Error != "" {
+            return fmt.Errorf("remote %s: %s", c.url, e.Error)
+        }
+        return fmt.Errorf("remote %s: %s", c.url, resp.Status)
+    }
+    return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// pkg/remote/sync.go
+package remote
+
+import (
+    "fmt"
+    "os"
+    "sort"
+    "strings"
+    
+    "netgit/pkg/storage"
+)
+
+const (
+    branchRefPrefix = "refs/heads/"
+    tagRefPrefix    = "refs/tags/"
+)
+
+type FetchResult struct {
+    Branches []RefResult
+    Tags     []RefResult
+    Objects  int
+}
+
+// Fetch downloads the branches and tags of a remote. Branches land in
+// remote-tracking refs; tags are created locally unless a tag of the same
+// name already points elsewhere, which is reported rather than overwritten.
+func Fetch(repo *storage.Repository, remoteName string) (*FetchResult, error) {
+    remote, err := repo.GetRemote(remoteName)
+    if err != nil {
+        return nil, err
+    }
+    client := NewClient(remote.URL, os.Getenv(TokenEnv))
+    
+    remoteRefs, err := client.Refs()
+    if err != nil {
+        return nil, err
+    }
+    localRefs, err := repo.Refs()
+    if err != nil {
+        return nil, err
+    }
+    tracking, err := repo.RemoteBranches(remoteName)
+    if err != nil {
+        return nil, err
+    }
+    
+    var wants, haves []string
+    wanted := map[string]bool{}
+    for _, hash := range remoteRefs {
+        if !wanted[hash] && !repo.HasObject(hash) {
+            wanted[hash] = true
+            wants = append(wants, hash)
+        }
+    }
+    for _, hash := range localRefs {
+        haves = append(haves, hash)
+    }
+    for _, hash := range tracking {
+        haves = append(haves, hash)
+    }
+    
+    result := &FetchResult{}
+    if len(wants) > 0 {
+        objects, err := client.Fetch(wants, haves)
+        if err != nil {
+            return nil, err
+        }
+        if err := repo.StoreObjects(objects); err != nil {
+            return nil, err
+        }
+        result.Objects = len(objects)
+    }
+    
+    for _, ref := range sortedRefs(remoteRefs) {
+        hash := remoteRefs[ref]
+        switch {
+        case strings.HasPrefix(ref, branchRefPrefix):
+            branch := strings.TrimPrefix(ref, branchRefPrefix)
+            update := RefResult{Ref: storage.RemoteRef(remoteName, branch), Old: tracking[branch], New: hash}
+            if update.Old == hash {
+                update.UpToDate = true
+            } else if err := repo.UpdateRef(storage.RefUpdate{Ref: update.Ref, New: hash, Force: true}); err != nil {
+                return nil, err
+            }
+            result.Branches = append(result.Branches, update)
+        case strings.HasPrefix(ref, tagRefPrefix):
+            local, exists := localRefs[ref]
+            update := RefResult{Ref: ref, Old: local, New: hash}
+            switch {
+            case local == hash:
+                continue
+            case exists:
+                update.Error = "tag already exists locally and points elsewhere"
+            default:
+                if err := repo.UpdateRef(storage.RefUpdate{Ref: ref, New: hash}); err != nil {
+                    update.Error = err.Error()
+                }
+            }
+            result.Tags = append(result.Tags, update)
+        }
+    }
+    return result, nil
+}
+
+// Push sends local branches or tags, named without their refs/ prefix, to
+// a remote. Updates the remote rejects are returned with their error.
+func Push(repo *storage.Repository, remoteName string, names []string, force bool) ([]RefResult, error) {
+    remote, err := repo.GetRemote(remoteName)
+    if err != nil {
+        return nil, err
+    }
+    client := NewClient(remote.URL, os.Getenv(TokenEnv))
+    
+    localRefs, err := repo.Refs()
+    if err != nil {
+        return nil, err
+    }
+    remoteRefs, err := client.Refs()
+    if err != nil {
+        return nil, err
+    }
+    
+    var results []RefResult
+    var updates []storage.RefUpdate
+    var wants []string
+    for _, name := range names {
+        ref := branchRefPrefix + name
+        hash, ok := localRefs[ref]
+        if !ok {
+            ref = tagRefPrefix + name
+            if hash, ok = localRefs[ref]; !ok {
+                return nil, fmt.Errorf("'%s' is not a local branch or tag", name)
+            }
+        }
+        
+        if remoteRefs[ref] == hash {
+            results = append(results, RefResult{Ref: ref, Old: hash, New: hash, UpToDate: true})
+            continue
+        }
+        updates = append(updates, storage.RefUpdate{Ref: ref, Old: remoteRefs[ref], New: hash, Force: force})
+        wants = append(wants, hash)
+    }
+    if len(updates) == 0 {
+        return results, nil
+    }
+    
+    var haves []string
+    for _, hash := range remoteRefs {
+        if repo.HasObject(hash) {
+            haves = append(haves, hash)
+        }
+    }
+    objects, err := repo.CollectObjects(wants, haves)
+    if err != nil {
+        return nil, err
+    }
+    
+    pushed, err := client.Push(objects, updates)
+    if err != nil {
+        return nil, err
+    }
+    for _, result := range pushed {
+        if result.Error == "" && strings.HasPrefix(result.Ref, branchRefPrefix) {
+            tracking := storage.RemoteRef(remoteName, strings.TrimPrefix(result.Ref, branchRefPrefix))
+            if err := repo.UpdateRef(storage.RefUpdate{Ref: tracking, New: result.New, Force: true}); err != nil {
+                return nil, err
+            }
+        }
+    }
+    return append(results, pushed...), nil
+}
+
+func sortedRefs(refs map[string]string) []string {
+    names := make([]string, 0, len(refs))
+    for ref := range refs {
+        names = append(names, ref)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// pkg/importer/importer.go
+package importer
+
+import (
+    "fmt"
+    "io/ioutil"
+    "strconv"
+    "strings"
+    
+    "netgit/pkg/config"
+)
+
+// Importer converts a provider's native export into a NetworkConfig.
+// Anything the export holds that netgit cannot represent is skipped and
+// reported as a warning rather than silently dropped.
+type Importer interface {
+    Import(data []byte) (*config.NetworkConfig, []string, error)
+}
+
+func GetImporter(source string) (Importer, error) {
+    switch source {
+    case "aws":
+        return NewAWSImporter(), nil
+    case "gcp":
+        return NewGCPImporter(), nil
+    case "azure":
+        return NewAzureImporter(), nil
+    case "k8s", "kubernetes":
+        return NewKubernetesImporter(), nil
+    default:
+        return nil, fmt.Errorf("unknown import source: %s", source)
+    }
+}
+
+// ImportFile reads and converts one export file
+func ImportFile(source, filename string) (*config.NetworkConfig, []string, error) {
+    importer, err := GetImporter(source)
+    if err != nil {
+        return nil, nil, err
+    }
+    
+    data, err := ioutil.ReadFile(filename)
+    if err != nil {
+        return nil, nil, err
+    }
+    
+    cfg, warnings, err := importer.Import(data)
+    if err != nil {
+        return nil, nil, fmt.Errorf("failed to import %s: %w", filename, err)
+    }
+    return cfg, warnings, nil
+}
+
+// portRange renders a port range the way netgit configs spell it
+func portRange(from, to int) string {
+    if from == to {
+        return strconv.Itoa(from)
+    }
+    return fmt.Sprintf("%d-%d", from, to)
+}
+
+// isJSONArray reports whether data holds a JSON array rather than an object
+func isJSONArray(data []byte) bool {
+    return strings.HasPrefix(strings.TrimSpace(string(data)), "[")
+}
+
+// pkg/importer/aws.go
+package importer
+
+import (
+    "encoding/json"
+    "fmt"
+    "strings"
+    
+    "netgit/pkg/config"
+)
+
+// AWSImporter reads the output of `aws ec2 describe-security-groups`
+type AWSImporter struct{}
+
+func NewAWSImporter() *AWSImporter {
+    return &AWSImporter{}
+}
+
+type awsSecurityGroups struct {
+    SecurityGroups []awsSecurityGroup `json:"SecurityGroups"`
+}
+
+type awsSecurityGroup struct {
+    GroupId             string          `json:"GroupId"`
+    GroupName           string          `json:"GroupName"`
+    Description         string          `json:"Description"`
+    VpcId               string          `json:"VpcId"`
+    IpPermissions       []awsPermission `json:"IpPermissions"`
+    IpPermissionsEgress []awsPermission `json:"IpPermissionsEgress"`
+}
+
+type awsPermission struct {
+    IpProtocol string `json:"IpProtocol"`
+    FromPort   *int   `json:"FromPort"`
+    ToPort     *int   `json:"ToPort"`
+    IpRanges   []struct {
+        CidrIp string `json:"CidrIp"`
+    } `json:"IpRanges"`
+    Ipv6Ranges []struct {
+        CidrIpv6 string `json:"CidrIpv6"`
+    } `json:"Ipv6Ranges"`
+    UserIdGroupPairs []struct {
+        GroupId string `json:"GroupId"`
+    } `json:"UserIdGroupPairs"`
+    PrefixListIds []struct {
+        PrefixListId string `json:"PrefixListId"`
+    } `json:"PrefixListIds"`
+}
+
+var awsProtocols = map[string]string{
+    "-1": "all",
+    "1":  "icmp",
+    "6":  "tcp",
+    "17": "udp",
+    "58": "icmpv6",
+}
+
+func (a *AWSImporter) Import(data []byte) (*config.NetworkConfig, []string, error) {
+    var export awsSecurityGroups
+    if err := json.Unmarshal(data, &export); err != nil {
+        return nil, nil, err
+    }
+    
+    cfg := &config.NetworkConfig{}
+    var warnings []string
+    for _, group := range export.SecurityGroups {
+        sg := config.SecurityGroup{
+            ID:          group.GroupId,
+            Name:        group.GroupName,
+            Description: group.Description,
+            VpcId:       group.VpcId,
+        }
+        for _, perm := range group.IpPermissions {
+            sg.Rules = append(sg.Rules, awsRule(perm))
+        }
+        
+        skipped := 0
+        for _, perm := range group.IpPermissionsEgress {
+            if !isDefaultEgress(perm) {
+                skipped++
+            }
+        }
+        if skipped > 0 {
+            warnings = append(warnings, fmt.Sprintf("security group %s: %d egress rule(s) not imported; security groups hold ingress rules only", group.GroupName, skipped))
+        }
+        
+        cfg.SecurityGroups = append(cfg.SecurityGroups, sg)
+    }
+    return cfg, warnings, nil
+}
+
+func awsRule(perm awsPermission) config.Rule {
+    protocol, ok := awsProtocols[perm.IpProtocol]
+    if !ok {
+        protocol = strings.ToLower(perm.IpProtocol)
+    }
+    
+    rule := config.Rule{Protocol: protocol, Action: "allow"}
+    if (protocol == "tcp" || protocol == "udp") && perm.FromPort != nil && perm.ToPort != nil {
+        rule.Ports = []string{portRange(*perm.FromPort, *perm.ToPort)}
+    }
+    for _, r := range perm.IpRanges {
+        rule.Sources = append(rule.Sources, r.CidrIp)
+    }
+    for _, r := range perm.Ipv6Ranges {
+        rule.Sources = append(rule.Sources, r.CidrIpv6)
+    }
+    for _, pair := range perm.UserIdGroupPairs {
+        rule.Sources = append(rule.Sources, pair.GroupId)
+    }
+    for _, list := range perm.PrefixListIds {
+        rule.Sources = append(rule.Sources, list.PrefixListId)
+    }
+    return rule
+}
+
+// isDefaultEgress matches the allow-all egress rule AWS adds to every group
+func isDefaultEgress(perm awsPermission) bool {
+    if perm.IpProtocol != "-1" || len(perm.UserIdGroupPairs) > 0 || len(perm.PrefixListIds) > 0 {
+        return false
+    }
+    for _, r := range perm.IpRanges {
+        if r.CidrIp != "0.0.0.0/0" {
+            return false
+        }
+    }
+    for _, r := range perm.Ipv6Ranges {
+        if r.CidrIpv6 != "::/0" {
+            return false
+        }
+    }
+    return true
+}
+
+// pkg/importer/gcp.go
+package importer
+
+import (
+    "encoding/json"
+    "fmt"
+    "strings"
+    
+    "netgit/pkg/config"
+)
+
+// GCPImporter reads the output of
+// `gcloud compute firewall-rules list --format=json`
+type GCPImporter struct{}
+
+func NewGCPImporter() *GCPImporter {
+    return &GCPImporter{}
+}
+
+type gcpFirewall struct {
+    Name                  string        `json:"name"`
+    Direction             string        `json:"direction"`
+    Priority              *int          `json:"priority"`
+    Disabled              bool          `json:"disabled"`
+    Allowed               []gcpProtocol `json:"allowed"`
+    Denied                []gcpProtocol `json:"denied"`
+    SourceRanges          []string      `json:"sourceRanges"`
+    DestinationRanges     []string      `json:"destinationRanges"`
+    SourceTags            []string      `json:"sourceTags"`
+    TargetTags            []string      `json:"targetTags"`
+    SourceServiceAccounts []string      `json:"sourceServiceAccounts"`
+    TargetServiceAccounts []string      `json:"targetServiceAccounts"`
+}
+
+type gcpProtocol struct {
+    IPProtocol string   `json:"IPProtocol"`
+    Ports      []string `json:"ports"`
+}
+
+// GCP applies this priority to rules created without one
+const gcpDefaultPriority = 1000
+
+func (g *GCPImporter) Import(data []byte) (*config.NetworkConfig, []string, error) {
+    var rules []gcpFirewall
+    var err error
+    if isJSONArray(data) {
+        err = json.Unmarshal(data, &rules)
+    } else {
+        rules = make([]gcpFirewall, 1)
+        err = json.Unmarshal(data, &rules[0])
+    }
+    if err != nil {
+        return nil, nil, err
+    }
+    
+    cfg := &config.NetworkConfig{}
+    var warnings []string
+    for _, fw := range rules {
+        if fw.Disabled {
+            warnings = append(warnings, fmt.Sprintf("firewall rule %s: disabled, not imported", fw.Name))
+            continue
+        }
+        if len(fw.SourceTags) > 0 || len(fw.SourceServiceAccounts) > 0 || len(fw.TargetServiceAccounts) > 0 {
+            warnings = append(warnings, fmt.Sprintf("firewall rule %s: source tags and service accounts are not imported", fw.Name))
+        }
+        
+        direction := strings.ToUpper(fw.Direction)
+        if direction == "" {
+            direction = "INGRESS"
+        }
+        priority := gcpDefaultPriority
+        if fw.Priority != nil {
+            priority = *fw.Priority
+        }
+        
+        // A GCP rule may list several protocols; netgit rules carry one,
+        // so such a rule becomes one netgit rule per protocol
+        type entry struct {
+            action   string
+            protocol gcpProtocol
+        }
+        var entries []entry
+        for _, p := range fw.Allowed {
+            entries = append(entries, entry{"allow", p})
+        }
+        for _, p := range fw.Denied {
+            entries = append(entries, entry{"deny", p})
+        }
+        
+        for _, e := range entries {
+            name := fw.Name
+            if len(entries) > 1 {
+                name = fmt.Sprintf("%s-%s", fw.Name, strings.ToLower(e.protocol.IPProtocol))
+            }
+            cfg.FirewallRules = append(cfg.FirewallRules, config.FirewallRule{
+                Name:              name,
+                Direction:         direction,
+                Priority:          priority,
+                Protocol:          strings.ToLower(e.protocol.IPProtocol),
+                Ports:             e.protocol.Ports,
+                SourceRanges:      fw.SourceRanges,
+                DestinationRanges: fw.DestinationRanges,
+                TargetTags:        fw.TargetTags,
+                Action:            e.action,
+            })
+        }
+    }
+    return cfg, warnings, nil
+}
+
+// pkg/importer/azure.go
+package importer
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/netip"
+    "strings"
+    
+    "netgit/pkg/config"
+)
+
+// AzureImporter reads network security groups as printed by
+// `az network nsg show` or `az network nsg list`. Rules nested under
+// "properties", as in ARM templates and the REST API, are read too. Each
+// security rule becomes a firewall rule tagged with its NSG's name.
+type AzureImporter struct{}
+
+func NewAzureImporter() *AzureImporter {
+    return &AzureImporter{}
+}
+
+type azureNSG struct {
+    Name          string      `json:"name"`
+    SecurityRules []azureRule `json:"securityRules"`
+    Properties    *struct {
+        SecurityRules []azureRule `json:"securityRules"`
+    } `json:"properties"`
+}
+
+type azureRule struct {
+    Name                       string     `json:"name"`
+    Access                     string     `json:"access"`
+    Direction                  string     `json:"direction"`
+    Priority                   int        `json:"priority"`
+    Protocol                   string     `json:"protocol"`
+    SourceAddressPrefix        string     `json:"sourceAddressPrefix"`
+    SourceAddressPrefixes      []string   `json:"sourceAddressPrefixes"`
+    DestinationAddressPrefix   string     `json:"destinationAddressPrefix"`
+    DestinationAddressPrefixes []string   `json:"destinationAddressPrefixes"`
+    DestinationPortRange       string     `json:"destinationPortRange"`
+    DestinationPortRanges      []string   `json:"destinationPortRanges"`
+    Properties                 *azureRule `json:"properties"`
+}
+
+func (az *AzureImporter) Import(data []byte) (*config.NetworkConfig, []string, error) {
+    var groups []azureNSG
+    var err error
+    if isJSONArray(data) {
+        err = json.Unmarshal(data, &groups)
+    } else {
+        groups = make([]azureNSG, 1)
+        err = json.Unmarshal(data, &groups[0])
+    }
+    if err != nil {
+        return nil, nil, err
+    }
+    
+    cfg := &config.NetworkConfig{}
+    var warnings []string
+    warned := map[string]bool{}
+    for _, nsg := range groups {
+        rules := nsg.SecurityRules
+        if nsg.Properties != nil {
+            rules = append(rules, nsg.Properties.SecurityRules...)
+        }
+        
+        for _, rule := range rules {
+            if rule.Properties != nil {
+                name := rule.Name
+                rule = *rule.Properties
+                rule.Name = name
+            }
+            
+            fw := config.FirewallRule{
+                Name:       nsg.Name + "-" + rule.Name,
+                Direction:  "INGRESS",
+                Priority:   rule.Priority,
+                Protocol:   strings.ToLower(rule.Protocol),
+                TargetTags: []string{nsg.Name},
+                Action:     strings.ToLower(rule.Access),
+            }
+            if fw.Protocol == "*" {
+                fw.Protocol = "all"
+            }
+            for _, ports := range append([]string{rule.DestinationPortRange}, rule.DestinationPortRanges...) {
+                if ports != "" && ports != "*" {
+                    fw.Ports = append(fw.Ports, ports)
+                }
+            }
+            
+            sources := azurePrefixes(rule.SourceAddressPrefix, rule.SourceAddressPrefixes)
+            destinations := azurePrefixes(rule.DestinationAddressPrefix, rule.DestinationAddressPrefixes)
+            // Firewall rules filter on the remote side only, so the local
+            // side of the rule is dropped unless it is anywhere
+            if strings.EqualFold(rule.Direction, "Outbound") {
+                fw.Direction = "EGRESS"
+                fw.DestinationRanges = destinations
+                if !azureAnywhere(sources) {
+                    warnings = append(warnings, fmt.Sprintf("%s: source %s dropped; outbound rules are imported with their destinations only", fw.Name, strings.Join(sources, ", ")))
+                }
+            } else {
+                fw.SourceRanges = sources
+                if !azureAnywhere(destinations) {
+                    warnings = append(warnings, fmt.Sprintf("%s: destination %s dropped; inbound rules are imported with their sources only", fw.Name, strings.Join(destinations, ", ")))
+                }
+            }
+            
+            for _, prefix := range append(fw.SourceRanges, fw.DestinationRanges...) {
+                if !strings.ContainsAny(prefix, ".:") && !warned[prefix] {
+                    warned[prefix] = true
+                    warnings = append(warnings, fmt.Sprintf("service tag %s kept as is; it is not a CIDR range", prefix))
+                }
+            }
+            
+            cfg.FirewallRules = append(cfg.FirewallRules, fw)
+        }
+    }
+    return cfg, warnings, nil
+}
+
+// azurePrefixes maps Azure's wildcards for "anywhere" to 0.0.0.0/0 and
+// bare addresses to single-address CIDRs
+func azurePrefixes(prefix string, prefixes []string) []string {
+    var out []string
+    for _, p := range append([]string{prefix}, prefixes...) {
+        switch p {
+        case "":
+            continue
+        case "*", "Internet", "Any":
+            p = "0.0.0.0/0"
+        }
+        if addr, err := netip.ParseAddr(p); err == nil {
+            p = netip.PrefixFrom(addr, addr.BitLen()).String()
+        }
+        out = append(out, p)
+    }
+    return out
+}
+
+func azureAnywhere(prefixes []string) bool {
+    for _, p := range prefixes {
+        if p != "0.0.0.0/0" && p != "::/0" {
+            return false
+        }
+    }
+    return true
+}
+
+// pkg/importer/kubernetes.go
+package importer
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "io"
+    "strconv"
+    
+    "gopkg.in/yaml.v3"
+    "netgit/pkg/config"
+)
+
+// KubernetesImporter reads NetworkPolicy manifests: one or more YAML
+// documents, JSON from `kubectl get -o json`, or a List of policies
+type KubernetesImporter struct{}
+
+func NewKubernetesImporter() *KubernetesImporter {
+    return &KubernetesImporter{}
+}
+
+type k8sObject struct {
+    Kind     string `yaml:"kind"`
+    Metadata struct {
+        Name      string `yaml:"name"`
+        Namespace string `yaml:"namespace"`
+    } `yaml:"metadata"`
+    Spec struct {
+        PodSelector k8sSelector `yaml:"podSelector"`
+        PolicyTypes []string    `yaml:"policyTypes"`
+        Ingress     []k8sRule   `yaml:"ingress"`
+        Egress      []k8sRule   `yaml:"egress"`
+    } `yaml:"spec"`
+    Items []k8sObject `yaml:"items"`
+}
+
+type k8sSelector struct {
+    MatchLabels      map[string]string `yaml:"matchLabels"`
+    MatchExpressions []interface{}     `yaml:"matchExpressions"`
+}
+
+type k8sRule struct {
+    Ports []struct {
+        Protocol string `yaml:"protocol"`
+        Port     string `yaml:"port"`
+        EndPort  int    `yaml:"endPort"`
+    } `yaml:"ports"`
+    From []k8sPeer `yaml:"from"`
+    To   []k8sPeer `yaml:"to"`
+}
+
+type k8sPeer struct {
+    PodSelector       *k8sSelector `yaml:"podSelector"`
+    NamespaceSelector *k8sSelector `yaml:"namespaceSelector"`
+    IPBlock           *struct {
+        CIDR   string   `yaml:"cidr"`
+        Except []string `yaml:"except"`
+    } `yaml:"ipBlock"`
+}
+
+func (k *KubernetesImporter) Import(data []byte) (*config.NetworkConfig, []string, error) {
+    cfg := &config.NetworkConfig{}
+    imp := &k8sImport{}
+    
+    decoder := yaml.NewDecoder(bytes.NewReader(data))
+    for {
+        var obj k8sObject
+        err := decoder.Decode(&obj)
+        if errors.Is(err, io.EOF) {
+            break
+        }
+        if err != nil {
+            return nil, nil, err
+        }
+        cfg.NetworkPolicies = append(cfg.NetworkPolicies, imp.object(obj)...)
+    }
+    return cfg, imp.warnings, nil
+}
+
+type k8sImport struct {
+    policy   string
+    warnings []string
+}
+
+func (imp *k8sImport) warn(format string, args ...interface{}) {
+    imp.warnings = append(imp.warnings, fmt.Sprintf("network policy %s: ", imp.policy)+fmt.Sprintf(format, args...))
+}
+
+func (imp *k8sImport) object(obj k8sObject) []config.NetworkPolicy {
+    switch obj.Kind {
+    case "":
+        // Empty document, e.g. a trailing "---"
+        return nil
+    case "List", "NetworkPolicyList":
+        var policies []config.NetworkPolicy
+        for _, item := range obj.Items {
+            policies = append(policies, imp.object(item)...)
+        }
+        return policies
+    case "NetworkPolicy":
+    default:
+        imp.warnings = append(imp.warnings, fmt.Sprintf("%s %s: not a NetworkPolicy, skipped", obj.Kind, obj.Metadata.Name))
+        return nil
+    }
+    
+    imp.policy = obj.Metadata.Name
+    np := config.NetworkPolicy{
+        Name:        obj.Metadata.Name,
+        Namespace:   obj.Metadata.Namespace,
+        Selector:    imp.selector(&obj.Spec.PodSelector),
+        PolicyTypes: obj.Spec.PolicyTypes,
+    }
+    if np.Namespace == "" {
+        np.Namespace = "default"
+    }
+    for _, rule := range obj.Spec.Ingress {
+        np.Ingress = append(np.Ingress, config.NetworkPolicyRule{Ports: imp.ports(rule), From: imp.peers(rule.From)})
+    }
+    for _, rule := range obj.Spec.Egress {
+        np.Egress = append(np.Egress, config.NetworkPolicyRule{Ports: imp.ports(rule), To: imp.peers(rule.To)})
+    }
+    return []config.NetworkPolicy{np}
+}
+
+func (imp *k8sImport) selector(sel *k8sSelector) map[string]string {
+    if sel == nil {
+        return nil
+    }
+    if len(sel.MatchExpressions) > 0 {
+        imp.warn("matchExpressions are not supported; only matchLabels were imported")
+    }
+    return sel.MatchLabels
+}
+
+func (imp *k8sImport) ports(rule k8sRule) []config.NetworkPolicyPort {
+    var ports []config.NetworkPolicyPort
+    for _, p := range rule.Ports {
+        port := config.NetworkPolicyPort{Protocol: p.Protocol, Port: p.Port}
+        if port.Protocol == "" {
+            port.Protocol = "TCP"
+        }
+        if p.EndPort > 0 {
+            if start, err := strconv.Atoi(p.Port); err == nil {
+                port.Port = portRange(start, p.EndPort)
+            }
+        }
+        ports = append(ports, port)
+    }
+    return ports
+}
+
+func (imp *k8sImport) peers(peers []k8sPeer) []config.NetworkPolicyPeer {
+    var out []config.NetworkPolicyPeer
+    for _, p := range peers {
+        peer := config.NetworkPolicyPeer{
+            PodSelector:       imp.selector(p.PodSelector),
+            NamespaceSelector: imp.selector(p.NamespaceSelector),
+        }
+        if p.NamespaceSelector != nil && len(p.NamespaceSelector.MatchLabels) == 0 {
+            imp.warn("namespaceSelector {} (all namespaces) cannot be represented; the peer was imported as the policy's own namespace")
+        }
+        if p.IPBlock != nil {
+            peer.IPBlock = &config.IPBlock{CIDR: p.IPBlock.CIDR, Except: p.IPBlock.Except}
+        }
+        out = append(out, peer)
+    }
+    return out
+}
+
+// pkg/exporter/exporter.go
+package exporter
+
+import (
+    "fmt"
+    "strings"
+    
+    "netgit/pkg/config"
+)
+
+// Exporter renders a NetworkConfig in a provider's native format
+type Exporter interface {
+    Export(cfg config.NetworkConfig) ([]byte, error)
+}
+
+// Formats lists the names GetExporter accepts
+var Formats = []string{"terraform-aws", "terraform-gcp", "terraform-azure", "k8s"}
+
+func GetExporter(format string) (Exporter, error) {
+    switch format {
+    case "terraform-aws":
+        return NewAWSTerraformExporter(), nil
+    case "terraform-gcp":
+        return NewGCPTerraformExporter(), nil
+    case "terraform-azure":
+        return NewAzureTerraformExporter(), nil
+    case "k8s", "kubernetes":
+        return NewKubernetesExporter(), nil
+    default:
+        return nil, fmt.Errorf("unknown export format: %s (expected one of %s)", format, strings.Join(Formats, ", "))
+    }
+}
+
+// transport returns the transport protocol of a rule: tcp, udp, icmp,
+// icmpv6 or all
+func transport(protocol string) (string, error) {
+    switch protocol {
+    case "", "*", "-1":
+        return "all", nil
+    }
+    if t, ok := config.TransportProtocol(protocol); ok {
+        return t, nil
+    }
+    return "", fmt.Errorf("unsupported protocol: %s", protocol)
+}
+
+// splitPorts splits "8000-8100" into its bounds; a single port is its own
+// range
+func splitPorts(ports string) (string, string) {
+    if from, to, ok := strings.Cut(ports, "-"); ok {
+        return from, to
+    }
+    return ports, ports
+}
+
+// pkg/exporter/hcl.go
+package exporter
+
+import (
+    "fmt"
+    "strings"
+)
+
+// hclBlock is a minimal HCL writer producing terraform fmt style output
+type hclBlock struct {
+    kind   string
+    labels []string
+    attrs  [][2]string
+    blocks []*hclBlock
+}
+
+func newBlock(kind string, labels ...string) *hclBlock {
+    return &hclBlock{kind: kind, labels: labels}
+}
+
+// set adds an attribute whose value is already rendered as HCL
+func (b *hclBlock) set(key, value string) {
+    b.attrs = append(b.attrs, [2]string{key, value})
+}
+
+func (b *hclBlock) str(key, value string) {
+    if value != "" {
+        b.set(key, hclString(value))
+    }
+}
+
+func (b *hclBlock) list(key string, values []string) {
+    if len(values) == 0 {
+        return
+    }
+    quoted := make([]string, len(values))
+    for i, v := range values {
+        quoted[i] = hclString(v)
+    }
+    b.set(key, "["+strings.Join(quoted, ", ")+"]")
+}
+
+func (b *hclBlock) block(kind string, labels ...string) *hclBlock {
+    child := newBlock(kind, labels...)
+    b.blocks = append(b.blocks, child)
+    return child
+}
+
+func (b *hclBlock) write(sb *strings.Builder, indent string) {
+    sb.WriteString(indent + b.kind)
+    for _, label := range b.labels {
+        sb.WriteString(" " + hclString(label))
+    }
+    sb.WriteString(" {\n")
+    
+    width := 0
+    for _, attr := range b.attrs {
+        if len(attr[0]) > width {
+            width = len(attr[0])
+        }
+    }
+    for _, attr := range b.attrs {
+        fmt.Fprintf(sb, "%s  %-*s = %s\n", indent, width, attr[0], attr[1])
+    }
+    for i, child := range b.blocks {
+        if i > 0 || len(b.attrs) > 0 {
+            sb.WriteString("\n")
+        }
+        child.write(sb, indent+"  ")
+    }
+    sb.WriteString(indent + "}\n")
+}
+
+func renderHCL(blocks []*hclBlock) []byte {
+    var sb strings.Builder
+    for i, b := range blocks {
+        if i > 0 {
+            sb.WriteString("\n")
+        }
+        b.write(&sb, "")
+    }
+    return []byte(sb.String())
+}
+
+// hclString quotes s, escaping the template sequences HCL would otherwise
+// interpolate
+func hclString(s string) string {
+    s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`, "${", "$${", "%{", "%%{").Replace(s)
+    return `"` + s + `"`
+}
+
+// resourceNames hands out unique Terraform resource names derived from
+// netgit names
+type resourceNames map[string]int
+
+func (n resourceNames) name(s string) string {
+    var sb strings.Builder
+    for _, r := range strings.ToLower(s) {
+        if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' {
+            sb.WriteRune(r)
+        } else {
+            sb.WriteRune('_')
+        }
+    }
+    id := sb.String()
+    if id == "" || id[0] >= '0' && id[0] <= '9' {
+        id = "r_" + id
+    }
+    
+    n[id]++
+    if n[id] > 1 {
+        return fmt.Sprintf("%s_%d", id, n[id])
+    }
+    return id
+}
+
+// pkg/exporter/terraform.go
+package exporter
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    
+    "netgit/pkg/config"
+)
+
+// AWSTerraformExporter renders security groups as aws_security_group
+// resources with inline rules
+type AWSTerraformExporter struct{}
+
+func NewAWSTerraformExporter() *AWSTerraformExporter {
+    return &AWSTerraformExporter{}
+}
+
+func (a *AWSTerraformExporter) Export(cfg config.NetworkConfig) ([]byte, error) {
+    names := resourceNames{}
+    var blocks []*hclBlock
+    for _, sg := range cfg.SecurityGroups {
+        resource := newBlock("resource", "aws_security_group", names.name(sg.Name))
+        resource.str("name", sg.Name)
+        resource.str("description", sg.Description)
+        resource.str("vpc_id", sg.VpcId)
+        
+        for _, rule := range sg.Rules {
+            if rule.Action != "" && rule.Action != "allow" {
+                return nil, fmt.Errorf("security group %s: AWS security groups cannot %s traffic", sg.Name, rule.Action)
+            }
+            protocol, err := transport(rule.Protocol)
+            if err != nil {
+                return nil, fmt.Errorf("security group %s: %w", sg.Name, err)
+            }
+            
+            ports := rule.Ports
+            if len(ports) == 0 || protocol == "all" || strings.HasPrefix(protocol, "icmp") {
+                ports = []string{""}
+            }
+            for _, p := range ports {
+                ingress := resource.block("ingress")
+                from, to := splitPorts(p)
+                switch {
+                case protocol == "all":
+                    ingress.str("protocol", "-1")
+                    from, to = "0", "0"
+                case strings.HasPrefix(protocol, "icmp"):
+                    ingress.str("protocol", protocol)
+                    from, to = "-1", "-1"
+                default:
+                    ingress.str("protocol", protocol)
+                    if p == "" {
+                        from, to = "0", "65535"
+                    }
+                }
+                ingress.set("from_port", from)
+                ingress.set("to_port", to)
+                
+                var cidrs, ipv6, groups, prefixLists []string
+                for _, source := range rule.Sources {
+                    switch {
+                    case strings.HasPrefix(source, "sg-"):
+                        groups = append(groups, source)
+                    case strings.HasPrefix(source, "pl-"):
+                        prefixLists = append(prefixLists, source)
+                    case strings.Contains(source, ":"):
+                        ipv6 = append(ipv6, source)
+                    default:
+                        cidrs = append(cidrs, source)
+                    }
+                }
+                ingress.list("cidr_blocks", cidrs)
+                ingress.list("ipv6_cidr_blocks", ipv6)
+                ingress.list("security_groups", groups)
+                ingress.list("prefix_list_ids", prefixLists)
+            }
+        }
+        
+        // Terraform deletes the allow-all egress rule AWS creates unless it
+        // is declared; netgit security groups only model ingress, so keep it
+        egress := resource.block("egress")
+        egress.str("protocol", "-1")
+        egress.set("from_port", "0")
+        egress.set("to_port", "0")
+        egress.list("cidr_blocks", []string{"0.0.0.0/0"})
+        
+        blocks = append(blocks, resource)
+    }
+    return renderHCL(blocks), nil
+}
+
+// GCPTerraformExporter renders firewall rules as google_compute_firewall
+// resources. The network the rules attach to is left to a variable.
+type GCPTerraformExporter struct{}
+
+func NewGCPTerraformExporter() *GCPTerraformExporter {
+    return &GCPTerraformExporter{}
+}
+
+func (g *GCPTerraformExporter) Export(cfg config.NetworkConfig) ([]byte, error) {
+    network := newBlock("variable", "network")
+    network.set("type", "string")
+    network.str("description", "VPC network the firewall rules apply to")
+    network.str("default", "default")
+    blocks := []*hclBlock{network}
+    
+    names := resourceNames{}
+    for _, rule := range cfg.FirewallRules {
+        protocol, err := transport(rule.Protocol)
+        if err != nil {
+            return nil, fmt.Errorf("firewall rule %s: %w", rule.Name, err)
+        }
+        
+        resource := newBlock("resource", "google_compute_firewall", names.name(rule.Name))
+        resource.str("name", rule.Name)
+        resource.set("network", "var.network")
+        resource.str("direction", strings.ToUpper(rule.Direction))
+        // Priority 0 is GCP's highest, not its default, so it is always set
+        resource.set("priority", strconv.Itoa(rule.Priority))
+        resource.list("source_ranges", rule.SourceRanges)
+        resource.list("destination_ranges", rule.DestinationRanges)
+        resource.list("target_tags", rule.TargetTags)
+        
+        kind := "allow"
+        if rule.Action == "deny" {
+            kind = "deny"
+        }
+        entry := resource.block(kind)
+        entry.str("protocol", protocol)
+        if protocol == "tcp" || protocol == "udp" {
+            entry.list("ports", rule.Ports)
+        }
+        
+        blocks = append(blocks, resource)
+    }
+    return renderHCL(blocks), nil
+}
+
+// AzureTerraformExporter renders firewall rules as
+// azurerm_network_security_group resources. Rules are grouped into one NSG
+// per target tag, the inverse of what the Azure importer does; a rule with
+// several target tags goes into each of their NSGs, and rules without a
+// target tag go into an NSG named after the config.
+type AzureTerraformExporter struct{}
+
+func NewAzureTerraformExporter() *AzureTerraformExporter {
+    return &AzureTerraformExporter{}
+}
+
+var azureProtocols = map[string]string{
+    "tcp":    "Tcp",
+    "udp":    "Udp",
+    "icmp":   "Icmp",
+    "icmpv6": "Icmp",
+    "all":    "*",
+}
+
+func (az *AzureTerraformExporter) Export(cfg config.NetworkConfig) ([]byte, error) {
+    location := newBlock("variable", "location")
+    location.set("type", "string")
+    resourceGroup := newBlock("variable", "resource_group_name")
+    resourceGroup.set("type", "string")
+    blocks := []*hclBlock{location, resourceGroup}
+    
+    fallback := cfg.Metadata.Name
+    if fallback == "" {
+        fallback = "netgit"
+    }
+    
+    var order []string
+    groups := map[string][]config.FirewallRule{}
+    for _, rule := range cfg.FirewallRules {
+        if rule.Priority < 100 || rule.Priority > 4096 {
+            return nil, fmt.Errorf("firewall rule %s: Azure NSG priorities must be 100-4096, got %d", rule.Name, rule.Priority)
+        }
+        
+        nsgs := rule.TargetTags
+        if len(nsgs) == 0 {
+            nsgs = []string{fallback}
+        }
+        for _, nsg := range nsgs {
+            if _, ok := groups[nsg]; !ok {
+                order = append(order, nsg)
+            }
+            groups[nsg] = append(groups[nsg], rule)
+        }
+    }
+    
+    names := resourceNames{}
+    for _, nsg := range order {
+        resource := newBlock("resource", "azurerm_network_security_group", names.name(nsg))
+        resource.str("name", nsg)
+        resource.set("location", "var.location")
+        resource.set("resource_group_name", "var.resource_group_name")
+        
+        for _, rule := range groups[nsg] {
+            protocol, err := transport(rule.Protocol)
+            if err != nil {
+                return nil, fmt.Errorf("firewall rule %s: %w", rule.Name, err)
+            }
+            
+            sr := resource.block("security_rule")
+            sr.str("name", strings.TrimPrefix(rule.Name, nsg+"-"))
+            sr.set("priority", strconv.Itoa(rule.Priority))
+            
+            sources, destinations := rule.SourceRanges, rule.DestinationRanges
+            if strings.ToUpper(rule.Direction) == "EGRESS" {
+                sr.str("direction", "Outbound")
+            } else {
+                sr.str("direction", "Inbound")
+            }
+            if rule.Action == "deny" {
+                sr.str("access", "Deny")
+            } else {
+                sr.str("access", "Allow")
+            }
+            sr.str("protocol", azureProtocols[protocol])
+            sr.str("source_port_range", "*")
+            azureMulti(sr, "destination_port_range", rule.Ports)
+            azureMulti(sr, "source_address_prefix", azureAddresses(sources))
+            azureMulti(sr, "destination_address_prefix", azureAddresses(destinations))
+        }
+        
+        blocks = append(blocks, resource)
+    }
+    return renderHCL(blocks), nil
+}
+
+// azureMulti sets the singular form of an azurerm attribute for zero or one
+// value and the plural form for several
+func azureMulti(b *hclBlock, key string, values []string) {
+    switch len(values) {
+    case 0:
+        b.str(key, "*")
+    case 1:
+        b.str(key, values[0])
+    default:
+        plural := key + "s"
+        if strings.HasSuffix(key, "prefix") {
+            plural = key + "es"
+        }
+        b.list(plural, values)
+    }
+}
+
+func azureAddresses(ranges []string) []string {
+    var out []string
+    for _, r := range ranges {
+        if r == "0.0.0.0/0" {
+            return nil
+        }
+        out = append(out, r)
+    }
+    return out
+}
+
+// pkg/exporter/kubernetes.go
+package exporter
+
+import (
+    "bytes"
+    "strconv"
+    "strings"
+    
+    "gopkg.in/yaml.v3"
+    "netgit/pkg/config"
+)
+
+// KubernetesExporter renders network policies as networking.k8s.io/v1
+// NetworkPolicy manifests, one YAML document per policy
+type KubernetesExporter struct{}
+
+func NewKubernetesExporter() *KubernetesExporter {
+    return &KubernetesExporter{}
+}
+
+type k8sNetworkPolicy struct {
+    APIVersion string `yaml:"apiVersion"`
+    Kind       string `yaml:"kind"`
+    Metadata   struct {
+        Name      string `yaml:"name"`
+        Namespace string `yaml:"namespace,omitempty"`
+    } `yaml:"metadata"`
+    Spec struct {
+        PodSelector k8sSelector `yaml:"podSelector"`
+        PolicyTypes []string    `yaml:"policyTypes"`
+        Ingress     []k8sRule   `yaml:"ingress,omitempty"`
+        Egress      []k8sRule   `yaml:"egress,omitempty"`
+    } `yaml:"spec"`
+}
+
+type k8sSelector struct {
+    MatchLabels map[string]string `yaml:"matchLabels,omitempty"`
+}
+
+type k8sRule struct {
+    From  []k8sPeer `yaml:"from,omitempty"`
+    To    []k8sPeer `yaml:"to,omitempty"`
+    Ports []k8sPort `yaml:"ports,omitempty"`
+}
+
+type k8sPeer struct {
+    PodSelector       *k8sSelector `yaml:"podSelector,omitempty"`
+    NamespaceSelector *k8sSelector `yaml:"namespaceSelector,omitempty"`
+    IPBlock           *k8sIPBlock  `yaml:"ipBlock,omitempty"`
+}
+
+type k8sIPBlock struct {
+    CIDR   string   `yaml:"cidr"`
+    Except []string `yaml:"except,omitempty"`
+}
+
+type k8sPort struct {
+    Protocol string      `yaml:"protocol,omitempty"`
+    Port     interface{} `yaml:"port,omitempty"`
+    EndPort  int         `yaml:"endPort,omitempty"`
+}
+
+func (k *KubernetesExporter) Export(cfg config.NetworkConfig) ([]byte, error) {
+    var buf bytes.Buffer
+    for i, np := range cfg.NetworkPolicies {
+        var policy k8sNetworkPolicy
+        policy.APIVersion = "networking.k8s.io/v1"
+        policy.Kind = "NetworkPolicy"
+        policy.Metadata.Name = np.Name
+        policy.Metadata.Namespace = np.Namespace
+        policy.Spec.PodSelector.MatchLabels = np.Selector
+        
+        policy.Spec.PolicyTypes = np.Types()
+        for _, rule := range np.Ingress {
+            policy.Spec.Ingress = append(policy.Spec.Ingress, k8sRule{From: k8sPeers(rule.From), Ports: k8sPorts(rule.Ports)})
+        }
+        for _, rule := range np.Egress {
+            policy.Spec.Egress = append(policy.Spec.Egress, k8sRule{To: k8sPeers(rule.To), Ports: k8sPorts(rule.Ports)})
+        }
+        
+        if i > 0 {
+            buf.WriteString("---\n")
+        }
+        enc := yaml.NewEncoder(&buf)
+        enc.SetIndent(2)
+        if err := enc.Encode(policy); err != nil {
+            return nil, err
+        }
+        enc.Close()
+    }
+    return buf.Bytes(), nil
+}
+
+func k8sPeers(peers []config.NetworkPolicyPeer) []k8sPeer {
+    var out []k8sPeer
+    for _, p := range peers {
+        var peer k8sPeer
+        if len(p.PodSelector) > 0 {
+            peer.PodSelector = &k8sSelector{MatchLabels: p.PodSelector}
+        }
+        if len(p.NamespaceSelector) > 0 {
+            peer.NamespaceSelector = &k8sSelector{MatchLabels: p.NamespaceSelector}
+        }
+        if p.IPBlock != nil {
+            peer.IPBlock = &k8sIPBlock{CIDR: p.IPBlock.CIDR, Except: p.IPBlock.Except}
+        }
+        if peer.PodSelector == nil && peer.NamespaceSelector == nil && peer.IPBlock == nil {
+            // A peer without selectors selects every pod in the namespace
+            peer.PodSelector = &k8sSelector{}
+        }
+        out = append(out, peer)
+    }
+    return out
+}
+
+func k8sPorts(ports []config.NetworkPolicyPort) []k8sPort {
+    var out []k8sPort
+    for _, p := range ports {
+        port := k8sPort{Protocol: strings.ToUpper(p.Protocol)}
+        from, to := splitPorts(p.Port)
+        if n, err := strconv.Atoi(from); err == nil {
+            port.Port = n
+            if end, err := strconv.Atoi(to); err == nil && end != n {
+                port.EndPort = end
+            }
+        } else if p.Port != "" {
+            // Named container port
+            port.Port = p.Port
+        }
+        out = append(out, port)
+    }
+    return out
+}
+
+// pkg/policy/engine.go
+package policy
+
+import (
+    "context"
+    "embed"
+    "encoding/json"
+    "fmt"
+    "io/fs"
+    "io/ioutil"
+    "path/filepath"
+    "sort"
+    "strings"
+    
+    "github.com/open-policy-agent/opa/ast"
+    "github.com/open-policy-agent/opa/rego"
+    "netgit/pkg/config"
+    "netgit/pkg/metrics"
+)
+
+// builtinRules are the rules a Policy may name, one Rego package per rule
+// under netgit.rules, with the helpers they share in netgit.lib
+//
+//go:embed rules/*.rego
+var builtinRules embed.FS
+
+const (
+    builtinPackage = "data.netgit.rules."
+    customPackage  = "data.netgit.policies."
+)
+
+type Engine struct {
+    policies []Policy
+    builtins map[string]rego.PreparedEvalQuery
+    custom   []customPolicy
+}
+
+// Policy either names a built-in Rule or declares one with Match and
+// Assert. Message replaces the messages of a declarative policy's
+// violations; {name}, {protocol}, {ports}, {sources} and {value}, the
+// offending value, are filled in from the rule.
+type Policy struct {
+    Name        string `yaml:"name" json:"name"`
+    Description string `yaml:"description" json:"description"`
+    Rule        string `yaml:"rule" json:"rule"`
+    Severity    string `yaml:"severity" json:"severity"`
+    
+    Match   *Match  `yaml:"match,omitempty" json:"match,omitempty"`
+    Assert  *Assert `yaml:"assert,omitempty" json:"assert,omitempty"`
+    Message string  `yaml:"message,omitempty" json:"message,omitempty"`
+    
+    // Module is the Rego package of a policy written in Rego
+    Module string `yaml:"-" json:"module,omitempty"`
+    
+    // Source is the file the policy was loaded from, or "built-in", and
+    // Bundle the name@version of the bundle that shipped it
+    Source string `yaml:"-" json:"source"`
+    Bundle string `yaml:"-" json:"bundle,omitempty"`
+}
+
+// Kind is how the policy is evaluated: rule, match or rego
+func (p Policy) Kind() string {
+    switch {
+    case p.Module != "":
+        return "rego"
+    case p.Match != nil:
+        return "match"
+    }
+    return "rule"
+}
+
+type Violation struct {
+    Rule        string `json:"rule"`
+    Message     string `json:"message"`
+    File        string `json:"file"`
+    Path        string `json:"path"`
+    Level       string `json:"level"`
+    // Line and Column locate Path in File, when it can be found there
+    Line   int `json:"line,omitempty"`
+    Column int `json:"column,omitempty"`
+    // Suggestion is a rewrite of the config that resolves the violation
+    Suggestion string `json:"suggestion,omitempty"`
+    // Enforcement is the level, block, warn or audit, the violation is
+    // enforced at once an Enforcement has been applied
+    Enforcement string `json:"enforcement,omitempty"`
+}
+
+// customPolicy is a Rego package from the policy directory
+type customPolicy struct {
+    policy Policy
+    query  rego.PreparedEvalQuery
+}
+
+// NewEngine loads the policies in policyDir: policy files, see
+// LoadPolicyFiles, and Rego modules whose packages under netgit.policies
+// are each evaluated as a policy of their own. Files ending in _test.rego
+// hold tests for RunTests and are not evaluated. The default policies
+// apply when policyDir has no policy files.
+func NewEngine(policyDir string) (*Engine, error) {
+    engine := &Engine{}
+    
+    policies, err := LoadPolicyFiles(policyDir)
+    if err != nil {
+        return nil, err
+    }
+    engine.policies = policies
+    if len(engine.policies) == 0 {
+        engine.policies = defaultPolicies()
+    }
+    
+    modules, err := loadModules(policyDir, false)
+    if err != nil {
+        return nil, err
+    }
+    compiler := ast.NewCompiler()
+    if compiler.Compile(modules); compiler.Failed() {
+        return nil, compiler.Errors
+    }
+    
+    engine.builtins = map[string]rego.PreparedEvalQuery{}
+    for _, policy := range engine.policies {
+        if policy.Match != nil {
+            if err := checkDeclarative(policy); err != nil {
+                return nil, err
+            }
+            continue
+        }
+        if _, ok := engine.builtins[policy.Rule]; ok {
+            continue
+        }
+        if !hasPackage(compiler, builtinPackage+policy.Rule) {
+            return nil, fmt.Errorf("policy %s: unknown rule %q", policy.Name, policy.Rule)
+        }
+        query, err := prepare(compiler, builtinPackage+policy.Rule)
+        if err != nil {
+            return nil, err
+        }
+        engine.builtins[policy.Rule] = query
+    }
+    
+    for _, pkg := range customPackages(compiler) {
+        query, err := prepare(compiler, pkg.path)
+        if err != nil {
+            return nil, err
+        }
+        engine.custom = append(engine.custom, customPolicy{
+            policy: Policy{
+                Name:     strings.TrimPrefix(pkg.path, customPackage),
+                Severity: "medium",
+                Module:   strings.TrimPrefix(pkg.path, "data."),
+                Source:   pkg.file,
+            },
+            query: query,
+        })
+    }
+    
+    return engine, nil
+}
+
+// Policies lists the policies the engine evaluates, Rego ones last
+func (e *Engine) Policies() []Policy {
+    policies := append([]Policy{}, e.policies...)
+    for _, custom := range e.custom {
+        policies = append(policies, custom.policy)
+    }
+    return policies
+}
+
+func defaultPolicies() []Policy {
+    return []Policy{
+        {
+            Name:        "no-public-db",
+            Description: "Database must never be publicly accessible",
+            Rule:        "deny_public_database",
+            Severity:    "high",
+            Source:      "built-in",
+        },
+        {
+            Name:        "require-redundant-routes",
+            Description: "Always require at least 2 redundant routes",
+            Rule:        "require_redundancy",
+            Severity:    "medium",
+            Source:      "built-in",
+        },
+        {
+            Name:        "secure-protocols",
+            Description: "Only allow secure protocols (HTTPS, SSH)",
+            Rule:        "secure_protocols_only",
+            Severity:    "high",
+            Source:      "built-in",
+        },
+    }
+}
+
+// loadModules parses the bundled rules and the Rego files in policyDir,
+// with or without their tests
+func loadModules(policyDir string, tests bool) (map[string]*ast.Module, error) {
+    modules := map[string]*ast.Module{}
+    add := func(name string, data []byte) error {
+        if !tests && strings.HasSuffix(name, "_test.rego") {
+            return nil
+        }
+        module, err := ast.ParseModule(name, string(data))
+        if err != nil {
+            return err
+        }
+        modules[name] = module
+        return nil
+    }
+    
+    err := fs.WalkDir(builtinRules, "rules", func(name string, entry fs.DirEntry, err error) error {
+        if err != nil || entry.IsDir() {
+            return err
+        }
+        data, err := builtinRules.ReadFile(name)
+        if err != nil {
+            return err
+        }
+        return add("builtin/"+name, data)
+    })
+    if err != nil {
+        return nil, err
+    }
+    
+    files, err := filepath.Glob(filepath.Join(policyDir, "*.rego"))
+    if err != nil {
+        return nil, err
+    }
+    for _, file := range files {
+        data, err := ioutil.ReadFile(file)
+        if err != nil {
+            return nil, err
+        }
+        if err := add(file, data); err != nil {
+            return nil, err
+        }
+    }
+    return modules, nil
+}
+
+func hasPackage(compiler *ast.Compiler, pkg string) bool {
+    for _, module := range compiler.Modules {
+        if module.Package.Path.String() == pkg {
+            return true
+        }
+    }
+    return false
+}
+
+type regoPackage struct {
+    path string
+    file string
+}
+
+// customPackages lists the packages under netgit.policies, sorted, with
+// the first file that declares each
+func customPackages(compiler *ast.Compiler) []regoPackage {
+    files := map[string]string{}
+    for name, module := range compiler.Modules {
+        pkg := module.Package.Path.String()
+        if !strings.HasPrefix(pkg, customPackage) {
+            continue
+        }
+        if file, ok := files[pkg]; !ok || name < file {
+            files[pkg] = name
+        }
+    }
+    
+    var packages []regoPackage
+    for pkg, file := range files {
+        packages = append(packages, regoPackage{path: pkg, file: file})
+    }
+    sort.Slice(packages, func(i, j int) bool { return packages[i].path < packages[j].path })
+    return packages
+}
+
+func prepare(compiler *ast.Compiler, pkg string) (rego.PreparedEvalQuery, error) {
+    return rego.New(
+        rego.Query(pkg+".violation"),
+        rego.Compiler(compiler),
+    ).PrepareForEval(context.Background())
+}
+
+// Verify checks the canonical form of a config, so policies need not care
+// how protocols are spelt or CIDRs split. Violation paths are mapped back to
+// the config as written and located in its file. Each violation found
+// counts towards metrics.PolicyViolationsTotal.
+func (e *Engine) Verify(config config.NetworkConfig) ([]Violation, error) {
+    var violations []Violation
+    
+    canonical := config.Canonical()
+    input, err := regoInput(canonical)
+    if err != nil {
+        return nil, err
+    }
+    
+    for _, policy := range e.policies {
+        if policy.Match != nil {
+            violations = append(violations, evaluateDeclarative(canonical, policy)...)
+            continue
+        }
+        found, err := evaluate(e.builtins[policy.Rule], input)
+        if err != nil {
+            return nil, fmt.Errorf("policy %s: %w", policy.Name, err)
+        }
+        for _, v := range found {
+            violations = append(violations, Violation{
+                Rule:    policy.Name,
+                Message: v.Message,
+                File:    sourceFile(canonical),
+                Path:    v.Path,
+                Level:   policy.Severity,
+            })
+        }
+    }
+    
+    for _, custom := range e.custom {
+        policy := custom.policy
+        found, err := evaluate(custom.query, input)
+        if err != nil {
+            return nil, fmt.Errorf("policy %s: %w", policy.Name, err)
+        }
+        for _, v := range found {
+            violation := Violation{
+                Rule:    v.Rule,
+                Message: v.Message,
+                File:    sourceFile(canonical),
+                Path:    v.Path,
+                Level:   v.Severity,
+            }
+            if violation.Rule == "" {
+                violation.Rule = policy.Name
+            }
+            if violation.Level == "" {
+                violation.Level = policy.Severity
+            }
+            violations = append(violations, violation)
+        }
+    }
+    
+    for i := range violations {
+        violations[i].Path = config.OriginalPath(violations[i].Path)
+        locate(config, &violations[i])
+        metrics.PolicyViolationsTotal.WithLabelValues(violations[i].Rule, violations[i].Level).Inc()
+    }
+    return violations, nil
+}
+
+// regoInput is the config as policies see it: its JSON form
+func regoInput(cfg config.NetworkConfig) (interface{}, error) {
+    data, err := json.Marshal(cfg)
+    if err != nil {
+        return nil, err
+    }
+    var input interface{}
+    err = json.Unmarshal(data, &input)
+    return input, err
+}
+
+// regoViolation is an element of a policy's violation set. A plain string
+// is taken as the message.
+type regoViolation struct {
+    Message  string `json:"message"`
+    Path     string `json:"path"`
+    Rule     string `json:"rule"`
+    Severity string `json:"severity"`
+}
+
+// evaluate returns the violations query finds in input, ordered by path
+func evaluate(query rego.PreparedEvalQuery, input interface{}) ([]regoViolation, error) {
+    results, err := query.Eval(context.Background(), rego.EvalInput(input))
+    if err != nil {
+        return nil, err
+    }
+    
+    var violations []regoViolation
+    for _, result := range results {
+        for _, expr := range result.Expressions {
+            set, ok := expr.Value.([]interface{})
+            if !ok {
+                return nil, fmt.Errorf("violation must be a set, got %T", expr.Value)
+            }
+            for _, item := range set {
+                if message, ok := item.(string); ok {
+                    violations = append(violations, regoViolation{Message: message})
+                    continue
+                }
+                data, err := json.Marshal(item)
+                if err != nil {
+                    return nil, err
+                }
+                var v regoViolation
+                if err := json.Unmarshal(data, &v); err != nil {
+                    return nil, fmt.Errorf("violation must be a string or an object: %w", err)
+                }
+                violations = append(violations, v)
+            }
+        }
+    }
+    
+    sort.SliceStable(violations, func(i, j int) bool {
+        if violations[i].Path != violations[j].Path {
+            return violations[i].Path < violations[j].Path
+        }
+        return violations[i].Message < violations[j].Message
+    })
+    return violations, nil
+}
+
+// sourceFile names the file a violation is reported against
+func sourceFile(cfg config.NetworkConfig) string {
+    if cfg.Source != "" {
+        return cfg.Source
+    }
+    return cfg.Metadata.Name
+}
+
+// pkg/policy/loader.go
+package policy
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "path/filepath"
+    
+    "gopkg.in/yaml.v3"
+)
+
+// Bundle is a versioned set of policies published together, such as a
+// security team's baseline
+type Bundle struct {
+    Name        string            `yaml:"name" json:"name"`
+    Version     string            `yaml:"version" json:"version"`
+    Description string            `yaml:"description" json:"description"`
+    Metadata    map[string]string `yaml:"metadata" json:"metadata"`
+    Policies    []Policy          `yaml:"policies" json:"policies"`
+}
+
+// LoadPolicyFiles reads the JSON and YAML policy files in policyDir, apart
+// from the ExceptionsFile. A file holds a policy, a list of policies or a
+// bundle; YAML files may hold several of these as --- separated documents.
+// Policies record the file and bundle they came from, and a policy name
+// may only be used once.
+func LoadPolicyFiles(policyDir string) ([]Policy, error) {
+    var files []string
+    for _, pattern := range []string{"*.json", "*.yaml", "*.yml"} {
+        matches, err := filepath.Glob(filepath.Join(policyDir, pattern))
+        if err != nil {
+            return nil, err
+        }
+        for _, match := range matches {
+            if filepath.Base(match) != ExceptionsFile {
+                files = append(files, match)
+            }
+        }
+    }
+    
+    var policies []Policy
+    defined := map[string]string{}
+    for _, file := range files {
+        loaded, err := loadPolicyFile(file)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %v", file, err)
+        }
+        for _, policy := range loaded {
+            if other, ok := defined[policy.Name]; ok {
+                return nil, fmt.Errorf("%s: policy %s is already defined in %s", file, policy.Name, other)
+            }
+            defined[policy.Name] = file
+            policies = append(policies, policy)
+        }
+    }
+    return policies, nil
+}
+
+func loadPolicyFile(filename string) ([]Policy, error) {
+    data, err := ioutil.ReadFile(filename)
+    if err != nil {
+        return nil, err
+    }
+    
+    // JSON is YAML, so one decoder reads both
+    var policies []Policy
+    decoder := yaml.NewDecoder(bytes.NewReader(data))
+    for {
+        var doc yaml.Node
+        if err := decoder.Decode(&doc); errors.Is(err, io.EOF) {
+            break
+        } else if err != nil {
+            return nil, err
+        }
+        loaded, err := decodePolicies(&doc)
+        if err != nil {
+            return nil, err
+        }
+        for i := range loaded {
+            loaded[i].Source = filename
+        }
+        policies = append(policies, loaded...)
+    }
+    return policies, nil
+}
+
+// decodePolicies reads a document holding a policy, a list of policies or
+// a bundle
+func decodePolicies(doc *yaml.Node) ([]Policy, error) {
+    node := doc
+    if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+        node = node.Content[0]
+    }
+    
+    switch {
+    case node.Kind == yaml.SequenceNode:
+        var policies []Policy
+        if err := node.Decode(&policies); err != nil {
+            return nil, err
+        }
+        return policies, checkPolicies(policies)
+    
+    case node.Kind == yaml.MappingNode && hasKey(node, "policies"):
+        var bundle Bundle
+        if err := node.Decode(&bundle); err != nil {
+            return nil, err
+        }
+        if bundle.Name == "" || bundle.Version == "" {
+            return nil, fmt.Errorf("line %d: a policy bundle needs a name and a version", node.Line)
+        }
+        for i := range bundle.Policies {
+            bundle.Policies[i].Bundle = bundle.Name + "@" + bundle.Version
+        }
+        return bundle.Policies, checkPolicies(bundle.Policies)
+    
+    case node.Kind == yaml.MappingNode:
+        var policy Policy
+        if err := node.Decode(&policy); err != nil {
+            return nil, err
+        }
+        return []Policy{policy}, checkPolicies([]Policy{policy})
+    }
+    return nil, fmt.Errorf("line %d: expected a policy, a list of policies or a policy bundle", node.Line)
+}
+
+func hasKey(mapping *yaml.Node, key string) bool {
+    for i := 0; i+1 < len(mapping.Content); i += 2 {
+        if mapping.Content[i].Value == key {
+            return true
+        }
+    }
+    return false
+}
+
+// checkPolicies catches policies that could never be evaluated
+func checkPolicies(policies []Policy) error {
+    for i, policy := range policies {
+        if policy.Name == "" {
+            return fmt.Errorf("policy %d has no name", i+1)
+        }
+        if policy.Rule == "" && policy.Match == nil {
+            return fmt.Errorf("policy %s names no rule and has no match", policy.Name)
+        }
+    }
+    return nil
+}
+
+// pkg/policy/builtins.go
+package policy
+
+import (
+    "github.com/open-policy-agent/opa/ast"
+    "github.com/open-policy-agent/opa/rego"
+    "github.com/open-policy-agent/opa/types"
+    "netgit/pkg/config"
+    "netgit/pkg/reach"
+)
+
+// netgit.reachable(config, from, to, flow) asks netgit query reachable's
+// question of a config, usually input: from and to are endpoints and flow
+// is a protocol and port such as "tcp/3306". It returns an object with
+// allowed, chain and reason.
+func init() {
+    rego.RegisterBuiltin4(&rego.Function{
+        Name: "netgit.reachable",
+        Decl: types.NewFunction(types.Args(types.A, types.S, types.S, types.S), types.A),
+    }, reachable)
+}
+
+func reachable(_ rego.BuiltinContext, cfgTerm, fromTerm, toTerm, flowTerm *ast.Term) (*ast.Term, error) {
+    var cfg config.NetworkConfig
+    if err := ast.As(cfgTerm.Value, &cfg); err != nil {
+        return nil, err
+    }
+    var from, to, flow string
+    for term, s := range map[*ast.Term]*string{fromTerm: &from, toTerm: &to, flowTerm: &flow} {
+        if err := ast.As(term.Value, s); err != nil {
+            return nil, err
+        }
+    }
+    
+    model := reach.New(cfg)
+    source, err := model.Endpoint(from)
+    if err != nil {
+        return nil, err
+    }
+    destination, err := model.Endpoint(to)
+    if err != nil {
+        return nil, err
+    }
+    protocol, port, err := reach.ParseFlow(flow)
+    if err != nil {
+        return nil, err
+    }
+    
+    value, err := ast.InterfaceToValue(model.Reachable(source, destination, protocol, port))
+    if err != nil {
+        return nil, err
+    }
+    return ast.NewTerm(value), nil
+}
+
+// pkg/policy/declarative.go
+package policy
+
+import (
+    "fmt"
+    "net/netip"
+    "path"
+    "strings"
+    
+    "netgit/pkg/config"
+)
+
+// Resources a declarative policy can match
+const (
+    ResourceSecurityGroupRule = "securityGroupRule"
+    ResourceFirewallRule      = "firewallRule"
+)
+
+// Match selects the rules a declarative policy applies to. Every field
+// that is set must match; ports and sources match when they intersect the
+// rule's. A rule for an application protocol such as ssh uses its own
+// port when it lists none, and icmp rules have no ports to match.
+type Match struct {
+    // Resource is securityGroupRule, the default, or firewallRule
+    Resource string `yaml:"resource" json:"resource"`
+    
+    // Names are glob patterns for the security group or firewall rule name
+    Names []string `yaml:"names" json:"names"`
+    
+    // Labels must all be set on the config's metadata
+    Labels map[string]string `yaml:"labels" json:"labels"`
+    
+    Protocols []string `yaml:"protocols" json:"protocols"`
+    Ports     []string `yaml:"ports" json:"ports"`
+    Sources   []string `yaml:"sources" json:"sources"`
+    Action    string   `yaml:"action" json:"action"`
+    Direction string   `yaml:"direction" json:"direction"`
+}
+
+// Assert is what must hold for every rule a declarative policy matches
+type Assert struct {
+    Protocols *SetAssertion `yaml:"protocols" json:"protocols"`
+    Ports     *SetAssertion `yaml:"ports" json:"ports"`
+    Sources   *SetAssertion `yaml:"sources" json:"sources"`
+    Action    string        `yaml:"action" json:"action"`
+}
+
+// SetAssertion constrains a rule's protocols, ports or sources to lie
+// within SubsetOf and outside DisjointFrom. Ports and CIDRs are compared
+// as the ranges they cover.
+type SetAssertion struct {
+    SubsetOf     []string `yaml:"subsetOf" json:"subsetOf"`
+    DisjointFrom []string `yaml:"disjointFrom" json:"disjointFrom"`
+}
+
+// matchedRule is a security group or firewall rule seen the same way
+type matchedRule struct {
+    kind      string
+    name      string
+    path      string
+    protocol  string
+    ports     []string
+    sources   []string
+    sourceKey string
+    action    string
+    direction string
+}
+
+func (m matchedRule) describe() string {
+    ports := "all ports"
+    if len(m.ports) > 0 {
+        ports = strings.Join(m.ports, ",")
+    }
+    return fmt.Sprintf("%s '%s' (%s %s)", m.kind, m.name, m.protocol, ports)
+}
+
+// checkDeclarative reports a policy whose Match is set as invalid
+func checkDeclarative(policy Policy) error {
+    if policy.Rule != "" {
+        return fmt.Errorf("policy %s: a policy has either a rule or match and assert, not both", policy.Name)
+    }
+    if policy.Assert == nil {
+        return fmt.Errorf("policy %s: match has no assert", policy.Name)
+    }
+    
+    switch policy.Match.Resource {
+    case "", ResourceSecurityGroupRule, ResourceFirewallRule:
+    default:
+        return fmt.Errorf("policy %s: unknown resource %q", policy.Name, policy.Match.Resource)
+    }
+    for _, pattern := range policy.Match.Names {
+        if _, err := path.Match(pattern, ""); err != nil {
+            return fmt.Errorf("policy %s: invalid name pattern %q", policy.Name, pattern)
+        }
+    }
+    
+    ports := append(append([]string{}, policy.Match.Ports...), assertionValues(policy.Assert.Ports)...)
+    for _, p := range ports {
+        if _, _, err := config.ParsePortRange(p); err != nil {
+            return fmt.Errorf("policy %s: %v", policy.Name, err)
+        }
+    }
+    sources := append(append([]string{}, policy.Match.Sources...), assertionValues(policy.Assert.Sources)...)
+    for _, s := range sources {
+        if _, err := netip.ParsePrefix(s); err != nil && !strings.HasPrefix(s, "sg-") && !strings.HasPrefix(s, "pl-") {
+            return fmt.Errorf("policy %s: invalid CIDR %q", policy.Name, s)
+        }
+    }
+    return nil
+}
+
+func assertionValues(a *SetAssertion) []string {
+    if a == nil {
+        return nil
+    }
+    return append(append([]string{}, a.SubsetOf...), a.DisjointFrom...)
+}
+
+// evaluateDeclarative checks every rule policy.Match selects in cfg
+// against policy.Assert
+func evaluateDeclarative(cfg config.NetworkConfig, policy Policy) []Violation {
+    match := policy.Match
+    for key, value := range match.Labels {
+        if cfg.Metadata.Labels[key] != value {
+            return nil
+        }
+    }
+    
+    var violations []Violation
+    for _, rule := range selectRules(cfg, match.Resource) {
+        if !matches(match, rule) {
+            continue
+        }
+        for _, failure := range assertRule(policy.Assert, rule) {
+            message := failure.message
+            if policy.Message != "" {
+                message = strings.NewReplacer(
+                    "{name}", rule.name,
+                    "{protocol}", rule.protocol,
+                    "{ports}", strings.Join(rule.ports, ","),
+                    "{sources}", strings.Join(rule.sources, ","),
+                    "{value}", failure.value,
+                ).Replace(policy.Message)
+            }
+            violations = append(violations, Violation{
+                Rule:    policy.Name,
+                Message: message,
+                File:    sourceFile(cfg),
+                Path:    failure.path,
+                Level:   policy.Severity,
+            })
+        }
+    }
+    return violations
+}
+
+func selectRules(cfg config.NetworkConfig, resource string) []matchedRule {
+    var rules []matchedRule
+    if resource == ResourceFirewallRule {
+        for i, fw := range cfg.FirewallRules {
+            rules = append(rules, matchedRule{
+                kind:      "Firewall rule",
+                name:      fw.Name,
+                path:      fmt.Sprintf("firewallRules[%d]", i),
+                protocol:  fw.Protocol,
+                ports:     fw.Ports,
+                sources:   fw.SourceRanges,
+                sourceKey: "sourceRanges",
+                action:    fw.Action,
+                direction: fw.Direction,
+            })
+        }
+        return rules
+    }
+    
+    for i, sg := range cfg.SecurityGroups {
+        for j, rule := range sg.Rules {
+            rules = append(rules, matchedRule{
+                kind:      "Security group",
+                name:      sg.Name,
+                path:      fmt.Sprintf("securityGroups[%d].rules[%d]", i, j),
+                protocol:  rule.Protocol,
+                ports:     rule.Ports,
+                sources:   rule.Sources,
+                sourceKey: "sources",
+                action:    rule.Action,
+                direction: "INGRESS",
+            })
+        }
+    }
+    return rules
+}
+
+func matches(match *Match, rule matchedRule) bool {
+    if len(match.Names) > 0 {
+        named := false
+        for _, pattern := range match.Names {
+            if ok, _ := path.Match(pattern, rule.name); ok {
+                named = true
+            }
+        }
+        if !named {
+            return false
+        }
+    }
+    if len(match.Protocols) > 0 && !protocolIn(rule.protocol, match.Protocols) {
+        return false
+    }
+    if len(match.Ports) > 0 {
+        ports, ok := rulePorts(rule)
+        if !ok || !config.PortsOverlap(ports, match.Ports) {
+            return false
+        }
+    }
+    if len(match.Sources) > 0 && !config.CIDRsOverlap(rule.sources, match.Sources) {
+        return false
+    }
+    if match.Action != "" && actionOf(rule) != match.Action {
+        return false
+    }
+    if match.Direction != "" && !strings.EqualFold(rule.direction, match.Direction) {
+        return false
+    }
+    return true
+}
+
+// rulePorts are the ports a rule's traffic uses: those it lists, or an
+// application protocol's own port when it lists none. Rules for icmp and
+// other protocols without ports have none.
+func rulePorts(rule matchedRule) ([]string, bool) {
+    transport, ok := config.TransportProtocol(rule.protocol)
+    if !ok {
+        return rule.ports, true
+    }
+    if transport != "tcp" && transport != "udp" && transport != "all" {
+        return nil, false
+    }
+    if len(rule.ports) == 0 {
+        if port, ok := config.ApplicationPort(rule.protocol); ok {
+            return []string{fmt.Sprint(port)}, true
+        }
+    }
+    return rule.ports, true
+}
+
+// protocolIn reports whether protocol is one of protocols, or carries the
+// transport one of them names: ssh is tcp, and all is every protocol
+func protocolIn(protocol string, protocols []string) bool {
+    transport, _ := config.TransportProtocol(protocol)
+    for _, p := range protocols {
+        p = strings.ToLower(p)
+        if p == protocol || p == transport || protocol == "all" || p == "all" {
+            return true
+        }
+    }
+    return false
+}
+
+// actionOf is a rule's action, allow when unset
+func actionOf(rule matchedRule) string {
+    if rule.action == "" {
+        return "allow"
+    }
+    return rule.action
+}
+
+type assertFailure struct {
+    path    string
+    value   string
+    message string
+}
+
+func assertRule(assert *Assert, rule matchedRule) []assertFailure {
+    var failures []assertFailure
+    
+    if assert.Action != "" && actionOf(rule) != assert.Action {
+        failures = append(failures, assertFailure{
+            path:    rule.path + ".action",
+            value:   actionOf(rule),
+            message: fmt.Sprintf("%s must %s", rule.describe(), assert.Action),
+        })
+    }
+    
+    if a := assert.Protocols; a != nil {
+        if len(a.SubsetOf) > 0 && !protocolIn(rule.protocol, a.SubsetOf) || len(a.DisjointFrom) > 0 && protocolIn(rule.protocol, a.DisjointFrom) {
+            failures = append(failures, assertFailure{
+                path:    rule.path + ".protocol",
+                value:   rule.protocol,
+                message: fmt.Sprintf("%s uses protocol %s, which the policy does not allow", rule.describe(), rule.protocol),
+            })
+        }
+    }
+    
+    if a := assert.Ports; a != nil {
+        ports := rule.ports
+        if len(ports) == 0 {
+            // every port, reported against the rule's ports field
+            ports = []string{"0-65535"}
+        }
+        for k, p := range ports {
+            within := config.PortsWithin([]string{p}, a.SubsetOf)
+            clear := len(a.DisjointFrom) == 0 || !config.PortsOverlap([]string{p}, a.DisjointFrom)
+            if within && clear {
+                continue
+            }
+            path := fmt.Sprintf("%s.ports[%d]", rule.path, k)
+            if len(rule.ports) == 0 {
+                path = rule.path + ".ports"
+            }
+            failures = append(failures, assertFailure{
+                path:    path,
+                value:   p,
+                message: fmt.Sprintf("%s allows port %s, which the policy does not allow", rule.describe(), p),
+            })
+        }
+    }
+    
+    if a := assert.Sources; a != nil {
+        for k, source := range rule.sources {
+            within := len(a.SubsetOf) == 0 || config.CIDRWithin(source, a.SubsetOf)
+            clear := len(a.DisjointFrom) == 0 || !config.CIDRsOverlap([]string{source}, a.DisjointFrom)
+            if within && clear {
+                continue
+            }
+            failures = append(failures, assertFailure{
+                path:    fmt.Sprintf("%s.%s[%d]", rule.path, rule.sourceKey, k),
+                value:   source,
+                message: fmt.Sprintf("%s allows source %s, which the policy does not allow", rule.describe(), source),
+            })
+        }
+    }
+    
+    return failures
+}
+
+// pkg/policy/lint.go
+package policy
+
+import (
+    "fmt"
+    "strings"
+    
+    "netgit/pkg/config"
+)
+
+// Lint findings, reported as violations of these rules
+const (
+    LintShadowed      = "shadowed-rule"
+    LintRedundant     = "redundant-rule"
+    LintOverlyBroad   = "overly-broad-rule"
+    LintContradictory = "contradictory-rules"
+)
+
+// Lint finds rules in a config that never take effect or say more than
+// they should: rules a higher-priority rule shadows, rules another rule
+// already covers, allow rules open to the internet on every port, and
+// allow and deny rules that fight over the same traffic. Each finding's
+// Suggestion is the smallest rewrite that resolves it. Paths point into
+// the config as given.
+func Lint(cfg config.NetworkConfig) []Violation {
+    l := &linter{file: sourceFile(cfg)}
+    for i, sg := range cfg.SecurityGroups {
+        l.securityGroup(i, sg)
+    }
+    l.firewall(cfg.FirewallRules)
+    for i := range l.violations {
+        locate(cfg, &l.violations[i])
+    }
+    return l.violations
+}
+
+type linter struct {
+    file       string
+    violations []Violation
+}
+
+func (l *linter) report(rule, level, path, suggestion, format string, args ...interface{}) {
+    l.violations = append(l.violations, Violation{
+        Rule:       rule,
+        Message:    fmt.Sprintf(format, args...),
+        File:       l.file,
+        Path:       path,
+        Level:      level,
+        Suggestion: suggestion,
+    })
+}
+
+// traffic is the part of a rule that decides what it matches
+type traffic struct {
+    protocol string
+    ports    []string
+    peers    []string
+}
+
+func (t traffic) String() string {
+    from := strings.Join(t.peers, ",")
+    switch {
+    case t.protocol == "all":
+        return "all traffic from " + from
+    case len(t.ports) == 0:
+        if _, ok := config.ApplicationPort(t.protocol); ok {
+            return fmt.Sprintf("%s from %s", t.protocol, from)
+        }
+        return fmt.Sprintf("%s on all ports from %s", t.protocol, from)
+    }
+    return fmt.Sprintf("%s %s from %s", t.protocol, strings.Join(t.ports, ","), from)
+}
+
+// transport is the rule's transport protocol and the ports it covers, an
+// application protocol's own port when it lists none
+func (t traffic) transport() (string, []string) {
+    transport, ok := config.TransportProtocol(t.protocol)
+    if !ok {
+        return t.protocol, t.ports
+    }
+    if len(t.ports) == 0 {
+        if port, ok := config.ApplicationPort(t.protocol); ok {
+            return transport, []string{fmt.Sprint(port)}
+        }
+    }
+    return transport, t.ports
+}
+
+// covers reports whether a matches all the traffic b matches
+func (a traffic) covers(b traffic) bool {
+    at, aports := a.transport()
+    bt, bports := b.transport()
+    if at != "all" && at != bt {
+        return false
+    }
+    if at != "all" && !config.PortsWithin(bports, aports) {
+        return false
+    }
+    if len(b.peers) == 0 {
+        return false
+    }
+    for _, peer := range b.peers {
+        if !config.CIDRWithin(peer, a.peers) {
+            return false
+        }
+    }
+    return true
+}
+
+// overlaps reports whether some traffic matches both a and b
+func (a traffic) overlaps(b traffic) bool {
+    at, aports := a.transport()
+    bt, bports := b.transport()
+    if at != "all" && bt != "all" && at != bt {
+        return false
+    }
+    if at != "all" && bt != "all" && !config.PortsOverlap(aports, bports) {
+        return false
+    }
+    return config.CIDRsOverlap(a.peers, b.peers)
+}
+
+// public reports whether the rule admits the whole internet on every port
+func (t traffic) public() bool {
+    open := false
+    for _, peer := range t.peers {
+        if peer == "0.0.0.0/0" || peer == "::/0" {
+            open = true
+        }
+    }
+    transport, ports := t.transport()
+    return open && (transport == "all" || config.PortsWithin([]string{"1-65535"}, ports))
+}
+
+// within lists the peers of t that lie inside other's
+func (t traffic) within(other traffic) []string {
+    var inside []string
+    for _, peer := range t.peers {
+        if config.CIDRWithin(peer, other.peers) {
+            inside = append(inside, peer)
+        }
+    }
+    return inside
+}
+
+func actionOrAllow(action string) string {
+    if action == "" {
+        return "allow"
+    }
+    return strings.ToLower(action)
+}
+
+// verb is the third person of an action, "allows" or "denies"
+func verb(action string) string {
+    if action == "deny" {
+        return "denies"
+    }
+    return action + "s"
+}
+
+func (l *linter) securityGroup(i int, sg config.SecurityGroup) {
+    rules := make([]traffic, len(sg.Rules))
+    for j, rule := range sg.Rules {
+        rules[j] = traffic{protocol: strings.ToLower(rule.Protocol), ports: rule.Ports, peers: rule.Sources}
+    }
+    path := func(j int) string {
+        return fmt.Sprintf("securityGroups[%d].rules[%d]", i, j)
+    }
+    
+    for j, rule := range sg.Rules {
+        action := actionOrAllow(rule.Action)
+        if action == "allow" && rules[j].public() {
+            l.report(LintOverlyBroad, "high", path(j),
+                "allow only the protocol and ports the service listens on",
+                "security group %s allows %s", sg.Name, rules[j])
+        }
+        
+        // Report each rule once: a deny rule hiding it matters most, then
+        // a rule making it redundant, then the deny rules it partly fights
+        shadowed, redundant := -1, -1
+        var conflicts []int
+        for k, other := range sg.Rules {
+            if k == j {
+                continue
+            }
+            otherAction := actionOrAllow(other.Action)
+            switch {
+            // Equal rules cover each other; the later one is redundant
+            case otherAction == action && rules[k].covers(rules[j]) && (k < j || !rules[j].covers(rules[k])):
+                if redundant < 0 {
+                    redundant = k
+                }
+            case action == "allow" && otherAction == "deny" && rules[k].covers(rules[j]):
+                if shadowed < 0 {
+                    shadowed = k
+                }
+            case action == "allow" && otherAction == "deny" && rules[j].overlaps(rules[k]):
+                conflicts = append(conflicts, k)
+            }
+        }
+        
+        switch {
+        case shadowed >= 0:
+            // Deny rules win, so the allow rule never applies
+            l.report(LintShadowed, "medium", path(j),
+                fmt.Sprintf("delete %s", path(j)),
+                "security group %s rule %s never applies: %s denies all of its traffic", sg.Name, rules[j], path(shadowed))
+        case redundant >= 0:
+            l.report(LintRedundant, "low", path(j),
+                fmt.Sprintf("delete %s", path(j)),
+                "security group %s rule %s is redundant: %s already %s it", sg.Name, rules[j], path(redundant), verb(action))
+        default:
+            for _, k := range conflicts {
+                suggestion := fmt.Sprintf("narrow %s so its sources or ports no longer overlap %s", path(j), path(k))
+                if inside := rules[j].within(rules[k]); len(inside) > 0 {
+                    suggestion = fmt.Sprintf("remove %s from the sources of %s", strings.Join(inside, ", "), path(j))
+                }
+                l.report(LintContradictory, "medium", path(j), suggestion,
+                    "security group %s allows %s, but %s denies %s", sg.Name, rules[j], path(k), rules[k])
+            }
+        }
+    }
+}
+
+func (l *linter) firewall(fws []config.FirewallRule) {
+    rules := make([]traffic, len(fws))
+    for i, fw := range fws {
+        peers := fw.SourceRanges
+        if strings.EqualFold(fw.Direction, "EGRESS") {
+            peers = fw.DestinationRanges
+        }
+        rules[i] = traffic{protocol: strings.ToLower(fw.Protocol), ports: fw.Ports, peers: peers}
+    }
+    path := func(i int) string {
+        return fmt.Sprintf("firewallRules[%d]", i)
+    }
+    
+    for i, fw := range fws {
+        action := actionOrAllow(fw.Action)
+        ingress := !strings.EqualFold(fw.Direction, "EGRESS")
+        if action == "allow" && ingress && rules[i].public() {
+            l.report(LintOverlyBroad, "high", path(i),
+                "allow only the protocol and ports the service listens on",
+                "firewall rule %s allows %s", fw.Name, rules[i])
+        }
+        
+        // The rule that decides the traffic before this one does: the
+        // lowest priority number, deny first at equal priority
+        first := -1
+        var conflicts []int
+        for j, other := range fws {
+            if i == j || !strings.EqualFold(fw.Direction, other.Direction) || !coversTags(other.TargetTags, fw.TargetTags) {
+                continue
+            }
+            otherAction := actionOrAllow(other.Action)
+            // Order does not matter between rules of one priority and action,
+            // so a broader one always makes a narrower one redundant
+            ahead := evaluatedBefore(fws, j, i) || other.Priority == fw.Priority && otherAction == action && !rules[i].covers(rules[j])
+            if rules[j].covers(rules[i]) && ahead && (first < 0 || evaluatedBefore(fws, j, first)) {
+                first = j
+            } else if other.Priority == fw.Priority && action == "allow" && otherAction == "deny" && rules[i].overlaps(rules[j]) {
+                conflicts = append(conflicts, j)
+            }
+        }
+        
+        switch {
+        case first >= 0 && actionOrAllow(fws[first].Action) == action:
+            l.report(LintRedundant, "low", path(i),
+                fmt.Sprintf("delete firewall rule %s", fw.Name),
+                "firewall rule %s is redundant: %s already %s %s", fw.Name, fws[first].Name, verb(action), rules[i])
+        case first >= 0:
+            other := fws[first]
+            l.report(LintShadowed, "medium", path(i),
+                fmt.Sprintf("delete firewall rule %s, or give it a priority below %d so it is evaluated before %s", fw.Name, other.Priority, other.Name),
+                "firewall rule %s never applies: %s, priority %d, %s all of its traffic first", fw.Name, other.Name, other.Priority, verb(actionOrAllow(other.Action)))
+        default:
+            for _, j := range conflicts {
+                l.report(LintContradictory, "medium", path(i),
+                    fmt.Sprintf("give firewall rule %s a priority other than %d, or narrow it so it no longer overlaps %s", fw.Name, fw.Priority, fws[j].Name),
+                    "firewall rules %s and %s allow and deny overlapping traffic at priority %d, so the deny wins", fw.Name, fws[j].Name, fw.Priority)
+            }
+        }
+    }
+}
+
+// evaluatedBefore reports whether firewall rule a decides traffic ahead of
+// rule b: it has a lower priority number, or the same priority and denies
+// where b allows. Between equal rules the earlier one comes first.
+func evaluatedBefore(fws []config.FirewallRule, a, b int) bool {
+    pa, pb := fws[a].Priority, fws[b].Priority
+    if pa != pb {
+        return pa < pb
+    }
+    da, db := actionOrAllow(fws[a].Action) == "deny", actionOrAllow(fws[b].Action) == "deny"
+    if da != db {
+        return da
+    }
+    return a < b
+}
+
+// coversTags reports whether a rule targeting tags applies to every
+// instance a rule targeting others does; no tags targets every instance
+func coversTags(tags, others []string) bool {
+    if len(tags) == 0 {
+        return true
+    }
+    if len(others) == 0 {
+        return false
+    }
+    for _, other := range others {
+        found := false
+        for _, tag := range tags {
+            if tag == other {
+                found = true
+            }
+        }
+        if !found {
+            return false
+        }
+    }
+    return true
+}
+
+// pkg/policy/enforcement.go
+package policy
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+)
+
+// Enforcement levels: what a violation does to the command that found it
+const (
+    // EnforceBlock fails verify and stops a commit or deploy
+    EnforceBlock = "block"
+    // EnforceWarn reports the violation and lets the command go ahead
+    EnforceWarn = "warn"
+    // EnforceAudit only records the violation in the audit log
+    EnforceAudit = "audit"
+)
+
+// severities in increasing order
+var severities = []string{"low", "medium", "high", "critical"}
+
+// SeverityRank orders severities from low (0) to critical, or returns -1
+// for a severity netgit does not know
+func SeverityRank(severity string) int {
+    for i, s := range severities {
+        if strings.EqualFold(s, severity) {
+            return i
+        }
+    }
+    return -1
+}
+
+// Enforcement decides the enforcement level of each violation from its
+// severity. Levels apply everywhere unless the environment the config is
+// checked for overrides them in Environments. Severities with no level
+// configured are blocked when high or critical and warned about otherwise.
+type Enforcement struct {
+    Levels       map[string]string            `yaml:"levels" json:"levels" mapstructure:"levels"`
+    Environments map[string]map[string]string `yaml:"environments" json:"environments" mapstructure:"environments"`
+    // FailOn, when set, blocks exactly the violations at or above this
+    // severity; levels that would block anything else only warn
+    FailOn string `yaml:"failOn" json:"failOn" mapstructure:"failOn"`
+}
+
+// Check rejects unknown enforcement levels and an unknown FailOn severity
+func (e Enforcement) Check() error {
+    if e.FailOn != "" && SeverityRank(e.FailOn) < 0 {
+        return fmt.Errorf("fail-on: unknown severity %q (want one of %s)", e.FailOn, strings.Join(severities, ", "))
+    }
+    check := func(where string, levels map[string]string) error {
+        for severity, level := range levels {
+            switch strings.ToLower(level) {
+            case EnforceBlock, EnforceWarn, EnforceAudit:
+            default:
+                return fmt.Errorf("%s: severity %s has unknown enforcement level %q (want block, warn or audit)", where, severity, level)
+            }
+        }
+        return nil
+    }
+    if err := check("enforcement", e.Levels); err != nil {
+        return err
+    }
+    envs := make([]string, 0, len(e.Environments))
+    for env := range e.Environments {
+        envs = append(envs, env)
+    }
+    sort.Strings(envs)
+    for _, env := range envs {
+        if err := check("enforcement for "+env, e.Environments[env]); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Level is the enforcement level of a violation of the given severity in
+// environment env
+func (e Enforcement) Level(severity, env string) string {
+    level := ""
+    for name, levels := range e.Environments {
+        if env != "" && strings.EqualFold(name, env) {
+            level = lookupLevel(levels, severity)
+        }
+    }
+    if level == "" {
+        level = lookupLevel(e.Levels, severity)
+    }
+    if level == "" {
+        level = EnforceWarn
+        if SeverityRank(severity) >= SeverityRank("high") {
+            level = EnforceBlock
+        }
+    }
+    
+    if e.FailOn != "" {
+        rank := SeverityRank(severity)
+        switch {
+        case rank >= 0 && rank >= SeverityRank(e.FailOn):
+            level = EnforceBlock
+        case level == EnforceBlock:
+            level = EnforceWarn
+        }
+    }
+    return level
+}
+
+func lookupLevel(levels map[string]string, severity string) string {
+    for s, level := range levels {
+        if strings.EqualFold(s, severity) {
+            return strings.ToLower(level)
+        }
+    }
+    return ""
+}
+
+// Enforce sets the enforcement level of each violation found in a config
+// checked for environment env, and reports whether any of them blocks
+func (e Enforcement) Enforce(violations []Violation, env string) bool {
+    blocked := false
+    for i := range violations {
+        violations[i].Enforcement = e.Level(violations[i].Level, env)
+        blocked = blocked || violations[i].Enforcement == EnforceBlock
+    }
+    return blocked
+}
+
+// pkg/policy/exceptions.go
+package policy
+
+import (
+    "bytes"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+    
+    "gopkg.in/yaml.v3"
+)
+
+// ExceptionsFile holds the waivers of a policy directory. The policy
+// loader skips it.
+const ExceptionsFile = "exceptions.yaml"
+
+// Waiver exempts one violation, the one its rule, file and path name,
+// until it expires. Expires is a date, and the waiver holds through the
+// end of that day (UTC), or an RFC 3339 time.
+type Waiver struct {
+    Rule          string `yaml:"rule" json:"rule"`
+    File          string `yaml:"file" json:"file"`
+    Path          string `yaml:"path" json:"path"`
+    Justification string `yaml:"justification" json:"justification"`
+    ApprovedBy    string `yaml:"approvedBy" json:"approvedBy"`
+    Expires       string `yaml:"expires" json:"expires"`
+    // Signature is the hex HMAC-SHA256 of the fields above, keyed with
+    // the repository's waiver key; see Sign
+    Signature string `yaml:"signature,omitempty" json:"signature,omitempty"`
+    
+    expires time.Time
+    signed  bool
+}
+
+func (w Waiver) String() string {
+    return fmt.Sprintf("%s at %s:%s", w.Rule, w.File, w.Path)
+}
+
+// Sign computes the waiver's signature under key. Each field is prefixed
+// with its length, so no two waivers sign the same bytes.
+func (w Waiver) Sign(key []byte) string {
+    mac := hmac.New(sha256.New, key)
+    for _, field := range []string{w.Rule, w.File, w.Path, w.Justification, w.ApprovedBy, w.Expires} {
+        fmt.Fprintf(mac, "%d:%s", len(field), field)
+    }
+    return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Signed reports whether the waiver's signature was checked against the
+// waiver key when it was loaded
+func (w Waiver) Signed() bool {
+    return w.signed
+}
+
+// ExpiresAt is the moment the waiver stops applying
+func (w Waiver) ExpiresAt() time.Time {
+    return w.expires
+}
+
+// Waived is a violation a waiver exempted
+type Waived struct {
+    Violation Violation `json:"violation"`
+    Waiver    Waiver    `json:"waiver"`
+}
+
+// Waivers are the waivers of a policy directory, and which of them have
+// exempted a violation so far
+type Waivers struct {
+    list []Waiver
+    used []bool
+}
+
+// LoadWaivers reads ExceptionsFile from policyDir; a directory without
+// one has no waivers. Every waiver needs a rule, file, path,
+// justification, approver and expiry, and its signature under key, so
+// waivers can only come from whoever holds it. With allowUnsigned, a
+// waiver without a signature, or any waiver when there is no key, is
+// loaded unsigned instead; a wrong signature is always an error.
+func LoadWaivers(policyDir string, key []byte, allowUnsigned bool) (*Waivers, error) {
+    file := filepath.Join(policyDir, ExceptionsFile)
+    data, err := ioutil.ReadFile(file)
+    if os.IsNotExist(err) {
+        return &Waivers{}, nil
+    } else if err != nil {
+        return nil, err
+    }
+    
+    var doc struct {
+        Waivers []Waiver `yaml:"waivers"`
+    }
+    decoder := yaml.NewDecoder(bytes.NewReader(data))
+    decoder.KnownFields(true)
+    if err := decoder.Decode(&doc); err != nil && !errors.Is(err, io.EOF) {
+        return nil, fmt.Errorf("%s: %v", file, err)
+    }
+    
+    for i := range doc.Waivers {
+        w := &doc.Waivers[i]
+        missing := []string{}
+        for _, field := range []struct{ name, value string }{
+            {"rule", w.Rule}, {"file", w.File}, {"path", w.Path},
+            {"justification", w.Justification}, {"approvedBy", w.ApprovedBy}, {"expires", w.Expires},
+        } {
+            if strings.TrimSpace(field.value) == "" {
+                missing = append(missing, field.name)
+            }
+        }
+        if len(missing) > 0 {
+            return nil, fmt.Errorf("%s: waiver %d has no %s", file, i+1, strings.Join(missing, ", "))
+        }
+        
+        if day, err := time.Parse("2006-01-02", w.Expires); err == nil {
+            w.expires = day.AddDate(0, 0, 1)
+        } else if w.expires, err = time.Parse(time.RFC3339, w.Expires); err != nil {
+            return nil, fmt.Errorf("%s: waiver %d: expires %q is neither a date nor an RFC 3339 time", file, i+1, w.Expires)
+        }
+        
+        switch {
+        case len(key) > 0 && w.Signature != "":
+            if !hmac.Equal([]byte(w.Signature), []byte(w.Sign(key))) {
+                return nil, fmt.Errorf("%s: waiver %d (%s) is not signed with the waiver key", file, i+1, w)
+            }
+            w.signed = true
+        case allowUnsigned:
+        case len(key) > 0:
+            return nil, fmt.Errorf("%s: waiver %d (%s) has no signature", file, i+1, w)
+        default:
+            return nil, fmt.Errorf("%s: waiver %d (%s) cannot be checked without the waiver key", file, i+1, w)
+        }
+    }
+    return &Waivers{list: doc.Waivers, used: make([]bool, len(doc.Waivers))}, nil
+}
+
+// List returns the waivers as loaded
+func (w *Waivers) List() []Waiver {
+    return w.list
+}
+
+// Apply removes the violations a waiver in force at now exempts, returning
+// those left and those waived
+func (w *Waivers) Apply(violations []Violation, now time.Time) (kept []Violation, waived []Waived) {
+    for _, v := range violations {
+        i := w.find(v, now)
+        if i < 0 {
+            kept = append(kept, v)
+            continue
+        }
+        w.used[i] = true
+        waived = append(waived, Waived{Violation: v, Waiver: w.list[i]})
+    }
+    return kept, waived
+}
+
+func (w *Waivers) find(v Violation, now time.Time) int {
+    for i, waiver := range w.list {
+        if waiver.Rule == v.Rule && filepath.ToSlash(waiver.File) == filepath.ToSlash(v.File) && waiver.Path == v.Path && now.Before(waiver.expires) {
+            return i
+        }
+    }
+    return -1
+}
+
+// Expired lists the waivers that no longer apply at now
+func (w *Waivers) Expired(now time.Time) []Waiver {
+    var expired []Waiver
+    for _, waiver := range w.list {
+        if !now.Before(waiver.expires) {
+            expired = append(expired, waiver)
+        }
+    }
+    return expired
+}
+
+// Unsigned lists the waivers loaded without a checked signature
+func (w *Waivers) Unsigned() []Waiver {
+    var unsigned []Waiver
+    for _, waiver := range w.list {
+        if !waiver.signed {
+            unsigned = append(unsigned, waiver)
+        }
+    }
+    return unsigned
+}
+
+// Unused lists the waivers still in force at now that Apply has not used,
+// because the violation they waive is gone or never matched
+func (w *Waivers) Unused(now time.Time) []Waiver {
+    var unused []Waiver
+    for i, waiver := range w.list {
+        if !w.used[i] && now.Before(waiver.expires) {
+            unused = append(unused, waiver)
+        }
+    }
+    return unused
+}
+
+// pkg/policy/report.go
+package policy
+
+import (
+    "encoding/json"
+    "encoding/xml"
+    "fmt"
+    "sort"
+    
+    "netgit/pkg/config"
+)
+
+// Report formats, besides plain text
+const (
+    OutputText  = "text"
+    OutputJSON  = "json"
+    OutputSARIF = "sarif"
+    OutputJUnit = "junit"
+)
+
+// Report is the outcome of checking a set of configs: the violations
+// found, each with its enforcement level, and what the waivers did
+type Report struct {
+    Files      []string    `json:"files"`
+    Violations []Violation `json:"violations"`
+    Waived     []Waived    `json:"waived,omitempty"`
+    Expired    []Waiver    `json:"expiredWaivers,omitempty"`
+    Unused     []Waiver    `json:"unusedWaivers,omitempty"`
+    Unsigned   []Waiver    `json:"unsignedWaivers,omitempty"`
+    Blocked    int         `json:"blocked"`
+    Warned     int         `json:"warned"`
+}
+
+// SchemaRule is the rule schema problems are reported under
+const SchemaRule = "schema"
+
+// SchemaReport reports configs that fail the schema, each problem as a
+// blocking violation of SchemaRule, so machine formats carry them too
+func SchemaReport(problems []config.Problem) *Report {
+    r := &Report{Violations: []Violation{}}
+    seen := map[string]bool{}
+    for _, p := range problems {
+        if !seen[p.File] {
+            seen[p.File] = true
+            r.Files = append(r.Files, p.File)
+        }
+        r.Violations = append(r.Violations, Violation{
+            Rule:        SchemaRule,
+            Message:     p.Message,
+            File:        p.File,
+            Path:        p.Path,
+            Level:       "high",
+            Line:        p.Line,
+            Column:      p.Column,
+            Enforcement: EnforceBlock,
+        })
+        r.Blocked++
+    }
+    return r
+}
+
+// locate fills in where in its file a violation's path is
+func locate(cfg config.NetworkConfig, v *Violation) {
+    if cfg.Raw != nil && v.Path != "" {
+        v.Line, v.Column = config.Position(cfg.Raw, v.Path)
+    }
+}
+
+// Format renders the report for machines: as JSON, as a SARIF 2.1.0 log
+// for code scanning, or as JUnit XML with a test suite per file
+func (r *Report) Format(format string) (string, error) {
+    var data []byte
+    var err error
+    switch format {
+    case OutputJSON:
+        data, err = json.MarshalIndent(r, "", "  ")
+    case OutputSARIF:
+        data, err = json.MarshalIndent(r.sarif(), "", "  ")
+    case OutputJUnit:
+        data, err = xml.MarshalIndent(r.junit(), "", "  ")
+        data = append([]byte(xml.Header), data...)
+    default:
+        return "", fmt.Errorf("unknown output format: %s (want text, json, sarif or junit)", format)
+    }
+    if err != nil {
+        return "", err
+    }
+    return string(data) + "\n", nil
+}
+
+type sarifLog struct {
+    Version string     `json:"version"`
+    Schema  string     `json:"$schema"`
+    Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+    Tool    sarifTool     `json:"tool"`
+    Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+    Driver struct {
+        Name  string      `json:"name"`
+        Rules []sarifRule `json:"rules"`
+    } `json:"driver"`
+}
+
+type sarifRule struct {
+    ID string `json:"id"`
+}
+
+type sarifResult struct {
+    RuleID     string            `json:"ruleId"`
+    Level      string            `json:"level"`
+    Message    sarifText         `json:"message"`
+    Locations  []sarifLocation   `json:"locations"`
+    Properties map[string]string `json:"properties"`
+}
+
+type sarifText struct {
+    Text string `json:"text"`
+}
+
+type sarifLocation struct {
+    PhysicalLocation struct {
+        ArtifactLocation struct {
+            URI string `json:"uri"`
+        } `json:"artifactLocation"`
+        Region *sarifRegion `json:"region,omitempty"`
+    } `json:"physicalLocation"`
+    LogicalLocations []sarifLogical `json:"logicalLocations,omitempty"`
+}
+
+type sarifRegion struct {
+    StartLine   int `json:"startLine"`
+    StartColumn int `json:"startColumn,omitempty"`
+}
+
+type sarifLogical struct {
+    FullyQualifiedName string `json:"fullyQualifiedName"`
+}
+
+// sarifLevel maps enforcement onto SARIF's levels: blocking violations are
+// errors, warnings warnings and audit-only ones notes
+func sarifLevel(v Violation) string {
+    switch v.Enforcement {
+    case EnforceBlock:
+        return "error"
+    case EnforceAudit:
+        return "note"
+    }
+    return "warning"
+}
+
+func (r *Report) sarif() sarifLog {
+    run := sarifRun{Results: []sarifResult{}}
+    run.Tool.Driver.Name = "netgit"
+    run.Tool.Driver.Rules = []sarifRule{}
+    
+    seen := map[string]bool{}
+    for _, v := range r.Violations {
+        if !seen[v.Rule] {
+            seen[v.Rule] = true
+            run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{ID: v.Rule})
+        }
+        
+        var location sarifLocation
+        location.PhysicalLocation.ArtifactLocation.URI = v.File
+        if v.Line > 0 {
+            location.PhysicalLocation.Region = &sarifRegion{StartLine: v.Line, StartColumn: v.Column}
+        }
+        if v.Path != "" {
+            location.LogicalLocations = []sarifLogical{{FullyQualifiedName: v.Path}}
+        }
+        run.Results = append(run.Results, sarifResult{
+            RuleID:     v.Rule,
+            Level:      sarifLevel(v),
+            Message:    sarifText{Text: v.Message},
+            Locations:  []sarifLocation{location},
+            Properties: map[string]string{"severity": v.Level, "enforcement": v.Enforcement},
+        })
+    }
+    return sarifLog{
+        Version: "2.1.0",
+        Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+        Runs:    []sarifRun{run},
+    }
+}
+
+type junitSuites struct {
+    XMLName  xml.Name     `xml:"testsuites"`
+    Name     string       `xml:"name,attr"`
+    Tests    int          `xml:"tests,attr"`
+    Failures int          `xml:"failures,attr"`
+    Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+    Name     string      `xml:"name,attr"`
+    Tests    int         `xml:"tests,attr"`
+    Failures int         `xml:"failures,attr"`
+    Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+    Name      string        `xml:"name,attr"`
+    ClassName string        `xml:"classname,attr"`
+    Failure   *junitFailure `xml:"failure,omitempty"`
+    SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+    Message string `xml:"message,attr"`
+    Type    string `xml:"type,attr"`
+    Text    string `xml:",chardata"`
+}
+
+// junit has a test suite per file, with a failing test case for each
+// blocking violation and a passing one, its message in system-out, for
+// each other violation. A file without violations has one passing case.
+func (r *Report) junit() junitSuites {
+    byFile := map[string][]Violation{}
+    for _, file := range r.Files {
+        byFile[file] = nil
+    }
+    for _, v := range r.Violations {
+        byFile[v.File] = append(byFile[v.File], v)
+    }
+    var files []string
+    for file := range byFile {
+        files = append(files, file)
+    }
+    sort.Strings(files)
+    
+    suites := junitSuites{Name: "netgit verify"}
+    for _, file := range files {
+        suite := junitSuite{Name: file}
+        for _, v := range byFile[file] {
+            name := v.Rule
+            if v.Path != "" {
+                name += " " + v.Path
+            }
+            c := junitCase{Name: name, ClassName: file}
+            where := file
+            if v.Line > 0 {
+                where = fmt.Sprintf("%s:%d:%d", file, v.Line, v.Column)
+            }
+            text := fmt.Sprintf("%s: %s (severity %s, %s)", where, v.Message, v.Level, v.Enforcement)
+            if v.Enforcement == EnforceBlock {
+                c.Failure = &junitFailure{Message: v.Message, Type: v.Level, Text: text}
+                suite.Failures++
+            } else {
+                c.SystemOut = text
+            }
+            suite.Cases = append(suite.Cases, c)
+        }
+        if len(suite.Cases) == 0 {
+            suite.Cases = []junitCase{{Name: "policies", ClassName: file}}
+        }
+        suite.Tests = len(suite.Cases)
+        suites.Tests += suite.Tests
+        suites.Failures += suite.Failures
+        suites.Suites = append(suites.Suites, suite)
+    }
+    return suites
+}
+
+// pkg/policy/testing.go
+package policy
+
+import (
+    "context"
+    "fmt"
+    
+    "github.com/open-policy-agent/opa/tester"
+)
+
+// TestResult is the outcome of one Rego test rule
+type TestResult struct {
+    Package  string `json:"package"`
+    Name     string `json:"name"`
+    Location string `json:"location"`
+    Passed   bool   `json:"passed"`
+    Error    string `json:"error,omitempty"`
+}
+
+// RunTests runs the test_ rules of the bundled rules and of the Rego files
+// in policyDir, tests included, against each other
+func RunTests(policyDir string) ([]TestResult, error) {
+    modules, err := loadModules(policyDir, true)
+    if err != nil {
+        return nil, err
+    }
+    
+    ch, err := tester.NewRunner().SetModules(modules).RunTests(context.Background(), nil)
+    if err != nil {
+        return nil, err
+    }
+    
+    var results []TestResult
+    for r := range ch {
+        result := TestResult{
+            Package: r.Package,
+            Name:    r.Name,
+            Passed:  r.Pass(),
+        }
+        if r.Location != nil {
+            result.Location = fmt.Sprintf("%s:%d", r.Location.File, r.Location.Row)
+        }
+        if r.Error != nil {
+            result.Error = r.Error.Error()
+        }
+        results = append(results, result)
+    }
+    return results, nil
+}
+
+# pkg/policy/rules/lib.rego
+package netgit.lib
+
+import future.keywords.if
+import future.keywords.in
+
+# list treats a null list, as configs encode an empty one, as empty
+list(x) := x if {
+    is_array(x)
+} else := []
+
+# Application protocols and the port each stands for
+named_ports := {
+    "http": 80,
+    "https": 443,
+    "ssh": 22,
+    "rdp": 3389,
+    "smtp": 25,
+    "mysql": 3306,
+    "postgres": 5432,
+    "dns": 53,
+}
+
+# allows is true for a security group rule or firewall rule that lets
+# traffic through; an empty action means allow
+allows(rule) if {
+    object.get(rule, "action", "") in {"", "allow"}
+}
+
+ingress(rule) if {
+    allows(rule)
+    object.get(rule, "direction", "INGRESS") == "INGRESS"
+}
+
+# port_in is true when a port spec, "80" or "8000-8100", covers port
+port_in(spec, port) if {
+    not contains(spec, "-")
+    to_number(spec) == port
+}
+
+port_in(spec, port) if {
+    bounds := split(spec, "-")
+    to_number(bounds[0]) <= port
+    port <= to_number(bounds[1])
+}
+
+# reaches_port is true when a rule carries TCP traffic to port. A rule
+# without ports covers every port.
+reaches_port(rule, port) if {
+    rule.protocol in {"tcp", "all"}
+    count(list(object.get(rule, "ports", []))) == 0
+}
+
+reaches_port(rule, port) if {
+    rule.protocol in {"tcp", "all"}
+    some spec in list(object.get(rule, "ports", []))
+    port_in(spec, port)
+}
+
+reaches_port(rule, port) if {
+    named_ports[rule.protocol] == port
+}
+
+# public is true for a range that covers the whole internet
+public(cidr) if {
+    cidr in {"0.0.0.0/0", "::/0"}
+}
+
+private_ranges := [
+    "10.0.0.0/8",
+    "172.16.0.0/12",
+    "192.168.0.0/16",
+    "100.64.0.0/10",
+    "fc00::/7",
+]
+
+# private is true for a range inside the private address space. Security
+# group and prefix list references are internal by definition.
+private(source) if {
+    not contains(source, "/")
+}
+
+private(source) if {
+    some r in private_ranges
+    net.cidr_contains(r, source)
+}
+
+# pkg/policy/rules/deny_public_database.rego
+package netgit.rules.deny_public_database
+
+import data.netgit.lib
+import future.keywords.contains
+import future.keywords.if
+import future.keywords.in
+
+database_protocols := {"mysql", "postgres", "postgresql", "mssql", "mongodb", "redis", "oracle"}
+
+database_ports := {3306, 5432, 1433, 1521, 27017, 6379}
+
+database(rule) if {
+    rule.protocol in database_protocols
+}
+
+database(rule) if {
+    some port in database_ports
+    lib.reaches_port(rule, port)
+}
+
+violation contains v if {
+    some i, j, k
+    sg := input.securityGroups[i]
+    rule := sg.rules[j]
+    lib.allows(rule)
+    database(rule)
+    lib.public(rule.sources[k])
+    v := {
+        "message": sprintf("Database security group '%s' allows public access", [sg.name]),
+        "path": sprintf("securityGroups[%d].rules[%d].sources[%d]", [i, j, k]),
+    }
+}
+
+violation contains v if {
+    some i, k
+    fw := input.firewallRules[i]
+    lib.ingress(fw)
+    database(fw)
+    lib.public(fw.sourceRanges[k])
+    v := {
+        "message": sprintf("Firewall rule '%s' allows public access to a database", [fw.name]),
+        "path": sprintf("firewallRules[%d].sourceRanges[%d]", [i, k]),
+    }
+}
+
+# pkg/policy/rules/require_redundancy.rego
+package netgit.rules.require_redundancy
+
+import data.netgit.lib
+import future.keywords.contains
+import future.keywords.if
+
+violation contains v if {
+    count(lib.list(object.get(input, "firewallRules", []))) < 2
+    v := {
+        "message": "Insufficient redundant routes configured",
+        "path": "firewallRules",
+    }
+}
+
+# pkg/policy/rules/secure_protocols_only.rego
+package netgit.rules.secure_protocols_only
+
+import data.netgit.lib
+import future.keywords.contains
+import future.keywords.if
+import future.keywords.in
+
+insecure := {"http", "ftp", "telnet"}
+
+violation contains v if {
+    some i, j
+    sg := input.securityGroups[i]
+    rule := sg.rules[j]
+    lib.allows(rule)
+    rule.protocol in insecure
+    v := {
+        "message": sprintf("Insecure protocol '%s' is not allowed in security group '%s'", [rule.protocol, sg.name]),
+        "path": sprintf("securityGroups[%d].rules[%d].protocol", [i, j]),
+    }
+}
+
+violation contains v if {
+    some i
+    fw := input.firewallRules[i]
+    lib.allows(fw)
+    fw.protocol in insecure
+    v := {
+        "message": sprintf("Insecure protocol '%s' is not allowed in firewall rule '%s'", [fw.protocol, fw.name]),
+        "path": sprintf("firewallRules[%d].protocol", [i]),
+    }
+}
+
+# pkg/policy/rules/require_https.rego
+package netgit.rules.require_https
+
+import data.netgit.lib
+import future.keywords.contains
+import future.keywords.if
+import future.keywords.in
+
+# Plain HTTP is port 80, whether named, given as a TCP port or covered by
+# a rule for every port
+
+violation contains v if {
+    some i, j
+    sg := input.securityGroups[i]
+    rule := sg.rules[j]
+    lib.allows(rule)
+    rule.protocol == "http"
+    v := {
+        "message": sprintf("Security group '%s' allows plain HTTP; serve web traffic over HTTPS", [sg.name]),
+        "path": sprintf("securityGroups[%d].rules[%d].protocol", [i, j]),
+    }
+}
+
+violation contains v if {
+    some i, j
+    sg := input.securityGroups[i]
+    rule := sg.rules[j]
+    lib.allows(rule)
+    rule.protocol != "http"
+    lib.reaches_port(rule, 80)
+    some path in port_80(sprintf("securityGroups[%d].rules[%d]", [i, j]), rule)
+    v := {
+        "message": sprintf("Security group '%s' allows plain HTTP on port 80; serve web traffic over HTTPS", [sg.name]),
+        "path": path,
+    }
+}
+
+violation contains v if {
+    some i
+    fw := input.firewallRules[i]
+    lib.ingress(fw)
+    lib.reaches_port(fw, 80)
+    some path in port_80(sprintf("firewallRules[%d]", [i]), fw)
+    v := {
+        "message": sprintf("Firewall rule '%s' allows plain HTTP on port 80; serve web traffic over HTTPS", [fw.name]),
+        "path": path,
+    }
+}
+
+# port_80 is the paths of the rule's port specs that cover port 80, or of
+# its protocol when it lists no ports
+port_80(path, rule) := {sprintf("%s.ports[%d]", [path, k]) | some k; lib.port_in(rule.ports[k], 80)} if {
+    count(lib.list(object.get(rule, "ports", []))) > 0
+} else := {sprintf("%s.protocol", [path])}
+
+# pkg/policy/rules/ssh_internal_only.rego
+package netgit.rules.ssh_internal_only
+
+import data.netgit.lib
+import future.keywords.contains
+import future.keywords.if
+
+violation contains v if {
+    some i, j, k
+    sg := input.securityGroups[i]
+    rule := sg.rules[j]
+    lib.allows(rule)
+    lib.reaches_port(rule, 22)
+    source := rule.sources[k]
+    not lib.private(source)
+    v := {
+        "message": sprintf("Security group '%s' allows SSH from %s; restrict it to internal networks", [sg.name, source]),
+        "path": sprintf("securityGroups[%d].rules[%d].sources[%d]", [i, j, k]),
+    }
+}
+
+violation contains v if {
+    some i, k
+    fw := input.firewallRules[i]
+    lib.ingress(fw)
+    lib.reaches_port(fw, 22)
+    source := fw.sourceRanges[k]
+    not lib.private(source)
+    v := {
+        "message": sprintf("Firewall rule '%s' allows SSH from %s; restrict it to internal networks", [fw.name, source]),
+        "path": sprintf("firewallRules[%d].sourceRanges[%d]", [i, k]),
+    }
+}
+
+# pkg/policy/rules/rules_test.rego
+package netgit.rules_test
+
+import data.netgit.rules
+import future.keywords.if
+
+sg(rule) := {"securityGroups": [{"name": "app", "rules": [rule]}]}
+
+test_public_database if {
+    count(rules.deny_public_database.violation) == 1 with input as sg({"protocol": "tcp", "ports": ["3300-3310"], "sources": ["0.0.0.0/0"]})
+}
+
+test_public_database_on_every_port if {
+    count(rules.deny_public_database.violation) == 1 with input as sg({"protocol": "all", "sources": ["0.0.0.0/0"]})
+    count(rules.deny_public_database.violation) == 1 with input as sg({"protocol": "tcp", "sources": ["0.0.0.0/0"]})
+    count(rules.deny_public_database.violation) == 1 with input as {"firewallRules": [{"name": "open", "direction": "INGRESS", "protocol": "all", "sourceRanges": ["0.0.0.0/0"]}]}
+    count(rules.deny_public_database.violation) == 0 with input as sg({"protocol": "udp", "ports": ["3306"], "sources": ["0.0.0.0/0"]})
+}
+
+test_internal_database if {
+    count(rules.deny_public_database.violation) == 0 with input as sg({"protocol": "mysql", "sources": ["10.0.0.0/8"]})
+}
+
+test_redundancy if {
+    count(rules.require_redundancy.violation) == 1 with input as {"firewallRules": [{"name": "only"}]}
+    count(rules.require_redundancy.violation) == 0 with input as {"firewallRules": [{"name": "a"}, {"name": "b"}]}
+}
+
+test_insecure_protocol if {
+    count(rules.secure_protocols_only.violation) == 1 with input as sg({"protocol": "telnet"})
+}
+
+test_plain_http if {
+    count(rules.require_https.violation) == 1 with input as sg({"protocol": "tcp", "ports": ["80-90"]})
+    count(rules.require_https.violation) == 0 with input as sg({"protocol": "tcp", "ports": ["443"]})
+}
+
+test_plain_http_on_every_port if {
+    rules.require_https.violation == {{
+        "message": "Security group 'app' allows plain HTTP on port 80; serve web traffic over HTTPS",
+        "path": "securityGroups[0].rules[0].protocol",
+    }} with input as sg({"protocol": "all"})
+    count(rules.require_https.violation) == 1 with input as sg({"protocol": "tcp", "ports": []})
+    count(rules.require_https.violation) == 1 with input as {"firewallRules": [{"name": "web", "protocol": "all", "ports": ["80"]}]}
+    count(rules.require_https.violation) == 0 with input as sg({"protocol": "udp"})
+    count(rules.require_https.violation) == 0 with input as sg({"protocol": "https"})
+}
+
+test_denied_http_is_fine if {
+    count(rules.require_https.violation) == 0 with input as sg({"protocol": "http", "action": "deny"})
+}
+
+test_public_ssh if {
+    count(rules.ssh_internal_only.violation) == 1 with input as sg({"protocol": "tcp", "ports": ["22"], "sources": ["10.0.0.0/8", "203.0.113.0/24"]})
+}
+
+test_ssh_from_referenced_group if {
+    count(rules.ssh_internal_only.violation) == 0 with input as sg({"protocol": "ssh", "sources": ["sg-0123"]})
+}
+
+test_firewall_ssh if {
+    count(rules.ssh_internal_only.violation) == 1 with input as {"firewallRules": [{"name": "ssh", "direction": "INGRESS", "protocol": "all", "sourceRanges": ["0.0.0.0/0"]}]}
+    count(rules.ssh_internal_only.violation) == 0 with input as {"firewallRules": [{"name": "ssh", "direction": "EGRESS", "protocol": "all", "destinationRanges": ["0.0.0.0/0"]}]}
+}
+
+// pkg/reach/model.go
+package reach
+
+import (
+    "fmt"
+    "net/netip"
+    "sort"
+    "strconv"
+    "strings"
+    
+    "netgit/pkg/config"
+)
+
+// Endpoint kinds
+const (
+    KindCIDR          = "cidr"
+    KindSecurityGroup = "securityGroup"
+    KindTag           = "tag"
+    KindPod           = "pod"
+)
+
+// Layers a step belongs to
+const (
+    LayerSecurityGroup = "securityGroup"
+    LayerFirewall      = "firewall"
+    LayerNetworkPolicy = "networkPolicy"
+)
+
+// Endpoint is one end of a flow: an address range, the members of a
+// security group, the instances a firewall target tag selects, or the
+// Kubernetes pods with some labels in a namespace
+type Endpoint struct {
+    Kind      string
+    Name      string
+    Prefix    netip.Prefix
+    GroupID   string
+    Namespace string
+    Labels    map[string]string
+}
+
+func (e Endpoint) String() string {
+    if e.Kind == KindTag {
+        return "tag:" + e.Name
+    }
+    return e.Name
+}
+
+// Step is a rule that decided whether traffic passes one layer
+type Step struct {
+    Layer     string `json:"layer"`
+    Direction string `json:"direction"`
+    Name      string `json:"name"`
+    Path      string `json:"path"`
+    Action    string `json:"action"`
+    Detail    string `json:"detail"`
+}
+
+func (s Step) String() string {
+    return fmt.Sprintf("%s %s %s: %s %s (%s)", layerNames[s.Layer], s.Name, s.Direction, s.Action, s.Detail, s.Path)
+}
+
+var layerNames = map[string]string{
+    LayerSecurityGroup: "security group",
+    LayerFirewall:      "firewall rule",
+    LayerNetworkPolicy: "network policy",
+}
+
+// Result answers whether a flow is allowed. Chain holds the rules that let
+// it through, source side first, or ends with the rule that blocks it;
+// Reason says why a blocked flow is blocked. When only part of an address
+// range gets through, Partial lists the prefixes that do, Chain the rules
+// letting them through and Reason why the rest is blocked.
+type Result struct {
+    Allowed bool     `json:"allowed"`
+    Partial []string `json:"partial,omitempty"`
+    Chain   []Step   `json:"chain"`
+    Reason  string   `json:"reason,omitempty"`
+}
+
+// Model answers reachability questions about a config. Security groups
+// only filter ingress and deny rules in them win over allow rules.
+// Firewall rules are evaluated in priority order, with deny first at equal
+// priorities, ingress denied and egress allowed when none match. Network
+// policies follow Kubernetes: pods no policy isolates accept everything.
+type Model struct {
+    cfg  config.NetworkConfig
+    cuts []netip.Prefix
+}
+
+func New(cfg config.NetworkConfig) *Model {
+    return &Model{cfg: cfg, cuts: ranges(cfg)}
+}
+
+// ranges lists the address ranges the config's rules name, the places
+// where the answer for a range of addresses can change
+func ranges(cfg config.NetworkConfig) []netip.Prefix {
+    var cidrs []string
+    for _, sg := range cfg.SecurityGroups {
+        for _, rule := range sg.Rules {
+            cidrs = append(cidrs, rule.Sources...)
+        }
+    }
+    for _, fw := range cfg.FirewallRules {
+        cidrs = append(cidrs, fw.SourceRanges...)
+        cidrs = append(cidrs, fw.DestinationRanges...)
+    }
+    for _, np := range cfg.NetworkPolicies {
+        rules := append(append([]config.NetworkPolicyRule{}, np.Ingress...), np.Egress...)
+        for _, rule := range rules {
+            peers := append(append([]config.NetworkPolicyPeer{}, rule.From...), rule.To...)
+            for _, peer := range peers {
+                if peer.IPBlock != nil {
+                    cidrs = append(cidrs, peer.IPBlock.CIDR)
+                    cidrs = append(cidrs, peer.IPBlock.Except...)
+                }
+            }
+        }
+    }
+    
+    var prefixes []netip.Prefix
+    for _, c := range cidrs {
+        if prefix, err := netip.ParsePrefix(strings.TrimSpace(c)); err == nil {
+            prefixes = append(prefixes, prefix.Masked())
+        }
+    }
+    return prefixes
+}
+
+// Endpoint parses an endpoint: a CIDR or address, a security group name or
+// id, tag:<target tag>, or pod:<namespace>/<key>=<value>,... with the
+// namespace defaulting to "default"
+func (m *Model) Endpoint(s string) (Endpoint, error) {
+    if prefix, err := netip.ParsePrefix(s); err == nil {
+        return Endpoint{Kind: KindCIDR, Name: s, Prefix: prefix.Masked()}, nil
+    }
+    if addr, err := netip.ParseAddr(s); err == nil {
+        return Endpoint{Kind: KindCIDR, Name: s, Prefix: netip.PrefixFrom(addr, addr.BitLen())}, nil
+    }
+    
+    if tag, ok := strings.CutPrefix(s, "tag:"); ok {
+        if tag == "" {
+            return Endpoint{}, fmt.Errorf("endpoint %q names no tag", s)
+        }
+        return Endpoint{Kind: KindTag, Name: tag}, nil
+    }
+    
+    if pod, ok := strings.CutPrefix(s, "pod:"); ok {
+        namespace, selector, found := strings.Cut(pod, "/")
+        if !found {
+            namespace, selector = "default", pod
+        }
+        labels := map[string]string{}
+        for _, pair := range strings.Split(selector, ",") {
+            if pair == "" {
+                continue
+            }
+            key, value, ok := strings.Cut(pair, "=")
+            if !ok || key == "" {
+                return Endpoint{}, fmt.Errorf("endpoint %q: labels are written key=value", s)
+            }
+            labels[key] = value
+        }
+        return Endpoint{Kind: KindPod, Name: s, Namespace: namespace, Labels: labels}, nil
+    }
+    
+    for _, sg := range m.cfg.SecurityGroups {
+        if sg.Name == s || sg.ID != "" && sg.ID == s {
+            return Endpoint{Kind: KindSecurityGroup, Name: sg.Name, GroupID: sg.ID}, nil
+        }
+    }
+    return Endpoint{}, fmt.Errorf("unknown endpoint %q: not a CIDR, security group, tag: or pod:", s)
+}
+
+// Reachable reports whether from can open a connection to to with
+// protocol, a transport protocol such as tcp, on port. An address range at
+// either end is answered for as a whole: it is split where the rules split
+// it, so several rules together can let all of it through, and a rule for
+// part of it lets that part through.
+func (m *Model) Reachable(from, to Endpoint, protocol string, port int) Result {
+    protocol = strings.ToLower(protocol)
+    var allowed, blocked []Result
+    var partial []string
+    if from.Kind == KindCIDR {
+        for _, piece := range m.split(from) {
+            result := m.reachable(piece, to, protocol, port)
+            if result.Allowed {
+                allowed = append(allowed, result)
+                partial = append(partial, piece.Prefix.String())
+            } else {
+                blocked = append(blocked, result)
+            }
+        }
+    } else {
+        for _, piece := range m.split(to) {
+            result := m.reachable(from, piece, protocol, port)
+            if result.Allowed {
+                allowed = append(allowed, result)
+                partial = append(partial, piece.Prefix.String())
+            } else {
+                blocked = append(blocked, result)
+            }
+        }
+    }
+    
+    switch {
+    case len(blocked) == 0:
+        return Result{Allowed: true, Chain: chain(allowed)}
+    case len(allowed) == 0:
+        return Result{Chain: chain(blocked), Reason: reason(blocked)}
+    }
+    return Result{Partial: config.NormalizeCIDRs(partial), Chain: chain(allowed), Reason: reason(blocked)}
+}
+
+// split cuts an address range into the largest pieces that each lie inside
+// or outside every range the rules name, so each rule treats all of a
+// piece alike. Pieces keep the endpoint's name for the reasons given.
+func (m *Model) split(e Endpoint) []Endpoint {
+    if e.Kind != KindCIDR {
+        return []Endpoint{e}
+    }
+    var pieces []Endpoint
+    for _, prefix := range splitPrefix(e.Prefix, m.cuts) {
+        piece := e
+        piece.Prefix = prefix
+        pieces = append(pieces, piece)
+    }
+    return pieces
+}
+
+func splitPrefix(prefix netip.Prefix, cuts []netip.Prefix) []netip.Prefix {
+    for _, c := range cuts {
+        if c.Bits() > prefix.Bits() && prefix.Contains(c.Addr()) {
+            lower, upper := halves(prefix)
+            return append(splitPrefix(lower, cuts), splitPrefix(upper, cuts)...)
+        }
+    }
+    return []netip.Prefix{prefix}
+}
+
+// halves splits a prefix into its two halves
+func halves(prefix netip.Prefix) (netip.Prefix, netip.Prefix) {
+    bits := prefix.Bits()
+    addr := prefix.Addr().AsSlice()
+    addr[bits/8] |= 0x80 >> (bits % 8)
+    upper, _ := netip.AddrFromSlice(addr)
+    return netip.PrefixFrom(prefix.Addr(), bits+1), netip.PrefixFrom(upper, bits+1)
+}
+
+// chain joins the chains of the results for the pieces of a range, each
+// step once and the source side first
+func chain(results []Result) []Step {
+    var steps []Step
+    seen := map[Step]bool{}
+    for _, result := range results {
+        for _, step := range result.Chain {
+            if !seen[step] {
+                seen[step] = true
+                steps = append(steps, step)
+            }
+        }
+    }
+    sort.SliceStable(steps, func(i, j int) bool {
+        return steps[i].Direction == "egress" && steps[j].Direction != "egress"
+    })
+    return steps
+}
+
+func reason(results []Result) string {
+    var reasons []string
+    seen := map[string]bool{}
+    for _, result := range results {
+        if !seen[result.Reason] {
+            seen[result.Reason] = true
+            reasons = append(reasons, result.Reason)
+        }
+    }
+    return strings.Join(reasons, "; ")
+}
+
+// reachable answers for endpoints each rule treats as a whole
+func (m *Model) reachable(from, to Endpoint, protocol string, port int) Result {
+    var result Result
+    
+    for _, layer := range []func(Endpoint, Endpoint, string, int) (*Step, bool, string){m.egress, m.ingress} {
+        step, allowed, reason := layer(from, to, protocol, port)
+        if step != nil {
+            result.Chain = append(result.Chain, *step)
+        }
+        if !allowed {
+            result.Reason = reason
+            return result
+        }
+    }
+    result.Allowed = true
+    return result
+}
+
+// egress checks the rules on the source side, which only firewall rules
+// and network policies have
+func (m *Model) egress(from, to Endpoint, protocol string, port int) (*Step, bool, string) {
+    switch from.Kind {
+    case KindTag:
+        step, matched := m.firewall("EGRESS", from.Name, to, protocol, port)
+        if matched && step.Action == "deny" {
+            return step, false, fmt.Sprintf("firewall rule %s denies egress from tag %s", step.Name, from.Name)
+        }
+        return step, true, ""
+    case KindPod:
+        return m.networkPolicy("Egress", from, to, protocol, port)
+    }
+    return nil, true, ""
+}
+
+func (m *Model) ingress(from, to Endpoint, protocol string, port int) (*Step, bool, string) {
+    switch to.Kind {
+    case KindSecurityGroup:
+        return m.securityGroup(from, to, protocol, port)
+    case KindTag:
+        step, matched := m.firewall("INGRESS", to.Name, from, protocol, port)
+        if !matched {
+            return nil, false, fmt.Sprintf("no firewall rule allows ingress to tag %s, so the implied deny applies", to.Name)
+        }
+        if step.Action == "deny" {
+            return step, false, fmt.Sprintf("firewall rule %s denies ingress to tag %s", step.Name, to.Name)
+        }
+        return step, true, ""
+    case KindPod:
+        return m.networkPolicy("Ingress", to, from, protocol, port)
+    }
+    return nil, true, ""
+}
+
+func (m *Model) securityGroup(from, to Endpoint, protocol string, port int) (*Step, bool, string) {
+    var allow *Step
+    for i, sg := range m.cfg.SecurityGroups {
+        if sg.Name != to.Name {
+            continue
+        }
+        for j, rule := range sg.Rules {
+            if !ruleCarries(rule.Protocol, rule.Ports, protocol, port) {
+                continue
+            }
+            for _, source := range rule.Sources {
+                if !sourceCovers(source, from) {
+                    continue
+                }
+                step := &Step{
+                    Layer:     LayerSecurityGroup,
+                    Direction: "ingress",
+                    Name:      sg.Name,
+                    Path:      fmt.Sprintf("securityGroups[%d].rules[%d]", i, j),
+                    Action:    action(rule.Action),
+                    Detail:    fmt.Sprintf("%s %s from %s", rule.Protocol, portList(rule.Ports), source),
+                }
+                if step.Action == "deny" {
+                    return step, false, fmt.Sprintf("security group %s denies %s", sg.Name, source)
+                }
+                if allow == nil {
+                    allow = step
+                }
+            }
+        }
+    }
+    if allow == nil {
+        return nil, false, fmt.Sprintf("no rule of security group %s allows %s", to.Name, from)
+    }
+    return allow, true, ""
+}
+
+// firewall finds the firewall rule that decides traffic in direction for
+// instances with tag, with peer at the other end
+func (m *Model) firewall(direction, tag string, peer Endpoint, protocol string, port int) (*Step, bool) {
+    type candidate struct {
+        index int
+        rule  config.FirewallRule
+    }
+    var candidates []candidate
+    for i, fw := range m.cfg.FirewallRules {
+        if !strings.EqualFold(fw.Direction, direction) || !targets(fw.TargetTags, tag) {
+            continue
+        }
+        if !ruleCarries(fw.Protocol, fw.Ports, protocol, port) {
+            continue
+        }
+        for _, r := range peerRanges(fw, direction) {
+            if sourceCovers(r, peer) {
+                candidates = append(candidates, candidate{i, fw})
+                break
+            }
+        }
+    }
+    if len(candidates) == 0 {
+        return nil, false
+    }
+    
+    sort.SliceStable(candidates, func(i, j int) bool {
+        a, b := candidates[i].rule, candidates[j].rule
+        if a.Priority != b.Priority {
+            return a.Priority < b.Priority
+        }
+        return action(a.Action) == "deny" && action(b.Action) != "deny"
+    })
+    c := candidates[0]
+    return &Step{
+        Layer:     LayerFirewall,
+        Direction: strings.ToLower(direction),
+        Name:      c.rule.Name,
+        Path:      fmt.Sprintf("firewallRules[%d]", c.index),
+        Action:    action(c.rule.Action),
+        Detail:    fmt.Sprintf("%s %s, priority %d", c.rule.Protocol, portList(c.rule.Ports), c.rule.Priority),
+    }, true
+}
+
+// networkPolicy checks the policies isolating pod in policyType, Ingress
+// or Egress, against traffic with peer
+func (m *Model) networkPolicy(policyType string, pod, peer Endpoint, protocol string, port int) (*Step, bool, string) {
+    isolated := false
+    for i, np := range m.cfg.NetworkPolicies {
+        if np.Namespace != pod.Namespace || !selects(np.Selector, pod.Labels) || !hasType(np.Types(), policyType) {
+            continue
+        }
+        isolated = true
+        
+        rules, peerField := np.Ingress, "from"
+        if policyType == "Egress" {
+            rules, peerField = np.Egress, "to"
+        }
+        for j, rule := range rules {
+            peers := rule.From
+            if policyType == "Egress" {
+                peers = rule.To
+            }
+            if !policyPortsCarry(rule.Ports, protocol, port) || !peersInclude(peers, np.Namespace, peer) {
+                continue
+            }
+            return &Step{
+                Layer:     LayerNetworkPolicy,
+                Direction: strings.ToLower(policyType),
+                Name:      np.Namespace + "/" + np.Name,
+                Path:      fmt.Sprintf("networkPolicies[%d].%s[%d]", i, strings.ToLower(policyType), j),
+                Action:    "allow",
+                Detail:    fmt.Sprintf("%s %s", peerField, peer),
+            }, true, ""
+        }
+    }
+    if isolated {
+        return nil, false, fmt.Sprintf("network policies isolate %s for %s and none allow %s", pod, strings.ToLower(policyType), peer)
+    }
+    return nil, true, ""
+}
+
+// ruleCarries reports whether a rule's protocol and ports cover the flow.
+// A rule naming an application protocol without ports covers its port.
+func ruleCarries(ruleProtocol string, ports []string, protocol string, port int) bool {
+    transport, ok := config.TransportProtocol(ruleProtocol)
+    if !ok || transport != "all" && transport != protocol {
+        return false
+    }
+    if len(ports) == 0 {
+        if appPort, ok := config.ApplicationPort(ruleProtocol); ok {
+            return appPort == port
+        }
+        return true
+    }
+    return portIn(ports, port)
+}
+
+func portIn(ports []string, port int) bool {
+    for _, p := range ports {
+        from, to, err := config.ParsePortRange(strings.TrimSpace(p))
+        if err == nil && from <= port && port <= to {
+            return true
+        }
+    }
+    return false
+}
+
+// sourceCovers reports whether a rule's source, a CIDR or a security group
+// reference, admits every address of the endpoint; Reachable splits ranges
+// so each piece lies wholly inside or outside it. Endpoints other than
+// address ranges have no known addresses, so only a range covering the
+// whole internet admits them.
+func sourceCovers(source string, e Endpoint) bool {
+    prefix, err := netip.ParsePrefix(strings.TrimSpace(source))
+    if err != nil {
+        return e.Kind == KindSecurityGroup && (source == e.Name || e.GroupID != "" && source == e.GroupID)
+    }
+    if e.Kind == KindCIDR {
+        return config.CIDRWithin(e.Prefix.String(), []string{prefix.Masked().String()})
+    }
+    return prefix.Bits() == 0
+}
+
+// peerRanges is the ranges a firewall rule matches the far end of traffic
+// in direction against. Egress rules without destination ranges match
+// every destination, as in GCP.
+func peerRanges(fw config.FirewallRule, direction string) []string {
+    if !strings.EqualFold(direction, "EGRESS") {
+        return fw.SourceRanges
+    }
+    if len(fw.DestinationRanges) == 0 {
+        return []string{"0.0.0.0/0"}
+    }
+    return fw.DestinationRanges
+}
+
+func targets(tags []string, tag string) bool {
+    if len(tags) == 0 {
+        return true
+    }
+    for _, t := range tags {
+        if t == tag {
+            return true
+        }
+    }
+    return false
+}
+
+// selects reports whether a label selector matches labels; an empty
+// selector matches everything
+func selects(selector, labels map[string]string) bool {
+    for key, value := range selector {
+        if labels[key] != value {
+            return false
+        }
+    }
+    return true
+}
+
+func hasType(types []string, policyType string) bool {
+    for _, t := range types {
+        if t == policyType {
+            return true
+        }
+    }
+    return false
+}
+
+// policyPortsCarry matches network policy ports, which default to TCP and
+// to every port. Named ports need the pod spec and never match.
+func policyPortsCarry(ports []config.NetworkPolicyPort, protocol string, port int) bool {
+    if len(ports) == 0 {
+        return true
+    }
+    for _, p := range ports {
+        proto := strings.ToLower(p.Protocol)
+        if proto == "" {
+            proto = "tcp"
+        }
+        if proto != protocol {
+            continue
+        }
+        if p.Port == "" || portIn([]string{p.Port}, port) {
+            return true
+        }
+    }
+    return false
+}
+
+// peersInclude matches a network policy rule's peers, where no peers
+// means every peer. Namespaces are matched by the kubernetes.io/
+// metadata.name label Kubernetes gives them.
+func peersInclude(peers []config.NetworkPolicyPeer, namespace string, e Endpoint) bool {
+    if len(peers) == 0 {
+        return true
+    }
+    for _, peer := range peers {
+        if peer.IPBlock != nil {
+            if !sourceCovers(peer.IPBlock.CIDR, e) {
+                continue
+            }
+            if e.Kind == KindCIDR && config.CIDRsOverlap([]string{e.Prefix.String()}, peer.IPBlock.Except) {
+                continue
+            }
+            return true
+        }
+        if e.Kind != KindPod {
+            continue
+        }
+        if peer.NamespaceSelector != nil {
+            if !selects(peer.NamespaceSelector, map[string]string{"kubernetes.io/metadata.name": e.Namespace}) {
+                continue
+            }
+        } else if e.Namespace != namespace {
+            continue
+        }
+        if selects(peer.PodSelector, e.Labels) {
+            return true
+        }
+    }
+    return false
+}
+
+func action(a string) string {
+    if a == "" {
+        return "allow"
+    }
+    return strings.ToLower(a)
+}
+
+func portList(ports []string) string {
+    if len(ports) == 0 {
+        return "all ports"
+    }
+    return strings.Join(ports, ",")
+}
+
+// ParseFlow reads a protocol and port written as tcp/3306, or an
+// application protocol such as mysql standing for its own
+func ParseFlow(s string) (protocol string, port int, err error) {
+    protocol, portText, found := strings.Cut(strings.ToLower(s), "/")
+    if !found {
+        appPort, ok := config.ApplicationPort(protocol)
+        if !ok {
+            return "", 0, fmt.Errorf("invalid flow %q: write protocol/port, such as tcp/443", s)
+        }
+        transport, _ := config.TransportProtocol(protocol)
+        return transport, appPort, nil
+    }
+    if transport, ok := config.TransportProtocol(protocol); ok {
+        protocol = transport
+    }
+    if port, err = strconv.Atoi(portText); err != nil || port < 0 || port > 65535 {
+        return "", 0, fmt.Errorf("invalid flow %q: %q is not a port", s, portText)
+    }
+    return protocol, port, nil
+}
+
+// pkg/reach/delta.go
+package reach
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/netip"
+    "sort"
+    "strings"
+    
+    "netgit/pkg/config"
+    "netgit/pkg/diff"
+)
+
+// Change is a flow that a new config allows and the old one blocked, or
+// the other way round. Except lists the prefixes of an address range the
+// change leaves alone, when it is not all of it. Chain and Reason are the
+// new config's answer for the part that changed.
+type Change struct {
+    Source      string   `json:"source"`
+    Destination string   `json:"destination"`
+    Protocol    string   `json:"protocol"`
+    Ports       string   `json:"ports"`
+    Allowed     bool     `json:"allowed"`
+    Except      []string `json:"except,omitempty"`
+    Chain       []Step   `json:"chain,omitempty"`
+    Reason      string   `json:"reason,omitempty"`
+}
+
+// Delta is the reachability difference between two revisions
+type Delta struct {
+    From    string   `json:"from"`
+    To      string   `json:"to"`
+    Changes []Change `json:"changes"`
+}
+
+// Compare lists the flows whose reachability differs between two configs.
+// It asks about every endpoint the configs name, and the ranges their
+// rules add up to, for every protocol their rules carry, once per run of
+// ports that no rule splits, and compares the parts of each range allowed.
+// Ports whose answers agree are listed together. A range whose change lies
+// wholly in smaller ranges that changed gives way to them, and a newly
+// allowed range hides the ranges inside it that the same rules allow.
+func Compare(old, new config.NetworkConfig) []Change {
+    before, after := New(old), New(new)
+    sources, destinations := endpoints(old, new)
+    var flows [][2]Endpoint
+    for _, dst := range destinations {
+        for _, src := range sources {
+            if pairs(src, dst) {
+                flows = append(flows, [2]Endpoint{src, dst})
+            }
+        }
+    }
+    
+    changes := []Change{}
+    for _, protocol := range protocols(old, new) {
+        found := make([][]Change, len(flows))
+        ports := make([][][]portRun, len(flows))
+        index := make([]map[string]int, len(flows))
+        for _, run := range portRuns(protocol, old, new) {
+            var moved []move
+            for i, f := range flows {
+                was := before.Reachable(f[0], f[1], protocol, run.from)
+                is := after.Reachable(f[0], f[1], protocol, run.from)
+                moved = append(moved, moves(after, f, i, protocol, run.from, was, is)...)
+            }
+            
+            for _, m := range explained(moved) {
+                i := m.index
+                if index[i] == nil {
+                    index[i] = map[string]int{}
+                }
+                key := fmt.Sprint(m.allowed, m.except, m.result.Reason, m.result.Chain)
+                j, ok := index[i][key]
+                if !ok {
+                    j = len(found[i])
+                    index[i][key] = j
+                    found[i] = append(found[i], Change{
+                        Source:      m.flow[0].String(),
+                        Destination: m.flow[1].String(),
+                        Protocol:    protocol,
+                        Allowed:     m.allowed,
+                        Except:      m.except,
+                        Chain:       m.result.Chain,
+                        Reason:      m.result.Reason,
+                    })
+                    ports[i] = append(ports[i], nil)
+                }
+                if n := len(ports[i][j]); n > 0 && ports[i][j][n-1].to+1 == run.from {
+                    ports[i][j][n-1].to = run.to
+                } else {
+                    ports[i][j] = append(ports[i][j], run)
+                }
+            }
+        }
+        
+        for i := range flows {
+            for j := range found[i] {
+                var list []string
+                for _, run := range ports[i][j] {
+                    list = append(list, run.String())
+                }
+                found[i][j].Ports = strings.Join(list, ",")
+            }
+            changes = append(changes, found[i]...)
+        }
+    }
+    return changes
+}
+
+// move is what changed for a flow on one run of ports: the prefixes of its
+// address range newly allowed or newly blocked, the prefixes left alone,
+// and the new config's answer for what moved. Flows without a range end
+// move as a whole.
+type move struct {
+    flow    [2]Endpoint
+    index   int
+    allowed bool
+    moved   []string
+    except  []string
+    result  Result
+}
+
+// rangeEnd is the index of the flow's address range end, or -1
+func rangeEnd(flow [2]Endpoint) int {
+    switch {
+    case flow[0].Kind == KindCIDR:
+        return 0
+    case flow[1].Kind == KindCIDR:
+        return 1
+    }
+    return -1
+}
+
+// span is the move's address range, and its other end with the side the
+// range is on
+func (m move) span() (netip.Prefix, string, bool) {
+    end := rangeEnd(m.flow)
+    if end < 0 {
+        return netip.Prefix{}, "", false
+    }
+    other := m.flow[1-end]
+    return m.flow[end].Prefix, fmt.Sprintf("%d %s/%s", end, other.Kind, other), true
+}
+
+// moves compares two answers for a flow
+func moves(model *Model, flow [2]Endpoint, index int, protocol string, port int, was, is Result) []move {
+    end := rangeEnd(flow)
+    if end < 0 {
+        if was.Allowed == is.Allowed {
+            return nil
+        }
+        return []move{{flow: flow, index: index, allowed: is.Allowed, result: is}}
+    }
+    
+    whole := []string{flow[end].Prefix.String()}
+    allowedPart := func(r Result) []string {
+        if r.Allowed {
+            return whole
+        }
+        return r.Partial
+    }
+    wasAllowed, isAllowed := allowedPart(was), allowedPart(is)
+    
+    var found []move
+    for _, allowed := range []bool{true, false} {
+        moved := subtract(isAllowed, wasAllowed)
+        if !allowed {
+            moved = subtract(wasAllowed, isAllowed)
+        }
+        if len(moved) == 0 {
+            continue
+        }
+        var results []Result
+        for _, prefix := range moved {
+            piece := flow
+            piece[end].Prefix = netip.MustParsePrefix(prefix)
+            results = append(results, model.Reachable(piece[0], piece[1], protocol, port))
+        }
+        m := move{flow: flow, index: index, allowed: allowed, moved: moved, except: subtract(whole, moved)}
+        m.result = Result{Allowed: allowed, Chain: chain(results)}
+        if !allowed {
+            m.result.Reason = reason(results)
+        }
+        found = append(found, m)
+    }
+    return found
+}
+
+// subtract lists the addresses of the prefixes a outside those of b
+func subtract(a, b []string) []string {
+    var cuts []netip.Prefix
+    for _, c := range b {
+        cuts = append(cuts, netip.MustParsePrefix(c))
+    }
+    var rest []string
+    for _, c := range a {
+        for _, piece := range splitPrefix(netip.MustParsePrefix(c), cuts) {
+            if !config.CIDRWithin(piece.String(), b) {
+                rest = append(rest, piece.String())
+            }
+        }
+    }
+    return config.NormalizeCIDRs(rest)
+}
+
+// explained drops the moves others on the same run of ports account for: a
+// range whose move lies wholly in the largest smaller ranges that moved,
+// when they describe it with fewer exceptions, and then a newly allowed
+// range inside a kept one the same rules let through
+func explained(moves []move) []move {
+    inside := func(c, o move) bool {
+        cp, cother, ok := c.span()
+        op, other, ok2 := o.span()
+        return ok && ok2 && cother == other && c.allowed == o.allowed && cp != op &&
+            config.CIDRWithin(cp.String(), []string{op.String()})
+    }
+    
+    covered := make([]bool, len(moves))
+    for i, o := range moves {
+        if len(o.except) == 0 {
+            continue
+        }
+        var smaller []string
+        cost := 0
+        for _, c := range moves {
+            if !inside(c, o) {
+                continue
+            }
+            largest := true
+            for _, d := range moves {
+                if inside(d, o) && inside(c, d) {
+                    largest = false
+                }
+            }
+            if largest {
+                prefix, _, _ := c.span()
+                smaller = append(smaller, prefix.String())
+                cost += 1 + len(c.except)
+            }
+        }
+        covered[i] = len(smaller) > 0 && cost < 1+len(o.except)
+        for _, prefix := range o.moved {
+            if !config.CIDRWithin(prefix, smaller) {
+                covered[i] = false
+            }
+        }
+    }
+    
+    var kept []move
+    for i, c := range moves {
+        if covered[i] {
+            continue
+        }
+        implied := false
+        for j, o := range moves {
+            if !covered[j] && c.allowed && inside(c, o) && stepsWithin(c.result.Chain, o.result.Chain) {
+                implied = true
+                break
+            }
+        }
+        if !implied {
+            kept = append(kept, c)
+        }
+    }
+    return kept
+}
+
+func stepsWithin(steps, of []Step) bool {
+    for _, step := range steps {
+        found := false
+        for _, other := range of {
+            if step == other {
+                found = true
+            }
+        }
+        if !found {
+            return false
+        }
+    }
+    return true
+}
+
+// pairs reports whether the model can say anything about traffic from src
+// to dst: tags and pods have no known addresses, so only rules naming
+// them directly apply
+func pairs(src, dst Endpoint) bool {
+    switch dst.Kind {
+    case KindSecurityGroup:
+        return (src.Kind == KindCIDR || src.Kind == KindSecurityGroup) && src.Name != dst.Name
+    case KindTag:
+        return src.Kind == KindCIDR
+    case KindPod:
+        return src.Kind == KindCIDR || src.Kind == KindPod && src.Name != dst.Name
+    case KindCIDR:
+        return src.Kind == KindTag || src.Kind == KindPod
+    }
+    return false
+}
+
+
+// endpointSet is a list of endpoints without repeats
+type endpointSet struct {
+    list []Endpoint
+    seen map[string]bool
+}
+
+func (s *endpointSet) add(e Endpoint) {
+    if s.seen == nil {
+        s.seen = map[string]bool{}
+    }
+    if key := e.Kind + "/" + e.String(); !s.seen[key] {
+        s.seen[key] = true
+        s.list = append(s.list, e)
+    }
+}
+
+func (s *endpointSet) cidr(c string) {
+    if prefix, err := netip.ParsePrefix(strings.TrimSpace(c)); err == nil {
+        prefix = prefix.Masked()
+        s.add(Endpoint{Kind: KindCIDR, Name: prefix.String(), Prefix: prefix})
+    }
+}
+
+// ranges adds the ranges a config names, the ranges they add up to and
+// the whole of each address family, so a change spread over several rules
+// shows as the range it opens
+func (s *endpointSet) ranges(cidrs []string) {
+    for _, c := range cidrs {
+        s.cidr(c)
+    }
+    for _, c := range config.NormalizeCIDRs(cidrs) {
+        s.cidr(c)
+    }
+    for _, c := range cidrs {
+        if prefix, err := netip.ParsePrefix(strings.TrimSpace(c)); err == nil && prefix.Addr().Is4() {
+            s.cidr("0.0.0.0/0")
+        } else if err == nil {
+            s.cidr("::/0")
+        }
+    }
+}
+
+// endpoints collects the endpoints either config names, as the sources and
+// destinations of the flows to compare
+func endpoints(configs ...config.NetworkConfig) (sources, destinations []Endpoint) {
+    var src, dst endpointSet
+    for _, cfg := range configs {
+        var srcRanges, dstRanges []string
+        for _, sg := range cfg.SecurityGroups {
+            group := Endpoint{Kind: KindSecurityGroup, Name: sg.Name, GroupID: sg.ID}
+            src.add(group)
+            dst.add(group)
+            for _, rule := range sg.Rules {
+                srcRanges = append(srcRanges, rule.Sources...)
+            }
+        }
+        
+        for _, fw := range cfg.FirewallRules {
+            for _, tag := range fw.TargetTags {
+                src.add(Endpoint{Kind: KindTag, Name: tag})
+                dst.add(Endpoint{Kind: KindTag, Name: tag})
+            }
+            srcRanges = append(srcRanges, fw.SourceRanges...)
+            if strings.EqualFold(fw.Direction, "EGRESS") {
+                dstRanges = append(dstRanges, peerRanges(fw, fw.Direction)...)
+            }
+        }
+        
+        for _, np := range cfg.NetworkPolicies {
+            selected := podEndpoint(np.Namespace, np.Selector)
+            src.add(selected)
+            dst.add(selected)
+            for _, rule := range np.Ingress {
+                for _, peer := range rule.From {
+                    if peer.IPBlock != nil {
+                        srcRanges = append(srcRanges, peer.IPBlock.CIDR)
+                    } else if pod, ok := peerEndpoint(np.Namespace, peer); ok {
+                        src.add(pod)
+                    }
+                }
+            }
+            for _, rule := range np.Egress {
+                for _, peer := range rule.To {
+                    if peer.IPBlock != nil {
+                        dstRanges = append(dstRanges, peer.IPBlock.CIDR)
+                    } else if pod, ok := peerEndpoint(np.Namespace, peer); ok {
+                        dst.add(pod)
+                    }
+                }
+            }
+        }
+        src.ranges(srcRanges)
+        dst.ranges(dstRanges)
+    }
+    return src.list, dst.list
+}
+
+func podEndpoint(namespace string, labels map[string]string) Endpoint {
+    var pairs []string
+    for key, value := range labels {
+        pairs = append(pairs, key+"="+value)
+    }
+    sort.Strings(pairs)
+    return Endpoint{
+        Kind:      KindPod,
+        Name:      fmt.Sprintf("pod:%s/%s", namespace, strings.Join(pairs, ",")),
+        Namespace: namespace,
+        Labels:    labels,
+    }
+}
+
+// peerEndpoint is the pods a peer selects, when their namespace is known
+func peerEndpoint(namespace string, peer config.NetworkPolicyPeer) (Endpoint, bool) {
+    if peer.NamespaceSelector != nil {
+        name, ok := peer.NamespaceSelector["kubernetes.io/metadata.name"]
+        if !ok || len(peer.NamespaceSelector) > 1 {
+            return Endpoint{}, false
+        }
+        namespace = name
+    }
+    return podEndpoint(namespace, peer.PodSelector), true
+}
+
+// protocols lists the transport protocols the configs' rules carry; rules
+// for every protocol stand for tcp and udp
+func protocols(configs ...config.NetworkConfig) []string {
+    seen := map[string]bool{}
+    add := func(protocol string) {
+        transport, ok := config.TransportProtocol(protocol)
+        switch {
+        case !ok:
+        case transport == "all":
+            seen["tcp"], seen["udp"] = true, true
+        default:
+            seen[transport] = true
+        }
+    }
+    for _, cfg := range configs {
+        for _, sg := range cfg.SecurityGroups {
+            for _, rule := range sg.Rules {
+                add(rule.Protocol)
+            }
+        }
+        for _, fw := range cfg.FirewallRules {
+            add(fw.Protocol)
+        }
+        for _, np := range cfg.NetworkPolicies {
+            rules := append(append([]config.NetworkPolicyRule{}, np.Ingress...), np.Egress...)
+            for _, rule := range rules {
+                if len(rule.Ports) == 0 {
+                    add("tcp")
+                }
+                for _, p := range rule.Ports {
+                    if p.Protocol == "" {
+                        add("tcp")
+                    } else {
+                        add(p.Protocol)
+                    }
+                }
+            }
+        }
+    }
+    
+    var list []string
+    for p := range seen {
+        list = append(list, p)
+    }
+    sort.Strings(list)
+    return list
+}
+
+type portRun struct {
+    from, to int
+}
+
+func (r portRun) String() string {
+    if r.from == r.to {
+        return fmt.Sprint(r.from)
+    }
+    return fmt.Sprintf("%d-%d", r.from, r.to)
+}
+
+// portRuns splits the port space at every boundary of a port range the
+// configs give for protocol, so each run is treated alike by every rule
+func portRuns(protocol string, configs ...config.NetworkConfig) []portRun {
+    cuts := map[int]bool{0: true, 65536: true}
+    cut := func(ruleProtocol string, ports []string) {
+        transport, ok := config.TransportProtocol(ruleProtocol)
+        if !ok || transport != protocol && transport != "all" {
+            return
+        }
+        if len(ports) == 0 {
+            if port, ok := config.ApplicationPort(ruleProtocol); ok {
+                cuts[port], cuts[port+1] = true, true
+            }
+            return
+        }
+        for _, p := range ports {
+            if from, to, err := config.ParsePortRange(strings.TrimSpace(p)); err == nil {
+                cuts[from], cuts[to+1] = true, true
+            }
+        }
+    }
+    
+    for _, cfg := range configs {
+        for _, sg := range cfg.SecurityGroups {
+            for _, rule := range sg.Rules {
+                cut(rule.Protocol, rule.Ports)
+            }
+        }
+        for _, fw := range cfg.FirewallRules {
+            cut(fw.Protocol, fw.Ports)
+        }
+        for _, np := range cfg.NetworkPolicies {
+            rules := append(append([]config.NetworkPolicyRule{}, np.Ingress...), np.Egress...)
+            for _, rule := range rules {
+                for _, p := range rule.Ports {
+                    if p.Protocol == "" {
+                        cut("tcp", []string{p.Port})
+                    } else {
+                        cut(p.Protocol, []string{p.Port})
+                    }
+                }
+            }
+        }
+    }
+    
+    var points []int
+    for p := range cuts {
+        points = append(points, p)
+    }
+    sort.Ints(points)
+    var runs []portRun
+    for i := 0; i+1 < len(points); i++ {
+        runs = append(runs, portRun{points[i], points[i+1] - 1})
+    }
+    return runs
+}
+
+const (
+    colorReset = "\033[0m"
+    colorRed   = "\033[31m"
+    colorGreen = "\033[32m"
+)
+
+// Format renders the delta in one of the diff formats
+func (d *Delta) Format(format string) (string, error) {
+    switch format {
+    case "", diff.FormatUnified, diff.FormatColor:
+    case diff.FormatJSON:
+        data, err := json.MarshalIndent(d, "", "  ")
+        if err != nil {
+            return "", err
+        }
+        return string(data) + "\n", nil
+    default:
+        return "", fmt.Errorf("unknown diff format: %s", format)
+    }
+    
+    if len(d.Changes) == 0 {
+        return fmt.Sprintf("No reachability changes between %s and %s\n", d.From, d.To), nil
+    }
+    
+    var sb strings.Builder
+    for _, c := range d.Changes {
+        except := ""
+        if len(c.Except) > 0 {
+            except = " apart from " + strings.Join(c.Except, ", ")
+        }
+        line := fmt.Sprintf("+ %s -> %s %s/%s newly allowed%s", c.Source, c.Destination, c.Protocol, c.Ports, except)
+        color := colorGreen
+        if !c.Allowed {
+            line = fmt.Sprintf("- %s -> %s %s/%s newly blocked%s: %s", c.Source, c.Destination, c.Protocol, c.Ports, except, c.Reason)
+            color = colorRed
+        }
+        if format == diff.FormatColor {
+            line = color + line + colorReset
+        }
+        sb.WriteString(line + "\n")
+        for _, step := range c.Chain {
+            sb.WriteString("      " + step.String() + "\n")
+        }
+    }
+    return sb.String(), nil
+}
+
+// pkg/deploy/deployer.go
+package deploy
+
+import (
+    "fmt"
+    "time"
+    
+    "netgit/pkg/config"
+)
+
+type Deployer interface {
+    DryRun(config config.NetworkConfig) error
+    Apply(config config.NetworkConfig) error
+    Rollback(config config.NetworkConfig) error
+    CanaryDeploy(config config.NetworkConfig, percentage int) error
+    ExpandCanary(config config.NetworkConfig) error
+}
+
+type Deployment struct {
+    CommitHash  string    `json:"commit_hash"`
+    Target      string    `json:"target"`
+    Environment string    `json:"environment,omitempty"`
+    Canary      bool      `json:"canary"`
+    Timestamp   time.Time `json:"timestamp"`
+    Status      string    `json:"status"`
+}
+
+func GetDeployer(target string) (Deployer, error) {
+    switch target {
+    case "mock":
+        return NewMockDeployer(), nil
+    case "aws":
+        return NewAWSDeployer(), nil
+    case "gcp":
+        return NewGCPDeployer(), nil
+    case "azure":
+        return NewAzureDeployer(), nil
+    case "k8s", "kubernetes":
+        return NewKubernetesDeployer(), nil
+    default:
+        return nil, fmt.Errorf("unknown deployment target: %s", target)
+    }
+}
+
+// Mock Deployer for testing
+type MockDeployer struct{}
+
+func NewMockDeployer() *MockDeployer {
+    return &MockDeployer{}
+}
+
+func (m *MockDeployer) DryRun(config config.NetworkConfig) error {
+    fmt.Printf("Mock: Dry run for %d security groups, %d policies, %d firewall rules\n", 
+        len(config.SecurityGroups), len(config.NetworkPolicies), len(config.FirewallRules))
+    return nil
+}
+
+func (m *MockDeployer) Apply(config config.NetworkConfig) error {
+    fmt.Printf("Mock: Applying configuration...\n")
+    time.Sleep(2 * time.Second) // Simulate deployment time
+    fmt.Printf("Mock: Applied %d security groups, %d policies, %d firewall rules\n", 
+        len(config.SecurityGroups), len(config.NetworkPolicies), len(config.FirewallRules))
+    return nil
+}
+
+func (m *MockDeployer) Rollback(config config.NetworkConfig) error {
+    fmt.Printf("Mock: Rolling back configuration...\n")
+    time.Sleep(1 * time.Second)
+    fmt.Printf("Mock: Rollback completed\n")
+    return nil
+}
+
+func (m *MockDeployer) CanaryDeploy(config config.NetworkConfig, percentage int) error {
+    fmt.Printf("Mock: Deploying to %d%% of targets...\n", percentage)
+    time.Sleep(1 * time.Second)
+    return nil
+}
+
+func (m *MockDeployer) ExpandCanary(config config.NetworkConfig) error {
+    fmt.Printf("Mock: Expanding canary to 100%%...\n")
+    time.Sleep(1 * time.Second)
+    return nil
+}
+
+// AWS Deployer (stub implementation)
+type AWSDeployer struct{}
+
+func NewAWSDeployer() *AWSDeployer {
+    return &AWSDeployer{}
+}
+
+func (a *AWSDeployer) DryRun(config config.NetworkConfig) error {
+    fmt.Printf("AWS: Validating security groups and VPC configuration\n")
+    return nil
+}
+
+func (a *AWSDeployer) Apply(config config.NetworkConfig) error {
+    fmt.Printf("AWS: Applying security groups to VPC\n")
+    for _, sg := range config.SecurityGroups {
+        fmt.Printf("AWS: Creating/updating security group %s\n", sg.Name)
+    }
+    return nil
+}
+
+func (a *AWSDeployer) Rollback(config config.NetworkConfig) error {
+    fmt.Printf("AWS: Rolling back security group changes\n")
+    return nil
+}
+
+func (a *AWSDeployer) CanaryDeploy(config config.NetworkConfig, percentage int) error {
+    fmt.Printf("AWS: Canary deploy to %d%% of instances\n", percentage)
+    return nil
+}
+
+func (a *AWSDeployer) ExpandCanary(config config.NetworkConfig) error {
+    fmt.Printf("AWS: Expanding to all instances\n")
+    return nil
+}
+
+// GCP Deployer (stub implementation)
+type GCPDeployer struct{}
+
+func NewGCPDeployer() *GCPDeployer {
+    return &GCPDeployer{}
+}
+
+func (g *GCPDeployer) DryRun(config config.NetworkConfig) error {
+    fmt.Printf("GCP: Validating firewall rules\n")
+    return nil
+}
+
+func (g *GCPDeployer) Apply(config config.NetworkConfig) error {
+    fmt.Printf("GCP: Applying firewall rules\n")
+    for _, rule := range config.FirewallRules {
+        fmt.Printf("GCP: Creating/updating firewall rule %s\n", rule.Name)
+    }
+    return nil
+}
+
+func (g *GCPDeployer) Rollback(config config.NetworkConfig) error {
+    fmt.Printf("GCP: Rolling back firewall rules\n")
+    return nil
+}
+
+func (g *GCPDeployer) CanaryDeploy(config config.NetworkConfig, percentage int) error {
+    fmt.Printf("GCP: Canary deploy to %d%% of targets\n", percentage)
+    return nil
+}
+
+func (g *GCPDeployer) ExpandCanary(config config.NetworkConfig) error {
+    fmt.Printf("GCP: Expanding to all targets\n")
+    return nil
+}
+
+// Azure Deployer (stub implementation)
+type AzureDeployer struct{}
+
+func NewAzureDeployer() *AzureDeployer {
+    return &AzureDeployer{}
+}
+
+func (az *AzureDeployer) DryRun(config config.NetworkConfig) error {
+    fmt.Printf("Azure: Validating Network Security Groups\n")
+    return nil
+}
+
+func (az *AzureDeployer) Apply(config config.NetworkConfig) error {
+    fmt.Printf("Azure: Applying Network Security Groups\n")
+    return nil
+}
+
+func (az *AzureDeployer) Rollback(config config.NetworkConfig) error {
+    fmt.Printf("Azure: Rolling back NSG changes\n")
+    return nil
+}
+
+func (az *AzureDeployer) CanaryDeploy(config config.NetworkConfig, percentage int) error {
+    fmt.Printf("Azure: Canary deploy to %d%% of resource groups\n", percentage)
+    return nil
+}
+
+func (az *AzureDeployer) ExpandCanary(config config.NetworkConfig) error {
+    fmt.Printf("Azure: Expanding to all resource groups\n")
+    return nil
+}
+
+// Kubernetes Deployer (stub implementation)
+type KubernetesDeployer struct{}
+
+func NewKubernetesDeployer() *KubernetesDeployer {
+    return &KubernetesDeployer{}
+}
+
+func (k *KubernetesDeployer) DryRun(config config.NetworkConfig) error {
+    fmt.Printf("Kubernetes: Validating network policies\n")
+    return nil
+}
+
+func (k *KubernetesDeployer) Apply(config config.NetworkConfig) error {
+    fmt.Printf("Kubernetes: Applying network policies\n")
+    for _, policy := range config.NetworkPolicies {
+        fmt.Printf("Kubernetes: Creating/updating NetworkPolicy %s in namespace %s\n", 
+            policy.Name, policy.Namespace)
+    }
+    return nil
+}
+
+func (k *KubernetesDeployer) Rollback(config config.NetworkConfig) error {
+    fmt.Printf("Kubernetes: Rolling back network policies\n")
+    return nil
+}
+
+func (k *KubernetesDeployer) CanaryDeploy(config config.NetworkConfig, percentage int) error {
+    fmt.Printf("Kubernetes: Canary deploy to %d%% of namespaces\n", percentage)
+    return nil
+}
+
+func (k *KubernetesDeployer) ExpandCanary(config config.NetworkConfig) error {
+    fmt.Printf("Kubernetes: Expanding to all namespaces\n")
+    return nil
+}
+
+// pkg/hooks/hooks.go
+package hooks
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "regexp"
+    "time"
+    
+    "gopkg.in/yaml.v3"
+    "netgit/pkg/config"
+    "netgit/pkg/deploy"
+    "netgit/pkg/policy"
+)
+
+// Hook points. A pre hook that fails aborts the operation; a post hook
+// runs once it is done, so its failure is only reported.
+const (
+    PreCommit  = "pre-commit"
+    CommitMsg  = "commit-msg"
+    PostCommit = "post-commit"
+    PreDeploy  = "pre-deploy"
+    PostDeploy = "post-deploy"
+)
+
+// ChecksFile lists the built-in checks to run at each hook point
+const ChecksFile = "hooks.yaml"
+
+// Event is the JSON a hook reads on stdin: the commit being made, with
+// the files it records, or the deployment
+type Event struct {
+    Hook       string             `json:"hook"`
+    Commit     *Commit            `json:"commit,omitempty"`
+    Deployment *deploy.Deployment `json:"deployment,omitempty"`
+    Files      []File             `json:"files,omitempty"`
+}
+
+// Commit describes a commit. Hash and Timestamp are only known once it is
+// made, after pre-commit and commit-msg.
+type Commit struct {
+    Hash      string     `json:"hash,omitempty"`
+    Parent    string     `json:"parent,omitempty"`
+    Branch    string     `json:"branch,omitempty"`
+    Message   string     `json:"message"`
+    Author    string     `json:"author"`
+    Timestamp *time.Time `json:"timestamp,omitempty"`
+}
+
+// File is a config file of the commit or deployment
+type File struct {
+    Source string               `json:"source"`
+    Config config.NetworkConfig `json:"config"`
+}
+
+// Files pairs configs with the files they were loaded from
+func Files(configs []config.NetworkConfig) []File {
+    files := make([]File, 0, len(configs))
+    for _, cfg := range configs {
+        if !cfg.Fragment {
+            files = append(files, File{Source: cfg.Source, Config: cfg})
+        }
+    }
+    return files
+}
+
+// Check is a built-in check run at a hook point
+type Check struct {
+    // Check names the check: message-pattern requires the commit message
+    // to match Pattern, lint fails on lint findings of Severity or above
+    Check    string `yaml:"check"`
+    Pattern  string `yaml:"pattern,omitempty"`
+    Severity string `yaml:"severity,omitempty"`
+    // Message replaces the check's own explanation when it fails
+    Message string `yaml:"message,omitempty"`
+}
+
+// Runner runs the hooks of a repository, found in .netgit/hooks: an
+// executable named after the hook point, and the built-in checks
+// ChecksFile lists for it. Files there that are not executable are
+// skipped, as git does.
+type Runner struct {
+    root   string
+    dir    string
+    checks map[string][]Check
+    // Stdout and Stderr receive what executable hooks print
+    Stdout, Stderr io.Writer
+}
+
+// Load reads the hooks of the repository at repoPath. A repository
+// without hooks gets a Runner that does nothing.
+func Load(repoPath string) (*Runner, error) {
+    r := &Runner{
+        root:   repoPath,
+        dir:    filepath.Join(repoPath, ".netgit", "hooks"),
+        checks: map[string][]Check{},
+        Stdout: os.Stdout,
+        Stderr: os.Stderr,
+    }
+    
+    file := filepath.Join(r.dir, ChecksFile)
+    data, err := ioutil.ReadFile(file)
+    if os.IsNotExist(err) {
+        return r, nil
+    } else if err != nil {
+        return nil, err
+    }
+    decoder := yaml.NewDecoder(bytes.NewReader(data))
+    decoder.KnownFields(true)
+    if err := decoder.Decode(&r.checks); err != nil && err != io.EOF {
+        return nil, fmt.Errorf("%s: %v", file, err)
+    }
+    
+    for hook, checks := range r.checks {
+        switch hook {
+        case PreCommit, CommitMsg, PostCommit, PreDeploy, PostDeploy:
+        default:
+            return nil, fmt.Errorf("%s: unknown hook %q", file, hook)
+        }
+        for _, c := range checks {
+            if err := c.validate(hook); err != nil {
+                return nil, fmt.Errorf("%s: %s: %v", file, hook, err)
+            }
+        }
+    }
+    return r, nil
+}
+
+func (c Check) validate(hook string) error {
+    switch c.Check {
+    case "message-pattern":
+        if hook != CommitMsg {
+            return fmt.Errorf("message-pattern only runs at %s", CommitMsg)
+        }
+        if _, err := regexp.Compile(c.Pattern); err != nil || c.Pattern == "" {
+            return fmt.Errorf("message-pattern needs a valid pattern, not %q", c.Pattern)
+        }
+    case "lint":
+        if c.Severity != "" && policy.SeverityRank(c.Severity) < 0 {
+            return fmt.Errorf("lint: unknown severity %q", c.Severity)
+        }
+    default:
+        return fmt.Errorf("unknown check %q", c.Check)
+    }
+    return nil
+}
+
+// Run runs the hooks of a hook point with event on stdin: the built-in
+// checks first, then the executable. It returns the first failure.
+func (r *Runner) Run(hook string, event Event) error {
+    event.Hook = hook
+    for _, c := range r.checks[hook] {
+        if err := c.run(event); err != nil {
+            return fmt.Errorf("%s hook: %v", hook, err)
+        }
+    }
+    
+    path := filepath.Join(r.dir, hook)
+    info, err := os.Stat(path)
+    if err != nil || info.IsDir() || info.Mode()&0111 == 0 {
+        return nil
+    }
+    
+    input, err := json.Marshal(event)
+    if err != nil {
+        return err
+    }
+    cmd := exec.Command(path)
+    cmd.Dir = r.root
+    cmd.Env = append(os.Environ(), "NETGIT_HOOK="+hook)
+    cmd.Stdin = bytes.NewReader(input)
+    cmd.Stdout = r.Stdout
+    cmd.Stderr = r.Stderr
+    if err := cmd.Run(); err != nil {
+        return fmt.Errorf("%s hook: %v", hook, err)
+    }
+    return nil
+}
+
+func (c Check) run(event Event) error {
+    switch c.Check {
+    case "message-pattern":
+        if event.Commit == nil || regexp.MustCompile(c.Pattern).MatchString(event.Commit.Message) {
+            return nil
+        }
+        return c.fail("commit message %q does not match %s", event.Commit.Message, c.Pattern)
+    
+    case "lint":
+        found := 0
+        for _, f := range event.Files {
+            for _, v := range policy.Lint(f.Config) {
+                if policy.SeverityRank(v.Level) >= policy.SeverityRank(c.Severity) {
+                    found++
+                }
+            }
+        }
+        if found > 0 {
+            return c.fail("%d lint findings; run netgit lint", found)
+        }
+    }
+    return nil
+}
+
+func (c Check) fail(format string, args ...interface{}) error {
+    if c.Message != "" {
+        return fmt.Errorf("%s: %s", c.Check, c.Message)
+    }
+    return fmt.Errorf("%s: "+format, append([]interface{}{c.Check}, args...)...)
+}
+
+// pkg/audit/logger.go
+package audit
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+    "time"
+    
+    "go.uber.org/zap"
+    "go.uber.org/zap/zapcore"
+)
+
+var logger *zap.Logger
+
+func init() {
+    config := zap.NewProductionConfig()
+    // stderr, so that audit events do not mix with a command's output,
+    // such as verify's JSON
+    config.OutputPaths = []string{"stderr", "audit.log"}
+    config.ErrorOutputPaths = []string{"stderr"}
+    config.EncoderConfig.TimeKey = "timestamp"
+    config.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+    
+    var err error
+    logger, err = config.Build()
+    if err != nil {
+        panic(fmt.Sprintf("Failed to initialize logger: %v", err))
+    }
+}
+
+type Event struct {
+    Action    string                 `json:"action"`
+    Timestamp time.Time              `json:"timestamp"`
+    User      string                 `json:"user"`
+    Data      map[string]interface{} `json:"data"`
+}
+
+func LogEvent(action string, data map[string]interface{}) {
+    event := Event{
+        Action:    action,
+        Timestamp: time.Now(),
+        User:      getUser(),
+        Data:      data,
+    }
+    
+    logger.Info("audit_event",
+        zap.String("action", event.Action),
+        zap.Time("timestamp", event.Timestamp),
+        zap.String("user", event.User),
+        zap.Any("data", event.Data),
+    )
+    
+    // Also write to structured JSON file
+    writeJSONLog(event)
+}
+
+func writeJSONLog(event Event) {
+    file, err := os.OpenFile("audit.json", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+    if err != nil {
+        return
+    }
+    defer file.Close()
+    
+    jsonData, _ := json.Marshal(event)
+    file.Write(jsonData)
+    file.WriteString("\n")
+}
+
+func getUser() string {
+    if user := os.Getenv("USER"); user != "" {
+        return user
+    }
+    if user := os.Getenv("USERNAME"); user != "" {
+        return user
+    }
+    return "unknown"
+}
+
+// pkg/metrics/prometheus.go
+package metrics
+
+import (
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+    CommitsTotal = promauto.NewCounter(prometheus.CounterOpts{
+        Name: "netgit_commits_total",
+        Help: "The total number of commits",
+    })
+    
+    DeploymentsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+        Name: "netgit_deployments_total",
+        Help: "The total number of deployments",
+    }, []string{"target", "status"})
+    
+    PolicyViolationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+        Name: "netgit_policy_violations_total",
+        Help: "The total number of policy violations",
+    }, []string{"rule", "severity"})
+    
+    DeploymentDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+        Name: "netgit_deployment_duration_seconds",
+        Help: "The duration of deployments",
+        Buckets: prometheus.DefBuckets,
+    }, []string{"target"})
+)
+
+// examples/sample-configs/aws-security-groups.yaml
+metadata:
+  name: "production-security-groups"
   version: "1.2.0"
   environment: "production"
-  labels:
-    team: "platform"
-    region: "us-east-1"
+  labels:
+    team: "platform"
+    region: "us-east-1"
+
+securityGroups:
+  - name: "web-tier-sg"
+    description: "Security group for web tier"
+    vpcId: "vpc-12345678"
+    rules:
+      - protocol: "https"
+        ports: ["443"]
+        sources: ["0.0.0.0/0"]
+        action: "allow"
+      - protocol: "http"
+        ports: ["80"]
+        sources: ["10.0.0.0/8"]
+        action: "allow"
+  
+  - name: "app-tier-sg"
+    description: "Security group for application tier"
+    vpcId: "vpc-12345678"
+    rules:
+      - protocol: "tcp"
+        ports: ["8080", "8443"]
+        sources: ["sg-web-tier"]
+        action: "allow"
+      - protocol: "tcp"
+        ports: ["22"]
+        sources: ["10.0.1.0/24"]
+        action: "allow"
+  
+  - name: "db-tier-sg"
+    description: "Security group for database tier"
+    vpcId: "vpc-12345678"
+    rules:
+      - protocol: "mysql"
+        ports: ["3306"]
+        sources: ["sg-app-tier"]
+        action: "allow"
+
+# examples/sample-configs/k8s-network-policies.yaml
+metadata:
+  name: "k8s-network-policies"
+  version: "1.0.0"
+  environment: "production"
+
+networkPolicies:
+  - name: "frontend-policy"
+    namespace: "default"
+    selector:
+      app: "frontend"
+    ingress:
+      - ports:
+          - protocol: "TCP"
+            port: "80"
+        from:
+          - podSelector:
+              app: "loadbalancer"
+    egress:
+      - ports:
+          - protocol: "TCP"
+            port: "8080"
+        to:
+          - podSelector:
+              app: "backend"
+  
+  - name: "backend-policy"
+    namespace: "default"
+    selector:
+      app: "backend"
+    ingress:
+      - ports:
+          - protocol: "TCP"
+            port: "8080"
+        from:
+          - podSelector:
+              app: "frontend"
+    egress:
+      - ports:
+          - protocol: "TCP"
+            port: "5432"
+        to:
+          - podSelector:
+              app: "database"
+
+# examples/sample-configs/gcp-firewall-rules.yaml
+metadata:
+  name: "gcp-firewall-rules"
+  version: "1.1.0"
+  environment: "production"
+
+firewallRules:
+  - name: "allow-web-traffic"
+    direction: "INGRESS"
+    priority: 1000
+    protocol: "tcp"
+    ports: ["80", "443"]
+    sourceRanges: ["0.0.0.0/0"]
+    targetTags: ["web-server"]
+  
+  - name: "allow-ssh-internal"
+    direction: "INGRESS"
+    priority: 1100
+    protocol: "tcp"
+    ports: ["22"]
+    sourceRanges: ["10.0.0.0/8"]
+    targetTags: ["ssh-access"]
+  
+  - name: "deny-all-ingress"
+    direction: "INGRESS"
+    priority: 65534
+    protocol: "all"
+    ports: []
+    sourceRanges: ["0.0.0.0/0"]
+    targetTags: ["secure"]
+
+# examples/policies/security-policies.json
+[
+  {
+    "name": "no-public-database",
+    "description": "Database ports must not be accessible from the internet",
+    "rule": "deny_public_database",
+    "severity": "high"
+  },
+  {
+    "name": "require-https",
+    "description": "Web traffic must use HTTPS",
+    "rule": "require_https",
+    "severity": "medium"
+  },
+  {
+    "name": "ssh-access-control",
+    "description": "SSH access must be restricted to internal networks",
+    "rule": "ssh_internal_only",
+    "severity": "high"
+  }
+]
+
+# examples/policies/remote-access.yaml
+name: remote-access-internal-only
+description: SSH and RDP may only be reached from the internal network
+severity: high
+match:
+  resource: securityGroupRule
+  protocols: [tcp]
+  ports: ["22", "3389"]
+  action: allow
+assert:
+  sources:
+    subsetOf: ["10.0.0.0/8"]
+message: "Security group '{name}' allows remote access from {value}"
+
+# tests/storage_test.go
+package tests
+
+import (
+    "encoding/json"
+    "os"
+    "path/filepath"
+    "testing"
+    
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+    "go.etcd.io/bbolt"
+    
+    "netgit/pkg/storage"
+    "netgit/pkg/config"
+)
+
+func TestRepository(t *testing.T) {
+    // Create temporary directory for testing
+    tmpDir, err := os.MkdirTemp("", "netgit-test")
+    require.NoError(t, err)
+    defer os.RemoveAll(tmpDir)
+    
+    // Test repository initialization
+    repo, err := storage.NewRepository(tmpDir)
+    require.NoError(t, err)
+    defer repo.Close()
+    
+    assert.Equal(t, tmpDir, repo.Path())
+    
+    // Test committing configuration
+    testConfig := config.NetworkConfig{
+        Metadata: config.Metadata{
+            Name:        "test-config",
+            Version:     "1.0.0",
+            Environment: "test",
+        },
+        SecurityGroups: []config.SecurityGroup{
+            {
+                Name:        "test-sg",
+                Description: "Test security group",
+                VpcId:       "vpc-test",
+                Rules: []config.Rule{
+                    {
+                        Protocol: "https",
+                        Ports:    []string{"443"},
+                        Sources:  []string{"0.0.0.0/0"},
+                        Action:   "allow",
+                    },
+                },
+            },
+        },
+    }
+    
+    commit, err := repo.Commit([]config.NetworkConfig{testConfig}, "Initial commit", "test@example.com")
+    require.NoError(t, err)
+    
+    assert.NotEmpty(t, commit.Hash)
+    assert.Equal(t, "Initial commit", commit.Message)
+    assert.Equal(t, "test@example.com", commit.Author)
+    
+    // Test getting commit back
+    retrievedCommit, err := repo.GetCommit(commit.Hash)
+    require.NoError(t, err)
+    
+    assert.Equal(t, commit.Hash, retrievedCommit.Hash)
+    assert.Equal(t, commit.Message, retrievedCommit.Message)
+    assert.Equal(t, testConfig.SecurityGroups, retrievedCommit.Config.SecurityGroups)
+}
+
+func TestContentAddressedCommits(t *testing.T) {
+    tmpDir, err := os.MkdirTemp("", "netgit-test")
+    require.NoError(t, err)
+    defer os.RemoveAll(tmpDir)
+    
+    repo, err := storage.NewRepository(tmpDir)
+    require.NoError(t, err)
+    defer repo.Close()
+    
+    testConfig := config.NetworkConfig{
+        SecurityGroups: []config.SecurityGroup{
+            {Name: "web-sg", Rules: []config.Rule{{Protocol: "tcp", Ports: []string{"443"}}}},
+            {Name: "db-sg", Rules: []config.Rule{{Protocol: "tcp", Ports: []string{"5432"}}}},
+        },
+    }
+    
+    first, err := repo.Commit([]config.NetworkConfig{testConfig}, "First", "test@example.com")
+    require.NoError(t, err)
+    second, err := repo.Commit([]config.NetworkConfig{testConfig}, "Second", "test@example.com")
+    require.NoError(t, err)
+    
+    assert.Len(t, first.Hash, 64)
+    assert.NotEqual(t, first.Hash, second.Hash)
+    assert.Equal(t, first.Tree, second.Tree)
+    assert.Equal(t, first.Hash, second.Parent)
+    
+    // Changing one group leaves the other group's blob untouched
+    testConfig.SecurityGroups[0].Rules[0].Ports = []string{"8443"}
+    third, err := repo.Commit([]config.NetworkConfig{testConfig}, "Third", "test@example.com")
+    require.NoError(t, err)
+    assert.NotEqual(t, second.Tree, third.Tree)
+    
+    retrieved, err := repo.GetCommit(third.Hash)
+    require.NoError(t, err)
+    
+    // Resources are stored in canonical order
+    assert.Equal(t, "db-sg", retrieved.Config.SecurityGroups[0].Name)
+    assert.Equal(t, []string{"8443"}, retrieved.Config.SecurityGroups[1].Rules[0].Ports)
+}
+
+func TestLegacyRepositoryMigration(t *testing.T) {
+    tmpDir, err := os.MkdirTemp("", "netgit-test")
+    require.NoError(t, err)
+    defer os.RemoveAll(tmpDir)
+    
+    // Build a repository in the pre-object-store layout by hand
+    require.NoError(t, os.MkdirAll(filepath.Join(tmpDir, ".netgit"), 0755))
+    db, err := bbolt.Open(filepath.Join(tmpDir, ".netgit", "objects.db"), 0600, nil)
+    require.NoError(t, err)
+    
+    legacy := map[string]map[string]interface{}{
+        "aaaa1111": {"hash": "aaaa1111", "message": "Initial", "author": "old@example.com",
+            "config": map[string]interface{}{"securityGroups": []interface{}{map[string]interface{}{"name": "web-sg"}}}},
+        "bbbb2222": {"hash": "bbbb2222", "parent": "aaaa1111", "message": "Second", "author": "old@example.com",
+            "config": map[string]interface{}{"securityGroups": []interface{}{map[string]interface{}{"name": "app-sg"}}}},
+    }
+    err = db.Update(func(tx *bbolt.Tx) error {
+        commits, err := tx.CreateBucket([]byte("commits"))
+        if err != nil {
+            return err
+        }
+        for hash, commit := range legacy {
+            data, _ := json.Marshal(commit)
+            commits.Put([]byte(hash), data)
+        }
+        refs, err := tx.CreateBucket([]byte("refs"))
+        if err != nil {
+            return err
+        }
+        refs.Put([]byte("HEAD"), []byte("refs/heads/main"))
+        return refs.Put([]byte("refs/heads/main"), []byte("bbbb2222"))
+    })
+    require.NoError(t, err)
+    require.NoError(t, db.Close())
+    
+    repo, err := storage.OpenRepository(tmpDir)
+    require.NoError(t, err)
+    defer repo.Close()
+    
+    history, err := repo.GetHistory()
+    require.NoError(t, err)
+    require.Len(t, history, 2)
+    
+    assert.Len(t, history[0].Hash, 64)
+    assert.Equal(t, "Second", history[0].Message)
+    assert.Equal(t, "app-sg", history[0].Config.SecurityGroups[0].Name)
+    assert.Equal(t, history[1].Hash, history[0].Parent)
+    assert.Equal(t, "Initial", history[1].Message)
+    
+    // The working files are compared with the migrated config as a whole
+    writeFiles(t, tmpDir, map[string]string{"network.yaml": "securityGroups:\n  - name: app-sg\n"})
+    status, err := repo.Status()
+    require.NoError(t, err)
+    assert.True(t, status.Clean)
+    assert.Empty(t, status.Added)
+    
+    writeFiles(t, tmpDir, map[string]string{"network.yaml": "securityGroups:\n  - name: app-sg\n  - name: db-sg\n"})
+    status, err = repo.Status()
+    require.NoError(t, err)
+    assert.False(t, status.Clean)
+    assert.Equal(t, []string{"network.yaml"}, status.Modified)
+}
+
+func TestHistoryMissingParent(t *testing.T) {
+    tmpDir, err := os.MkdirTemp("", "netgit-test")
+    require.NoError(t, err)
+    defer os.RemoveAll(tmpDir)
+    
+    repo, err := storage.NewRepository(tmpDir)
+    require.NoError(t, err)
+    
+    testConfig := config.NetworkConfig{Source: "web.yaml", SecurityGroups: []config.SecurityGroup{{Name: "web-sg"}}}
+    first, err := repo.Commit([]config.NetworkConfig{testConfig}, "First", "test@example.com")
+    require.NoError(t, err)
+    _, err = repo.Commit([]config.NetworkConfig{testConfig}, "Second", "test@example.com")
+    require.NoError(t, err)
+    require.NoError(t, repo.Close())
+    
+    // Lose the first commit object, as a damaged database would
+    db, err := bbolt.Open(filepath.Join(tmpDir, ".netgit", "objects.db"), 0600, nil)
+    require.NoError(t, err)
+    require.NoError(t, db.Update(func(tx *bbolt.Tx) error {
+        return tx.Bucket([]byte("objects")).Delete([]byte(first.Hash))
+    }))
+    require.NoError(t, db.Close())
+    
+    repo, err = storage.OpenRepository(tmpDir)
+    require.NoError(t, err)
+    defer repo.Close()
+    
+    // A history cut short is an error, not a shorter log
+    history, err := repo.GetHistory()
+    assert.Error(t, err)
+    assert.Nil(t, history)
+}
+
+func TestCommitUnreadableHEAD(t *testing.T) {
+    tmpDir, err := os.MkdirTemp("", "netgit-test")
+    require.NoError(t, err)
+    defer os.RemoveAll(tmpDir)
+    
+    repo, err := storage.NewRepository(tmpDir)
+    require.NoError(t, err)
+    
+    testConfig := config.NetworkConfig{Source: "web.yaml", SecurityGroups: []config.SecurityGroup{{Name: "web-sg"}}}
+    first, err := repo.Commit([]config.NetworkConfig{testConfig}, "First", "test@example.com")
+    require.NoError(t, err)
+    assert.Empty(t, first.Parent)
+    require.NoError(t, repo.Close())
+    
+    // Lose the commit HEAD points at
+    db, err := bbolt.Open(filepath.Join(tmpDir, ".netgit", "objects.db"), 0600, nil)
+    require.NoError(t, err)
+    require.NoError(t, db.Update(func(tx *bbolt.Tx) error {
+        return tx.Bucket([]byte("objects")).Delete([]byte(first.Hash))
+    }))
+    require.NoError(t, db.Close())
+    
+    repo, err = storage.OpenRepository(tmpDir)
+    require.NoError(t, err)
+    defer repo.Close()
+    
+    // The next commit fails rather than starting a new history
+    _, err = repo.Commit([]config.NetworkConfig{testConfig}, "Second", "test@example.com")
+    if assert.Error(t, err) {
+        assert.Contains(t, err.Error(), "commit not found: "+first.Hash)
+    }
+    head, err := repo.ResolveCommit("HEAD")
+    require.NoError(t, err)
+    assert.Equal(t, first.Hash, head)
+}
+
+func TestWorkingDirectoryStatus(t *testing.T) {
+    tmpDir, err := os.MkdirTemp("", "netgit-test")
+    require.NoError(t, err)
+    defer os.RemoveAll(tmpDir)
+    
+    repo, err := storage.NewRepository(tmpDir)
+    require.NoError(t, err)
+    defer repo.Close()
+    
+    writeFile := func(name, content string) {
+        require.NoError(t, os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0644))
+    }
+    
+    writeFile("web.yaml", "securityGroups:\n  - name: web-sg\n    rules:\n      - protocol: tcp\n        ports: [\"443\"]\n")
+    writeFile("db.yaml", "securityGroups:\n  - name: db-sg\n")
+    writeFile("gcp.yaml", "firewallRules:\n  - name: allow-ssh\n")
+    
+    status, err := repo.Status()
+    require.NoError(t, err)
+    assert.False(t, status.Clean)
+    assert.Equal(t, []string{"db.yaml", "gcp.yaml", "web.yaml"}, status.Added)
+    
+    configs, err := config.LoadWorkingDirectory(tmpDir)
+    require.NoError(t, err)
+    _, err = repo.Commit(configs, "Initial commit", "test@example.com")
+    require.NoError(t, err)
+    
+    status, err = repo.Status()
+    require.NoError(t, err)
+    assert.True(t, status.Clean)
+    
+    writeFile("web.yaml", "securityGroups:\n  - name: web-sg\n    rules:\n      - protocol: tcp\n        ports: [\"443\", \"8443\"]\n")
+    writeFile("cache.yaml", "securityGroups:\n  - name: cache-sg\n")
+    writeFile("gcp.yaml", "firewallRules: [\n")
+    require.NoError(t, os.Remove(filepath.Join(tmpDir, "db.yaml")))
+    
+    status, err = repo.Status()
+    require.NoError(t, err)
+    assert.False(t, status.Clean)
+    assert.Equal(t, "main", status.Branch)
+    assert.Equal(t, []string{"cache.yaml"}, status.Added)
+    assert.Equal(t, []string{"web.yaml"}, status.Modified)
+    assert.Equal(t, []string{"db.yaml"}, status.Deleted)
+    require.Len(t, status.Invalid, 1)
+    assert.Equal(t, "gcp.yaml", status.Invalid[0].File)
+}
+
+func TestStatusSeesRulesSharingAKey(t *testing.T) {
+    tmpDir, err := os.MkdirTemp("", "netgit-test")
+    require.NoError(t, err)
+    defer os.RemoveAll(tmpDir)
+    
+    repo, err := storage.NewRepository(tmpDir)
+    require.NoError(t, err)
+    defer repo.Close()
+    
+    writeFiles(t, tmpDir, map[string]string{
+        "web.yaml": "securityGroups:\n  - name: web-sg\n    rules:\n      - protocol: tcp\n        ports: [\"22\"]\n        sources: [\"10.0.0.0/8\"]\n",
+    })
+    commitWorkingDir(t, repo, tmpDir, "Initial commit")
+    
+    // A second rule on the same port opens SSH to the world
+    writeFiles(t, tmpDir, map[string]string{
+        "web.yaml": "securityGroups:\n  - name: web-sg\n    rules:\n      - protocol: tcp\n        ports: [\"22\"]\n        sources: [\"10.0.0.0/8\"]\n      - protocol: tcp\n        ports: [\"22\"]\n        sources: [\"0.0.0.0/0\"]\n",
+    })
+    status, err := repo.Status()
+    require.NoError(t, err)
+    assert.False(t, status.Clean)
+    assert.Equal(t, []string{"web.yaml"}, status.Modified)
+}
+
+func TestPerFileProvenance(t *testing.T) {
+    tmpDir, err := os.MkdirTemp("", "netgit-test")
+    require.NoError(t, err)
+    defer os.RemoveAll(tmpDir)
+    
+    repo, err := storage.NewRepository(tmpDir)
+    require.NoError(t, err)
+    defer repo.Close()
+    
+    awsYAML := "# AWS production groups\nmetadata:\n  name: aws\n  environment: production\nsecurityGroups:\n  - name: web-sg\n"
+    gcpYAML := "metadata:\n  name: gcp\n  environment: staging\nfirewallRules:\n  - name: allow-ssh\n"
+    require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "aws.yaml"), []byte(awsYAML), 0644))
+    require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "gcp.yaml"), []byte(gcpYAML), 0644))
+    
+    configs, err := config.LoadWorkingDirectory(tmpDir)
+    require.NoError(t, err)
+    commit, err := repo.Commit(configs, "Initial commit", "test@example.com")
+    require.NoError(t, err)
+    
+    head, err := repo.GetCommit(commit.Hash)
+    require.NoError(t, err)
+    require.Len(t, head.Files, 2)
+    assert.Equal(t, "aws.yaml", head.Files[0].Source)
+    assert.Equal(t, "production", head.Files[0].Metadata.Environment)
+    assert.Equal(t, "staging", head.Files[1].Metadata.Environment)
+    assert.Equal(t, "", head.Config.Metadata.Environment)
+    
+    require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "gcp.yaml"), []byte(gcpYAML+"  - name: allow-web\n"), 0644))
+    changes, err := repo.Diff("HEAD", "WORKING")
+    require.NoError(t, err)
+    assert.Equal(t, []string{"gcp.yaml:firewallRule/allow-web"}, changes.Added)
+    assert.Equal(t, []string{"gcp.yaml"}, changes.Files())
+    
+    // Checkout writes the committed bytes back, comments included
+    require.NoError(t, os.Remove(filepath.Join(tmpDir, "aws.yaml")))
+    require.NoError(t, repo.WriteFiles(head.Files))
+    restored, err := os.ReadFile(filepath.Join(tmpDir, "aws.yaml"))
+    require.NoError(t, err)
+    assert.Equal(t, awsYAML, string(restored))
+    
+    assert.Error(t, repo.WriteFiles([]config.NetworkConfig{{Source: "../escape.yaml"}}))
+}
+
+# tests/branch_test.go
+package tests
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+    
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+    
+    "netgit/pkg/config"
+    "netgit/pkg/storage"
+)
+
+// commitWorkingDir commits whatever is currently on disk in dir
+func commitWorkingDir(t *testing.T, repo *storage.Repository, dir, message string) *storage.Commit {
+    configs, err := config.LoadWorkingDirectory(dir)
+    require.NoError(t, err)
+    commit, err := repo.Commit(configs, message, "test@example.com")
+    require.NoError(t, err)
+    return commit
+}
+
+func TestBranchingAndCheckout(t *testing.T) {
+    tmpDir, err := os.MkdirTemp("", "netgit-test")
+    require.NoError(t, err)
+    defer os.RemoveAll(tmpDir)
+    
+    repo, err := storage.NewRepository(tmpDir)
+    require.NoError(t, err)
+    defer repo.Close()
+    
+    webPath := filepath.Join(tmpDir, "web.yaml")
+    mainYAML := "securityGroups:\n  - name: web-sg\n"
+    require.NoError(t, os.WriteFile(webPath, []byte(mainYAML), 0644))
+    initial := commitWorkingDir(t, repo, tmpDir, "Initial commit")
+    
+    require.NoError(t, repo.CreateBranch("feature/cache"))
+    assert.Error(t, repo.CreateBranch("feature/cache"))
+    assert.Error(t, repo.CreateBranch("bad..name"))
+    
+    _, err = repo.Checkout("feature/cache", false)
+    require.NoError(t, err)
+    branch, err := repo.CurrentBranch()
+    require.NoError(t, err)
+    assert.Equal(t, "feature/cache", branch)
+    
+    require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "cache.yaml"), []byte("securityGroups:\n  - name: cache-sg\n"), 0644))
+    feature := commitWorkingDir(t, repo, tmpDir, "Add cache")
+    assert.Equal(t, initial.Hash, feature.Parent)
+    
+    // Local changes block a checkout unless forced
+    require.NoError(t, os.WriteFile(webPath, []byte("securityGroups:\n  - name: edited-sg\n"), 0644))
+    _, err = repo.Checkout("main", false)
+    assert.Error(t, err)
+    
+    _, err = repo.Checkout("main", true)
+    require.NoError(t, err)
+    restored, err := os.ReadFile(webPath)
+    require.NoError(t, err)
+    assert.Equal(t, mainYAML, string(restored))
+    assert.NoFileExists(t, filepath.Join(tmpDir, "cache.yaml"))
+    
+    branches, err := repo.ListBranches()
+    require.NoError(t, err)
+    assert.Equal(t, []string{"feature/cache", "main"}, branches)
+    
+    // Detached HEAD follows new commits without moving any branch
+    _, err = repo.Checkout(feature.Hash, false)
+    require.NoError(t, err)
+    assert.FileExists(t, filepath.Join(tmpDir, "cache.yaml"))
+    branch, err = repo.CurrentBranch()
+    require.NoError(t, err)
+    assert.Equal(t, "", branch)
+    
+    status, err := repo.Status()
+    require.NoError(t, err)
+    assert.Equal(t, feature.Hash, status.Detached)
+    
+    require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "db.yaml"), []byte("securityGroups:\n  - name: db-sg\n"), 0644))
+    detached := commitWorkingDir(t, repo, tmpDir, "Detached work")
+    head, err := repo.GetHEAD()
+    require.NoError(t, err)
+    assert.Equal(t, detached.Hash, head.Hash)
+    
+    _, err = repo.Checkout("main", false)
+    require.NoError(t, err)
+    head, err = repo.GetHEAD()
+    require.NoError(t, err)
+    assert.Equal(t, initial.Hash, head.Hash)
+}
+
+func TestRenameAndDeleteBranch(t *testing.T) {
+    tmpDir, err := os.MkdirTemp("", "netgit-test")
+    require.NoError(t, err)
+    defer os.RemoveAll(tmpDir)
+    
+    repo, err := storage.NewRepository(tmpDir)
+    require.NoError(t, err)
+    defer repo.Close()
+    
+    require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "web.yaml"), []byte("securityGroups:\n  - name: web-sg\n"), 0644))
+    commitWorkingDir(t, repo, tmpDir, "Initial commit")
+    
+    require.NoError(t, repo.RenameBranch("main", "trunk"))
+    branch, err := repo.CurrentBranch()
+    require.NoError(t, err)
+    assert.Equal(t, "trunk", branch)
+    
+    assert.Error(t, repo.DeleteBranch("trunk", false))
+    
+    require.NoError(t, repo.CreateBranch("topic"))
+    _, err = repo.Checkout("topic", false)
+    require.NoError(t, err)
+    require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "db.yaml"), []byte("securityGroups:\n  - name: db-sg\n"), 0644))
+    commitWorkingDir(t, repo, tmpDir, "Topic work")
+    _, err = repo.Checkout("trunk", false)
+    require.NoError(t, err)
+    
+    // topic has a commit trunk does not
+    assert.Error(t, repo.DeleteBranch("topic", false))
+    require.NoError(t, repo.DeleteBranch("topic", true))
+    
+    branches, err := repo.ListBranches()
+    require.NoError(t, err)
+    assert.Equal(t, []string{"trunk"}, branches)
+}
+
+func TestTags(t *testing.T) {
+    tmpDir, err := os.MkdirTemp("", "netgit-test")
+    require.NoError(t, err)
+    defer os.RemoveAll(tmpDir)
+    
+    repo, err := storage.NewRepository(tmpDir)
+    require.NoError(t, err)
+    defer repo.Close()
+    
+    webPath := filepath.Join(tmpDir, "web.yaml")
+    require.NoError(t, os.WriteFile(webPath, []byte("securityGroups:\n  - name: web-sg\n"), 0644))
+    v1 := commitWorkingDir(t, repo, tmpDir, "Initial commit")
+    
+    light, err := repo.CreateTag("v1.0", "HEAD", "test@example.com", "")
+    require.NoError(t, err)
+    assert.False(t, light.Annotated)
+    assert.Equal(t, v1.Hash, light.Commit)
+    
+    _, err = repo.CreateTag("v1.0", "HEAD", "test@example.com", "")
+    assert.Error(t, err)
+    _, err = repo.CreateTag("release", "no-such-revision", "test@example.com", "")
+    assert.Error(t, err)
+    
+    require.NoError(t, os.WriteFile(webPath, []byte("securityGroups:\n  - name: web-sg\n  - name: db-sg\n"), 0644))
+    v2 := commitWorkingDir(t, repo, tmpDir, "Add db")
+    
+    annotated, err := repo.CreateTag("v2.0", v2.Hash, "test@example.com", "Second release")
+    require.NoError(t, err)
+    assert.True(t, annotated.Annotated)
+    
+    tag, err := repo.GetTag("v2.0")
+    require.NoError(t, err)
+    assert.Equal(t, v2.Hash, tag.Commit)
+    assert.Equal(t, "test@example.com", tag.Tagger)
+    assert.Equal(t, "Second release", tag.Message)
+    assert.False(t, tag.Timestamp.IsZero())
+    
+    tags, err := repo.ListTags()
+    require.NoError(t, err)
+    require.Len(t, tags, 2)
+    assert.Equal(t, "v1.0", tags[0].Name)
+    assert.Equal(t, "v2.0", tags[1].Name)
+    
+    // Tags resolve wherever a commit hash is accepted
+    hash, err := repo.ResolveCommit("v2.0")
+    require.NoError(t, err)
+    assert.Equal(t, v2.Hash, hash)
+    
+    changes, err := repo.Diff("v1.0", "v2.0")
+    require.NoError(t, err)
+    assert.Equal(t, []string{"web.yaml"}, changes.Files())
+    
+    commit, err := repo.Checkout("v1.0", false)
+    require.NoError(t, err)
+    assert.Equal(t, v1.Hash, commit.Hash)
+    status, err := repo.Status()
+    require.NoError(t, err)
+    assert.Equal(t, v1.Hash, status.Detached)
+    
+    require.NoError(t, repo.DeleteTag("v1.0"))
+    _, err = repo.ResolveCommit("v1.0")
+    assert.Error(t, err)
+}
+
+func TestRevisionExpressions(t *testing.T) {
+    tmpDir, err := os.MkdirTemp("", "netgit-test")
+    require.NoError(t, err)
+    defer os.RemoveAll(tmpDir)
+    
+    repo, err := storage.NewRepository(tmpDir)
+    require.NoError(t, err)
+    defer repo.Close()
+    
+    writeFile := func(name, content string) {
+        require.NoError(t, os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0644))
+    }
+    
+    writeFile("web.yaml", "securityGroups:\n  - name: web-sg\n")
+    first := commitWorkingDir(t, repo, tmpDir, "Initial commit")
+    writeFile("db.yaml", "securityGroups:\n  - name: db-sg\n")
+    second := commitWorkingDir(t, repo, tmpDir, "Add db")
+    
+    require.NoError(t, repo.CreateBranch("feature"))
+    _, err = repo.Checkout("feature", false)
+    require.NoError(t, err)
+    writeFile("cache.yaml", "securityGroups:\n  - name: cache-sg\n")
+    feature := commitWorkingDir(t, repo, tmpDir, "Add cache")
+    
+    _, err = repo.Checkout("main", false)
+    require.NoError(t, err)
+    writeFile("web.yaml", "securityGroups:\n  - name: web-sg\n    description: Web tier\n")
+    third := commitWorkingDir(t, repo, tmpDir, "Describe web tier")
+    result, err := repo.Merge("feature", "test@example.com")
+    require.NoError(t, err)
+    merge := result.Commit
+    
+    _, err = repo.CreateTag("v1.0", "HEAD~2", "test@example.com", "")
+    require.NoError(t, err)
+    
+    expressions := map[string]string{
+        "HEAD":            merge.Hash,
+        "@":               merge.Hash,
+        "HEAD^":           third.Hash,
+        "HEAD^1":          third.Hash,
+        "HEAD^2":          feature.Hash,
+        "HEAD~":           third.Hash,
+        "HEAD~3":          first.Hash,
+        "main~2":          second.Hash,
+        "HEAD^2~1":        second.Hash,
+        "v1.0":            second.Hash,
+        "v1.0^":           first.Hash,
+        feature.Hash[:10]: feature.Hash,
+        "main@{now}":      merge.Hash,
+    }
+    for expr, want := range expressions {
+        hash, err := repo.ResolveCommit(expr)
+        require.NoError(t, err, expr)
+        assert.Equal(t, want, hash, expr)
+    }
+    
+    // Without a reflog, @{date} is the newest commit made by then
+    hash, err := repo.ResolveCommit("main@{" + second.Timestamp.Format(time.RFC3339Nano) + "}")
+    require.NoError(t, err)
+    assert.Equal(t, second.Hash, hash)
+    
+    for _, expr := range []string{"HEAD~4", "HEAD^3", "HEAD^^2", "main@{1.year.ago}", "main@{whenever}", "HEAD~x", "main..feature", "abc"} {
+        _, err := repo.ResolveCommit(expr)
+        assert.Error(t, err, expr)
+    }
+    
+    history, err := repo.History("feature..main")
+    require.NoError(t, err)
+    require.Len(t, history, 2)
+    assert.Equal(t, merge.Hash, history[0].Hash)
+    assert.Equal(t, third.Hash, history[1].Hash)
+    
+    history, err = repo.History("HEAD~1")
+    require.NoError(t, err)
+    assert.Len(t, history, 3)
+    
+    changes, err := repo.Diff("v1.0", "HEAD^2")
+    require.NoError(t, err)
+    assert.Equal(t, []string{"cache.yaml"}, changes.Files())
+}
+
+func TestAbbreviatedHashAmbiguity(t *testing.T) {
+    tmpDir, err := os.MkdirTemp("", "netgit-test")
+    require.NoError(t, err)
+    defer os.RemoveAll(tmpDir)
+    
+    repo, err := storage.NewRepository(tmpDir)
+    require.NoError(t, err)
+    defer repo.Close()
+    
+    // Commit until two commit hashes share a four-character prefix
+    byPrefix := map[string]string{}
+    prefix := ""
+    for i := 0; prefix == "" && i < 5000; i++ {
+        cfg := config.NetworkConfig{
+            Source:         "web.yaml",
+            SecurityGroups: []config.SecurityGroup{{Name: fmt.Sprintf("web-sg-%d", i)}},
+        }
+        commit, err := repo.Commit([]config.NetworkConfig{cfg}, "Commit", "test@example.com")
+        require.NoError(t, err)
+        if _, ok := byPrefix[commit.Hash[:4]]; ok {
+            prefix = commit.Hash[:4]
+        }
+        byPrefix[commit.Hash[:4]] = commit.Hash
+    }
+    require.NotEmpty(t, prefix)
+    
+    _, err = repo.ResolveCommit(prefix)
+    require.Error(t, err)
+    assert.Contains(t, err.Error(), "ambiguous")
+    
+    hash, err := repo.ResolveCommit(byPrefix[prefix][:20])
+    require.NoError(t, err)
+    assert.Equal(t, byPrefix[prefix], hash)
+}
+
+# tests/merge_test.go
+package tests
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+    
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+    
+    "netgit/pkg/config"
+    "netgit/pkg/merge"
+    "netgit/pkg/storage"
+)
+
+func TestThreeWayMergeOfRules(t *testing.T) {
+    base := []config.NetworkConfig{{
+        Source: "aws.yaml",
+        SecurityGroups: []config.SecurityGroup{
+            {Name: "app-sg", Description: "App tier", Rules: []config.Rule{
+                {Protocol: "tcp", Ports: []string{"22"}, Sources: []string{"10.0.1.0/24", "10.0.9.0/24"}, Action: "allow"},
+            }},
+        },
+        FirewallRules: []config.FirewallRule{{Name: "allow-web", Priority: 1000}},
+    }}
+    
+    ours := []config.NetworkConfig{{
+        Source: "aws.yaml",
+        SecurityGroups: []config.SecurityGroup{
+            {Name: "app-sg", Description: "App tier", Rules: []config.Rule{
+                {Protocol: "tcp", Ports: []string{"22"}, Sources: []string{"10.0.1.0/24", "10.0.9.0/24", "10.0.2.0/24"}, Action: "allow"},
+            }},
+        },
+        FirewallRules: []config.FirewallRule{{Name: "allow-web", Priority: 900}},
+    }}
+    
+    theirs := []config.NetworkConfig{{
+        Source: "aws.yaml",
+        SecurityGroups: []config.SecurityGroup{
+            {Name: "app-sg", Description: "Application tier", Rules: []config.Rule{
+                {Protocol: "tcp", Ports: []string{"22"}, Sources: []string{"10.0.1.0/24"}, Action: "allow"},
+            }},
+            {Name: "cache-sg"},
+        },
+        FirewallRules: []config.FirewallRule{{Name: "allow-web", Priority: 800}},
+    }}
+    
+    merged, conflicts := merge.ThreeWay(base, ours, theirs)
+    require.Len(t, merged, 1)
+    
+    groups := merged[0].SecurityGroups
+    require.Len(t, groups, 2)
+    assert.Equal(t, "Application tier", groups[0].Description)
+    assert.Equal(t, []string{"10.0.1.0/24", "10.0.2.0/24"}, groups[0].Rules[0].Sources)
+    assert.Equal(t, "cache-sg", groups[1].Name)
+    
+    require.Len(t, conflicts, 1)
+    assert.Equal(t, "allow-web", conflicts[0].Name)
+    assert.Equal(t, "priority", conflicts[0].Path)
+    assert.Equal(t, 900, merged[0].FirewallRules[0].Priority)
+}
+
+func TestThreeWayMergeOfRulesSharingAKey(t *testing.T) {
+    internal := config.Rule{Protocol: "tcp", Ports: []string{"22"}, Sources: []string{"10.0.1.0/24"}, Action: "allow"}
+    office := config.Rule{Protocol: "tcp", Ports: []string{"22"}, Sources: []string{"203.0.113.0/24"}, Action: "allow"}
+    group := func(rules ...config.Rule) []config.NetworkConfig {
+        return []config.NetworkConfig{{
+            Source:         "aws.yaml",
+            SecurityGroups: []config.SecurityGroup{{Name: "app-sg", Rules: rules}},
+        }}
+    }
+    
+    // Ours denies the office rule; theirs widens the internal rule and
+    // adds a third rule on the same port
+    denied := office
+    denied.Action = "deny"
+    widened := internal
+    widened.Sources = []string{"10.0.1.0/24", "10.0.3.0/24"}
+    public := config.Rule{Protocol: "tcp", Ports: []string{"22"}, Sources: []string{"0.0.0.0/0"}, Action: "allow"}
+    
+    merged, conflicts := merge.ThreeWay(group(internal, office), group(internal, denied), group(widened, office, public))
+    assert.Empty(t, conflicts)
+    require.Len(t, merged, 1)
+    assert.Equal(t, []config.Rule{widened, denied, public}, merged[0].SecurityGroups[0].Rules)
+    
+    // Deleting one of the rules while the other side edits it conflicts
+    _, conflicts = merge.ThreeWay(group(internal, office), group(office), group(widened, office))
+    require.Len(t, conflicts, 1)
+    assert.Equal(t, "rules[tcp:22]", conflicts[0].Path)
+    assert.Equal(t, "deleted in ours, modified in theirs", conflicts[0].Reason)
+    
+    // Both sides changing the same rule's action conflicts too
+    allowed := denied
+    allowed.Action = ""
+    _, conflicts = merge.ThreeWay(group(internal, office), group(internal, denied), group(internal, allowed))
+    require.Len(t, conflicts, 1)
+    assert.Equal(t, "rules[tcp:22].action", conflicts[0].Path)
+}
+
+func TestRepositoryMerge(t *testing.T) {
+    tmpDir, err := os.MkdirTemp("", "netgit-test")
+    require.NoError(t, err)
+    defer os.RemoveAll(tmpDir)
+    
+    repo, err := storage.NewRepository(tmpDir)
+    require.NoError(t, err)
+    defer repo.Close()
+    
+    writeFile := func(name, content string) {
+        require.NoError(t, os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0644))
+    }
+    
+    writeFile("web.yaml", "securityGroups:\n  - name: web-sg\n    description: Web tier\n")
+    initial := commitWorkingDir(t, repo, tmpDir, "Initial commit")
+    
+    // A branch that only moved ahead fast-forwards
+    require.NoError(t, repo.CreateBranch("feature"))
+    _, err = repo.Checkout("feature", false)
+    require.NoError(t, err)
+    writeFile("db.yaml", "securityGroups:\n  - name: db-sg\n")
+    feature := commitWorkingDir(t, repo, tmpDir, "Add db")
+    
+    _, err = repo.Checkout("main", false)
+    require.NoError(t, err)
+    result, err := repo.Merge("feature", "test@example.com")
+    require.NoError(t, err)
+    assert.True(t, result.FastForward)
+    head, err := repo.GetHEAD()
+    require.NoError(t, err)
+    assert.Equal(t, feature.Hash, head.Hash)
+    assert.FileExists(t, filepath.Join(tmpDir, "db.yaml"))
+    
+    result, err = repo.Merge("feature", "test@example.com")
+    require.NoError(t, err)
+    assert.True(t, result.UpToDate)
+    
+    // Diverged branches get a merge commit with two parents
+    _, err = repo.Checkout("feature", false)
+    require.NoError(t, err)
+    writeFile("cache.yaml", "securityGroups:\n  - name: cache-sg\n")
+    featureTip := commitWorkingDir(t, repo, tmpDir, "Add cache")
+    
+    _, err = repo.Checkout("main", false)
+    require.NoError(t, err)
+    writeFile("web.yaml", "securityGroups:\n  - name: web-sg\n    description: Public web tier\n")
+    mainTip := commitWorkingDir(t, repo, tmpDir, "Describe web tier")
+    
+    result, err = repo.Merge("feature", "test@example.com")
+    require.NoError(t, err)
+    require.Empty(t, result.Conflicts)
+    assert.Equal(t, mainTip.Hash, result.Commit.Parent)
+    assert.Equal(t, featureTip.Hash, result.Commit.MergeParent)
+    assert.Len(t, result.Commit.Files, 3)
+    assert.NotEqual(t, initial.Hash, result.Commit.Hash)
+    
+    status, err := repo.Status()
+    require.NoError(t, err)
+    assert.True(t, status.Clean)
+}
+
+func TestRepositoryMergeConflicts(t *testing.T) {
+    tmpDir, err := os.MkdirTemp("", "netgit-test")
+    require.NoError(t, err)
+    defer os.RemoveAll(tmpDir)
+    
+    repo, err := storage.NewRepository(tmpDir)
+    require.NoError(t, err)
+    defer repo.Close()
+    
+    webPath := filepath.Join(tmpDir, "web.yaml")
+    writeWeb := func(description string) {
+        content := "securityGroups:\n  - name: web-sg\n    description: " + description + "\n"
+        require.NoError(t, os.WriteFile(webPath, []byte(content), 0644))
+    }
+    
+    writeWeb("Web tier")
+    commitWorkingDir(t, repo, tmpDir, "Initial commit")
+    require.NoError(t, repo.CreateBranch("feature"))
+    
+    writeWeb("Ours")
+    ours := commitWorkingDir(t, repo, tmpDir, "Ours")
+    
+    _, err = repo.Checkout("feature", false)
+    require.NoError(t, err)
+    writeWeb("Theirs")
+    theirs := commitWorkingDir(t, repo, tmpDir, "Theirs")
+    _, err = repo.Checkout("main", false)
+    require.NoError(t, err)
+    
+    result, err := repo.Merge("feature", "test@example.com")
+    require.NoError(t, err)
+    require.Len(t, result.Conflicts, 1)
+    assert.Equal(t, "description", result.Conflicts[0].Path)
+    
+    pending, err := repo.PendingMerge()
+    require.NoError(t, err)
+    require.NotNil(t, pending)
+    assert.Equal(t, theirs.Hash, pending.Head)
+    
+    _, err = repo.Commit([]config.NetworkConfig{{Source: "web.yaml"}}, "Sneaky", "test@example.com")
+    assert.Error(t, err)
+    
+    // Aborting restores HEAD's files
+    require.NoError(t, repo.AbortMerge())
+    pending, err = repo.PendingMerge()
+    require.NoError(t, err)
+    assert.Nil(t, pending)
+    
+    // Resolving by hand and continuing records both parents
+    _, err = repo.Merge("feature", "test@example.com")
+    require.NoError(t, err)
+    writeWeb("Resolved")
+    merged, err := repo.ContinueMerge("test@example.com")
+    require.NoError(t, err)
+    assert.Equal(t, ours.Hash, merged.Parent)
+    assert.Equal(t, theirs.Hash, merged.MergeParent)
+    assert.Equal(t, "Resolved", merged.Files[0].SecurityGroups[0].Description)
+}
+
+# tests/remote_test.go
+package tests
+
+import (
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "testing"
+    
+    "github.com/gin-gonic/gin"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+    
+    "netgit/pkg/remote"
+    "netgit/pkg/storage"
+)
+
+// newRemoteRepo creates a repository in a temporary directory with a remote
+// named origin
+func newRemoteRepo(t *testing.T, url string) (*storage.Repository, string) {
+    dir, err := os.MkdirTemp("", "netgit-test")
+    require.NoError(t, err)
+    t.Cleanup(func() { os.RemoveAll(dir) })
+    
+    repo, err := storage.NewRepository(dir)
+    require.NoError(t, err)
+    t.Cleanup(func() { repo.Close() })
+    
+    if url != "" {
+        require.NoError(t, repo.AddRemote("origin", url))
+    }
+    return repo, dir
+}
+
+// testToken is the token test servers expect and test clients present
+const testToken = "s3cret"
+
+func TestPushFetchPull(t *testing.T) {
+    gin.SetMode(gin.TestMode)
+    t.Setenv(remote.TokenEnv, testToken)
+    central, _ := newRemoteRepo(t, "")
+    server := httptest.NewServer(remote.NewServer(central, remote.ServerOptions{Token: testToken, AllowForce: true}))
+    defer server.Close()
+    
+    alice, aliceDir := newRemoteRepo(t, server.URL)
+    bob, bobDir := newRemoteRepo(t, server.URL)
+    
+    require.NoError(t, os.WriteFile(filepath.Join(aliceDir, "web.yaml"), []byte("securityGroups:\n  - name: web-sg\n"), 0644))
+    initial := commitWorkingDir(t, alice, aliceDir, "Initial commit")
+    _, err := alice.CreateTag("v1.0", "HEAD", "alice@example.com", "First release")
+    require.NoError(t, err)
+    
+    results, err := remote.Push(alice, "origin", []string{"main", "v1.0"}, false)
+    require.NoError(t, err)
+    require.Len(t, results, 2)
+    for _, result := range results {
+        assert.Empty(t, result.Error, result.Ref)
+    }
+    refs, err := central.Refs()
+    require.NoError(t, err)
+    assert.Equal(t, initial.Hash, refs["refs/heads/main"])
+    
+    tracking, err := alice.RemoteBranches("origin")
+    require.NoError(t, err)
+    assert.Equal(t, map[string]string{"main": initial.Hash}, tracking)
+    
+    // Pushing again changes nothing
+    results, err = remote.Push(alice, "origin", []string{"main"}, false)
+    require.NoError(t, err)
+    assert.True(t, results[0].UpToDate)
+    
+    // A fresh repository fetches history and tags, then adopts the branch
+    fetched, err := remote.Fetch(bob, "origin")
+    require.NoError(t, err)
+    assert.Greater(t, fetched.Objects, 0)
+    require.Len(t, fetched.Tags, 1)
+    assert.Empty(t, fetched.Tags[0].Error)
+    hash, err := bob.ResolveCommit("v1.0")
+    require.NoError(t, err)
+    assert.Equal(t, initial.Hash, hash)
+    
+    merged, err := bob.Merge("origin/main", "bob@example.com")
+    require.NoError(t, err)
+    assert.True(t, merged.FastForward)
+    assert.FileExists(t, filepath.Join(bobDir, "web.yaml"))
+    
+    require.NoError(t, os.WriteFile(filepath.Join(bobDir, "db.yaml"), []byte("securityGroups:\n  - name: db-sg\n"), 0644))
+    bobCommit := commitWorkingDir(t, bob, bobDir, "Add db")
+    results, err = remote.Push(bob, "origin", []string{"main"}, false)
+    require.NoError(t, err)
+    assert.Empty(t, results[0].Error)
+    
+    // Alice diverged from what the server has, so her push is rejected
+    require.NoError(t, os.WriteFile(filepath.Join(aliceDir, "cache.yaml"), []byte("securityGroups:\n  - name: cache-sg\n"), 0644))
+    aliceCommit := commitWorkingDir(t, alice, aliceDir, "Add cache")
+    results, err = remote.Push(alice, "origin", []string{"main"}, false)
+    require.NoError(t, err)
+    assert.Contains(t, results[0].Error, "non-fast-forward")
+    refs, err = central.Refs()
+    require.NoError(t, err)
+    assert.Equal(t, bobCommit.Hash, refs["refs/heads/main"])
+    
+    // Pulling makes the push a fast-forward
+    _, err = remote.Fetch(alice, "origin")
+    require.NoError(t, err)
+    pulled, err := alice.Merge("origin/main", "alice@example.com")
+    require.NoError(t, err)
+    require.Empty(t, pulled.Conflicts)
+    assert.Equal(t, aliceCommit.Hash, pulled.Commit.Parent)
+    assert.Equal(t, bobCommit.Hash, pulled.Commit.MergeParent)
+    results, err = remote.Push(alice, "origin", []string{"main"}, false)
+    require.NoError(t, err)
+    assert.Empty(t, results[0].Error)
+    
+    // Forced pushes may rewind a branch
+    results, err = remote.Push(bob, "origin", []string{"main"}, true)
+    require.NoError(t, err)
+    assert.Empty(t, results[0].Error)
+    refs, err = central.Refs()
+    require.NoError(t, err)
+    assert.Equal(t, bobCommit.Hash, refs["refs/heads/main"])
+}
+
+func TestRemoteRejectsBadObjectsAndRefs(t *testing.T) {
+    gin.SetMode(gin.TestMode)
+    central, _ := newRemoteRepo(t, "")
+    server := httptest.NewServer(remote.NewServer(central, remote.ServerOptions{Token: testToken}))
+    defer server.Close()
+    
+    client := remote.NewClient(server.URL, testToken)
+    _, err := client.Push([]storage.Object{{Hash: "deadbeef", Type: storage.BlobObject, Data: []byte("{}")}}, nil)
+    assert.Error(t, err)
+    
+    // A ref may not point at history the server does not have
+    results, err := client.Push(nil, []storage.RefUpdate{{Ref: "refs/heads/main", New: storage.HashObject(storage.CommitObject, []byte("{}"))}})
+    require.NoError(t, err)
+    assert.NotEmpty(t, results[0].Error)
+    
+    results, err = client.Push(nil, []storage.RefUpdate{{Ref: "refs/remotes/origin/main", New: "abc"}})
+    require.NoError(t, err)
+    assert.NotEmpty(t, results[0].Error)
+    
+    // Nor at a commit whose parent is missing, and the objects sent for a
+    // push that applies nothing are not kept
+    local, localDir := newRemoteRepo(t, "")
+    require.NoError(t, os.WriteFile(filepath.Join(localDir, "web.yaml"), []byte("securityGroups:\n  - name: web-sg\n"), 0644))
+    first := commitWorkingDir(t, local, localDir, "Initial commit")
+    require.NoError(t, os.WriteFile(filepath.Join(localDir, "db.yaml"), []byte("securityGroups:\n  - name: db-sg\n"), 0644))
+    second := commitWorkingDir(t, local, localDir, "Add db")
+    objects, err := local.CollectObjects([]string{second.Hash}, []string{first.Hash})
+    require.NoError(t, err)
+    results, err = client.Push(objects, []storage.RefUpdate{{Ref: "refs/heads/main", New: second.Hash}})
+    require.NoError(t, err)
+    assert.Contains(t, results[0].Error, "incomplete history: object not found: "+first.Hash)
+    assert.False(t, central.HasObject(second.Hash))
+    refs, err := central.Refs()
+    require.NoError(t, err)
+    assert.Empty(t, refs)
+    
+    repo, _ := newRemoteRepo(t, "")
+    assert.Error(t, repo.AddRemote("origin", "ftp://example.com"))
+    assert.Error(t, repo.AddRemote("bad/name", server.URL))
+    require.NoError(t, repo.AddRemote("origin", server.URL))
+    assert.Error(t, repo.AddRemote("origin", server.URL))
+    
+    remotes, err := repo.ListRemotes()
+    require.NoError(t, err)
+    require.Len(t, remotes, 1)
+    assert.Equal(t, server.URL, remotes[0].URL)
+    require.NoError(t, repo.RemoveRemote("origin"))
+    _, err = remote.Fetch(repo, "origin")
+    assert.Error(t, err)
+}
+
+func TestServerAccessControl(t *testing.T) {
+    gin.SetMode(gin.TestMode)
+    central, centralDir := newRemoteRepo(t, "")
+    require.NoError(t, os.WriteFile(filepath.Join(centralDir, "web.yaml"), []byte("securityGroups:\n  - name: web-sg\n"), 0644))
+    initial := commitWorkingDir(t, central, centralDir, "Initial commit")
+    
+    server := httptest.NewServer(remote.NewServer(central, remote.ServerOptions{Token: testToken}))
+    defer server.Close()
+    
+    // Requests without the token are turned away
+    for _, token := range []string{"", "wrong"} {
+        _, err := remote.NewClient(server.URL, token).Refs()
+        assert.ErrorContains(t, err, "unauthorized", token)
+    }
+    
+    // A server without a token serves no one
+    closed := httptest.NewServer(remote.NewServer(central, remote.ServerOptions{}))
+    defer closed.Close()
+    _, err := remote.NewClient(closed.URL, "").Refs()
+    assert.ErrorContains(t, err, "unauthorized")
+    
+    // Forced updates and deletes need the server's consent
+    client := remote.NewClient(server.URL, testToken)
+    results, err := client.Push(nil, []storage.RefUpdate{
+        {Ref: "refs/heads/rewound", New: initial.Hash, Force: true},
+        {Ref: "refs/heads/main", Old: initial.Hash},
+    })
+    require.NoError(t, err)
+    require.Len(t, results, 2)
+    assert.Contains(t, results[0].Error, "forced updates are disabled")
+    assert.Contains(t, results[1].Error, "deleting refs is disabled")
+    
+    refs, err := central.Refs()
+    require.NoError(t, err)
+    assert.Equal(t, map[string]string{"refs/heads/main": initial.Hash}, refs)
+}
+
+# tests/testdata/import/aws-security-groups.json
+{
+    "SecurityGroups": [
+        {
+            "GroupId": "sg-0a1b2c3d4e5f60001",
+            "GroupName": "web-tier-sg",
+            "Description": "Web tier security group",
+            "VpcId": "vpc-0123456789abcdef0",
+            "OwnerId": "123456789012",
+            "IpPermissions": [
+                {
+                    "IpProtocol": "tcp",
+                    "FromPort": 443,
+                    "ToPort": 443,
+                    "IpRanges": [{"CidrIp": "0.0.0.0/0", "Description": "HTTPS"}],
+                    "Ipv6Ranges": [{"CidrIpv6": "::/0"}],
+                    "UserIdGroupPairs": [],
+                    "PrefixListIds": []
+                },
+                {
+                    "IpProtocol": "tcp",
+                    "FromPort": 8000,
+                    "ToPort": 8100,
+                    "IpRanges": [],
+                    "Ipv6Ranges": [],
+                    "UserIdGroupPairs": [{"GroupId": "sg-0a1b2c3d4e5f60002", "UserId": "123456789012"}],
+                    "PrefixListIds": []
+                }
+            ],
+            "IpPermissionsEgress": [
+                {
+                    "IpProtocol": "-1",
+                    "IpRanges": [{"CidrIp": "0.0.0.0/0"}],
+                    "Ipv6Ranges": [],
+                    "UserIdGroupPairs": [],
+                    "PrefixListIds": []
+                }
+            ],
+            "Tags": [{"Key": "tier", "Value": "web"}]
+        },
+        {
+            "GroupId": "sg-0a1b2c3d4e5f60002",
+            "GroupName": "bastion-sg",
+            "Description": "Bastion hosts",
+            "VpcId": "vpc-0123456789abcdef0",
+            "OwnerId": "123456789012",
+            "IpPermissions": [
+                {
+                    "IpProtocol": "-1",
+                    "IpRanges": [{"CidrIp": "10.0.0.0/8"}],
+                    "Ipv6Ranges": [],
+                    "UserIdGroupPairs": [],
+                    "PrefixListIds": []
+                },
+                {
+                    "IpProtocol": "icmp",
+                    "FromPort": 8,
+                    "ToPort": -1,
+                    "IpRanges": [{"CidrIp": "192.168.0.0/16"}],
+                    "Ipv6Ranges": [],
+                    "UserIdGroupPairs": [],
+                    "PrefixListIds": []
+                }
+            ],
+            "IpPermissionsEgress": [
+                {
+                    "IpProtocol": "tcp",
+                    "FromPort": 22,
+                    "ToPort": 22,
+                    "IpRanges": [{"CidrIp": "10.0.0.0/8"}],
+                    "Ipv6Ranges": [],
+                    "UserIdGroupPairs": [],
+                    "PrefixListIds": []
+                }
+            ]
+        }
+    ]
+}
+
+# tests/testdata/import/gcp-firewall-rules.json
+[
+    {
+        "id": "4512897310948572011",
+        "kind": "compute#firewall",
+        "name": "allow-web",
+        "network": "https://www.googleapis.com/compute/v1/projects/demo/global/networks/default",
+        "direction": "INGRESS",
+        "priority": 900,
+        "disabled": false,
+        "allowed": [
+            {"IPProtocol": "tcp", "ports": ["80", "443"]},
+            {"IPProtocol": "icmp"}
+        ],
+        "sourceRanges": ["0.0.0.0/0"],
+        "targetTags": ["web-server"]
+    },
+    {
+        "id": "4512897310948572012",
+        "kind": "compute#firewall",
+        "name": "deny-smtp-egress",
+        "network": "https://www.googleapis.com/compute/v1/projects/demo/global/networks/default",
+        "direction": "EGRESS",
+        "priority": 100,
+        "disabled": false,
+        "denied": [
+            {"IPProtocol": "tcp", "ports": ["25"]}
+        ],
+        "destinationRanges": ["0.0.0.0/0"]
+    },
+    {
+        "id": "4512897310948572013",
+        "kind": "compute#firewall",
+        "name": "allow-internal",
+        "network": "https://www.googleapis.com/compute/v1/projects/demo/global/networks/default",
+        "direction": "INGRESS",
+        "disabled": false,
+        "allowed": [
+            {"IPProtocol": "all"}
+        ],
+        "sourceRanges": ["10.128.0.0/9"]
+    },
+    {
+        "id": "4512897310948572014",
+        "kind": "compute#firewall",
+        "name": "legacy-rdp",
+        "network": "https://www.googleapis.com/compute/v1/projects/demo/global/networks/default",
+        "direction": "INGRESS",
+        "priority": 1000,
+        "disabled": true,
+        "allowed": [
+            {"IPProtocol": "tcp", "ports": ["3389"]}
+        ],
+        "sourceRanges": ["0.0.0.0/0"]
+    }
+]
+
+# tests/testdata/import/azure-nsg.json
+[
+    {
+        "name": "web-nsg",
+        "location": "westeurope",
+        "resourceGroup": "prod-rg",
+        "securityRules": [
+            {
+                "name": "allow-https",
+                "access": "Allow",
+                "direction": "Inbound",
+                "priority": 100,
+                "protocol": "Tcp",
+                "sourceAddressPrefix": "Internet",
+                "sourceAddressPrefixes": [],
+                "sourcePortRange": "*",
+                "destinationAddressPrefix": "*",
+                "destinationPortRange": "443",
+                "destinationPortRanges": []
+            },
+            {
+                "name": "allow-lb-probe",
+                "access": "Allow",
+                "direction": "Inbound",
+                "priority": 110,
+                "protocol": "*",
+                "sourceAddressPrefix": "AzureLoadBalancer",
+                "sourcePortRange": "*",
+                "destinationAddressPrefix": "*",
+                "destinationPortRange": "*"
+            },
+            {
+                "name": "deny-egress-db",
+                "access": "Deny",
+                "direction": "Outbound",
+                "priority": 200,
+                "protocol": "Tcp",
+                "sourceAddressPrefix": "*",
+                "sourcePortRange": "*",
+                "destinationAddressPrefixes": ["10.1.0.0/16", "10.2.0.0/16"],
+                "destinationPortRanges": ["1433", "5432-5433"]
+            }
+        ]
+    },
+    {
+        "name": "mgmt-nsg",
+        "properties": {
+            "securityRules": [
+                {
+                    "name": "allow-ssh",
+                    "properties": {
+                        "access": "Allow",
+                        "direction": "Inbound",
+                        "priority": 300,
+                        "protocol": "Tcp",
+                        "sourceAddressPrefix": "10.0.0.0/24",
+                        "sourcePortRange": "*",
+                        "destinationAddressPrefix": "*",
+                        "destinationPortRange": "22"
+                    }
+                },
+                {
+                    "name": "allow-rdp-jump",
+                    "properties": {
+                        "access": "Allow",
+                        "direction": "Inbound",
+                        "priority": 310,
+                        "protocol": "Tcp",
+                        "sourceAddressPrefixes": ["203.0.113.5", "2001:db8::10"],
+                        "sourcePortRange": "*",
+                        "destinationAddressPrefix": "10.0.2.4",
+                        "destinationPortRange": "3389"
+                    }
+                }
+            ]
+        }
+    }
+]
+
+# tests/testdata/import/k8s-network-policies.yaml
+apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: api-allow-frontend
+  namespace: production
+spec:
+  podSelector:
+    matchLabels:
+      app: api
+  policyTypes:
+    - Ingress
+    - Egress
+  ingress:
+    - from:
+        - podSelector:
+            matchLabels:
+              app: frontend
+        - ipBlock:
+            cidr: 10.0.0.0/8
+            except:
+              - 10.0.5.0/24
+      ports:
+        - port: 8080
+        - protocol: TCP
+          port: 9000
+          endPort: 9010
+  egress:
+    - to:
+        - namespaceSelector:
+            matchLabels:
+              name: database
+      ports:
+        - protocol: TCP
+          port: 5432
+---
+apiVersion: v1
+kind: List
+items:
+  - apiVersion: networking.k8s.io/v1
+    kind: NetworkPolicy
+    metadata:
+      name: default-deny
+    spec:
+      podSelector: {}
+      policyTypes:
+        - Ingress
+  - apiVersion: networking.k8s.io/v1
+    kind: NetworkPolicy
+    metadata:
+      name: default-deny-egress
+    spec:
+      podSelector: {}
+      policyTypes:
+        - Egress
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: app-settings
+    data:
+      mode: strict
+---
+apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: worker-egress
+  namespace: production
+spec:
+  podSelector:
+    matchExpressions:
+      - key: role
+        operator: In
+        values: [worker, batch]
+  egress:
+    - to:
+        - ipBlock:
+            cidr: 0.0.0.0/0
+      ports:
+        - protocol: UDP
+          port: 53
+
+# tests/import_test.go
+package tests
+
+import (
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+    
+    "netgit/pkg/config"
+    "netgit/pkg/importer"
+)
+
+func TestImportAWS(t *testing.T) {
+    cfg, warnings, err := importer.ImportFile("aws", "testdata/import/aws-security-groups.json")
+    require.NoError(t, err)
+    require.Len(t, cfg.SecurityGroups, 2)
+    
+    web := cfg.SecurityGroups[0]
+    assert.Equal(t, "sg-0a1b2c3d4e5f60001", web.ID)
+    assert.Equal(t, "web-tier-sg", web.Name)
+    assert.Equal(t, "vpc-0123456789abcdef0", web.VpcId)
+    assert.Equal(t, []config.Rule{
+        {Protocol: "tcp", Ports: []string{"443"}, Sources: []string{"0.0.0.0/0", "::/0"}, Action: "allow"},
+        {Protocol: "tcp", Ports: []string{"8000-8100"}, Sources: []string{"sg-0a1b2c3d4e5f60002"}, Action: "allow"},
+    }, web.Rules)
+    
+    bastion := cfg.SecurityGroups[1]
+    assert.Equal(t, []config.Rule{
+        {Protocol: "all", Sources: []string{"10.0.0.0/8"}, Action: "allow"},
+        {Protocol: "icmp", Sources: []string{"192.168.0.0/16"}, Action: "allow"},
+    }, bastion.Rules)
+    
+    // The default allow-all egress rule is expected; anything else is not
+    // representable and must be reported
+    require.Len(t, warnings, 1)
+    assert.Contains(t, warnings[0], "bastion-sg")
+}
+
+func TestImportGCP(t *testing.T) {
+    cfg, warnings, err := importer.ImportFile("gcp", "testdata/import/gcp-firewall-rules.json")
+    require.NoError(t, err)
+    
+    rules := map[string]config.FirewallRule{}
+    for _, rule := range cfg.FirewallRules {
+        rules[rule.Name] = rule
+    }
+    require.Len(t, rules, 4)
+    
+    web := rules["allow-web-tcp"]
+    assert.Equal(t, "INGRESS", web.Direction)
+    assert.Equal(t, 900, web.Priority)
+    assert.Equal(t, []string{"80", "443"}, web.Ports)
+    assert.Equal(t, []string{"web-server"}, web.TargetTags)
+    assert.Equal(t, "allow", web.Action)
+    assert.Equal(t, "icmp", rules["allow-web-icmp"].Protocol)
+    
+    smtp := rules["deny-smtp-egress"]
+    assert.Equal(t, "EGRESS", smtp.Direction)
+    assert.Equal(t, "deny", smtp.Action)
+    assert.Equal(t, []string{"0.0.0.0/0"}, smtp.DestinationRanges)
+    
+    assert.Equal(t, 1000, rules["allow-internal"].Priority, "missing priority takes GCP's default")
+    
+    require.Len(t, warnings, 1)
+    assert.Contains(t, warnings[0], "legacy-rdp")
+}
+
+func TestImportAzure(t *testing.T) {
+    cfg, warnings, err := importer.ImportFile("azure", "testdata/import/azure-nsg.json")
+    require.NoError(t, err)
+    require.Len(t, cfg.FirewallRules, 5)
+    
+    https := cfg.FirewallRules[0]
+    assert.Equal(t, "web-nsg-allow-https", https.Name)
+    assert.Equal(t, "INGRESS", https.Direction)
+    assert.Equal(t, "tcp", https.Protocol)
+    assert.Equal(t, []string{"443"}, https.Ports)
+    assert.Equal(t, []string{"0.0.0.0/0"}, https.SourceRanges)
+    assert.Equal(t, []string{"web-nsg"}, https.TargetTags)
+    assert.Equal(t, "allow", https.Action)
+    
+    probe := cfg.FirewallRules[1]
+    assert.Equal(t, "all", probe.Protocol)
+    assert.Empty(t, probe.Ports)
+    assert.Equal(t, []string{"AzureLoadBalancer"}, probe.SourceRanges)
+    
+    egress := cfg.FirewallRules[2]
+    assert.Equal(t, "EGRESS", egress.Direction)
+    assert.Equal(t, "deny", egress.Action)
+    assert.Equal(t, 200, egress.Priority)
+    assert.Equal(t, []string{"1433", "5432-5433"}, egress.Ports)
+    assert.Equal(t, []string{"10.1.0.0/16", "10.2.0.0/16"}, egress.DestinationRanges)
+    assert.Empty(t, egress.SourceRanges)
+    
+    ssh := cfg.FirewallRules[3]
+    assert.Equal(t, "mgmt-nsg-allow-ssh", ssh.Name)
+    assert.Equal(t, []string{"10.0.0.0/24"}, ssh.SourceRanges)
+    assert.Equal(t, []string{"22"}, ssh.Ports)
+    
+    // Bare addresses become single-address ranges
+    rdp := cfg.FirewallRules[4]
+    assert.Equal(t, []string{"203.0.113.5/32", "2001:db8::10/128"}, rdp.SourceRanges)
+    assert.Empty(t, rdp.DestinationRanges)
+    
+    require.Len(t, warnings, 2)
+    assert.Contains(t, warnings[0], "AzureLoadBalancer")
+    assert.Contains(t, warnings[1], "mgmt-nsg-allow-rdp-jump: destination 10.0.2.4/32 dropped")
+}
+
+func TestImportKubernetes(t *testing.T) {
+    cfg, warnings, err := importer.ImportFile("k8s", "testdata/import/k8s-network-policies.yaml")
+    require.NoError(t, err)
+    require.Len(t, cfg.NetworkPolicies, 4)
+    
+    api := cfg.NetworkPolicies[0]
+    assert.Equal(t, "api-allow-frontend", api.Name)
+    assert.Equal(t, "production", api.Namespace)
+    assert.Equal(t, map[string]string{"app": "api"}, api.Selector)
+    assert.Equal(t, []string{"Ingress", "Egress"}, api.PolicyTypes)
+    require.Len(t, api.Ingress, 1)
+    assert.Equal(t, []config.NetworkPolicyPort{
+        {Protocol: "TCP", Port: "8080"},
+        {Protocol: "TCP", Port: "9000-9010"},
+    }, api.Ingress[0].Ports)
+    require.Len(t, api.Ingress[0].From, 2)
+    assert.Equal(t, map[string]string{"app": "frontend"}, api.Ingress[0].From[0].PodSelector)
+    assert.Equal(t, &config.IPBlock{CIDR: "10.0.0.0/8", Except: []string{"10.0.5.0/24"}}, api.Ingress[0].From[1].IPBlock)
+    require.Len(t, api.Egress, 1)
+    assert.Equal(t, map[string]string{"name": "database"}, api.Egress[0].To[0].NamespaceSelector)
+    
+    deny := cfg.NetworkPolicies[1]
+    assert.Equal(t, "default-deny", deny.Name)
+    assert.Equal(t, "default", deny.Namespace)
+    assert.Empty(t, deny.Selector)
+    assert.Empty(t, deny.Ingress)
+    assert.Equal(t, []string{"Ingress"}, deny.PolicyTypes)
+    
+    // Without rules only policyTypes says which direction is denied
+    denyEgress := cfg.NetworkPolicies[2]
+    assert.Equal(t, "default-deny-egress", denyEgress.Name)
+    assert.Equal(t, []string{"Egress"}, denyEgress.PolicyTypes)
+    assert.Empty(t, denyEgress.Egress)
+    
+    worker := cfg.NetworkPolicies[3]
+    assert.Empty(t, worker.PolicyTypes)
+    assert.Equal(t, "0.0.0.0/0", worker.Egress[0].To[0].IPBlock.CIDR)
+    assert.Equal(t, "UDP", worker.Egress[0].Ports[0].Protocol)
+    
+    require.Len(t, warnings, 2)
+    assert.True(t, strings.Contains(warnings[0], "ConfigMap"))
+    assert.True(t, strings.Contains(warnings[1], "matchExpressions"))
+}
+
+func TestImportedConfigRoundTrips(t *testing.T) {
+    for _, tc := range []struct{ source, file string }{
+        {"aws", "testdata/import/aws-security-groups.json"},
+        {"gcp", "testdata/import/gcp-firewall-rules.json"},
+        {"azure", "testdata/import/azure-nsg.json"},
+        {"k8s", "testdata/import/k8s-network-policies.yaml"},
+    } {
+        t.Run(tc.source, func(t *testing.T) {
+            cfg, _, err := importer.ImportFile(tc.source, tc.file)
+            require.NoError(t, err)
+            
+            data, err := cfg.ToYAML()
+            require.NoError(t, err)
+            file := filepath.Join(t.TempDir(), "imported.yaml")
+            require.NoError(t, os.WriteFile(file, data, 0644))
+            reloaded, err := config.LoadFile(file)
+            require.NoError(t, err)
+            problems, err := config.Validate(file, data)
+            require.NoError(t, err)
+            assert.Empty(t, problems)
+            assert.Equal(t, len(cfg.SecurityGroups), len(reloaded.SecurityGroups))
+            assert.Equal(t, len(cfg.FirewallRules), len(reloaded.FirewallRules))
+            assert.Equal(t, len(cfg.NetworkPolicies), len(reloaded.NetworkPolicies))
+        })
+    }
+    
+    _, err := importer.GetImporter("openstack")
+    assert.Error(t, err)
+}
+
+# tests/testdata/export/network.yaml
+metadata:
+  name: "edge"
+  environment: "production"
+
+securityGroups:
+  - name: "web-tier-sg"
+    description: "Web tier"
+    vpcId: "vpc-12345678"
+    rules:
+      - protocol: "https"
+        ports: ["443"]
+        sources: ["0.0.0.0/0", "::/0"]
+        action: "allow"
+      - protocol: "tcp"
+        ports: ["8000-8100", "9090"]
+        sources: ["sg-0a1b2c3d", "pl-63a5400a"]
+        action: "allow"
+  - name: "ops"
+    description: "Operator access with a $${literal}"
+    vpcId: "vpc-12345678"
+    rules:
+      - protocol: "all"
+        sources: ["10.0.0.0/8"]
+        action: "allow"
+      - protocol: "icmp"
+        sources: ["10.0.0.0/8"]
+        action: "allow"
+
+firewallRules:
+  - name: "web-allow-https"
+    direction: "INGRESS"
+    priority: 100
+    protocol: "tcp"
+    ports: ["443"]
+    sourceRanges: ["0.0.0.0/0"]
+    targetTags: ["web"]
+    action: "allow"
+  - name: "web-deny-db"
+    direction: "EGRESS"
+    priority: 200
+    protocol: "tcp"
+    ports: ["1433", "5432-5433"]
+    destinationRanges: ["10.1.0.0/16", "10.2.0.0/16"]
+    targetTags: ["web"]
+    action: "deny"
+  - name: "allow-internal"
+    direction: "INGRESS"
+    priority: 1000
+    protocol: "all"
+    sourceRanges: ["10.128.0.0/9"]
+
+networkPolicies:
+  - name: "api"
+    namespace: "production"
+    selector:
+      app: "api"
+    ingress:
+      - ports:
+          - protocol: "tcp"
+            port: "8080"
+          - protocol: "TCP"
+            port: "9000-9010"
+        from:
+          - podSelector:
+              app: "frontend"
+          - ipBlock:
+              cidr: "10.0.0.0/8"
+              except: ["10.0.5.0/24"]
+    egress:
+      - ports:
+          - protocol: "TCP"
+            port: "5432"
+        to:
+          - namespaceSelector:
+              name: "database"
+  - name: "default-deny"
+    namespace: "production"
+
+# tests/testdata/export/k8s.golden
+apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: api
+  namespace: production
+spec:
+  podSelector:
+    matchLabels:
+      app: api
+  policyTypes:
+    - Ingress
+    - Egress
+  ingress:
+    - from:
+        - podSelector:
+            matchLabels:
+              app: frontend
+        - ipBlock:
+            cidr: 10.0.0.0/8
+            except:
+              - 10.0.5.0/24
+      ports:
+        - protocol: TCP
+          port: 8080
+        - protocol: TCP
+          port: 9000
+          endPort: 9010
+  egress:
+    - to:
+        - namespaceSelector:
+            matchLabels:
+              name: database
+      ports:
+        - protocol: TCP
+          port: 5432
+---
+apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: default-deny
+  namespace: production
+spec:
+  podSelector: {}
+  policyTypes:
+    - Ingress
+
+# tests/testdata/export/egress.yaml
+networkPolicies:
+  - name: "default-deny-egress"
+    namespace: "production"
+    policyTypes: ["Egress"]
+  - name: "dns-only"
+    namespace: "production"
+    selector:
+      app: "worker"
+    egress:
+      - ports:
+          - protocol: "UDP"
+            port: "53"
+
+# tests/testdata/export/k8s-egress.golden
+apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: default-deny-egress
+  namespace: production
+spec:
+  podSelector: {}
+  policyTypes:
+    - Egress
+---
+apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: dns-only
+  namespace: production
+spec:
+  podSelector:
+    matchLabels:
+      app: worker
+  policyTypes:
+    - Ingress
+    - Egress
+  egress:
+    - ports:
+        - protocol: UDP
+          port: 53
+
+# tests/testdata/export/terraform-aws.golden
+resource "aws_security_group" "web_tier_sg" {
+  name        = "web-tier-sg"
+  description = "Web tier"
+  vpc_id      = "vpc-12345678"
+
+  ingress {
+    protocol         = "tcp"
+    from_port        = 443
+    to_port          = 443
+    cidr_blocks      = ["0.0.0.0/0"]
+    ipv6_cidr_blocks = ["::/0"]
+  }
+
+  ingress {
+    protocol        = "tcp"
+    from_port       = 8000
+    to_port         = 8100
+    security_groups = ["sg-0a1b2c3d"]
+    prefix_list_ids = ["pl-63a5400a"]
+  }
+
+  ingress {
+    protocol        = "tcp"
+    from_port       = 9090
+    to_port         = 9090
+    security_groups = ["sg-0a1b2c3d"]
+    prefix_list_ids = ["pl-63a5400a"]
+  }
+
+  egress {
+    protocol    = "-1"
+    from_port   = 0
+    to_port     = 0
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+}
+
+resource "aws_security_group" "ops" {
+  name        = "ops"
+  description = "Operator access with a $${literal}"
+  vpc_id      = "vpc-12345678"
+
+  ingress {
+    protocol    = "-1"
+    from_port   = 0
+    to_port     = 0
+    cidr_blocks = ["10.0.0.0/8"]
+  }
+
+  ingress {
+    protocol    = "icmp"
+    from_port   = -1
+    to_port     = -1
+    cidr_blocks = ["10.0.0.0/8"]
+  }
+
+  egress {
+    protocol    = "-1"
+    from_port   = 0
+    to_port     = 0
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+}
+
+# tests/testdata/export/terraform-azure.golden
+variable "location" {
+  type = string
+}
+
+variable "resource_group_name" {
+  type = string
+}
+
+resource "azurerm_network_security_group" "web" {
+  name                = "web"
+  location            = var.location
+  resource_group_name = var.resource_group_name
+
+  security_rule {
+    name                       = "allow-https"
+    priority                   = 100
+    direction                  = "Inbound"
+    access                     = "Allow"
+    protocol                   = "Tcp"
+    source_port_range          = "*"
+    destination_port_range     = "443"
+    source_address_prefix      = "*"
+    destination_address_prefix = "*"
+  }
+
+  security_rule {
+    name                         = "deny-db"
+    priority                     = 200
+    direction                    = "Outbound"
+    access                       = "Deny"
+    protocol                     = "Tcp"
+    source_port_range            = "*"
+    destination_port_ranges      = ["1433", "5432-5433"]
+    source_address_prefix        = "*"
+    destination_address_prefixes = ["10.1.0.0/16", "10.2.0.0/16"]
+  }
+}
+
+resource "azurerm_network_security_group" "edge" {
+  name                = "edge"
+  location            = var.location
+  resource_group_name = var.resource_group_name
+
+  security_rule {
+    name                       = "allow-internal"
+    priority                   = 1000
+    direction                  = "Inbound"
+    access                     = "Allow"
+    protocol                   = "*"
+    source_port_range          = "*"
+    destination_port_range     = "*"
+    source_address_prefix      = "10.128.0.0/9"
+    destination_address_prefix = "*"
+  }
+}
+
+# tests/testdata/export/terraform-gcp.golden
+variable "network" {
+  type        = string
+  description = "VPC network the firewall rules apply to"
+  default     = "default"
+}
+
+resource "google_compute_firewall" "web_allow_https" {
+  name          = "web-allow-https"
+  network       = var.network
+  direction     = "INGRESS"
+  priority      = 100
+  source_ranges = ["0.0.0.0/0"]
+  target_tags   = ["web"]
+
+  allow {
+    protocol = "tcp"
+    ports    = ["443"]
+  }
+}
+
+resource "google_compute_firewall" "web_deny_db" {
+  name               = "web-deny-db"
+  network            = var.network
+  direction          = "EGRESS"
+  priority           = 200
+  destination_ranges = ["10.1.0.0/16", "10.2.0.0/16"]
+  target_tags        = ["web"]
+
+  deny {
+    protocol = "tcp"
+    ports    = ["1433", "5432-5433"]
+  }
+}
+
+resource "google_compute_firewall" "allow_internal" {
+  name          = "allow-internal"
+  network       = var.network
+  direction     = "INGRESS"
+  priority      = 1000
+  source_ranges = ["10.128.0.0/9"]
+
+  allow {
+    protocol = "all"
+  }
+}
+
+# tests/export_test.go
+package tests
+
+import (
+    "flag"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+    
+    "netgit/pkg/config"
+    "netgit/pkg/exporter"
+    "netgit/pkg/importer"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite golden files with the current output")
+
+func TestExportGolden(t *testing.T) {
+    cfg, err := config.LoadFile("testdata/export/network.yaml")
+    require.NoError(t, err)
+    
+    for _, format := range exporter.Formats {
+        t.Run(format, func(t *testing.T) {
+            exp, err := exporter.GetExporter(format)
+            require.NoError(t, err)
+            
+            got, err := exp.Export(*cfg)
+            require.NoError(t, err)
+            
+            golden := filepath.Join("testdata", "export", format+".golden")
+            if *updateGolden {
+                require.NoError(t, os.WriteFile(golden, got, 0644))
+            }
+            want, err := os.ReadFile(golden)
+            require.NoError(t, err)
+            assert.Equal(t, strings.TrimRight(string(want), "\n"), strings.TrimRight(string(got), "\n"))
+        })
+    }
+}
+
+// A policy that only denies egress must come back as one
+func TestExportKubernetesPolicyTypes(t *testing.T) {
+    cfg, err := config.LoadFile("testdata/export/egress.yaml")
+    require.NoError(t, err)
+    
+    got, err := exporter.NewKubernetesExporter().Export(*cfg)
+    require.NoError(t, err)
+    
+    golden := filepath.Join("testdata", "export", "k8s-egress.golden")
+    if *updateGolden {
+        require.NoError(t, os.WriteFile(golden, got, 0644))
+    }
+    want, err := os.ReadFile(golden)
+    require.NoError(t, err)
+    assert.Equal(t, strings.TrimRight(string(want), "\n"), strings.TrimRight(string(got), "\n"))
+    
+    reimported, _, err := importer.NewKubernetesImporter().Import(got)
+    require.NoError(t, err)
+    require.Len(t, reimported.NetworkPolicies, 2)
+    assert.Equal(t, []string{"Egress"}, reimported.NetworkPolicies[0].PolicyTypes)
+}
+
+func TestExportFirewallPrioritiesAndTags(t *testing.T) {
+    cfg := config.NetworkConfig{
+        FirewallRules: []config.FirewallRule{
+            {Name: "first", Direction: "INGRESS", Priority: 0, Protocol: "tcp", Ports: []string{"22"}},
+            {Name: "shared", Direction: "INGRESS", Priority: 300, Protocol: "tcp", Ports: []string{"443"}, TargetTags: []string{"web", "api"}},
+        },
+    }
+    
+    // Priority 0 outranks GCP's default of 1000 and must not be dropped
+    out, err := exporter.NewGCPTerraformExporter().Export(cfg)
+    require.NoError(t, err)
+    assert.Regexp(t, `priority\s+= 0\n`, string(out))
+    
+    // Every target tag's NSG gets the rule
+    cfg.FirewallRules = cfg.FirewallRules[1:]
+    out, err = exporter.NewAzureTerraformExporter().Export(cfg)
+    require.NoError(t, err)
+    assert.Contains(t, string(out), `resource "azurerm_network_security_group" "web"`)
+    assert.Contains(t, string(out), `resource "azurerm_network_security_group" "api"`)
+    assert.Equal(t, 2, strings.Count(string(out), `name                       = "shared"`))
+}
+
+func TestExportRejectsUnrepresentableRules(t *testing.T) {
+    cfg := config.NetworkConfig{
+        SecurityGroups: []config.SecurityGroup{
+            {Name: "sg", Rules: []config.Rule{{Protocol: "tcp", Ports: []string{"22"}, Action: "deny"}}},
+        },
+        FirewallRules: []config.FirewallRule{
+            {Name: "odd", Direction: "INGRESS", Protocol: "gre"},
+        },
+    }
+    
+    _, err := exporter.NewAWSTerraformExporter().Export(cfg)
+    assert.ErrorContains(t, err, "cannot deny")
+    
+    _, err = exporter.NewGCPTerraformExporter().Export(cfg)
+    assert.ErrorContains(t, err, "unsupported protocol: gre")
+    
+    _, err = exporter.NewAzureTerraformExporter().Export(cfg)
+    assert.ErrorContains(t, err, "priorities must be 100-4096, got 0")
+    
+    _, err = exporter.GetExporter("cloudformation")
+    assert.Error(t, err)
+}
+
+# tests/validate_test.go
+package tests
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+    
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+    
+    "netgit/pkg/config"
+)
+
+const invalidConfig = `metadata:
+  name: broken
+securityGroups:
+  - name: web
+    rules:
+      - protocol: tcp
+        ports: ["443", "8100-8000", "70000"]
+        sources: ["10.0.0.0/33", "10.0.0.1/8", "sg-app"]
+        action: permit
+  - description: no name
+firewallRules:
+  - name: fw
+    direction: inbound
+    priority: 70000
+    protocol: gre
+    sourceRange: ["0.0.0.0/0"]
+networkPolicies:
+  - name: np
+    ingress:
+      - ports:
+          - protocol: ICMP
+            port: http_alt
+        from:
+          - ipBlock:
+              cidr: 10.0.0.0/8
+`
+
+func TestValidateReportsPositions(t *testing.T) {
+    problems, err := config.Validate("broken.yaml", []byte(invalidConfig))
+    require.NoError(t, err)
+    
+    var got []string
+    for _, p := range problems {
+        got = append(got, p.String())
+    }
+    assert.Equal(t, []string{
+        `broken.yaml:7:24: securityGroups[0].rules[0].ports[1]: invalid port range "8100-8000": start is above end`,
+        `broken.yaml:7:37: securityGroups[0].rules[0].ports[2]: invalid port range "70000": port 70000 is out of range 0-65535`,
+        `broken.yaml:8:19: securityGroups[0].rules[0].sources[0]: invalid CIDR "10.0.0.0/33"`,
+        `broken.yaml:8:34: securityGroups[0].rules[0].sources[1]: CIDR 10.0.0.1/8 has host bits set; the network is 10.0.0.0/8`,
+        `broken.yaml:9:17: securityGroups[0].rules[0].action: action must be allow or deny, got "permit"`,
+        `broken.yaml:10:5: securityGroups[1]: missing required field "name"`,
+        `broken.yaml:13:16: firewallRules[0].direction: direction must be INGRESS or EGRESS, got "inbound"`,
+        `broken.yaml:14:15: firewallRules[0].priority: priority 70000 is out of range 0-65535`,
+        `broken.yaml:15:15: firewallRules[0].protocol: unknown protocol "gre"`,
+        `broken.yaml:16:5: firewallRules[0]: unknown field "sourceRange"`,
+        `broken.yaml:21:23: networkPolicies[0].ingress[0].ports[0].protocol: network policy protocol must be TCP, UDP or SCTP, got "ICMP"`,
+        `broken.yaml:22:19: networkPolicies[0].ingress[0].ports[0].port: invalid named port "http_alt"`,
+    }, got)
+}
+
+func TestValidateJSONAndTypes(t *testing.T) {
+    data := "{\n\t\"firewallRules\": [\n\t\t{\"name\": \"fw\", \"protocol\": \"tcp\", \"priority\": \"high\", \"ports\": \"22\"}\n\t]\n}\n"
+    problems, err := config.Validate("rules.json", []byte(data))
+    require.NoError(t, err)
+    require.Len(t, problems, 2)
+    assert.Equal(t, "firewallRules[0].priority", problems[0].Path)
+    assert.Equal(t, "expected an integer", problems[0].Message)
+    assert.Equal(t, 3, problems[0].Line)
+    assert.Equal(t, "expected a list", problems[1].Message)
+    
+    _, err = config.Validate("bad.yaml", []byte("securityGroups: [\n"))
+    assert.Error(t, err)
+}
+
+func TestSampleConfigsAreValid(t *testing.T) {
+    files, err := filepath.Glob("../examples/sample-configs/*.yaml")
+    require.NoError(t, err)
+    require.NotEmpty(t, files)
+    
+    for _, file := range files {
+        problems, err := config.ValidateFile(file)
+        require.NoError(t, err)
+        assert.Empty(t, problems, file)
+    }
+}
+
+func TestLoadRejectsUnknownFields(t *testing.T) {
+    dir, err := os.MkdirTemp("", "netgit-test")
+    require.NoError(t, err)
+    defer os.RemoveAll(dir)
+    
+    require.NoError(t, os.WriteFile(filepath.Join(dir, "good.yaml"), []byte("metadata:\n  name: good\n"), 0644))
+    require.NoError(t, os.WriteFile(filepath.Join(dir, "typo.yaml"), []byte("metadata:\n  name: typo\nsecurityGroup:\n  - name: web\n"), 0644))
+    
+    configs, err := config.LoadWorkingDirectory(dir)
+    require.Len(t, configs, 1)
+    assert.Equal(t, "good.yaml", configs[0].Source)
+    
+    var loadErr *config.LoadError
+    require.ErrorAs(t, err, &loadErr)
+    require.Len(t, loadErr.Files, 1)
+    assert.Equal(t, "typo.yaml", loadErr.Files[0].File)
+    assert.Contains(t, loadErr.Error(), `typo.yaml:3:1: unknown field "securityGroup"`)
+}
+
+# tests/loader_test.go
+package tests
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+    
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+    
+    "netgit/pkg/config"
+    "netgit/pkg/storage"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+    for name, content := range files {
+        path := filepath.Join(dir, filepath.FromSlash(name))
+        require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+        require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+    }
+}
+
+func sources(configs []config.NetworkConfig) []string {
+    var names []string
+    for _, cfg := range configs {
+        names = append(names, cfg.Source)
+    }
+    return names
+}
+
+func TestLoadWalksTreeAndHonoursIgnore(t *testing.T) {
+    dir, err := os.MkdirTemp("", "netgit-test")
+    require.NoError(t, err)
+    defer os.RemoveAll(dir)
+    
+    writeFiles(t, dir, map[string]string{
+        "aws.yaml":               "securityGroups:\n  - name: web\n",
+        "gcp/firewall.yml":       "firewallRules:\n  - name: fw\n    protocol: tcp\n",
+        "k8s/prod/policies.json": `{"networkPolicies": [{"name": "np"}]}`,
+        "drafts/wip.yaml":        "securityGroups: [\n",
+        "gcp/next.draft.yaml":    "metadata:\n  name: draft\n",
+        "gcp/keep.draft.yaml":    "metadata:\n  name: keep\n",
+        "policies/security.json": `[{"name": "p"}]`,
+        ".hidden/secret.yaml":    "metadata:\n  name: hidden\n",
+        "notes.txt":              "not a config",
+        "audit.json":             `{"action": "commit"}`,
+        ".netgitignore":          "# work in progress\ndrafts/\n*.draft.yaml\n!keep.draft.yaml\n",
+    })
+    
+    configs, err := config.LoadWorkingDirectory(dir)
+    require.NoError(t, err)
+    assert.Equal(t, []string{"aws.yaml", "gcp/firewall.yml", "gcp/keep.draft.yaml", "k8s/prod/policies.json"}, sources(configs))
+}
+
+func TestLoadMultiDocumentYAML(t *testing.T) {
+    dir, err := os.MkdirTemp("", "netgit-test")
+    require.NoError(t, err)
+    defer os.RemoveAll(dir)
+    
+    writeFiles(t, dir, map[string]string{
+        "network.yaml": "metadata:\n  name: first\n---\nsecurityGroups:\n  - name: web\n---\n---\nmetadata:\n  name: second\nsecurityGroups:\n  - name: db\n",
+    })
+    
+    configs, err := config.LoadWorkingDirectory(dir)
+    require.NoError(t, err)
+    require.Len(t, configs, 1)
+    assert.Equal(t, "first", configs[0].Metadata.Name)
+    require.Len(t, configs[0].SecurityGroups, 2)
+    assert.Equal(t, "db", configs[0].SecurityGroups[1].Name)
+}
+
+func TestIncludeSharedFragments(t *testing.T) {
+    dir, err := os.MkdirTemp("", "netgit-test")
+    require.NoError(t, err)
+    defer os.RemoveAll(dir)
+    
+    repo, err := storage.NewRepository(dir)
+    require.NoError(t, err)
+    defer repo.Close()
+    
+    office := "- 203.0.113.0/24\n- 198.51.100.0/24\n"
+    writeFiles(t, dir, map[string]string{
+        "shared/office-cidrs.yaml": office,
+        "shared/ssh-rule.yaml":     "protocol: tcp\nports: [\"22\"]\nsources: !include office-cidrs.yaml\naction: allow\n",
+        "prod/aws.yaml": `securityGroups:
+  - name: bastion
+    rules:
+      - !include ../shared/ssh-rule.yaml
+firewallRules:
+  - name: office-https
+    protocol: tcp
+    sourceRanges: [!include ../shared/office-cidrs.yaml, 192.168.0.0/16]
+`,
+    })
+    
+    configs, err := config.LoadWorkingDirectory(dir)
+    require.NoError(t, err)
+    assert.Equal(t, []string{"prod/aws.yaml", "shared/office-cidrs.yaml", "shared/ssh-rule.yaml"}, sources(configs))
+    assert.False(t, configs[0].Fragment)
+    assert.True(t, configs[1].Fragment)
+    assert.True(t, configs[2].Fragment)
+    
+    aws := configs[0]
+    assert.Equal(t, []config.Rule{
+        {Protocol: "tcp", Ports: []string{"22"}, Sources: []string{"203.0.113.0/24", "198.51.100.0/24"}, Action: "allow"},
+    }, aws.SecurityGroups[0].Rules)
+    assert.Equal(t, []string{"203.0.113.0/24", "198.51.100.0/24", "192.168.0.0/16"}, aws.FirewallRules[0].SourceRanges)
+    
+    // Fragments are committed with the configs and restored on checkout;
+    // editing one shows up in status
+    first := commitWorkingDir(t, repo, dir, "Use shared office ranges")
+    assert.Len(t, first.Config.SecurityGroups, 1)
+    
+    writeFiles(t, dir, map[string]string{"shared/office-cidrs.yaml": office + "- 192.0.2.0/24\n"})
+    status, err := repo.Status()
+    require.NoError(t, err)
+    assert.Equal(t, []string{"prod/aws.yaml", "shared/office-cidrs.yaml"}, status.Modified)
+    
+    commitWorkingDir(t, repo, dir, "Add a third office")
+    _, err = repo.Checkout(first.Hash, false)
+    require.NoError(t, err)
+    data, err := os.ReadFile(filepath.Join(dir, "shared", "office-cidrs.yaml"))
+    require.NoError(t, err)
+    assert.Equal(t, office, string(data))
+}
+
+func TestIncludeErrors(t *testing.T) {
+    dir, err := os.MkdirTemp("", "netgit-test")
+    require.NoError(t, err)
+    defer os.RemoveAll(dir)
+    
+    writeFiles(t, dir, map[string]string{
+        "a.yaml":          "securityGroups: !include b.yaml\n",
+        "b.yaml":          "- name: web\n  rules: !include a.yaml\n",
+        "missing.yaml":    "firewallRules:\n  - name: fw\n    protocol: tcp\n    sourceRanges: !include nowhere.yaml\n",
+        "escape.yaml":     "firewallRules: !include ../outside.yaml\n",
+        "ranges.yaml":     "- 10.0.0.0/8\n- 10.0.0.1/24\n",
+        "uses-ranges.yml": "firewallRules:\n  - name: fw\n    protocol: tcp\n    sourceRanges: !include ranges.yaml\n",
+    })
+    
+    _, err = config.LoadWorkingDirectory(dir)
+    var loadErr *config.LoadError
+    require.ErrorAs(t, err, &loadErr)
+    
+    failed := map[string]string{}
+    for _, f := range loadErr.Files {
+        failed[f.File] = f.Error()
+    }
+    assert.Contains(t, failed["a.yaml"], "include cycle")
+    assert.Contains(t, failed["missing.yaml"], `4:19: included file "nowhere.yaml" does not exist`)
+    assert.Contains(t, failed["escape.yaml"], "outside the working directory")
+    assert.Len(t, failed, 4)
+    
+    // Problems inside a fragment are reported against the fragment
+    problems, err := config.ValidateFile(filepath.Join(dir, "uses-ranges.yml"))
+    require.NoError(t, err)
+    require.Len(t, problems, 1)
+    assert.Equal(t, filepath.Join(dir, "ranges.yaml"), problems[0].File)
+    assert.Equal(t, 2, problems[0].Line)
+    assert.Contains(t, problems[0].Message, "host bits")
+}
+
+func TestPosition(t *testing.T) {
+    raw := []byte(`metadata:
+  name: net
+securityGroups:
+  - name: web
+    rules:
+      - protocol: https
+        sources: ["0.0.0.0/0"]
+---
+securityGroups:
+  - name: db
+    rules:
+      - protocol: mysql
+        sources:
+          - 10.0.0.0/8
+          - 0.0.0.0/0
+`)
+    
+    for path, want := range map[string][2]int{
+        "securityGroups[0].rules[0].protocol":   {6, 19},
+        "securityGroups[0].rules[0].sources[0]": {7, 19},
+        "securityGroups[1].name":                {10, 11},
+        "securityGroups[1].rules":               {11, 5},
+        "securityGroups[1].rules[0].sources[1]": {15, 13},
+        "securityGroups[1].description":         {10, 5},
+        "firewallRules":                         {1, 1},
+        "metadata.name":                         {2, 9},
+    } {
+        line, column := config.Position(raw, path)
+        assert.Equal(t, want, [2]int{line, column}, path)
+    }
+    
+    line, column := config.Position([]byte("securityGroups: [\n"), "securityGroups[0]")
+    assert.Equal(t, [2]int{0, 0}, [2]int{line, column})
+}
+
+# tests/overlay_test.go
+package tests
+
+import (
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+    
+    "netgit/pkg/config"
+    "netgit/pkg/storage"
+)
+
+const baseConfig = `metadata:
+  name: network
+variables:
+  vpc:
+    type: string
+    value: vpc-dev
+  office:
+    type: list
+    value: ["203.0.113.0/24"]
+  ssh_priority:
+    type: number
+    value: 900
+securityGroups:
+  - name: web
+    description: "web tier in ${vpc}, costs $${cost}"
+    vpcId: ${vpc}
+    rules:
+      - protocol: tcp
+        ports: ["443"]
+        sources: ["0.0.0.0/0"]
+      - protocol: tcp
+        ports: ["22"]
+        sources: ["10.0.0.0/8", "${office}"]
+firewallRules:
+  - name: office-ssh
+    direction: INGRESS
+    priority: ${ssh_priority}
+    protocol: tcp
+    ports: ["22"]
+    sourceRanges: ${office}
+  - name: debug
+    direction: INGRESS
+    protocol: tcp
+    ports: ["8080"]
+    sourceRanges: ["0.0.0.0/0"]
+`
+
+const productionOverlay = `metadata:
+  environment: production
+  overlay: true
+variables:
+  vpc:
+    value: vpc-prod
+  office:
+    value: ["198.51.100.0/24", "192.0.2.0/24"]
+securityGroups:
+  - name: web
+    rules:
+      - protocol: tcp
+        ports: ["443"]
+        sources: ["10.0.0.0/8"]
+  - name: bastion
+    rules:
+      - protocol: tcp
+        ports: ["22"]
+        sources: ${office}
+firewallRules:
+  - name: office-ssh
+    priority: 100
+delete:
+  - firewallRule/debug
+`
+
+func environmentDir(t *testing.T) string {
+    dir, err := os.MkdirTemp("", "netgit-test")
+    require.NoError(t, err)
+    writeFiles(t, dir, map[string]string{
+        "network.yaml":            baseConfig,
+        "overlays/production.yaml": productionOverlay,
+        "overlays/staging.yaml":   "metadata:\n  environment: staging\n  overlay: true\nvariables:\n  vpc:\n    value: vpc-staging\n",
+    })
+    return dir
+}
+
+func TestVariablesTakeDeclaredValues(t *testing.T) {
+    dir := environmentDir(t)
+    defer os.RemoveAll(dir)
+    
+    configs, err := config.LoadWorkingDirectory(dir)
+    require.NoError(t, err)
+    require.Equal(t, []string{"network.yaml", "overlays/production.yaml", "overlays/staging.yaml"}, sources(configs))
+    
+    base := configs[0]
+    web := base.SecurityGroups[0]
+    assert.Equal(t, "vpc-dev", web.VpcId)
+    assert.Equal(t, "web tier in vpc-dev, costs ${cost}", web.Description)
+    assert.Equal(t, []string{"10.0.0.0/8", "203.0.113.0/24"}, web.Rules[1].Sources)
+    assert.Equal(t, 900, base.FirewallRules[0].Priority)
+    assert.Equal(t, []string{"203.0.113.0/24"}, base.FirewallRules[0].SourceRanges)
+    assert.True(t, configs[1].Metadata.Overlay)
+    
+    // Without an environment, overlays are left out
+    configs, err = config.LoadEnvironment(dir, "")
+    require.NoError(t, err)
+    assert.Equal(t, []string{"network.yaml"}, sources(configs))
+}
+
+func TestOverlayMergesByName(t *testing.T) {
+    dir := environmentDir(t)
+    defer os.RemoveAll(dir)
+    
+    configs, err := config.LoadEnvironment(dir, "production")
+    require.NoError(t, err)
+    require.Equal(t, []string{"network.yaml", "overlays/production.yaml"}, sources(configs))
+    
+    base := configs[0]
+    assert.Equal(t, "production", base.Metadata.Environment)
+    require.Len(t, base.SecurityGroups, 1)
+    web := base.SecurityGroups[0]
+    assert.Equal(t, "vpc-prod", web.VpcId)
+    assert.Equal(t, "web tier in vpc-prod, costs ${cost}", web.Description)
+    require.Len(t, web.Rules, 2)
+    assert.Equal(t, []string{"10.0.0.0/8"}, web.Rules[0].Sources)
+    assert.Equal(t, []string{"10.0.0.0/8", "198.51.100.0/24", "192.0.2.0/24"}, web.Rules[1].Sources)
+    
+    // The priority is overridden, the rest of the rule kept, and the debug
+    // rule deleted
+    require.Len(t, base.FirewallRules, 1)
+    assert.Equal(t, 100, base.FirewallRules[0].Priority)
+    assert.Equal(t, "tcp", base.FirewallRules[0].Protocol)
+    assert.Equal(t, []string{"198.51.100.0/24", "192.0.2.0/24"}, base.FirewallRules[0].SourceRanges)
+    
+    // Resources only the overlay has are added from the overlay's file
+    added := configs[1]
+    assert.False(t, added.Metadata.Overlay)
+    require.Len(t, added.SecurityGroups, 1)
+    assert.Equal(t, "bastion", added.SecurityGroups[0].Name)
+    
+    // Staging only changes a variable
+    configs, err = config.LoadEnvironment(dir, "staging")
+    require.NoError(t, err)
+    require.Len(t, configs, 1)
+    assert.Equal(t, "vpc-staging", configs[0].SecurityGroups[0].VpcId)
+    assert.Len(t, configs[0].FirewallRules, 2)
+}
+
+func TestEnvironmentProblems(t *testing.T) {
+    dir := environmentDir(t)
+    defer os.RemoveAll(dir)
+    
+    writeFiles(t, dir, map[string]string{
+        "overlays/staging.yaml": "metadata:\n  environment: staging\n  overlay: true\nvariables:\n  vpc:\n    value: 42\n  region:\n    value: eu\n",
+        "extra.yaml":            "securityGroups:\n  - name: cache\n    vpcId: ${cache_vpc}\n",
+    })
+    
+    problems, err := config.ValidateWorkingDirectory(dir, "production")
+    require.NoError(t, err)
+    var got []string
+    for _, p := range problems {
+        got = append(got, p.String())
+    }
+    assert.Equal(t, []string{
+        filepath.Join(dir, "extra.yaml") + `:3:12: undefined variable "cache_vpc"`,
+        filepath.Join(dir, "overlays/staging.yaml") + `:6:5: variables.vpc: variable "vpc": value 42 is not a string`,
+        filepath.Join(dir, "overlays/staging.yaml") + `:8:5: variables.region: overlay sets undeclared variable "region"`,
+    }, got)
+    
+    _, err = config.LoadEnvironment(dir, "production")
+    var loadErr *config.LoadError
+    require.ErrorAs(t, err, &loadErr)
+    
+    // A value substituted into a typed field is checked where it is used
+    writeFiles(t, dir, map[string]string{
+        "overlays/staging.yaml": "metadata:\n  environment: staging\n  overlay: true\nvariables:\n  office:\n    value: [\"10.0.0.1/8\"]\n",
+        "extra.yaml":            "metadata:\n  name: extra\n",
+    })
+    problems, err = config.ValidateWorkingDirectory(dir, "staging")
+    require.NoError(t, err)
+    require.Len(t, problems, 2)
+    assert.Equal(t, filepath.Join(dir, "network.yaml"), problems[0].File)
+    assert.Equal(t, 23, problems[0].Line)
+    assert.Equal(t, "securityGroups[0].rules[1].sources[1]", problems[0].Path)
+    assert.Equal(t, 30, problems[1].Line)
+    assert.Equal(t, "firewallRules[0].sourceRanges[0]", problems[1].Path)
+    
+    problems, err = config.ValidateWorkingDirectory(dir, "")
+    require.NoError(t, err)
+    assert.Empty(t, problems)
+}
+
+func TestDiffAndDeployEnvironment(t *testing.T) {
+    dir := environmentDir(t)
+    defer os.RemoveAll(dir)
+    
+    repo, err := storage.NewRepository(dir)
+    require.NoError(t, err)
+    defer repo.Close()
+    commitWorkingDir(t, repo, dir, "Add environments")
+    
+    // Committed configs hold the default values; overlays stay separate
+    head, err := repo.GetHEAD()
+    require.NoError(t, err)
+    require.Len(t, head.Config.SecurityGroups, 1)
+    assert.Equal(t, "vpc-dev", head.Config.SecurityGroups[0].VpcId)
+    
+    deployed, err := repo.EnvironmentConfig("HEAD", "production")
+    require.NoError(t, err)
+    assert.Equal(t, "production", deployed.Metadata.Environment)
+    require.Len(t, deployed.SecurityGroups, 2)
+    assert.Equal(t, "vpc-prod", deployed.SecurityGroups[0].VpcId)
+    
+    status, err := repo.Status()
+    require.NoError(t, err)
+    assert.True(t, status.Clean)
+    
+    // Changing a production value only shows up in production's diff
+    writeFiles(t, dir, map[string]string{
+        "overlays/production.yaml": strings.Replace(productionOverlay, "vpc-prod", "vpc-prod-2", 1),
+    })
+    status, err = repo.Status()
+    require.NoError(t, err)
+    assert.Equal(t, []string{"overlays/production.yaml"}, status.Modified)
+    
+    changes, err := repo.DiffEnvironment("HEAD", "WORKING", "production")
+    require.NoError(t, err)
+    assert.Equal(t, []string{"network.yaml"}, changes.Files())
+    
+    changes, err = repo.DiffEnvironment("HEAD", "WORKING", "staging")
+    require.NoError(t, err)
+    assert.Empty(t, changes.Files())
+}
+
+# tests/normalize_test.go
+package tests
+
+import (
+    "os"
+    "testing"
+    
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+    
+    "netgit/pkg/config"
+    "netgit/pkg/diff"
+    "netgit/pkg/merge"
+    "netgit/pkg/policy"
+    "netgit/pkg/storage"
+)
+
+func TestNormalizePortsAndCIDRs(t *testing.T) {
+    assert.Equal(t, []string{"22", "80-90", "443", "http"},
+        config.NormalizePorts([]string{"443", "81", "http", "80-90", "22", "22-22", "85"}))
+    
+    assert.Equal(t, []string{"10.0.0.0/8", "192.168.0.0/23", "2001:db8::/32", "sg-0123"},
+        config.NormalizeCIDRs([]string{
+            "sg-0123", "10.1.0.0/16", "2001:db8::/32", "10.0.0.0/8",
+            "192.168.1.0/24", "192.168.0.0/24", "10.2.3.4/32", "sg-0123",
+        }))
+    
+    // Sibling halves join recursively, and host bits are masked off
+    assert.Equal(t, []string{"10.0.0.0/8"},
+        config.NormalizeCIDRs([]string{"10.128.0.0/9", "10.0.0.0/10", "10.64.0.1/10"}))
+}
+
+func TestPortAndCIDRSets(t *testing.T) {
+    assert.True(t, config.PortsWithin([]string{"80-90"}, []string{"80-84", "85", "86-100"}))
+    assert.False(t, config.PortsWithin([]string{"80-90", "22"}, []string{"80-100"}))
+    assert.False(t, config.PortsWithin(nil, []string{"1-65535"}))
+    assert.True(t, config.PortsOverlap([]string{"3300-3310"}, []string{"3306"}))
+    assert.False(t, config.PortsOverlap([]string{"22"}, []string{"23-25"}))
+    
+    // Halves together cover their parent, but neither does alone
+    assert.True(t, config.CIDRWithin("10.0.0.0/8", []string{"10.0.0.0/9", "10.128.0.0/9"}))
+    assert.False(t, config.CIDRWithin("10.0.0.0/8", []string{"10.0.0.0/9"}))
+    assert.False(t, config.CIDRWithin("::/0", []string{"0.0.0.0/0"}))
+    assert.True(t, config.CIDRWithin("sg-0123", []string{"sg-0123"}))
+    assert.True(t, config.CIDRsOverlap([]string{"0.0.0.0/0"}, []string{"192.168.1.0/24"}))
+    assert.False(t, config.CIDRsOverlap([]string{"10.0.0.0/8"}, []string{"172.16.0.0/12", "sg-0123"}))
+}
+
+func TestNormalizeOrdersResources(t *testing.T) {
+    cfg := config.NetworkConfig{
+        Metadata: config.Metadata{Labels: map[string]string{}},
+        SecurityGroups: []config.SecurityGroup{
+            {Name: "web", Rules: []config.Rule{
+                {Protocol: "TCP", Ports: []string{"443", "80"}, Sources: []string{"10.1.0.0/16", "10.0.0.0/8"}},
+                {Protocol: "tcp", Ports: []string{"22"}, Sources: []string{"10.0.0.0/8"}},
+                {Protocol: "tcp", Ports: []string{"22"}, Sources: []string{"10.0.0.0/8"}},
+            }},
+            {Name: "db"},
+        },
+        FirewallRules: []config.FirewallRule{
+            {Name: "b", Direction: "ingress", TargetTags: []string{"web", "api", "web"}},
+            {Name: "a", Protocol: "UDP"},
+        },
+    }
+    original := cfg.SecurityGroups[0].Rules[0].Ports[0]
+    
+    cfg.Normalize()
+    assert.Nil(t, cfg.Metadata.Labels)
+    assert.Equal(t, "db", cfg.SecurityGroups[0].Name)
+    assert.Equal(t, []config.Rule{
+        {Protocol: "tcp", Ports: []string{"22"}, Sources: []string{"10.0.0.0/8"}},
+        {Protocol: "tcp", Ports: []string{"80", "443"}, Sources: []string{"10.0.0.0/8"}},
+    }, cfg.SecurityGroups[1].Rules)
+    assert.Equal(t, "a", cfg.FirewallRules[0].Name)
+    assert.Equal(t, "udp", cfg.FirewallRules[0].Protocol)
+    assert.Equal(t, "INGRESS", cfg.FirewallRules[1].Direction)
+    assert.Equal(t, []string{"api", "web"}, cfg.FirewallRules[1].TargetTags)
+    assert.Equal(t, "443", original)
+}
+
+func TestReorderedFileIsNotAChange(t *testing.T) {
+    dir, err := os.MkdirTemp("", "netgit-test")
+    require.NoError(t, err)
+    defer os.RemoveAll(dir)
+    
+    repo, err := storage.NewRepository(dir)
+    require.NoError(t, err)
+    defer repo.Close()
+    
+    writeFiles(t, dir, map[string]string{
+        "network.yaml": `securityGroups:
+  - name: web
+    rules:
+      - protocol: tcp
+        ports: ["80", "443"]
+        sources: ["10.0.0.0/8"]
+  - name: db
+    rules:
+      - protocol: tcp
+        ports: ["5432"]
+        sources: ["10.1.0.0/16", "10.2.0.0/16"]
+`,
+    })
+    first := commitWorkingDir(t, repo, dir, "Add groups")
+    
+    writeFiles(t, dir, map[string]string{
+        "network.yaml": `securityGroups:
+  - name: db
+    rules:
+      - protocol: TCP
+        ports: ["5432"]
+        sources: ["10.2.0.0/16", "10.1.0.0/16", "10.1.2.0/24"]
+  - name: web
+    rules:
+      - protocol: tcp
+        ports: ["443", "80"]
+        sources: ["10.0.0.0/8"]
+`,
+    })
+    
+    status, err := repo.Status()
+    require.NoError(t, err)
+    assert.True(t, status.Clean)
+    
+    changes, err := repo.Diff("HEAD", "WORKING")
+    require.NoError(t, err)
+    assert.Empty(t, changes.Files())
+    
+    // The resources hash the same; only the file's bytes differ
+    second := commitWorkingDir(t, repo, dir, "Reorder")
+    assert.NotEqual(t, first.Tree, second.Tree)
+    assert.True(t, diff.Compare(first.Config, second.Config).Empty())
+}
+
+func TestDiffAfterNormalize(t *testing.T) {
+    before := config.NetworkConfig{
+        SecurityGroups: []config.SecurityGroup{
+            {Name: "web", Rules: []config.Rule{{Protocol: "tcp", Ports: []string{"80"}, Sources: []string{"10.0.0.0/8"}}}},
+        },
+        NetworkPolicies: []config.NetworkPolicy{
+            {Name: "api", Ingress: []config.NetworkPolicyRule{{Ports: []config.NetworkPolicyPort{{Protocol: "TCP", Port: "8080"}}}}},
+        },
+    }
+    after := config.NetworkConfig{
+        SecurityGroups: []config.SecurityGroup{
+            {Name: "web", Rules: []config.Rule{{Protocol: "TCP", Ports: []string{"81", "80"}, Sources: []string{"10.0.0.0/8"}}}},
+        },
+        NetworkPolicies: []config.NetworkPolicy{
+            {Name: "api", Ingress: []config.NetworkPolicyRule{{Ports: []config.NetworkPolicyPort{{Protocol: "tcp", Port: "8080"}}}}},
+        },
+    }
+    
+    // Merging 80 and 81 into 80-81 changes the rule's key, but it is still
+    // the same rule with a port added; protocol case is not a change
+    result := diff.Compare(before, after)
+    require.Len(t, result.Changes, 1)
+    assert.Equal(t, "securityGroup/web", result.Changes[0].ID())
+    assert.Equal(t, []diff.FieldChange{
+        {Path: "rules[tcp:80].ports", Added: []string{"80-81"}, Removed: []string{"80"}},
+    }, result.Changes[0].Fields)
+    
+    // Files committed before normalization merge with normalized ones
+    group := func(rule config.Rule) []config.NetworkConfig {
+        return []config.NetworkConfig{{
+            Source:         "web.yaml",
+            SecurityGroups: []config.SecurityGroup{{Name: "web", Rules: []config.Rule{rule}}},
+        }}
+    }
+    base := config.Rule{Protocol: "TCP", Ports: []string{"81", "80"}, Sources: []string{"10.0.0.0/8"}}
+    ours := config.Rule{Protocol: "tcp", Ports: []string{"80-81"}, Sources: []string{"10.0.0.0/8", "192.168.0.0/16"}}
+    theirs := config.Rule{Protocol: "tcp", Ports: []string{"80", "81"}, Sources: []string{"10.0.0.0/8"}, Action: "deny"}
+    merged, conflicts := merge.ThreeWay(group(base), group(ours), group(theirs))
+    assert.Empty(t, conflicts)
+    require.Len(t, merged, 1)
+    assert.Equal(t, []config.Rule{
+        {Protocol: "tcp", Ports: []string{"80-81"}, Sources: []string{"10.0.0.0/8", "192.168.0.0/16"}, Action: "deny"},
+    }, merged[0].SecurityGroups[0].Rules)
+}
+
+func TestPolicyPathsPointAtTheFile(t *testing.T) {
+    engine, err := policy.NewEngine("../examples/policies")
+    require.NoError(t, err)
+    
+    cfg := config.NetworkConfig{
+        Source: "db.yaml",
+        SecurityGroups: []config.SecurityGroup{
+            {Name: "web", Rules: []config.Rule{{Protocol: "tcp", Ports: []string{"443"}, Sources: []string{"0.0.0.0/0"}}}},
+            {Name: "db", Rules: []config.Rule{
+                {Protocol: "tcp", Ports: []string{"22"}, Sources: []string{"10.0.0.0/8"}},
+                {Protocol: "MySQL", Ports: []string{"3306"}, Sources: []string{"10.0.0.0/8", "0.0.0.0/0"}},
+            }},
+        },
+    }
+    
+    // The canonical form sorts db first, the MySQL rule first and collapses
+    // its sources to 0.0.0.0/0
+    violations, err := engine.Verify(cfg)
+    require.NoError(t, err)
+    require.NotEmpty(t, violations)
+    assert.Equal(t, "securityGroups[1].rules[1].sources[1]", violations[0].Path)
+    
+    // Sources merged into a new prefix map to the list that held them
+    cfg.SecurityGroups[0].Rules[0].Sources = []string{"10.128.0.0/9", "10.0.0.0/9"}
+    assert.Equal(t, "securityGroups[0].rules[0].sources", cfg.OriginalPath("securityGroups[1].rules[0].sources[0]"))
+    assert.Equal(t, "securityGroups[0].rules[0].ports[0]", cfg.OriginalPath("securityGroups[1].rules[0].ports[0]"))
+    
+    // Network policy ports sort by their lowercased protocol, then port
+    policies := config.NetworkConfig{NetworkPolicies: []config.NetworkPolicy{{
+        Name: "web", Namespace: "prod",
+        Ingress: []config.NetworkPolicyRule{{Ports: []config.NetworkPolicyPort{
+            {Protocol: "TCP", Port: "443"},
+            {Protocol: "TCP", Port: "80"},
+        }}},
+    }}}
+    assert.Equal(t, "networkPolicies[0].ingress[0].ports[1]", policies.OriginalPath("networkPolicies[0].ingress[0].ports[0]"))
+    assert.Equal(t, "networkPolicies[0].ingress[0].ports[0]", policies.OriginalPath("networkPolicies[0].ingress[0].ports[1]"))
+}
+
+# tests/diff_test.go
+package tests
+
+import (
+    "encoding/json"
+    "strings"
+    "testing"
+    
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+    
+    "netgit/pkg/config"
+    "netgit/pkg/diff"
+)
+
+func TestSemanticDiff(t *testing.T) {
+    before := config.NetworkConfig{
+        SecurityGroups: []config.SecurityGroup{
+            {
+                Name: "app-sg",
+                Rules: []config.Rule{
+                    {Protocol: "tcp", Ports: []string{"22"}, Sources: []string{"10.0.1.0/24"}, Action: "allow"},
+                },
+            },
+            {Name: "legacy-sg"},
+        },
+        FirewallRules: []config.FirewallRule{
+            {Name: "allow-web", Priority: 1000, Ports: []string{"80"}},
+        },
+    }
+    
+    after := config.NetworkConfig{
+        SecurityGroups: []config.SecurityGroup{
+            {
+                Name: "app-sg",
+                Rules: []config.Rule{
+                    {Protocol: "tcp", Ports: []string{"22"}, Sources: []string{"10.0.1.0/24", "10.0.2.0/24"}, Action: "allow"},
+                },
+            },
+            {Name: "cache-sg"},
+        },
+        FirewallRules: []config.FirewallRule{
+            {Name: "allow-web", Priority: 900, Ports: []string{"80"}},
+        },
+    }
+    
+    result := diff.Compare(before, after)
+    
+    assert.Equal(t, []string{"securityGroup/cache-sg"}, result.IDs(diff.Added))
+    assert.Equal(t, []string{"securityGroup/legacy-sg"}, result.IDs(diff.Removed))
+    assert.Equal(t, []string{"securityGroup/app-sg", "firewallRule/allow-web"}, result.IDs(diff.Modified))
+    
+    appChange := result.Changes[0]
+    require.Len(t, appChange.Fields, 1)
+    assert.Equal(t, "rules[tcp:22].sources", appChange.Fields[0].Path)
+    assert.Equal(t, []string{"10.0.2.0/24"}, appChange.Fields[0].Added)
+    assert.Empty(t, appChange.Fields[0].Removed)
+    
+    unified, err := result.Format(diff.FormatUnified)
+    require.NoError(t, err)
+    assert.Contains(t, unified, "@@ rules[tcp:22].sources @@\n+10.0.2.0/24\n")
+    assert.Contains(t, unified, "--- a/securityGroup/legacy-sg\n+++ /dev/null\n")
+    assert.NotContains(t, unified, "\033[")
+    
+    colored, err := result.Format(diff.FormatColor)
+    require.NoError(t, err)
+    assert.True(t, strings.Contains(colored, "\033[32m+10.0.2.0/24"))
+    
+    jsonOut, err := result.Format(diff.FormatJSON)
+    require.NoError(t, err)
+    var decoded diff.Result
+    require.NoError(t, json.Unmarshal([]byte(jsonOut), &decoded))
+    assert.Len(t, decoded.Changes, 4)
+    
+    _, err = result.Format("xml")
+    assert.Error(t, err)
+}
+
+func TestDiffRulesSharingAKey(t *testing.T) {
+    internal := config.Rule{Protocol: "tcp", Ports: []string{"22"}, Sources: []string{"10.0.0.0/8"}}
+    public := config.Rule{Protocol: "tcp", Ports: []string{"22"}, Sources: []string{"0.0.0.0/0"}}
+    group := func(rules ...config.Rule) config.NetworkConfig {
+        return config.NetworkConfig{SecurityGroups: []config.SecurityGroup{{Name: "web-sg", Rules: rules}}}
+    }
+    
+    result := diff.Compare(group(internal), group(internal, public))
+    require.Len(t, result.Changes, 1)
+    require.Len(t, result.Changes[0].Fields, 1)
+    field := result.Changes[0].Fields[0]
+    assert.Equal(t, "rules[tcp:22]", field.Path)
+    assert.Equal(t, public, field.New)
+    
+    // Changing either of two rules on one port is seen
+    widened := public
+    widened.Sources = []string{"0.0.0.0/0", "::/0"}
+    result = diff.Compare(group(internal, public), group(widened, internal))
+    require.Len(t, result.Changes, 1)
+    require.Len(t, result.Changes[0].Fields, 1)
+    assert.Equal(t, []string{"::/0"}, result.Changes[0].Fields[0].Added)
+    
+    assert.True(t, diff.Compare(group(internal, public), group(public, internal)).Empty())
+}
+
+func TestSemanticDiffIdenticalConfigs(t *testing.T) {
+    cfg := config.NetworkConfig{
+        SecurityGroups: []config.SecurityGroup{
+            {Name: "web-sg", Rules: []config.Rule{{Protocol: "tcp", Ports: []string{"443"}, Sources: []string{"0.0.0.0/0"}}}},
+        },
+    }
+    
+    result := diff.Compare(cfg, cfg)
+    assert.True(t, result.Empty())
+}
+
+# tests/policy_test.go
+package tests
+
+import (
+    "encoding/json"
+    "encoding/xml"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+    
+    "github.com/prometheus/client_golang/prometheus/testutil"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+    
+    "netgit/pkg/policy"
+    "netgit/pkg/config"
+    "netgit/pkg/metrics"
+)
+
+func TestPolicyEngine(t *testing.T) {
+    engine, err := policy.NewEngine("../examples/policies")
+    require.NoError(t, err)
+    
+    // Test configuration with policy violations
+    violatingConfig := config.NetworkConfig{
+        SecurityGroups: []config.SecurityGroup{
+            {
+                Name: "bad-sg",
+                Rules: []config.Rule{
+                    {
+                        Protocol: "mysql",
+                        Ports:    []string{"3306"},
+                        Sources:  []string{"0.0.0.0/0"}, // Public access to database
+                        Action:   "allow",
+                    },
+                },
+            },
+        },
+    }
+    
+    violatingConfig.Source = "db.yaml"
+    violations, err := engine.Verify(violatingConfig)
+    require.NoError(t, err)
+    
+    assert.Greater(t, len(violations), 0)
+    assert.Equal(t, "db.yaml", violations[0].File)
+    assert.Equal(t, "securityGroups[0].rules[0].sources[0]", violations[0].Path)
+    
+    // Test configuration without violations
+    goodConfig := config.NetworkConfig{
+        SecurityGroups: []config.SecurityGroup{
+            {
+                Name: "good-sg",
+                Rules: []config.Rule{
+                    {
+                        Protocol: "https",
+                        Ports:    []string{"443"},
+                        Sources:  []string{"10.0.0.0/8"}, // Internal only
+                        Action:   "allow",
+                    },
+                },
+            },
+        },
+        FirewallRules: []config.FirewallRule{
+            {Name: "rule1"},
+            {Name: "rule2"}, // Satisfies redundancy requirement
+        },
+    }
+    
+    violations, err = engine.Verify(goodConfig)
+    require.NoError(t, err)
+    
+    assert.Equal(t, 0, len(violations))
+}
 
-securityGroups:
-  - name: "web-tier-sg"
-    description: "Security group for web tier"
-    vpcId: "vpc-12345678"
-    rules:
-      - protocol: "https"
-        ports: ["443"]
-        sources: ["0.0.0.0/0"]
-        action: "allow"
-      - protocol: "http"
-        ports: ["80"]
-        sources: ["10.0.0.0/8"]
-        action: "allow"
-  
-  - name: "app-tier-sg"
-    description: "Security group for application tier"
-    vpcId: "vpc-12345678"
-    rules:
-      - protocol: "tcp"
-        ports: ["8080", "8443"]
-        sources: ["sg-web-tier"]
-        action: "allow"
-      - protocol: "tcp"
-        ports: ["22"]
-        sources: ["10.0.1.0/24"]
-        action: "allow"
-  
-  - name: "db-tier-sg"
-    description: "Security group for database tier"
-    vpcId: "vpc-12345678"
-    rules:
-      - protocol: "mysql"
-        ports: ["3306"]
-        sources: ["sg-app-tier"]
-        action: "allow"
+func TestBuiltinPolicyTests(t *testing.T) {
+    dir, err := os.MkdirTemp("", "netgit-test")
+    require.NoError(t, err)
+    defer os.RemoveAll(dir)
+    
+    results, err := policy.RunTests(dir)
+    require.NoError(t, err)
+    require.NotEmpty(t, results)
+    for _, r := range results {
+        assert.True(t, r.Passed, "%s.%s: %s", r.Package, r.Name, r.Error)
+    }
+}
 
-# examples/sample-configs/k8s-network-policies.yaml
-metadata:
-  name: "k8s-network-policies"
-  version: "1.0.0"
-  environment: "production"
+func TestRegoPolicies(t *testing.T) {
+    dir, err := os.MkdirTemp("", "netgit-test")
+    require.NoError(t, err)
+    defer os.RemoveAll(dir)
+    
+    writeFiles(t, dir, map[string]string{
+        "descriptions.rego": `package netgit.policies.descriptions
 
-networkPolicies:
-  - name: "frontend-policy"
-    namespace: "default"
-    selector:
-      app: "frontend"
-    ingress:
-      - ports:
-          - protocol: "TCP"
-            port: "80"
-        from:
-          - podSelector:
-              app: "loadbalancer"
-    egress:
-      - ports:
-          - protocol: "TCP"
-            port: "8080"
-        to:
-          - podSelector:
-              app: "backend"
-  
-  - name: "backend-policy"
-    namespace: "default"
-    selector:
-      app: "backend"
-    ingress:
-      - ports:
-          - protocol: "TCP"
-            port: "8080"
-        from:
-          - podSelector:
-              app: "frontend"
-    egress:
-      - ports:
-          - protocol: "TCP"
-            port: "5432"
-        to:
-          - podSelector:
-              app: "database"
+import future.keywords.contains
+import future.keywords.if
 
-# examples/sample-configs/gcp-firewall-rules.yaml
+violation contains v if {
+    some i
+    sg := input.securityGroups[i]
+    sg.description == ""
+    v := {
+        "message": sprintf("security group '%s' has no description", [sg.name]),
+        "path": sprintf("securityGroups[%d].description", [i]),
+        "severity": "low",
+    }
+}
+`,
+        "descriptions_test.rego": `package netgit.policies.descriptions_test
+
+import data.netgit.policies.descriptions
+import future.keywords.if
+
+test_missing_description if {
+    count(descriptions.violation) == 1 with input as {"securityGroups": [{"name": "web", "description": ""}]}
+}
+
+test_broken if {
+    count(descriptions.violation) == 1 with input as {"securityGroups": []}
+}
+`,
+        "ssh.json": `{"name": "internal-ssh", "rule": "ssh_internal_only", "severity": "high"}`,
+    })
+    
+    results, err := policy.RunTests(dir)
+    require.NoError(t, err)
+    outcomes := map[string]bool{}
+    for _, r := range results {
+        outcomes[r.Package+"."+r.Name] = r.Passed
+    }
+    assert.True(t, outcomes["data.netgit.policies.descriptions_test.test_missing_description"])
+    assert.False(t, outcomes["data.netgit.policies.descriptions_test.test_broken"])
+    
+    engine, err := policy.NewEngine(dir)
+    require.NoError(t, err)
+    
+    cfg := config.NetworkConfig{
+        SecurityGroups: []config.SecurityGroup{
+            {
+                Name:        "bastion",
+                Description: "",
+                Rules: []config.Rule{
+                    {Protocol: "ssh", Sources: []string{"10.0.0.0/8", "203.0.113.0/24"}, Action: "allow"},
+                },
+            },
+        },
+        FirewallRules: []config.FirewallRule{{Name: "rule1"}, {Name: "rule2"}},
+    }
+    cfg.Source = "bastion.yaml"
+    
+    violations, err := engine.Verify(cfg)
+    require.NoError(t, err)
+    require.Len(t, violations, 2)
+    
+    assert.Equal(t, "internal-ssh", violations[0].Rule)
+    assert.Equal(t, "securityGroups[0].rules[0].sources[1]", violations[0].Path)
+    assert.Equal(t, "high", violations[0].Level)
+    
+    assert.Equal(t, "descriptions", violations[1].Rule)
+    assert.Equal(t, "security group 'bastion' has no description", violations[1].Message)
+    assert.Equal(t, "securityGroups[0].description", violations[1].Path)
+    assert.Equal(t, "low", violations[1].Level)
+    assert.Equal(t, "bastion.yaml", violations[1].File)
+}
+
+func TestPolicyLoadErrors(t *testing.T) {
+    dir, err := os.MkdirTemp("", "netgit-test")
+    require.NoError(t, err)
+    defer os.RemoveAll(dir)
+    
+    writeFiles(t, dir, map[string]string{"typo.json": `{"name": "typo", "rule": "deny_public_databse"}`})
+    _, err = policy.NewEngine(dir)
+    assert.ErrorContains(t, err, `unknown rule "deny_public_databse"`)
+    
+    require.NoError(t, os.Remove(filepath.Join(dir, "typo.json")))
+    for name, tc := range map[string]struct{ content, err string }{
+        "truncated.json": {`[{"name": "a", "rule": "require_https"}`, "truncated.json: "},
+        "unnamed.yaml":   {"rule: require_https\n", "policy 1 has no name"},
+        "empty.yaml":     {"name: empty\nseverity: low\n", "policy empty names no rule and has no match"},
+        "bundle.yaml":    {"name: baseline\npolicies:\n  - {name: a, rule: require_https}\n", "a policy bundle needs a name and a version"},
+    } {
+        writeFiles(t, dir, map[string]string{name: tc.content})
+        _, err = policy.NewEngine(dir)
+        assert.ErrorContains(t, err, tc.err, name)
+        require.NoError(t, os.Remove(filepath.Join(dir, name)))
+    }
+    
+    writeFiles(t, dir, map[string]string{
+        "a.json": `{"name": "https", "rule": "require_https"}`,
+        "b.yaml": "name: https\nrule: require_https\n",
+    })
+    _, err = policy.NewEngine(dir)
+    assert.ErrorContains(t, err, "policy https is already defined in "+filepath.Join(dir, "a.json"))
+    require.NoError(t, os.Remove(filepath.Join(dir, "a.json")))
+    require.NoError(t, os.Remove(filepath.Join(dir, "b.yaml")))
+    
+    writeFiles(t, dir, map[string]string{"broken.rego": "package netgit.policies.broken\n\nviolation contains v if {"})
+    _, err = policy.NewEngine(dir)
+    assert.Error(t, err)
+}
+
+func TestPolicyFileFormats(t *testing.T) {
+    dir, err := os.MkdirTemp("", "netgit-test")
+    require.NoError(t, err)
+    defer os.RemoveAll(dir)
+    
+    // Without policy files of its own a directory gets the defaults
+    engine, err := policy.NewEngine(dir)
+    require.NoError(t, err)
+    require.Len(t, engine.Policies(), 3)
+    assert.Equal(t, "built-in", engine.Policies()[0].Source)
+    
+    writeFiles(t, dir, map[string]string{
+        "array.json": `[
+  {"name": "no-public-database", "rule": "deny_public_database", "severity": "high"},
+  {"name": "require-https", "rule": "require_https", "severity": "medium"}
+]`,
+        "baseline.yaml": `name: acme-baseline
+version: 1.2.0
+description: Controls every network must meet
 metadata:
-  name: "gcp-firewall-rules"
-  version: "1.1.0"
-  environment: "production"
+  owner: security
+policies:
+  - name: ssh-internal
+    rule: ssh_internal_only
+    severity: high
+  - name: no-telnet
+    severity: medium
+    match:
+      ports: ["23"]
+    assert:
+      action: deny
+`,
+        "local.yml": "name: redundancy\nrule: require_redundancy\nseverity: low\n---\nname: secure-protocols\nrule: secure_protocols_only\nseverity: high\n",
+        "custom.rego": "package netgit.policies.custom\n\nimport future.keywords.contains\nimport future.keywords.if\n\nviolation contains \"never\" if false\n",
+    })
+    
+    engine, err = policy.NewEngine(dir)
+    require.NoError(t, err)
+    
+    type listed struct{ name, kind, source, bundle string }
+    var policies []listed
+    for _, p := range engine.Policies() {
+        policies = append(policies, listed{p.Name, p.Kind(), filepath.Base(p.Source), p.Bundle})
+    }
+    assert.Equal(t, []listed{
+        {"no-public-database", "rule", "array.json", ""},
+        {"require-https", "rule", "array.json", ""},
+        {"ssh-internal", "rule", "baseline.yaml", "acme-baseline@1.2.0"},
+        {"no-telnet", "match", "baseline.yaml", "acme-baseline@1.2.0"},
+        {"redundancy", "rule", "local.yml", ""},
+        {"secure-protocols", "rule", "local.yml", ""},
+        {"custom", "rego", "custom.rego", ""},
+    }, policies)
+}
 
-firewallRules:
-  - name: "allow-web-traffic"
-    direction: "INGRESS"
-    priority: 1000
-    protocol: "tcp"
-    ports: ["80", "443"]
-    sourceRanges: ["0.0.0.0/0"]
-    targetTags: ["web-server"]
-  
-  - name: "allow-ssh-internal"
-    direction: "INGRESS"
-    priority: 1100
-    protocol: "tcp"
-    ports: ["22"]
-    sourceRanges: ["10.0.0.0/8"]
-    targetTags: ["ssh-access"]
-  
-  - name: "deny-all-ingress"
-    direction: "INGRESS"
-    priority: 65534
-    protocol: "all"
-    ports: []
-    sourceRanges: ["0.0.0.0/0"]
-    targetTags: ["secure"]
+func TestDeclarativePolicies(t *testing.T) {
+    dir, err := os.MkdirTemp("", "netgit-test")
+    require.NoError(t, err)
+    defer os.RemoveAll(dir)
+    
+    writeFiles(t, dir, map[string]string{
+        "remote-access.yaml": `name: remote-access
+severity: high
+match:
+  names: ["prod-*"]
+  labels: {tier: web}
+  ports: ["22", "3389"]
+assert:
+  sources:
+    subsetOf: ["10.0.0.0/9", "10.128.0.0/9"]
+message: "{name} allows remote access from {value}"
+`,
+        "no-telnet.yaml": `name: no-telnet-ports
+severity: medium
+match:
+  resource: firewallRule
+  direction: INGRESS
+assert:
+  ports:
+    disjointFrom: ["23"]
+`,
+    })
+    
+    engine, err := policy.NewEngine(dir)
+    require.NoError(t, err)
+    
+    cfg := config.NetworkConfig{
+        Metadata: config.Metadata{Labels: map[string]string{"tier": "web"}},
+        SecurityGroups: []config.SecurityGroup{
+            {
+                Name: "prod-bastion",
+                Rules: []config.Rule{
+                    {Protocol: "tcp", Ports: []string{"20-25"}, Sources: []string{"10.1.0.0/16", "198.51.100.0/24"}, Action: "allow"},
+                    {Protocol: "tcp", Ports: []string{"443"}, Sources: []string{"0.0.0.0/0"}, Action: "allow"},
+                },
+            },
+            {
+                Name: "staging-bastion",
+                Rules: []config.Rule{
+                    {Protocol: "ssh", Sources: []string{"0.0.0.0/0"}, Action: "allow"},
+                },
+            },
+        },
+        FirewallRules: []config.FirewallRule{
+            {Name: "legacy", Direction: "INGRESS", Protocol: "tcp", Ports: []string{"20-30"}, SourceRanges: []string{"10.0.0.0/8"}},
+            {Name: "egress", Direction: "EGRESS", Protocol: "tcp", Ports: []string{"23"}, DestinationRanges: []string{"10.0.0.0/8"}},
+        },
+    }
+    
+    violations, err := engine.Verify(cfg)
+    require.NoError(t, err)
+    require.Len(t, violations, 2)
+    
+    assert.Equal(t, "no-telnet-ports", violations[0].Rule)
+    assert.Equal(t, "Firewall rule 'legacy' (tcp 20-30) allows port 20-30, which the policy does not allow", violations[0].Message)
+    assert.Equal(t, "firewallRules[0].ports[0]", violations[0].Path)
+    assert.Equal(t, "medium", violations[0].Level)
+    
+    assert.Equal(t, "remote-access", violations[1].Rule)
+    assert.Equal(t, "prod-bastion allows remote access from 198.51.100.0/24", violations[1].Message)
+    assert.Equal(t, "securityGroups[0].rules[0].sources[1]", violations[1].Path)
+    
+    // The selector no longer matches once the label changes
+    cfg.Metadata.Labels["tier"] = "db"
+    violations, err = engine.Verify(cfg)
+    require.NoError(t, err)
+    require.Len(t, violations, 1)
+    assert.Equal(t, "no-telnet-ports", violations[0].Rule)
+    
+    writeFiles(t, dir, map[string]string{"bad.yaml": "name: bad\nmatch:\n  sources: [\"10.0.0.0/33\"]\nassert:\n  action: deny\n"})
+    _, err = policy.NewEngine(dir)
+    assert.ErrorContains(t, err, `policy bad: invalid CIDR "10.0.0.0/33"`)
+}
+
+func TestDeclarativePortsFollowProtocol(t *testing.T) {
+    dir, err := os.MkdirTemp("", "netgit-test")
+    require.NoError(t, err)
+    defer os.RemoveAll(dir)
+    
+    writeFiles(t, dir, map[string]string{
+        "ssh.yaml": `name: ssh-internal
+severity: high
+match:
+  protocols: [tcp]
+  ports: ["22"]
+assert:
+  sources:
+    subsetOf: ["10.0.0.0/8"]
+`,
+        "port-22.yaml": `name: port-22-internal
+severity: high
+match:
+  ports: ["22"]
+assert:
+  sources:
+    subsetOf: ["10.0.0.0/8"]
+`,
+    })
+    engine, err := policy.NewEngine(dir)
+    require.NoError(t, err)
+    
+    public := []string{"0.0.0.0/0"}
+    cfg := config.NetworkConfig{
+        SecurityGroups: []config.SecurityGroup{{
+            Name: "edge",
+            Rules: []config.Rule{
+                {Protocol: "tcp", Ports: []string{"22"}, Sources: public},
+                {Protocol: "udp", Ports: []string{"22"}, Sources: public},
+                {Protocol: "icmp", Sources: public},
+                {Protocol: "ssh", Sources: public},
+                {Protocol: "https", Sources: public},
+                {Protocol: "all", Sources: public},
+            },
+        }},
+    }
+    violations, err := engine.Verify(cfg)
+    require.NoError(t, err)
+    
+    // icmp has no ports and https is port 443, so neither matches; udp/22
+    // matches the ports but not the protocol
+    var found []string
+    for _, v := range violations {
+        found = append(found, v.Rule+" "+v.Path)
+    }
+    assert.ElementsMatch(t, []string{
+        "ssh-internal securityGroups[0].rules[0].sources[0]",
+        "ssh-internal securityGroups[0].rules[3].sources[0]",
+        "ssh-internal securityGroups[0].rules[5].sources[0]",
+        "port-22-internal securityGroups[0].rules[0].sources[0]",
+        "port-22-internal securityGroups[0].rules[1].sources[0]",
+        "port-22-internal securityGroups[0].rules[3].sources[0]",
+        "port-22-internal securityGroups[0].rules[5].sources[0]",
+    }, found)
+}
+
+func TestEnforcement(t *testing.T) {
+    enforcement := policy.Enforcement{
+        Levels: map[string]string{"medium": "block", "low": "audit"},
+        Environments: map[string]map[string]string{
+            "dev": {"high": "warn", "medium": "warn"},
+        },
+    }
+    require.NoError(t, enforcement.Check())
+    
+    assert.Equal(t, policy.EnforceBlock, enforcement.Level("high", ""))
+    assert.Equal(t, policy.EnforceBlock, enforcement.Level("medium", "production"))
+    assert.Equal(t, policy.EnforceAudit, enforcement.Level("low", ""))
+    assert.Equal(t, policy.EnforceWarn, enforcement.Level("high", "dev"))
+    assert.Equal(t, policy.EnforceAudit, enforcement.Level("low", "dev"))
+    assert.Equal(t, policy.EnforceWarn, enforcement.Level("info", ""))
+    
+    // A threshold blocks what reaches it and only warns about the rest
+    enforcement.FailOn = "high"
+    assert.Equal(t, policy.EnforceBlock, enforcement.Level("high", "dev"))
+    assert.Equal(t, policy.EnforceBlock, enforcement.Level("critical", ""))
+    assert.Equal(t, policy.EnforceWarn, enforcement.Level("medium", ""))
+    assert.Equal(t, policy.EnforceAudit, enforcement.Level("low", ""))
+    
+    violations := []policy.Violation{{Rule: "a", Level: "medium"}, {Rule: "b", Level: "low"}}
+    assert.False(t, enforcement.Enforce(violations, ""))
+    assert.Equal(t, policy.EnforceWarn, violations[0].Enforcement)
+    assert.Equal(t, policy.EnforceAudit, violations[1].Enforcement)
+    enforcement.FailOn = "medium"
+    assert.True(t, enforcement.Enforce(violations, ""))
+    
+    enforcement.FailOn = "severe"
+    assert.EqualError(t, enforcement.Check(), `fail-on: unknown severity "severe" (want one of low, medium, high, critical)`)
+    enforcement = policy.Enforcement{Environments: map[string]map[string]string{"prod": {"high": "stop"}}}
+    assert.EqualError(t, enforcement.Check(), `enforcement for prod: severity high has unknown enforcement level "stop" (want block, warn or audit)`)
+}
+
+func TestVerifyCountsViolations(t *testing.T) {
+    engine, err := policy.NewEngine("../examples/policies")
+    require.NoError(t, err)
+    
+    counter := metrics.PolicyViolationsTotal.WithLabelValues("no-public-database", "high")
+    before := testutil.ToFloat64(counter)
+    cfg := config.NetworkConfig{SecurityGroups: []config.SecurityGroup{{Name: "db", Rules: []config.Rule{
+        {Protocol: "mysql", Sources: []string{"0.0.0.0/0"}},
+    }}}}
+    for i := 0; i < 2; i++ {
+        _, err := engine.Verify(cfg)
+        require.NoError(t, err)
+    }
+    assert.Equal(t, before+2, testutil.ToFloat64(counter))
+}
+
+func TestWaivers(t *testing.T) {
+    dir, err := os.MkdirTemp("", "netgit-test")
+    require.NoError(t, err)
+    defer os.RemoveAll(dir)
+    
+    writeFiles(t, dir, map[string]string{
+        "exceptions.yaml": `waivers:
+  - rule: no-public-database
+    file: db.yaml
+    path: securityGroups[0].rules[0].sources[0]
+    justification: replica catch-up during the migration
+    approvedBy: security@example.com
+    expires: 2026-03-31
+  - rule: no-public-database
+    file: db.yaml
+    path: securityGroups[1].rules[0].sources[0]
+    justification: old migration
+    approvedBy: security@example.com
+    expires: 2026-01-31
+  - rule: secure-protocols
+    file: web.yaml
+    path: securityGroups[0].rules[0].protocol
+    justification: health checks
+    approvedBy: security@example.com
+    expires: 2026-12-31T12:00:00Z
+`,
+    })
+    
+    // The exceptions file is not a policy file
+    engine, err := policy.NewEngine(dir)
+    require.NoError(t, err)
+    assert.Len(t, engine.Policies(), 3)
+    
+    // Unsigned waivers are refused unless the operator allows them
+    _, err = policy.LoadWaivers(dir, nil, false)
+    assert.EqualError(t, err, filepath.Join(dir, "exceptions.yaml")+": waiver 1 (no-public-database at db.yaml:securityGroups[0].rules[0].sources[0]) cannot be checked without the waiver key")
+    waivers, err := policy.LoadWaivers(dir, nil, true)
+    require.NoError(t, err)
+    require.Len(t, waivers.List(), 3)
+    assert.Len(t, waivers.Unsigned(), 3)
+    
+    violations := []policy.Violation{
+        {Rule: "no-public-database", File: "db.yaml", Path: "securityGroups[0].rules[0].sources[0]", Level: "high"},
+        {Rule: "no-public-database", File: "db.yaml", Path: "securityGroups[1].rules[0].sources[0]", Level: "high"},
+        {Rule: "require-redundant-routes", File: "db.yaml", Path: "firewallRules", Level: "medium"},
+    }
+    
+    // A date waiver holds through the end of its day
+    now := time.Date(2026, 3, 31, 23, 0, 0, 0, time.UTC)
+    kept, waived := waivers.Apply(violations, now)
+    assert.Equal(t, violations[1:], kept)
+    require.Len(t, waived, 1)
+    assert.Equal(t, violations[0], waived[0].Violation)
+    assert.Equal(t, "replica catch-up during the migration", waived[0].Waiver.Justification)
+    
+    assert.Equal(t, []string{"no-public-database at db.yaml:securityGroups[1].rules[0].sources[0]"}, waiverNames(waivers.Expired(now)))
+    assert.Equal(t, []string{"secure-protocols at web.yaml:securityGroups[0].rules[0].protocol"}, waiverNames(waivers.Unused(now)))
+    
+    later := time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)
+    kept, waived = waivers.Apply(violations, later)
+    assert.Equal(t, violations, kept)
+    assert.Empty(t, waived)
+    
+    // With a key every waiver must carry its signature
+    key := []byte("waiver-key")
+    _, err = policy.LoadWaivers(dir, key, false)
+    assert.EqualError(t, err, filepath.Join(dir, "exceptions.yaml")+": waiver 1 (no-public-database at db.yaml:securityGroups[0].rules[0].sources[0]) has no signature")
+    waivers, err = policy.LoadWaivers(dir, key, true)
+    require.NoError(t, err)
+    assert.Len(t, waivers.Unsigned(), 3)
+    
+    signed := ""
+    for _, w := range waivers.List() {
+        signed += fmt.Sprintf("  - rule: %s\n    file: %s\n    path: %s\n    justification: %s\n    approvedBy: %s\n    expires: %s\n    signature: %s\n",
+            w.Rule, w.File, w.Path, w.Justification, w.ApprovedBy, w.Expires, w.Sign(key))
+    }
+    writeFiles(t, dir, map[string]string{"exceptions.yaml": "waivers:\n" + signed})
+    waivers, err = policy.LoadWaivers(dir, key, false)
+    require.NoError(t, err)
+    assert.Len(t, waivers.List(), 3)
+    assert.Empty(t, waivers.Unsigned())
+    
+    // A signature that does not match is refused even when unsigned
+    // waivers are allowed
+    forged := strings.Replace(signed, "expires: 2026-12-31T12:00:00Z", "expires: 2027-12-31T12:00:00Z", 1)
+    writeFiles(t, dir, map[string]string{"exceptions.yaml": "waivers:\n" + forged})
+    _, err = policy.LoadWaivers(dir, key, true)
+    assert.EqualError(t, err, filepath.Join(dir, "exceptions.yaml")+": waiver 3 (secure-protocols at web.yaml:securityGroups[0].rules[0].protocol) is not signed with the waiver key")
+    
+    // Moving text from one field to the next changes the signature
+    a := policy.Waiver{Rule: "r", File: "f\np", Path: "x"}
+    b := policy.Waiver{Rule: "r\nf", File: "p", Path: "x"}
+    assert.NotEqual(t, a.Sign(key), b.Sign(key))
+    
+    for content, want := range map[string]string{
+        "waivers:\n  - rule: r\n    file: f\n    path: p\n    expires: 2026-01-01\n": "waiver 1 has no justification, approvedBy",
+        "waivers:\n  - rule: r\n    file: f\n    path: p\n    justification: j\n    approvedBy: a\n    expires: soon\n": `waiver 1: expires "soon" is neither a date nor an RFC 3339 time`,
+        "waivers:\n  - rule: r\n    approver: a\n": "field approver not found",
+    } {
+        writeFiles(t, dir, map[string]string{"exceptions.yaml": content})
+        _, err := policy.LoadWaivers(dir, nil, true)
+        if assert.Error(t, err) {
+            assert.Contains(t, err.Error(), want)
+        }
+    }
+}
 
-# examples/policies/security-policies.json
-[
-  {
-    "name": "no-public-database",
-    "description": "Database ports must not be accessible from the internet",
-    "rule": "deny_public_database",
-    "severity": "high"
-  },
-  {
-    "name": "require-https",
-    "description": "Web traffic must use HTTPS",
-    "rule": "require_https",
-    "severity": "medium"
-  },
-  {
-    "name": "ssh-access-control",
-    "description": "SSH access must be restricted to internal networks",
-    "rule": "ssh_internal_only",
-    "severity": "high"
-  }
-]
+func waiverNames(waivers []policy.Waiver) []string {
+    var names []string
+    for _, w := range waivers {
+        names = append(names, w.String())
+    }
+    return names
+}
 
-# tests/storage_test.go
+func TestVerifyReportFormats(t *testing.T) {
+    dir, err := os.MkdirTemp("", "netgit-test")
+    require.NoError(t, err)
+    defer os.RemoveAll(dir)
+    
+    writeFiles(t, dir, map[string]string{
+        "web.yaml": "securityGroups:\n  - name: web\n    rules:\n      - protocol: http\n        sources: [\"10.0.0.0/8\"]\nfirewallRules:\n  - name: a\n    protocol: tcp\n  - name: b\n    protocol: tcp\n",
+        "ok.yaml":  "firewallRules:\n  - name: a\n    protocol: tcp\n  - name: b\n    protocol: tcp\n",
+    })
+    configs, err := config.LoadWorkingDirectory(dir)
+    require.NoError(t, err)
+    
+    engine, err := policy.NewEngine("../examples/policies")
+    require.NoError(t, err)
+    report := &policy.Report{Violations: []policy.Violation{}}
+    for _, cfg := range configs {
+        report.Files = append(report.Files, cfg.Source)
+        found, err := engine.Verify(cfg)
+        require.NoError(t, err)
+        report.Violations = append(report.Violations, found...)
+    }
+    assert.True(t, policy.Enforcement{FailOn: "medium"}.Enforce(report.Violations, ""))
+    report.Blocked = 1
+    
+    require.Len(t, report.Violations, 1)
+    v := report.Violations[0]
+    assert.Equal(t, "require-https", v.Rule)
+    assert.Equal(t, "web.yaml", v.File)
+    assert.Equal(t, 4, v.Line)
+    assert.Equal(t, 19, v.Column)
+    
+    content, err := report.Format(policy.OutputJSON)
+    require.NoError(t, err)
+    var decoded policy.Report
+    require.NoError(t, json.Unmarshal([]byte(content), &decoded))
+    assert.Equal(t, report.Violations, decoded.Violations)
+    
+    content, err = report.Format(policy.OutputSARIF)
+    require.NoError(t, err)
+    var sarif struct {
+        Version string `json:"version"`
+        Runs    []struct {
+            Results []struct {
+                RuleID    string `json:"ruleId"`
+                Level     string `json:"level"`
+                Locations []struct {
+                    PhysicalLocation struct {
+                        ArtifactLocation struct{ URI string } `json:"artifactLocation"`
+                        Region           struct {
+                            StartLine   int `json:"startLine"`
+                            StartColumn int `json:"startColumn"`
+                        } `json:"region"`
+                    } `json:"physicalLocation"`
+                } `json:"locations"`
+            } `json:"results"`
+        } `json:"runs"`
+    }
+    require.NoError(t, json.Unmarshal([]byte(content), &sarif))
+    assert.Equal(t, "2.1.0", sarif.Version)
+    require.Len(t, sarif.Runs[0].Results, 1)
+    result := sarif.Runs[0].Results[0]
+    assert.Equal(t, "require-https", result.RuleID)
+    assert.Equal(t, "error", result.Level)
+    assert.Equal(t, "web.yaml", result.Locations[0].PhysicalLocation.ArtifactLocation.URI)
+    assert.Equal(t, 4, result.Locations[0].PhysicalLocation.Region.StartLine)
+    
+    content, err = report.Format(policy.OutputJUnit)
+    require.NoError(t, err)
+    var junit struct {
+        Tests    int `xml:"tests,attr"`
+        Failures int `xml:"failures,attr"`
+        Suites   []struct {
+            Name  string `xml:"name,attr"`
+            Cases []struct {
+                Name    string `xml:"name,attr"`
+                Failure *struct {
+                    Text string `xml:",chardata"`
+                } `xml:"failure"`
+            } `xml:"testcase"`
+        } `xml:"testsuite"`
+    }
+    require.NoError(t, xml.Unmarshal([]byte(content), &junit))
+    assert.Equal(t, 2, junit.Tests)
+    assert.Equal(t, 1, junit.Failures)
+    require.Len(t, junit.Suites, 2)
+    assert.Equal(t, "ok.yaml", junit.Suites[0].Name)
+    assert.Nil(t, junit.Suites[0].Cases[0].Failure)
+    assert.Equal(t, "require-https securityGroups[0].rules[0].protocol", junit.Suites[1].Cases[0].Name)
+    require.NotNil(t, junit.Suites[1].Cases[0].Failure)
+    assert.Contains(t, junit.Suites[1].Cases[0].Failure.Text, "web.yaml:4:19:")
+    
+    _, err = report.Format("xml")
+    assert.EqualError(t, err, "unknown output format: xml (want text, json, sarif or junit)")
+}
+
+func TestSchemaReportFormats(t *testing.T) {
+    dir, err := os.MkdirTemp("", "netgit-test")
+    require.NoError(t, err)
+    defer os.RemoveAll(dir)
+    
+    writeFiles(t, dir, map[string]string{
+        "web.yaml": "securityGroups:\n  - name: web\n    rules:\n      - protocol: tcp\n        ports: [\"443\"]\n        sources: [\"10.0.0.0/33\"]\n",
+    })
+    problems, err := config.ValidateWorkingDirectory(dir, "")
+    require.NoError(t, err)
+    require.Len(t, problems, 1)
+    
+    // Schema problems are blocking results, so the artifact is never empty
+    report := policy.SchemaReport(problems)
+    assert.Equal(t, []string{filepath.Join(dir, "web.yaml")}, report.Files)
+    assert.Equal(t, 1, report.Blocked)
+    require.Len(t, report.Violations, 1)
+    v := report.Violations[0]
+    assert.Equal(t, policy.SchemaRule, v.Rule)
+    assert.Equal(t, problems[0].Message, v.Message)
+    assert.Equal(t, policy.EnforceBlock, v.Enforcement)
+    assert.Equal(t, 6, v.Line)
+    
+    content, err := report.Format(policy.OutputSARIF)
+    require.NoError(t, err)
+    var sarif struct {
+        Runs []struct {
+            Results []struct {
+                RuleID    string `json:"ruleId"`
+                Level     string `json:"level"`
+                Locations []struct {
+                    PhysicalLocation struct {
+                        ArtifactLocation struct{ URI string } `json:"artifactLocation"`
+                        Region           struct {
+                            StartLine int `json:"startLine"`
+                        } `json:"region"`
+                    } `json:"physicalLocation"`
+                } `json:"locations"`
+            } `json:"results"`
+        } `json:"runs"`
+    }
+    require.NoError(t, json.Unmarshal([]byte(content), &sarif))
+    require.Len(t, sarif.Runs[0].Results, 1)
+    result := sarif.Runs[0].Results[0]
+    assert.Equal(t, "schema", result.RuleID)
+    assert.Equal(t, "error", result.Level)
+    assert.Equal(t, filepath.Join(dir, "web.yaml"), result.Locations[0].PhysicalLocation.ArtifactLocation.URI)
+    assert.Equal(t, 6, result.Locations[0].PhysicalLocation.Region.StartLine)
+    
+    content, err = report.Format(policy.OutputJUnit)
+    require.NoError(t, err)
+    var junit struct {
+        Failures int `xml:"failures,attr"`
+    }
+    require.NoError(t, xml.Unmarshal([]byte(content), &junit))
+    assert.Equal(t, 1, junit.Failures)
+}
+
+# tests/reach_test.go
 package tests
 
 import (
+    "encoding/json"
     "os"
     "testing"
-    "path/filepath"
     
     "github.com/stretchr/testify/assert"
     "github.com/stretchr/testify/require"
     
-    "netgit/pkg/storage"
     "netgit/pkg/config"
+    "netgit/pkg/policy"
+    "netgit/pkg/reach"
 )
 
-func TestRepository(t *testing.T) {
-    // Create temporary directory for testing
-    tmpDir, err := os.MkdirTemp("", "netgit-test")
-    require.NoError(t, err)
-    defer os.RemoveAll(tmpDir)
+func reachabilityConfig() config.NetworkConfig {
+    return config.NetworkConfig{
+        SecurityGroups: []config.SecurityGroup{
+            {ID: "sg-0aa", Name: "web-sg", Rules: []config.Rule{
+                {Protocol: "https", Sources: []string{"0.0.0.0/0"}},
+            }},
+            {ID: "sg-0bb", Name: "db-tier-sg", Rules: []config.Rule{
+                {Protocol: "tcp", Ports: []string{"3306"}, Sources: []string{"sg-0aa", "10.20.0.0/16"}, Action: "allow"},
+                {Protocol: "tcp", Ports: []string{"3306"}, Sources: []string{"10.20.9.0/24"}, Action: "deny"},
+            }},
+        },
+        FirewallRules: []config.FirewallRule{
+            {Name: "allow-http", Direction: "INGRESS", Priority: 1000, Protocol: "tcp", Ports: []string{"80", "8000-8100"}, SourceRanges: []string{"0.0.0.0/0"}, TargetTags: []string{"web"}},
+            {Name: "block-scanner", Direction: "INGRESS", Priority: 900, Protocol: "all", SourceRanges: []string{"198.51.100.0/24"}, TargetTags: []string{"web"}, Action: "deny"},
+            {Name: "no-smtp-out", Direction: "EGRESS", Priority: 100, Protocol: "tcp", Ports: []string{"25"}, DestinationRanges: []string{"0.0.0.0/0"}, Action: "deny"},
+        },
+        NetworkPolicies: []config.NetworkPolicy{
+            {Name: "db", Namespace: "prod", Selector: map[string]string{"app": "db"},
+                Ingress: []config.NetworkPolicyRule{{
+                    Ports: []config.NetworkPolicyPort{{Protocol: "TCP", Port: "5432"}},
+                    From: []config.NetworkPolicyPeer{
+                        {PodSelector: map[string]string{"app": "api"}},
+                        {NamespaceSelector: map[string]string{"kubernetes.io/metadata.name": "ops"}, PodSelector: map[string]string{}},
+                    },
+                }}},
+            {Name: "api-egress", Namespace: "prod", Selector: map[string]string{"app": "api"}, PolicyTypes: []string{"Egress"},
+                Egress: []config.NetworkPolicyRule{{
+                    To: []config.NetworkPolicyPeer{{PodSelector: map[string]string{"app": "db"}}},
+                }}},
+        },
+    }
+}
+
+func TestReachability(t *testing.T) {
+    model := reach.New(reachabilityConfig())
+    query := func(from, to, flow string) reach.Result {
+        source, err := model.Endpoint(from)
+        require.NoError(t, err)
+        destination, err := model.Endpoint(to)
+        require.NoError(t, err)
+        protocol, port, err := reach.ParseFlow(flow)
+        require.NoError(t, err)
+        return model.Reachable(source, destination, protocol, port)
+    }
     
-    // Test repository initialization
-    repo, err := storage.NewRepository(tmpDir)
-    require.NoError(t, err)
-    defer repo.Close()
+    // Security groups, by reference and by range, with deny winning
+    result := query("web-sg", "db-tier-sg", "tcp/3306")
+    require.True(t, result.Allowed, result.Reason)
+    require.Len(t, result.Chain, 1)
+    assert.Equal(t, "securityGroups[1].rules[0]", result.Chain[0].Path)
+    assert.Equal(t, "tcp 3306 from sg-0aa", result.Chain[0].Detail)
     
-    assert.Equal(t, tmpDir, repo.Path())
+    assert.True(t, query("10.20.1.0/24", "sg-0bb", "mysql").Allowed)
+    result = query("10.20.9.7", "db-tier-sg", "tcp/3306")
+    assert.False(t, result.Allowed)
+    assert.Equal(t, "deny", result.Chain[0].Action)
     
-    // Test committing configuration
-    testConfig := config.NetworkConfig{
-        Metadata: config.Metadata{
-            Name:        "test-config",
-            Version:     "1.0.0",
-            Environment: "test",
-        },
+    // Only the part of a range some rule admits gets through
+    result = query("0.0.0.0/0", "db-tier-sg", "tcp/3306")
+    assert.False(t, result.Allowed)
+    assert.Equal(t, []string{"10.20.0.0/21", "10.20.8.0/24", "10.20.10.0/23", "10.20.12.0/22", "10.20.16.0/20", "10.20.32.0/19", "10.20.64.0/18", "10.20.128.0/17"}, result.Partial)
+    require.Len(t, result.Chain, 1)
+    assert.Equal(t, "securityGroups[1].rules[0]", result.Chain[0].Path)
+    assert.Equal(t, "no rule of security group db-tier-sg allows 0.0.0.0/0; security group db-tier-sg denies 10.20.9.0/24", result.Reason)
+    result = query("192.0.2.0/24", "db-tier-sg", "tcp/3306")
+    assert.Empty(t, result.Partial)
+    assert.Empty(t, result.Chain)
+    assert.Equal(t, "no rule of security group db-tier-sg allows 192.0.2.0/24", result.Reason)
+    
+    // An application protocol without ports only covers its own port
+    assert.True(t, query("0.0.0.0/0", "web-sg", "tcp/443").Allowed)
+    assert.False(t, query("0.0.0.0/0", "web-sg", "tcp/80").Allowed)
+    
+    // Firewall rules by priority and target tag, with the implied rules
+    result = query("203.0.113.0/24", "tag:web", "tcp/8080")
+    require.True(t, result.Allowed)
+    assert.Equal(t, "allow-http", result.Chain[0].Name)
+    result = query("198.51.100.7", "tag:web", "tcp/80")
+    assert.False(t, result.Allowed)
+    assert.Equal(t, "block-scanner", result.Chain[0].Name)
+    assert.Contains(t, query("203.0.113.0/24", "tag:db", "tcp/80").Reason, "implied deny")
+    
+    result = query("tag:web", "203.0.113.25", "tcp/25")
+    assert.False(t, result.Allowed)
+    assert.Equal(t, "no-smtp-out", result.Chain[0].Name)
+    assert.True(t, query("tag:web", "203.0.113.25", "tcp/443").Allowed)
+    
+    // Network policies through pod and namespace selectors, on both ends
+    result = query("pod:prod/app=api,version=2", "pod:prod/app=db", "tcp/5432")
+    require.True(t, result.Allowed, result.Reason)
+    require.Len(t, result.Chain, 2)
+    assert.Equal(t, "prod/api-egress", result.Chain[0].Name)
+    assert.Equal(t, "egress", result.Chain[0].Direction)
+    assert.Equal(t, "prod/db", result.Chain[1].Name)
+    assert.Equal(t, "networkPolicies[0].ingress[0]", result.Chain[1].Path)
+    
+    assert.True(t, query("pod:ops/app=backup", "pod:prod/app=db", "tcp/5432").Allowed)
+    assert.False(t, query("pod:staging/app=api", "pod:prod/app=db", "tcp/5432").Allowed)
+    assert.False(t, query("pod:prod/app=api", "pod:prod/app=db", "udp/5432").Allowed)
+    assert.Contains(t, query("pod:prod/app=api", "10.0.0.1", "tcp/443").Reason, "network policies isolate")
+    assert.True(t, query("pod:prod/app=web", "10.0.0.1", "tcp/443").Allowed)
+    
+    _, err := model.Endpoint("cache-sg")
+    assert.ErrorContains(t, err, `unknown endpoint "cache-sg"`)
+    
+    // Egress rules without destination ranges match every destination
+    cfg := reachabilityConfig()
+    cfg.FirewallRules = append(cfg.FirewallRules, config.FirewallRule{Name: "deny-all-out", Direction: "EGRESS", Priority: 65000, Protocol: "all", Action: "deny"})
+    model = reach.New(cfg)
+    result = query("tag:web", "8.8.8.8", "tcp/443")
+    assert.False(t, result.Allowed)
+    require.Len(t, result.Chain, 1)
+    assert.Equal(t, "deny-all-out", result.Chain[0].Name)
+    assert.Equal(t, "firewall rule deny-all-out denies egress from tag web", result.Reason)
+}
+
+func TestReachabilityAcrossRules(t *testing.T) {
+    model := reach.New(config.NetworkConfig{
         SecurityGroups: []config.SecurityGroup{
-            {
-                Name:        "test-sg",
-                Description: "Test security group",
-                VpcId:       "vpc-test",
-                Rules: []config.Rule{
-                    {
-                        Protocol: "https",
-                        Ports:    []string{"443"},
-                        Sources:  []string{"0.0.0.0/0"},
-                        Action:   "allow",
-                    },
-                },
-            },
+            {Name: "db-tier-sg", Rules: []config.Rule{
+                {Protocol: "tcp", Ports: []string{"3306"}, Sources: []string{"0.0.0.0/1"}},
+                {Protocol: "tcp", Ports: []string{"3306"}, Sources: []string{"128.0.0.0/1"}},
+            }},
+            {Name: "web-sg", Rules: []config.Rule{
+                {Protocol: "tcp", Ports: []string{"443"}, Sources: []string{"203.0.113.0/24"}},
+            }},
         },
+    })
+    query := func(from, to string) reach.Result {
+        source, err := model.Endpoint(from)
+        require.NoError(t, err)
+        destination, err := model.Endpoint(to)
+        require.NoError(t, err)
+        return model.Reachable(source, destination, "tcp", map[string]int{"db-tier-sg": 3306, "web-sg": 443}[to])
     }
     
-    commit, err := repo.Commit([]config.NetworkConfig{testConfig}, "Initial commit", "test@example.com")
+    // Two rules together admit the whole internet
+    result := query("0.0.0.0/0", "db-tier-sg")
+    require.True(t, result.Allowed, result.Reason)
+    assert.Empty(t, result.Partial)
+    require.Len(t, result.Chain, 2)
+    assert.Equal(t, "securityGroups[0].rules[0]", result.Chain[0].Path)
+    assert.Equal(t, "securityGroups[0].rules[1]", result.Chain[1].Path)
+    assert.True(t, query("100.0.0.0/6", "db-tier-sg").Allowed)
+    
+    // A rule for part of a range lets that part through
+    result = query("0.0.0.0/0", "web-sg")
+    assert.False(t, result.Allowed)
+    assert.Equal(t, []string{"203.0.113.0/24"}, result.Partial)
+    require.Len(t, result.Chain, 1)
+    assert.Equal(t, "securityGroups[1].rules[0]", result.Chain[0].Path)
+    assert.Equal(t, "no rule of security group web-sg allows 0.0.0.0/0", result.Reason)
+    assert.True(t, query("203.0.113.7", "web-sg").Allowed)
+}
+
+func TestPoliciesQueryReachability(t *testing.T) {
+    dir, err := os.MkdirTemp("", "netgit-test")
     require.NoError(t, err)
+    defer os.RemoveAll(dir)
     
-    assert.NotEmpty(t, commit.Hash)
-    assert.Equal(t, "Initial commit", commit.Message)
-    assert.Equal(t, "test@example.com", commit.Author)
+    writeFiles(t, dir, map[string]string{
+        "exposure.rego": `package netgit.policies.database_exposure
+
+import future.keywords.contains
+import future.keywords.if
+
+violation contains v if {
+    result := netgit.reachable(input, "0.0.0.0/0", "db-tier-sg", "tcp/3306")
+    result.allowed
+    v := {"message": "db-tier-sg is reachable from the internet", "path": result.chain[0].path}
+}
+`,
+        "exposure_test.rego": `package netgit.policies.database_exposure_test
+
+import data.netgit.policies.database_exposure
+import future.keywords.if
+
+test_private_database if {
+    count(database_exposure.violation) == 0 with input as {"securityGroups": [{"name": "db-tier-sg", "rules": [{"protocol": "tcp", "ports": ["3306"], "sources": ["10.0.0.0/8"]}]}]}
+}
+`,
+    })
     
-    // Test getting commit back
-    retrievedCommit, err := repo.GetCommit(commit.Hash)
+    results, err := policy.RunTests(dir)
     require.NoError(t, err)
+    for _, r := range results {
+        assert.True(t, r.Passed, "%s.%s: %s", r.Package, r.Name, r.Error)
+    }
     
-    assert.Equal(t, commit.Hash, retrievedCommit.Hash)
-    assert.Equal(t, commit.Message, retrievedCommit.Message)
+    engine, err := policy.NewEngine(dir)
+    require.NoError(t, err)
+    cfg := reachabilityConfig()
+    violations, err := engine.Verify(cfg)
+    require.NoError(t, err)
+    assert.Empty(t, violations)
+    
+    // All of the internet, now that nothing in it is denied
+    cfg.SecurityGroups[1].Rules[0].Sources = append(cfg.SecurityGroups[1].Rules[0].Sources, "0.0.0.0/0")
+    cfg.SecurityGroups[1].Rules = cfg.SecurityGroups[1].Rules[:1]
+    violations, err = engine.Verify(cfg)
+    require.NoError(t, err)
+    var exposures []policy.Violation
+    for _, v := range violations {
+        if v.Rule == "database_exposure" {
+            exposures = append(exposures, v)
+        }
+    }
+    require.Len(t, exposures, 1)
+    assert.Equal(t, "db-tier-sg is reachable from the internet", exposures[0].Message)
+    assert.Equal(t, "securityGroups[1].rules[0]", exposures[0].Path)
 }
 
-# tests/policy_test.go
+func TestReachabilityDelta(t *testing.T) {
+    before := reachabilityConfig()
+    after := reachabilityConfig()
+    
+    // Open 5432 to 10.0.0.0/8 next to 3306, stop denying 10.20.9.0/24,
+    // and let the web tier's scanner block lapse
+    after.SecurityGroups[1].Rules[0] = config.Rule{Protocol: "tcp", Ports: []string{"3306", "5432"}, Sources: []string{"sg-0aa", "10.0.0.0/8"}}
+    after.SecurityGroups[1].Rules = after.SecurityGroups[1].Rules[:1]
+    after.FirewallRules = append(after.FirewallRules[:1], after.FirewallRules[2:]...)
+    
+    changes := reach.Compare(before, after)
+    type flow struct {
+        source, destination, ports string
+        allowed                    bool
+    }
+    var flows []flow
+    for _, c := range changes {
+        require.Equal(t, "tcp", c.Protocol)
+        flows = append(flows, flow{c.Source, c.Destination, c.Ports, c.Allowed})
+    }
+    
+    // What 10.20.0.0/16 and 10.20.9.0/24 gain follows from 10.0.0.0/8,
+    // and 198.51.100.0/24, all the internet lacked, gains the web tier's
+    // open ports only
+    assert.ElementsMatch(t, []flow{
+        {"10.0.0.0/8", "db-tier-sg", "3306", true},
+        {"10.0.0.0/8", "db-tier-sg", "5432", true},
+        {"web-sg", "db-tier-sg", "5432", true},
+        {"198.51.100.0/24", "tag:web", "80,8000-8100", true},
+    }, flows)
+    
+    for _, c := range changes {
+        if c.Source == "10.0.0.0/8" {
+            require.Len(t, c.Chain, 1)
+            assert.Equal(t, "securityGroups[1].rules[0]", c.Chain[0].Path)
+        }
+        // 3306 is new to 10.0.0.0/8 apart from where 10.20.0.0/16 had it
+        if c.Source == "10.0.0.0/8" && c.Ports == "3306" {
+            assert.Equal(t, []string{"10.20.0.0/21", "10.20.8.0/24", "10.20.10.0/23", "10.20.12.0/22", "10.20.16.0/20", "10.20.32.0/19", "10.20.64.0/18", "10.20.128.0/17"}, c.Except)
+        }
+    }
+    
+    delta := &reach.Delta{From: "HEAD", To: "WORKING", Changes: reach.Compare(after, before)}
+    text, err := delta.Format("unified")
+    require.NoError(t, err)
+    assert.Contains(t, text, "- 10.0.0.0/8 -> db-tier-sg tcp/5432 newly blocked: no rule of security group db-tier-sg allows 10.0.0.0/8\n")
+    assert.Contains(t, text, "- 198.51.100.0/24 -> tag:web tcp/80,8000-8100 newly blocked: firewall rule block-scanner denies ingress to tag web\n")
+    
+    data, err := delta.Format("json")
+    require.NoError(t, err)
+    var decoded reach.Delta
+    require.NoError(t, json.Unmarshal([]byte(data), &decoded))
+    assert.Equal(t, delta.Changes, decoded.Changes)
+    
+    // Two rules that together open the database to the whole internet
+    split := reachabilityConfig()
+    split.SecurityGroups[1].Rules = append(split.SecurityGroups[1].Rules,
+        config.Rule{Protocol: "tcp", Ports: []string{"3306"}, Sources: []string{"0.0.0.0/1"}},
+        config.Rule{Protocol: "tcp", Ports: []string{"3306"}, Sources: []string{"128.0.0.0/1"}})
+    changes = reach.Compare(before, split)
+    require.Len(t, changes, 1)
+    assert.Equal(t, "0.0.0.0/0", changes[0].Source)
+    assert.Equal(t, "3306", changes[0].Ports)
+    assert.True(t, changes[0].Allowed)
+    assert.Equal(t, []string{"10.20.0.0/16"}, changes[0].Except)
+    require.Len(t, changes[0].Chain, 2)
+    assert.Equal(t, "securityGroups[1].rules[2]", changes[0].Chain[0].Path)
+    assert.Equal(t, "securityGroups[1].rules[3]", changes[0].Chain[1].Path)
+    text, err = (&reach.Delta{From: "HEAD", To: "WORKING", Changes: reach.Compare(split, before)}).Format("unified")
+    require.NoError(t, err)
+    assert.Contains(t, text, "- 0.0.0.0/0 -> db-tier-sg tcp/3306 newly blocked apart from 10.20.0.0/16: no rule of security group db-tier-sg allows 0.0.0.0/0\n")
+    
+    none := &reach.Delta{From: "HEAD", To: "WORKING", Changes: reach.Compare(before, before)}
+    text, err = none.Format("unified")
+    require.NoError(t, err)
+    assert.Equal(t, "No reachability changes between HEAD and WORKING\n", text)
+}
+
+# tests/lint_test.go
 package tests
 
 import (
     "testing"
     
     "github.com/stretchr/testify/assert"
-    "github.com/stretchr/testify/require"
     
+    "netgit/pkg/config"
     "netgit/pkg/policy"
+)
+
+func TestLint(t *testing.T) {
+    cfg := config.NetworkConfig{
+        Source: "network.yaml",
+        SecurityGroups: []config.SecurityGroup{
+            {Name: "web", Rules: []config.Rule{
+                {Protocol: "tcp", Ports: []string{"443"}, Sources: []string{"0.0.0.0/0"}, Action: "allow"},
+                {Protocol: "https", Sources: []string{"203.0.113.0/24"}, Action: "allow"},
+                {Protocol: "tcp", Ports: []string{"22"}, Sources: []string{"10.0.0.0/8"}, Action: "allow"},
+                {Protocol: "tcp", Ports: []string{"22"}, Sources: []string{"10.1.0.0/16"}, Action: "deny"},
+            }},
+            {Name: "db", Rules: []config.Rule{
+                {Protocol: "tcp", Ports: []string{"5432"}, Sources: []string{"10.2.0.0/24"}, Action: "allow"},
+                {Protocol: "tcp", Ports: []string{"5432"}, Sources: []string{"10.2.0.0/16"}, Action: "deny"},
+            }},
+            {Name: "open", Rules: []config.Rule{
+                {Protocol: "tcp", Ports: []string{"0-65535"}, Sources: []string{"0.0.0.0/0"}, Action: "allow"},
+            }},
+            {Name: "api", Rules: []config.Rule{
+                {Protocol: "tcp", Ports: []string{"8080"}, Sources: []string{"10.0.0.0/8", "192.168.1.0/24"}, Action: "allow"},
+                {Protocol: "tcp", Ports: []string{"8080"}, Sources: []string{"192.168.0.0/16"}, Action: "deny"},
+            }},
+        },
+        FirewallRules: []config.FirewallRule{
+            {Name: "allow-ssh", Direction: "INGRESS", Priority: 1000, Protocol: "tcp", Ports: []string{"22"}, SourceRanges: []string{"10.0.0.0/8"}, TargetTags: []string{"web"}},
+            {Name: "deny-ssh", Direction: "INGRESS", Priority: 500, Protocol: "tcp", Ports: []string{"22"}, SourceRanges: []string{"0.0.0.0/0"}, Action: "deny"},
+            {Name: "allow-web", Direction: "INGRESS", Priority: 1000, Protocol: "tcp", Ports: []string{"80", "443"}, SourceRanges: []string{"0.0.0.0/0"}, TargetTags: []string{"web"}},
+            {Name: "allow-https", Direction: "INGRESS", Priority: 1000, Protocol: "tcp", Ports: []string{"443"}, SourceRanges: []string{"0.0.0.0/0"}, TargetTags: []string{"web"}},
+            {Name: "allow-dns", Direction: "INGRESS", Priority: 1000, Protocol: "udp", Ports: []string{"53"}, SourceRanges: []string{"10.0.0.0/8"}, TargetTags: []string{"dns"}},
+            {Name: "block-dns", Direction: "INGRESS", Priority: 1000, Protocol: "udp", Ports: []string{"53"}, SourceRanges: []string{"10.5.0.0/16"}, TargetTags: []string{"dns"}, Action: "deny"},
+        },
+    }
+    
+    type finding struct {
+        Rule, Path, Suggestion string
+    }
+    var findings []finding
+    for _, v := range policy.Lint(cfg) {
+        assert.Equal(t, "network.yaml", v.File)
+        assert.NotEmpty(t, v.Message)
+        findings = append(findings, finding{v.Rule, v.Path, v.Suggestion})
+    }
+    
+    assert.Equal(t, []finding{
+        {policy.LintRedundant, "securityGroups[0].rules[1]", "delete securityGroups[0].rules[1]"},
+        {policy.LintContradictory, "securityGroups[0].rules[2]", "narrow securityGroups[0].rules[2] so its sources or ports no longer overlap securityGroups[0].rules[3]"},
+        {policy.LintShadowed, "securityGroups[1].rules[0]", "delete securityGroups[1].rules[0]"},
+        {policy.LintOverlyBroad, "securityGroups[2].rules[0]", "allow only the protocol and ports the service listens on"},
+        {policy.LintContradictory, "securityGroups[3].rules[0]", "remove 192.168.1.0/24 from the sources of securityGroups[3].rules[0]"},
+        {policy.LintShadowed, "firewallRules[0]", "delete firewall rule allow-ssh, or give it a priority below 500 so it is evaluated before deny-ssh"},
+        {policy.LintRedundant, "firewallRules[3]", "delete firewall rule allow-https"},
+        {policy.LintContradictory, "firewallRules[4]", "give firewall rule allow-dns a priority other than 1000, or narrow it so it no longer overlaps block-dns"},
+    }, findings)
+    
+    // Exact duplicates are reported once, against the later rule
+    dup := config.NetworkConfig{SecurityGroups: []config.SecurityGroup{{Name: "web", Rules: []config.Rule{
+        {Protocol: "tcp", Ports: []string{"443"}, Sources: []string{"10.0.0.0/8"}},
+        {Protocol: "tcp", Ports: []string{"443"}, Sources: []string{"10.0.0.0/8"}},
+    }}}}
+    violations := policy.Lint(dup)
+    if assert.Len(t, violations, 1) {
+        assert.Equal(t, "securityGroups[0].rules[1]", violations[0].Path)
+    }
+    
+    clean := config.NetworkConfig{SecurityGroups: []config.SecurityGroup{{Name: "web", Rules: []config.Rule{
+        {Protocol: "https", Sources: []string{"0.0.0.0/0"}},
+        {Protocol: "ssh", Sources: []string{"10.0.0.0/8"}},
+    }}}}
+    assert.Empty(t, policy.Lint(clean))
+}
+
+# tests/hooks_test.go
+package tests
+
+import (
+    "bytes"
+    "encoding/json"
+    "os"
+    "path/filepath"
+    "testing"
+    
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+    
     "netgit/pkg/config"
+    "netgit/pkg/deploy"
+    "netgit/pkg/hooks"
 )
 
-func TestPolicyEngine(t *testing.T) {
-    engine, err := policy.NewEngine("../examples/policies")
+func TestBuiltinHookChecks(t *testing.T) {
+    dir, err := os.MkdirTemp("", "netgit-test")
     require.NoError(t, err)
+    defer os.RemoveAll(dir)
     
-    // Test configuration with policy violations
-    violatingConfig := config.NetworkConfig{
-        SecurityGroups: []config.SecurityGroup{
-            {
-                Name: "bad-sg",
-                Rules: []config.Rule{
-                    {
-                        Protocol: "mysql",
-                        Ports:    []string{"3306"},
-                        Sources:  []string{"0.0.0.0/0"}, // Public access to database
-                        Action:   "allow",
-                    },
-                },
-            },
-        },
+    writeFiles(t, dir, map[string]string{
+        ".netgit/hooks/hooks.yaml": `commit-msg:
+  - check: message-pattern
+    pattern: "^[A-Z]+-[0-9]+ "
+pre-commit:
+  - check: lint
+    severity: high
+`,
+    })
+    runner, err := hooks.Load(dir)
+    require.NoError(t, err)
+    
+    event := hooks.Event{Commit: &hooks.Commit{Message: "open ssh"}}
+    assert.EqualError(t, runner.Run(hooks.CommitMsg, event), `commit-msg hook: message-pattern: commit message "open ssh" does not match ^[A-Z]+-[0-9]+ `)
+    event.Commit.Message = "NET-42 open ssh"
+    assert.NoError(t, runner.Run(hooks.CommitMsg, event))
+    
+    // Only findings of the configured severity fail the check
+    redundant := config.NetworkConfig{Source: "web.yaml", SecurityGroups: []config.SecurityGroup{{Name: "web", Rules: []config.Rule{
+        {Protocol: "tcp", Ports: []string{"443"}, Sources: []string{"10.0.0.0/8"}},
+        {Protocol: "tcp", Ports: []string{"443"}, Sources: []string{"10.0.0.0/8"}},
+    }}}}
+    event.Files = hooks.Files([]config.NetworkConfig{redundant})
+    assert.NoError(t, runner.Run(hooks.PreCommit, event))
+    redundant.SecurityGroups[0].Rules[0].Protocol = "all"
+    redundant.SecurityGroups[0].Rules[0].Sources = []string{"0.0.0.0/0"}
+    event.Files = hooks.Files([]config.NetworkConfig{redundant})
+    assert.EqualError(t, runner.Run(hooks.PreCommit, event), "pre-commit hook: lint: 1 lint findings; run netgit lint")
+    
+    for content, want := range map[string]string{
+        "pre-merge:\n  - check: lint\n":                           `unknown hook "pre-merge"`,
+        "pre-commit:\n  - check: spellcheck\n":                    `pre-commit: unknown check "spellcheck"`,
+        "pre-commit:\n  - check: message-pattern\n    pattern: x\n": "message-pattern only runs at commit-msg",
+        "commit-msg:\n  - check: message-pattern\n    pattern: (\n": `message-pattern needs a valid pattern, not "("`,
+        "pre-deploy:\n  - check: lint\n    severity: severe\n":      `lint: unknown severity "severe"`,
+    } {
+        writeFiles(t, dir, map[string]string{".netgit/hooks/hooks.yaml": content})
+        _, err := hooks.Load(dir)
+        if assert.Error(t, err) {
+            assert.Contains(t, err.Error(), want)
+        }
     }
+}
+
+func TestExecutableHooks(t *testing.T) {
+    dir, err := os.MkdirTemp("", "netgit-test")
+    require.NoError(t, err)
+    defer os.RemoveAll(dir)
     
-    violations, err := engine.Verify(violatingConfig)
+    hooksDir := filepath.Join(dir, ".netgit", "hooks")
+    require.NoError(t, os.MkdirAll(hooksDir, 0755))
+    require.NoError(t, os.WriteFile(filepath.Join(hooksDir, "pre-deploy"), []byte("#!/bin/sh\ncat > event.json\necho \"$NETGIT_HOOK ran\"\n"), 0755))
+    require.NoError(t, os.WriteFile(filepath.Join(hooksDir, "post-deploy"), []byte("#!/bin/sh\necho frozen >&2\nexit 3\n"), 0755))
+    // Not executable, so not run
+    require.NoError(t, os.WriteFile(filepath.Join(hooksDir, "pre-commit"), []byte("#!/bin/sh\nexit 1\n"), 0644))
+    
+    runner, err := hooks.Load(dir)
     require.NoError(t, err)
+    var stdout, stderr bytes.Buffer
+    runner.Stdout, runner.Stderr = &stdout, &stderr
     
-    assert.Greater(t, len(violations), 0)
+    assert.NoError(t, runner.Run(hooks.PreCommit, hooks.Event{Commit: &hooks.Commit{Message: "m"}}))
     
-    // Test configuration without violations
-    goodConfig := config.NetworkConfig{
-        SecurityGroups: []config.SecurityGroup{
-            {
-                Name: "good-sg",
-                Rules: []config.Rule{
-                    {
-                        Protocol: "https",
-                        Ports:    []string{"443"},
-                        Sources:  []string{"10.0.0.0/8"}, // Internal only
-                        Action:   "allow",
-                    },
-                },
-            },
-        },
-        FirewallRules: []config.FirewallRule{
-            {Name: "rule1"},
-            {Name: "rule2"}, // Satisfies redundancy requirement
-        },
+    deployment := &deploy.Deployment{CommitHash: "abc123", Target: "mock", Environment: "staging", Status: "pending"}
+    event := hooks.Event{
+        Deployment: deployment,
+        Files:      hooks.Files([]config.NetworkConfig{{Source: "web.yaml", SecurityGroups: []config.SecurityGroup{{Name: "web"}}}}),
     }
+    require.NoError(t, runner.Run(hooks.PreDeploy, event))
+    assert.Equal(t, "pre-deploy ran\n", stdout.String())
     
-    violations, err = engine.Verify(goodConfig)
+    // The hook runs in the repository root and reads the event on stdin
+    data, err := os.ReadFile(filepath.Join(dir, "event.json"))
     require.NoError(t, err)
+    var received hooks.Event
+    require.NoError(t, json.Unmarshal(data, &received))
+    assert.Equal(t, hooks.PreDeploy, received.Hook)
+    assert.Equal(t, deployment, received.Deployment)
+    require.Len(t, received.Files, 1)
+    assert.Equal(t, "web.yaml", received.Files[0].Source)
+    assert.Equal(t, "web", received.Files[0].Config.SecurityGroups[0].Name)
     
-    assert.Equal(t, 0, len(violations))
+    assert.EqualError(t, runner.Run(hooks.PostDeploy, event), "post-deploy hook: exit status 3")
+    assert.Equal(t, "frozen\n", stderr.String())
 }
 
 # tests/deploy_test.go
@@ -1724,7 +17700,6 @@ import (
     "testing"
     
     "github.com/stretchr/testify/assert"
-    "github.com/stretchr/testify/require"
     
     "netgit/pkg/deploy"
     "netgit/pkg/config"